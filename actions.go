@@ -0,0 +1,82 @@
+package main
+
+import "strings"
+
+// errorReasonDescriptions describes the error_reason codes of Application Load Balancers, see
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#error-reason-codes
+var errorReasonDescriptions = map[string]string{
+	"AuthInvalidCookie":                          "The authentication cookie is not valid",
+	"AuthInvalidGrantError":                      "The authorization grant code from the token endpoint is not valid",
+	"AuthInvalidIdToken":                         "The ID token is not valid",
+	"AuthInvalidStateParam":                      "The state parameter is not valid",
+	"AuthInvalidTokenResponse":                   "The response from the token endpoint is not valid",
+	"AuthInvalidUserinfoResponse":                "The response from the user info endpoint is not valid",
+	"AuthMissingCodeParam":                       "The authentication response from the identity provider is missing the code parameter",
+	"AuthMissingHostHeader":                      "The authentication response from the identity provider is missing the host header",
+	"AuthMissingStateParam":                      "The authentication response from the identity provider is missing the state parameter",
+	"AuthTokenEpRequestFailed":                   "There is an error response from the token endpoint",
+	"AuthTokenEpRequestTimeout":                  "The load balancer is unable to communicate with the token endpoint, or the token endpoint is not responding within 5 seconds",
+	"AuthUnhandledException":                     "The load balancer encountered an unhandled exception during authentication",
+	"AuthUserinfoEpRequestFailed":                "There is an error response from the user info endpoint",
+	"AuthUserinfoEpRequestTimeout":               "The load balancer is unable to communicate with the user info endpoint, or the user info endpoint is not responding within 5 seconds",
+	"AuthUserinfoResponseSizeExceeded":           "The size of the claims returned by the identity provider exceeded 11K bytes",
+	"LambdaAccessDenied":                         "The load balancer did not have permission to invoke the Lambda function",
+	"LambdaBadRequest":                           "Lambda invocation failed because the client request headers or body did not contain only UTF-8 characters",
+	"LambdaConnectionError":                      "The load balancer cannot connect to Lambda",
+	"LambdaConnectionTimeout":                    "An attempt to connect to Lambda timed out",
+	"LambdaEC2AccessDeniedException":             "Amazon EC2 denied access to Lambda during function initialization",
+	"LambdaEC2ThrottledException":                "Amazon EC2 throttled Lambda during function initialization",
+	"LambdaEC2UnexpectedException":               "Amazon EC2 encountered an unexpected exception during function initialization",
+	"LambdaENILimitReachedException":             "Lambda couldn't create a network interface in the VPC of the function because the limit for network interfaces was exceeded",
+	"LambdaInvalidResponse":                      "The response from the Lambda function is malformed or is missing required fields",
+	"LambdaInvalidRuntimeException":              "The specified version of the Lambda runtime is not supported",
+	"LambdaInvalidSecurityGroupIDException":      "The security group ID specified in the Lambda function configuration is not valid",
+	"LambdaInvalidSubnetIDException":             "The subnet ID specified in the Lambda function configuration is not valid",
+	"LambdaInvalidZipFileException":              "Lambda could not unzip the specified function zip file",
+	"LambdaKMSAccessDeniedException":             "Lambda could not decrypt environment variables because access to the KMS key was denied",
+	"LambdaKMSDisabledException":                 "Lambda could not decrypt environment variables because the specified KMS key is disabled",
+	"LambdaKMSInvalidStateException":             "Lambda could not decrypt environment variables because the state of the KMS key is not valid",
+	"LambdaKMSNotFoundException":                 "Lambda could not decrypt environment variables because the KMS key was not found",
+	"LambdaRequestTooLarge":                      "The size of the request body exceeded 1 MB",
+	"LambdaResourceNotFound":                     "The Lambda function could not be found",
+	"LambdaResponseTooLarge":                     "The size of the response exceeded 1 MB",
+	"LambdaServiceException":                     "Lambda encountered an internal error",
+	"LambdaSubnetIPAddressLimitReachedException": "Lambda couldn't set up VPC access for the function because one or more subnets have no available IP addresses",
+	"LambdaThrottling":                           "The Lambda function was throttled because there were too many requests",
+	"LambdaUnhandled":                            "The Lambda function encountered an unhandled exception",
+	"WAFConnectionError":                         "The load balancer cannot connect to AWS WAF",
+	"WAFConnectionTimeout":                       "The connection to AWS WAF timed out",
+	"WAFResponseReadTimeout":                     "A request to AWS WAF timed out",
+	"WAFServiceError":                            "AWS WAF returned a 5xx error",
+	"WAFUnhandledException":                      "The load balancer encountered an unhandled exception",
+}
+
+// ActionsExpander replaces the comma separated actions_executed field with a list of actions, e.g. ["waf","forward"],
+// and adds an error_reason_description field explaining the error_reason code
+type ActionsExpander struct{}
+
+func (e *ActionsExpander) Transform(record []string, entry *LogEntry) {
+	// actions_executed is only replaced when it is selected, other fields are left as they are
+	if _, ok := entry.Data["actions_executed"]; ok {
+		entry.Data["actions_executed"] = ParseActions(recordValue(record, "actions_executed"))
+	}
+	if description, ok := errorReasonDescriptions[recordValue(record, "error_reason")]; ok {
+		entry.Data["error_reason_description"] = description
+	}
+}
+
+// ParseActions splits an actions_executed value into the individual actions. An empty list is returned
+// when no actions were taken ("-"), so the field is always a JSON array.
+func ParseActions(value string) []string {
+	actions := []string{}
+	if value == "" || value == "-" {
+		return actions
+	}
+	for _, action := range strings.Split(value, ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			actions = append(actions, action)
+		}
+	}
+
+	return actions
+}
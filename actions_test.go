@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseActions(t *testing.T) {
+	assert.Equal(t, []string{"waf", "forward"}, ParseActions("waf,forward"))
+	assert.Equal(t, []string{"authenticate", "fixed-response"}, ParseActions("authenticate,fixed-response"))
+	assert.Equal(t, []string{}, ParseActions("-"))
+	assert.Equal(t, []string{}, ParseActions(""))
+}
+
+func TestActionsExpander(t *testing.T) {
+	newRecord := func(actions, errorReason string) []string {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("actions_executed")] = actions
+		record[getFieldIndex("error_reason")] = errorReason
+		return record
+	}
+	expander := &ActionsExpander{}
+
+	entry := LogEntry{Data: map[string]interface{}{"actions_executed": "authenticate,forward", "error_reason": "AuthInvalidIdToken"}}
+	expander.Transform(newRecord("authenticate,forward", "AuthInvalidIdToken"), &entry)
+	assert.Equal(t, []string{"authenticate", "forward"}, entry.Data["actions_executed"])
+	assert.Equal(t, "The ID token is not valid", entry.Data["error_reason_description"])
+
+	data, err := json.Marshal(entry.Data)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"actions_executed":["authenticate","forward"]`)
+
+	// Unselected fields are not added, unknown or missing error reasons have no description
+	entry = LogEntry{Data: map[string]interface{}{}}
+	expander.Transform(newRecord("forward", "-"), &entry)
+	assert.NotContains(t, entry.Data, "actions_executed")
+	assert.NotContains(t, entry.Data, "error_reason_description")
+}
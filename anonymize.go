@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// redactedValue replaces the values of query parameters
+const redactedValue = "redacted"
+
+// Anonymizer removes identifying information from entries so logs can be shared, e.g. in bug reports: IP
+// addresses are masked to their network (/24 for IPv4, /48 for IPv6), query parameter values are redacted, and
+// hosts, load balancer names and account IDs are renamed consistently, so the same host is always renamed to the
+// same name. It runs on the fields of the entries, after all other transformers.
+type Anonymizer struct {
+	mu      sync.Mutex
+	renamed map[string]string
+	counts  map[string]int
+}
+
+// anonymizedFields are the fields the Anonymizer changes, in a fixed order so the numbering of renamed values only
+// depends on the entries
+var anonymizedFields = []string{
+	"client:port", "target:port", "target:port_list", "domain_name", "request", "redirect_url", "elb",
+	"target_group_arn", "chosen_cert_arn", "account_id", "target_group_account", "target_group_name",
+}
+
+func (a *Anonymizer) Transform(record []string, entry *LogEntry) {
+	for _, field := range anonymizedFields {
+		s, ok := entry.Data[field].(string)
+		if !ok || s == "" || s == "-" {
+			continue
+		}
+		switch field {
+		case "client:port", "target:port":
+			entry.Data[field] = maskAddress(s)
+		case "target:port_list":
+			addresses := strings.Fields(s)
+			for i, address := range addresses {
+				addresses[i] = maskAddress(address)
+			}
+			entry.Data[field] = strings.Join(addresses, " ")
+		case "request":
+			entry.Data[field] = a.anonymizeRequest(s)
+		case "redirect_url":
+			entry.Data[field] = a.anonymizeURL(s)
+		case "domain_name":
+			entry.Data[field] = a.rename("host", s)
+		case "elb":
+			entry.Data[field] = a.anonymizeLoadBalancer(s)
+		case "target_group_arn", "chosen_cert_arn":
+			entry.Data[field] = a.anonymizeARN(s)
+		case "account_id", "target_group_account":
+			entry.Data[field] = a.rename("account", s)
+		case "target_group_name":
+			entry.Data[field] = a.rename("target-group", s)
+		}
+	}
+}
+
+// rename returns the replacement of a value of a kind, e.g. host-1.example for the first host
+func (a *Anonymizer) rename(kind, value string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := kind + "\x00" + value
+	if renamed, ok := a.renamed[key]; ok {
+		return renamed
+	}
+	if a.renamed == nil {
+		a.renamed = make(map[string]string)
+		a.counts = make(map[string]int)
+	}
+	a.counts[kind]++
+	n := a.counts[kind]
+	var renamed string
+	switch kind {
+	case "host":
+		renamed = fmt.Sprintf("host-%d.example", n)
+	case "account":
+		renamed = fmt.Sprintf("%012d", n)
+	default:
+		renamed = fmt.Sprintf("%s-%d", kind, n)
+	}
+	a.renamed[key] = renamed
+
+	return renamed
+}
+
+// anonymizeRequest anonymizes the URL of a request line, e.g. "GET https://example.com:443/?id=1 HTTP/1.1"
+func (a *Anonymizer) anonymizeRequest(request string) string {
+	parts := strings.SplitN(request, " ", 3)
+	if len(parts) != 3 {
+		return request
+	}
+	parts[1] = a.anonymizeURL(parts[1])
+
+	return strings.Join(parts, " ")
+}
+
+// anonymizeURL renames the host and redacts the values of the query parameters, keeping their names
+func (a *Anonymizer) anonymizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redactedValue
+	}
+	if host := u.Hostname(); host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			host = maskIP(ip)
+		} else {
+			host = a.rename("host", host)
+		}
+		if port := u.Port(); port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+		u.Host = host
+	}
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			if name, _, ok := strings.Cut(param, "="); ok {
+				params[i] = name + "=" + redactedValue
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	u.Fragment = ""
+	u.User = nil
+
+	return u.String()
+}
+
+// anonymizeLoadBalancer renames the load balancer in an elb field, e.g. app/my-lb/50dc6c495c0c9188
+func (a *Anonymizer) anonymizeLoadBalancer(name string) string {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 {
+		return a.rename("elb", name)
+	}
+
+	return parts[0] + "/" + a.rename("elb", parts[1]) + "/" + a.rename("elb-id", parts[2])
+}
+
+// anonymizeARN renames the account and resource of an ARN, keeping the partition, service and region
+func (a *Anonymizer) anonymizeARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return redactedValue
+	}
+	parts[4] = a.rename("account", parts[4])
+	if resourceType, _, ok := strings.Cut(parts[5], "/"); ok {
+		parts[5] = resourceType + "/" + a.rename(resourceType, parts[5])
+	} else {
+		parts[5] = a.rename("resource", parts[5])
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// maskAddress masks the IP address of an ip:port address, the port is kept
+func maskAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return redactedValue
+	}
+	if port == "" {
+		return maskIP(ip)
+	}
+
+	return net.JoinHostPort(maskIP(ip), port)
+}
+
+// maskIP keeps the /24 network of IPv4 addresses and the /48 network of IPv6 addresses
+func maskIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
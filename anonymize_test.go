@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnonymizer(t *testing.T) {
+	a := &Anonymizer{}
+	newEntry := func() *LogEntry {
+		return &LogEntry{Data: map[string]interface{}{
+			"client:port":      "192.0.2.104:36217",
+			"target:port":      "[2001:db8:1234:5678::1]:443",
+			"target:port_list": "10.0.0.24:3003 10.0.1.5:3003",
+			"request":          "GET https://example.com:443/api/users?id=42&token=secret HTTP/1.1",
+			"redirect_url":     "https://login.example.org/?next=/home",
+			"domain_name":      "example.com",
+			"elb":              "app/example-prod-lb/50dc6c495c0c9188",
+			"target_group_arn": "arn:aws:elasticloadbalancing:eu-west-1:987654321098:targetgroup/example-prod-tg/6d0ecf831eec9f09",
+			"account_id":       "987654321098",
+			"user_agent":       "curl/8.0",
+			"error_reason":     "-",
+			"received_bytes":   1694,
+		}}
+	}
+
+	entry := newEntry()
+	a.Transform(nil, entry)
+	assert.Equal(t, map[string]interface{}{
+		"client:port":      "192.0.2.0:36217",
+		"target:port":      "[2001:db8:1234::]:443",
+		"target:port_list": "10.0.0.0:3003 10.0.1.0:3003",
+		"request":          "GET https://host-1.example:443/api/users?id=redacted&token=redacted HTTP/1.1",
+		"redirect_url":     "https://host-2.example/?next=redacted",
+		"domain_name":      "host-1.example",
+		"elb":              "app/elb-1/elb-id-1",
+		"target_group_arn": "arn:aws:elasticloadbalancing:eu-west-1:000000000001:targetgroup/targetgroup-1",
+		"account_id":       "000000000001",
+		"user_agent":       "curl/8.0",
+		"error_reason":     "-",
+		"received_bytes":   1694,
+	}, entry.Data)
+
+	// Values are renamed consistently across entries
+	again := newEntry()
+	a.Transform(nil, again)
+	assert.Equal(t, entry.Data, again.Data)
+}
+
+func TestMaskAddress(t *testing.T) {
+	assert.Equal(t, "192.0.2.0:80", maskAddress("192.0.2.255:80"))
+	assert.Equal(t, "192.0.2.0", maskAddress("192.0.2.255"))
+	assert.Equal(t, "[2001:db8:1::]:443", maskAddress("[2001:db8:1:2::3]:443"))
+	assert.Equal(t, redactedValue, maskAddress("not-an-address"))
+}
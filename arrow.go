@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+// arrowBatchRows is the number of rows per Arrow record batch and Parquet row group, large enough for efficient
+// columnar reads while keeping the memory of a batch small
+const arrowBatchRows = 64 * 1024
+
+// arrowWriter writes entries as an Arrow IPC file or a Parquet file, for pandas, Polars and DuckDB, with columns of
+// the type of their field (see columnType, timestamps are in microseconds in UTC), see rowColumns. Rows are
+// collected in record batches of arrowBatchRows, and the file is complete when closed.
+type arrowWriter struct {
+	rowColumns
+	w       *positionWriter
+	format  string // exportArrow or exportParquet
+	types   []string
+	builder *array.RecordBuilder
+	rows    int // Rows in the current batch
+	file    interface{ Write(arrow.Record) error }
+	closer  io.Closer
+}
+
+// positionWriter tracks the bytes written, as the Arrow file writer seeks its position to write the footer, so the
+// output can be a pipe. It also hides the Close of a writer, as the Arrow and Parquet writers close their output
+// when done.
+type positionWriter struct {
+	w   io.Writer
+	pos int64
+}
+
+func (p *positionWriter) Write(data []byte) (int, error) {
+	n, err := p.w.Write(data)
+	p.pos += int64(n)
+
+	return n, err
+}
+
+// Seek only returns the position, other seeks fail
+func (p *positionWriter) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekCurrent {
+		return 0, fmt.Errorf("can't seek %d from %d", offset, whence)
+	}
+
+	return p.pos, nil
+}
+
+func newArrowWriter(w io.Writer, format string, columns []string) *arrowWriter {
+	return &arrowWriter{rowColumns: rowColumns{columns: columns}, w: &positionWriter{w: w}, format: format}
+}
+
+// arrowType returns the Arrow type of a column type
+func arrowType(columnType string) arrow.DataType {
+	switch columnType {
+	case columnInteger:
+		return arrow.PrimitiveTypes.Int64
+	case columnReal:
+		return arrow.PrimitiveTypes.Float64
+	case columnTimestamp:
+		return arrow.FixedWidthTypes.Timestamp_us
+	}
+
+	return arrow.BinaryTypes.String
+}
+
+// write appends an entry to the current batch, creating the file with the columns of the first entry. It returns 0
+// bytes, as the size of a batch is only known when it is written.
+func (a *arrowWriter) write(entry LogEntry) (int, error) {
+	if a.builder == nil {
+		if err := a.create(entry); err != nil {
+			return 0, err
+		}
+	}
+	a.track(entry)
+	for i, column := range a.columns {
+		value, err := typedValue(entry.Data[column], a.types[i])
+		if err != nil {
+			return 0, fmt.Errorf("failed to convert %s: %v", column, err)
+		}
+		field := a.builder.Field(i)
+		switch v := value.(type) {
+		case nil:
+			field.AppendNull()
+		case int64:
+			field.(*array.Int64Builder).Append(v)
+		case float64:
+			field.(*array.Float64Builder).Append(v)
+		case time.Time:
+			field.(*array.TimestampBuilder).Append(arrow.Timestamp(v.UnixMicro()))
+		case string:
+			field.(*array.StringBuilder).Append(v)
+		}
+	}
+	a.rows++
+	if a.rows == arrowBatchRows {
+		return 0, a.flush()
+	}
+
+	return 0, nil
+}
+
+// create starts the file with the schema of the columns of the first entry
+func (a *arrowWriter) create(entry LogEntry) error {
+	a.choose(entry)
+	fields := make([]arrow.Field, len(a.columns))
+	for i, column := range a.columns {
+		a.types = append(a.types, columnType(column, entry.Data[column]))
+		fields[i] = arrow.Field{Name: column, Type: arrowType(a.types[i]), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+	switch a.format {
+	case exportParquet:
+		// Snappy is fast and read by every Parquet reader
+		props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+		file, err := pqarrow.NewFileWriter(schema, a.w, props, pqarrow.DefaultWriterProps())
+		if err != nil {
+			return err
+		}
+		a.file, a.closer = file, file
+	default:
+		file, err := ipc.NewFileWriter(a.w, ipc.WithSchema(schema))
+		if err != nil {
+			return err
+		}
+		a.file, a.closer = file, file
+	}
+	a.builder = array.NewRecordBuilder(memory.DefaultAllocator, schema)
+
+	return nil
+}
+
+// flush writes the rows of the current batch
+func (a *arrowWriter) flush() error {
+	if a.rows == 0 {
+		return nil
+	}
+	record := a.builder.NewRecord()
+	defer record.Release()
+	a.rows = 0
+
+	return a.file.Write(record)
+}
+
+// close writes the last batch and the footer of the file, nothing is written without entries
+func (a *arrowWriter) close() error {
+	if a.builder == nil {
+		return nil
+	}
+	defer a.builder.Release()
+	if err := a.flush(); err != nil {
+		a.closer.Close()
+		return err
+	}
+
+	return a.closer.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportArrow(t *testing.T) {
+	dir := t.TempDir()
+	lines := []string{testRecordLine(0), testRecordLine(1)}
+	// A request that didn't reach a target has no target status code
+	lines = append(lines, strings.Replace(testRecordLine(2), " 0.004 0.024 0.003 203 203 ", " 0.004 -1 -1 502 - ", 1))
+	logFile := filepath.Join(dir, "a.log")
+	require.NoError(t, os.WriteFile(logFile, []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+	s3Objects, err := listLocalObjects(logFile)
+	require.NoError(t, err)
+	fields, err := NewFields("time,elb,elb_status_code,target_status_code,target_processing_time,sent_bytes")
+	require.NoError(t, err)
+
+	check := func(t *testing.T, table arrow.Table) {
+		assert.Equal(t, int64(3), table.NumRows())
+		schema := table.Schema()
+		var names []string
+		for _, field := range schema.Fields() {
+			names = append(names, field.Name)
+		}
+		assert.Equal(t, []string{"time", "elb", "target_processing_time", "elb_status_code", "target_status_code", "sent_bytes"}, names)
+		assert.Equal(t, arrow.TIMESTAMP, schema.Field(0).Type.ID())
+		assert.Equal(t, arrow.STRING, schema.Field(1).Type.ID())
+		assert.Equal(t, arrow.FLOAT64, schema.Field(2).Type.ID())
+		assert.Equal(t, arrow.INT64, schema.Field(3).Type.ID())
+
+		times := table.Column(0).Data().Chunk(0).(*array.Timestamp)
+		assert.Equal(t, time.Date(2024, 3, 21, 16, 10, 26, 71854000, time.UTC), times.Value(0).ToTime(arrow.Microsecond))
+		statuses := table.Column(3).Data().Chunk(0).(*array.Int64)
+		assert.Equal(t, []int64{203, 203, 502}, statuses.Int64Values())
+		targetStatuses := table.Column(4).Data().Chunk(0).(*array.Int64)
+		assert.True(t, targetStatuses.IsNull(2))
+		targetTimes := table.Column(2).Data().Chunk(0).(*array.Float64)
+		assert.Equal(t, -1.0, targetTimes.Value(2))
+	}
+
+	t.Run("Arrow", func(t *testing.T) {
+		exporter := &Exporter{Source: NewSources(nil), Fields: fields, Format: exportArrow}
+		var out bytes.Buffer
+		entries, err := exporter.Export(s3Objects, &out)
+		require.NoError(t, err)
+		assert.Equal(t, 3, entries)
+
+		reader, err := ipc.NewFileReader(bytes.NewReader(out.Bytes()))
+		require.NoError(t, err)
+		defer reader.Close()
+		var records []arrow.Record
+		for i := 0; i < reader.NumRecords(); i++ {
+			record, err := reader.Record(i)
+			require.NoError(t, err)
+			records = append(records, record)
+		}
+		table := array.NewTableFromRecords(reader.Schema(), records)
+		defer table.Release()
+		check(t, table)
+	})
+
+	t.Run("Parquet", func(t *testing.T) {
+		exporter := &Exporter{Source: NewSources(nil), Fields: fields, Format: exportParquet}
+		var out bytes.Buffer
+		entries, err := exporter.Export(s3Objects, &out)
+		require.NoError(t, err)
+		assert.Equal(t, 3, entries)
+
+		reader, err := file.NewParquetReader(bytes.NewReader(out.Bytes()))
+		require.NoError(t, err)
+		defer reader.Close()
+		fileReader, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+		require.NoError(t, err)
+		table, err := fileReader.ReadTable(context.Background())
+		require.NoError(t, err)
+		defer table.Release()
+		check(t, table)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		exporter := &Exporter{Source: NewSources(nil), Fields: fields, Format: exportArrow}
+		var out bytes.Buffer
+		entries, err := exporter.Export(nil, &out)
+		require.NoError(t, err)
+		assert.Zero(t, entries)
+		assert.Zero(t, out.Len())
+	})
+}
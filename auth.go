@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// AuthenticationTagger sets authenticated=true on requests that passed an authenticate action (OIDC or Cognito),
+// and false otherwise. The claims themselves are not in the access logs, so TargetGroupPattern optionally also marks
+// requests to target groups whose names match a pattern, e.g. targets behind authenticating rules that log the
+// x-amzn-oidc-* headers themselves, such that their logs can be joined on the trace ID.
+type AuthenticationTagger struct {
+	TargetGroupPattern string
+}
+
+func (t *AuthenticationTagger) Transform(record []string, entry *LogEntry) {
+	entry.Data["authenticated"] = t.authenticated(record)
+}
+
+func (t *AuthenticationTagger) authenticated(record []string) bool {
+	for _, action := range strings.Split(recordValue(record, "actions_executed"), ",") {
+		if action == "authenticate" {
+			return true
+		}
+	}
+	if t.TargetGroupPattern == "" {
+		return false
+	}
+	targetGroup, err := ParseTargetGroupARN(recordValue(record, "target_group_arn"))
+	if err != nil {
+		return false
+	}
+	// The pattern is validated when the config is loaded
+	matched, _ := path.Match(t.TargetGroupPattern, targetGroup.Name)
+
+	return matched
+}
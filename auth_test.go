@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticationTagger(t *testing.T) {
+	newRecord := func(actions, targetGroupARN string) []string {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("actions_executed")] = actions
+		record[getFieldIndex("target_group_arn")] = targetGroupARN
+		return record
+	}
+	const internalTargetGroup = "arn:aws:elasticloadbalancing:eu-west-1:123456789012:targetgroup/internal-auth-api/6d0ecf831eec9f09"
+	const publicTargetGroup = "arn:aws:elasticloadbalancing:eu-west-1:123456789012:targetgroup/public-web/6d0ecf831eec9f09"
+
+	tests := []struct {
+		name    string
+		pattern string
+		record  []string
+		want    bool
+	}{
+		{"Authenticate action", "", newRecord("authenticate,forward", publicTargetGroup), true},
+		{"Anonymous", "", newRecord("forward", publicTargetGroup), false},
+		{"No actions", "", newRecord("-", "-"), false},
+		{"Matching target group", "*-auth-*", newRecord("forward", internalTargetGroup), true},
+		{"Other target group", "*-auth-*", newRecord("forward", publicTargetGroup), false},
+		{"No target group", "*-auth-*", newRecord("redirect", "-"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := LogEntry{Data: map[string]interface{}{}}
+			(&AuthenticationTagger{TargetGroupPattern: tt.pattern}).Transform(tt.record, &entry)
+			assert.Equal(t, tt.want, entry.Data["authenticated"])
+		})
+	}
+}
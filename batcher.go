@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// Batcher is the unit of work that turns the entries of an object into PutLogEvents batches. Entries are batched
+// per destination and UTC day, as PutLogEvents rejects batches spanning more than 24 hours, and every batch is
+// sent when it is full, when the flush interval elapses, and when the entries are exhausted. After the first
+// batch that fails to send, the remaining entries are discarded and the error is returned, so a retry sends as
+// few entries twice as possible. Sending and the clock are injected, so partial failures can be tested.
+type Batcher struct {
+	Destination func(entry LogEntry) LogConfig
+	// Ensure makes sure a destination exists before its first batch, errors are logged as sending will fail
+	Ensure func(destination LogConfig) error
+	// Send sends a batch and resets it, counting it and marking its records sent on success
+	Send              func(destination LogConfig, batch *eventBatch) error
+	Strict            bool // Fail on entries that can't be sent instead of dropping them
+	FlushInterval     time.Duration
+	ReorderBufferSize int
+	Counters          *sendCounters
+	Progress          *objectProgress
+	// newTicker returns the channel of a ticker and a function to stop it, nil uses time.NewTicker
+	newTicker func(interval time.Duration) (<-chan time.Time, func())
+	batches   map[batchKey]*eventBatch
+	err       error
+}
+
+// Run consumes the entries until the channel is closed and returns the first error
+func (b *Batcher) Run(entries <-chan LogEntry) error {
+	b.batches = make(map[batchKey]*eventBatch)
+	b.err = nil
+	// A nil channel never receives, so without a flush interval batches are only sent by size and count
+	var flush <-chan time.Time
+	if b.FlushInterval > 0 {
+		var stop func()
+		flush, stop = b.ticker(b.FlushInterval)
+		defer stop()
+	}
+	var reorder *ReorderBuffer
+	if b.ReorderBufferSize > 0 {
+		reorder = NewReorderBuffer(b.ReorderBufferSize)
+	}
+	// flushAll sends the buffered entries as well, so a buffer that doesn't fill up doesn't hold them back
+	flushAll := func() {
+		if reorder != nil {
+			for _, entry := range reorder.Drain() {
+				b.add(entry)
+			}
+		}
+		b.flushAll()
+	}
+	for {
+		select {
+		case <-flush:
+			flushAll()
+		case entry, ok := <-entries:
+			if !ok {
+				// Send any remaining events
+				flushAll()
+				return b.err
+			}
+			b.Progress.receive(entry.Record)
+			if reorder != nil {
+				if entry, ok = reorder.Push(entry); !ok {
+					continue
+				}
+			}
+			b.add(entry)
+		}
+	}
+}
+
+func (b *Batcher) ticker(interval time.Duration) (<-chan time.Time, func()) {
+	if b.newTicker != nil {
+		return b.newTicker(interval)
+	}
+	ticker := time.NewTicker(interval)
+
+	return ticker.C, ticker.Stop
+}
+
+// add adds an entry to the batch of its destination and day, sending the batch first if the entry doesn't fit
+func (b *Batcher) add(entry LogEntry) {
+	if b.err != nil && b.Strict {
+		return
+	}
+	// Transformers such as the latency breakdown may remove every field, which would send an event of {}
+	if len(entry.Data) == 0 {
+		b.drop(entry, &b.Counters.blank, errors.New("entry without fields"))
+		return
+	}
+	if entry.Size == 0 {
+		if err := entry.encode(); err != nil {
+			logf(verbosityNormal, "%v", err)
+			b.drop(entry, &b.Counters.unencodable, err)
+			return
+		}
+	}
+	if entry.Size > maxEventSize {
+		printf(verbosityNormal, "dropping log entry of %d bytes, exceeding the maximum event size of %d bytes\n", entry.Size, maxEventSize)
+		b.drop(entry, &b.Counters.oversized, fmt.Errorf("entry of %d bytes exceeds the maximum event size of %d bytes", entry.Size, maxEventSize))
+		return
+	}
+	event := &cloudwatchlogs.InputLogEvent{
+		Message:   aws.String(entry.Message),
+		Timestamp: aws.Int64(entry.Timestamp.UnixMilli()),
+	}
+	destination := b.Destination(entry)
+	key := batchKey{destination: destination, day: entry.Timestamp.UTC().Format(time.DateOnly)}
+	batch, ok := b.batches[key]
+	if !ok {
+		if b.Ensure != nil {
+			if err := b.Ensure(destination); err != nil {
+				fmt.Println("error creating log group and stream:", err)
+			}
+		}
+		batch = &eventBatch{}
+		b.batches[key] = batch
+	}
+	// Send the batch first if adding this event would exceed the size or count limit
+	if len(batch.events) > 0 && (batch.size+entry.Size > maxBatchSize || len(batch.events) >= maxBatchCount) {
+		b.send(destination, batch)
+	}
+	batch.events = append(batch.events, event)
+	batch.records = append(batch.records, entry.Record)
+	batch.size += entry.Size
+}
+
+// drop skips an entry that can't be sent and counts it, or fails the object when strict
+func (b *Batcher) drop(entry LogEntry, counter *SafeCounter, err error) {
+	if b.Strict {
+		if b.err == nil {
+			b.err = fmt.Errorf("record %d: %v", entry.Record+1, err)
+		}
+		return
+	}
+	counter.Increment(1)
+	b.Progress.markSent(entry.Record)
+}
+
+// send sends a batch unless an earlier batch failed, in which case the batch is discarded
+func (b *Batcher) send(destination LogConfig, batch *eventBatch) {
+	if b.err != nil {
+		batch.reset()
+		return
+	}
+	b.err = b.Send(destination, batch)
+}
+
+func (b *Batcher) flushAll() {
+	for key, batch := range b.batches {
+		if len(batch.events) > 0 {
+			b.send(key.destination, batch)
+		}
+	}
+}
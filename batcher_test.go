@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chaosCloudWatch fails every Nth PutLogEvents request, storing nothing for it, and stores the records of the others
+type chaosCloudWatch struct {
+	MockCloudWatchLogsClient
+	every  int
+	mu     sync.Mutex
+	calls  int
+	failed int
+	stored map[int]int // Number of times every record was stored
+}
+
+func (c *chaosCloudWatch) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.calls%c.every == 0 {
+		c.failed++
+		return nil, errors.New("ServiceUnavailableException")
+	}
+	for _, event := range input.LogEvents {
+		var message struct{ Record int }
+		if err := json.Unmarshal([]byte(aws.StringValue(event.Message)), &message); err != nil {
+			return nil, err
+		}
+		if c.stored == nil {
+			c.stored = make(map[int]int)
+		}
+		c.stored[message.Record]++
+	}
+
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+// manualTicker returns a ticker for the batcher that only ticks when a value is sent on the channel
+func manualTicker(ticks chan time.Time) func(time.Duration) (<-chan time.Time, func()) {
+	return func(time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() {}
+	}
+}
+
+func TestBatcherFlushInterval(t *testing.T) {
+	var sent [][]int
+	ticks := make(chan time.Time)
+	batcher := &Batcher{
+		Destination: func(LogEntry) LogConfig { return LogConfig{LogGroupName: "group", LogStreamName: "stream"} },
+		Send: func(destination LogConfig, batch *eventBatch) error {
+			sent = append(sent, batch.records)
+			batch.reset()
+			return nil
+		},
+		FlushInterval: time.Hour,
+		Counters:      &sendCounters{},
+		newTicker:     manualTicker(ticks),
+	}
+	entries := make(chan LogEntry)
+	done := make(chan error)
+	go func() { done <- batcher.Run(entries) }()
+
+	// The channels are unbuffered, so every tick is received after the entries sent before it
+	entries <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: time.Now(), Record: 0}
+	entries <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: time.Now(), Record: 1}
+	ticks <- time.Now()
+	ticks <- time.Now()
+	entries <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: time.Now(), Record: 2}
+	close(entries)
+
+	require.NoError(t, <-done)
+	assert.Equal(t, [][]int{{0, 1}, {2}}, sent)
+
+	t.Run("Reordered entries", func(t *testing.T) {
+		sent = nil
+		batcher.ReorderBufferSize = 10
+		entries := make(chan LogEntry)
+		go func() { done <- batcher.Run(entries) }()
+
+		// The buffer isn't full, the tick sends its entries ordered by timestamp
+		now := time.Now()
+		entries <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: now.Add(time.Second), Record: 0}
+		entries <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: now, Record: 1}
+		ticks <- time.Now()
+		ticks <- time.Now()
+		assert.Equal(t, [][]int{{1, 0}}, sent)
+		entries <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: now.Add(2 * time.Second), Record: 2}
+		close(entries)
+
+		require.NoError(t, <-done)
+		assert.Equal(t, [][]int{{1, 0}, {2}}, sent)
+	})
+}
+
+func TestBatcherStopsAfterFailure(t *testing.T) {
+	calls := 0
+	ticks := make(chan time.Time)
+	batcher := &Batcher{
+		Destination: func(LogEntry) LogConfig { return LogConfig{LogGroupName: "group", LogStreamName: "stream"} },
+		Send: func(destination LogConfig, batch *eventBatch) error {
+			calls++
+			batch.reset()
+			return errors.New("throttled")
+		},
+		FlushInterval: time.Hour,
+		Counters:      &sendCounters{},
+		newTicker:     manualTicker(ticks),
+	}
+	entries := make(chan LogEntry)
+	done := make(chan error)
+	go func() { done <- batcher.Run(entries) }()
+
+	entries <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: time.Now(), Record: 0}
+	ticks <- time.Now()
+	entries <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: time.Now(), Record: 1}
+	close(entries)
+
+	// The remaining entries are discarded instead of sent, so a retry sends as few entries twice as possible
+	require.EqualError(t, <-done, "throttled")
+	assert.Equal(t, 1, calls)
+}
+
+// runChaos sends the records of an object with a flush after every flushEvery entries, retrying the object
+// after a failure like RETRY_FAILED_OBJECTS does, and returns the number of attempts
+func runChaos(t *testing.T, cw *chaosCloudWatch, records, flushEvery int, destination func(LogEntry) LogConfig) int {
+	lp := &CloudWatchLogProcessor{cwClient: cw, logConfig: LogConfig{LogGroupName: "group", LogStreamName: "stream"}}
+	store := &MemoryProgressStore{}
+	for attempt := 1; attempt <= 1000; attempt++ {
+		progress := newObjectProgress(store, "bucket", "key", "etag")
+		counters := &sendCounters{}
+		ticks := make(chan time.Time)
+		batcher := &Batcher{
+			Destination: destination,
+			Send: func(destination LogConfig, batch *eventBatch) error {
+				return lp.sendBatch(destination, batch, counters, progress)
+			},
+			FlushInterval: time.Hour,
+			Counters:      counters,
+			Progress:      progress,
+			newTicker:     manualTicker(ticks),
+		}
+		entries := make(chan LogEntry)
+		done := make(chan error)
+		go func() { done <- batcher.Run(entries) }()
+		// Like the ResumeFilter, only the records after the saved progress are sent again
+		for record := progress.resumeAfter(); record < records; record++ {
+			entries <- LogEntry{Data: map[string]interface{}{"record": record}, Timestamp: time.Now(), Record: record}
+			if (record+1)%flushEvery == 0 {
+				ticks <- time.Now()
+			}
+		}
+		close(entries)
+		if err := <-done; err == nil {
+			progress.clear()
+			return attempt
+		}
+	}
+	t.Fatal("object was not sent after 1000 attempts")
+
+	return 0
+}
+
+func TestBatcherChaos(t *testing.T) {
+	for _, every := range []int{2, 3, 7} {
+		t.Run(fmt.Sprintf("Every %dth PutLogEvents fails, single destination", every), func(t *testing.T) {
+			cw := &chaosCloudWatch{every: every}
+			attempts := runChaos(t, cw, 100, 5, func(LogEntry) LogConfig {
+				return LogConfig{LogGroupName: "group", LogStreamName: "stream"}
+			})
+
+			assert.Greater(t, cw.failed, 0)
+			assert.Equal(t, cw.failed+1, attempts)
+			// Batches to a single destination are sent in record order, so the progress covers every
+			// stored record and no event is lost or stored twice
+			require.Len(t, cw.stored, 100)
+			for record := 0; record < 100; record++ {
+				assert.Equal(t, 1, cw.stored[record], "record %d", record)
+			}
+		})
+
+		t.Run(fmt.Sprintf("Every %dth PutLogEvents fails, two destinations", every), func(t *testing.T) {
+			cw := &chaosCloudWatch{every: every}
+			runChaos(t, cw, 100, 5, func(entry LogEntry) LogConfig {
+				return LogConfig{LogGroupName: "group", LogStreamName: fmt.Sprintf("stream-%d", entry.Record%2)}
+			})
+
+			// Batches to different destinations complete out of record order, and the progress only covers
+			// the leading records that were all sent, so events may be stored twice but never lost. Only the
+			// batch of the other destination flushed with the failed one is ahead of the progress.
+			require.Len(t, cw.stored, 100)
+			duplicates := 0
+			for record := 0; record < 100; record++ {
+				assert.GreaterOrEqual(t, cw.stored[record], 1, "record %d", record)
+				duplicates += cw.stored[record] - 1
+			}
+			assert.LessOrEqual(t, duplicates, cw.failed*5)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Budget limits the objects of a CLI run, so a large prefix is not shipped by accident. A zero limit means no limit.
+type Budget struct {
+	MaxObjects int
+	MaxBytes   int64 // Compressed size of the objects as listed, objects of unknown size count as 0
+}
+
+// Apply returns the objects within the budget in their processing order, and the remaining objects. The first
+// object is always included, so a run with a budget smaller than a single object still makes progress.
+func (b Budget) Apply(s3Objects []S3ObjectInfo) (selected, remaining []S3ObjectInfo) {
+	var bytes int64
+	for i, s3Object := range s3Objects {
+		bytes += aws.Int64Value(s3Object.Size)
+		if i > 0 && ((b.MaxObjects > 0 && i >= b.MaxObjects) || (b.MaxBytes > 0 && bytes > b.MaxBytes)) {
+			return s3Objects[:i], s3Objects[i:]
+		}
+	}
+
+	return s3Objects, nil
+}
+
+// startAfter returns the objects after the one with the key of a resume marker, in processing order. The same
+// listing and order must be used as in the run that printed the marker.
+func startAfter(s3Objects []S3ObjectInfo, marker string) ([]S3ObjectInfo, error) {
+	for i, s3Object := range s3Objects {
+		if s3Object.Key == marker {
+			return s3Objects[i+1:], nil
+		}
+	}
+
+	return nil, fmt.Errorf("resume marker '%s' is not one of the %d listed objects", marker, len(s3Objects))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+	s3Objects := []S3ObjectInfo{
+		{Key: "a", Size: aws.Int64(100)},
+		{Key: "b", Size: aws.Int64(200)},
+		{Key: "c"},
+		{Key: "d", Size: aws.Int64(300)},
+	}
+	tests := []struct {
+		name          string
+		budget        Budget
+		wantSelected  int
+		wantRemaining int
+	}{
+		{"No limits", Budget{}, 4, 0},
+		{"Max objects", Budget{MaxObjects: 2}, 2, 2},
+		{"Max objects above count", Budget{MaxObjects: 10}, 4, 0},
+		{"Max bytes", Budget{MaxBytes: 300}, 3, 1},
+		{"Max bytes below first object", Budget{MaxBytes: 50}, 1, 3},
+		{"Both limits", Budget{MaxObjects: 3, MaxBytes: 150}, 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, remaining := tt.budget.Apply(s3Objects)
+			assert.Len(t, selected, tt.wantSelected)
+			assert.Len(t, remaining, tt.wantRemaining)
+		})
+	}
+}
+
+func TestStartAfter(t *testing.T) {
+	s3Objects := []S3ObjectInfo{{Key: "c"}, {Key: "a"}, {Key: "b"}}
+
+	remaining, err := startAfter(s3Objects, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, []S3ObjectInfo{{Key: "b"}}, remaining)
+
+	remaining, err = startAfter(s3Objects, "b")
+	assert.NoError(t, err)
+	assert.Empty(t, remaining)
+
+	_, err = startAfter(s3Objects, "d")
+	assert.ErrorContains(t, err, "resume marker 'd' is not one of the 3 listed objects")
+}
@@ -0,0 +1,35 @@
+package main
+
+import "sync"
+
+// CheckpointStore remembers the ETag of every object that was processed successfully,
+// so a re-delivered key with an identical ETag can be skipped while a new object
+// written under the same key is processed again
+type CheckpointStore interface {
+	ProcessedETag(bucket, key string) (etag string, ok bool)
+	MarkProcessed(bucket, key, etag string)
+}
+
+// MemoryCheckpointStore is a CheckpointStore that lives as long as the process, which includes
+// warm Lambda invocations. The zero value is ready to use.
+type MemoryCheckpointStore struct {
+	mu    sync.Mutex
+	etags map[string]string
+}
+
+func (s *MemoryCheckpointStore) ProcessedETag(bucket, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	etag, ok := s.etags[bucket+"/"+key]
+
+	return etag, ok
+}
+
+func (s *MemoryCheckpointStore) MarkProcessed(bucket, key, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.etags == nil {
+		s.etags = make(map[string]string)
+	}
+	s.etags[bucket+"/"+key] = etag
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCheckpointStore(t *testing.T) {
+	var store MemoryCheckpointStore
+
+	_, ok := store.ProcessedETag("bucket", "key")
+	assert.False(t, ok)
+
+	store.MarkProcessed("bucket", "key", `"etag1"`)
+	etag, ok := store.ProcessedETag("bucket", "key")
+	assert.True(t, ok)
+	assert.Equal(t, `"etag1"`, etag)
+
+	store.MarkProcessed("bucket", "key", `"etag2"`)
+	etag, _ = store.ProcessedETag("bucket", "key")
+	assert.Equal(t, `"etag2"`, etag)
+
+	_, ok = store.ProcessedETag("other-bucket", "key")
+	assert.False(t, ok)
+}
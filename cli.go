@@ -0,0 +1,855 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// Exit codes of the CLI
+const (
+	exitSuccess        = 0 // All objects were processed successfully
+	exitTotalFailure   = 1 // Nothing could be processed, e.g. invalid arguments or every object failed
+	exitPartialFailure = 2 // Some objects failed or their events differ after sending, the others were processed
+)
+
+// runCLI processes the objects under the S3 URL given in args, listed by an S3 Inventory report, or the local
+// files or standard input given in args, and returns the exit code
+func runCLI(h *Handler, args []string, stdout, stderr io.Writer) int {
+	levelFlags, args := parseLeadingVerbosity(args)
+	if levelFlags.given() {
+		verbosity = levelFlags.level()
+	}
+	if len(args) > 0 && args[0] == "replay" {
+		return runReplay(h, args[1:], stderr)
+	}
+	if len(args) > 0 && args[0] == "export" {
+		return runExport(h, args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "kinesis" {
+		return runKinesis(h, args[1:], stderr)
+	}
+	if len(args) > 0 && args[0] == "serve" {
+		return runServe(h, args[1:], stderr)
+	}
+	if len(args) > 0 && args[0] == "validate" {
+		return runValidate(h, args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "stats" {
+		return runStats(h, args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "setup" {
+		return runSetup(h, args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "selftest" {
+		return runSelfTest(h, args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "tail" {
+		return runTail(h, args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "compare" {
+		return runCompare(h, args[1:], stdout, stderr)
+	}
+	flags := flag.NewFlagSet("elb-logs-to-cloudwatch", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch [flags] s3://<bucket>/<prefix>")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch [flags] <file or directory>|-")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch [flags] --org [--date yyyy/mm/dd] s3://<bucket>/<root>")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch [flags] --watch <interval> [--health-addr :8080] s3://<bucket>/<prefix>")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch [flags] --inventory s3://<bucket>/<path>/manifest.json [s3://<bucket>/<prefix>]")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch replay [<directory>|s3://<bucket>/<prefix>]")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch export [--anonymize] [--format ndjson|csv|tsv|sqlite|arrow|parquet|human] [--columns <fields>] [--raw-formulas] [--out <file>] s3://<bucket>/<prefix>|<file or directory>|-")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch stats [--top 10] s3://<bucket>/<prefix>|<file or directory>|-")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch tail [--interval 30s] [--filter <field><operator><value>]... [--columns <fields>] [--format text|human] s3://<bucket>/<prefix>")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch compare [--a-range <from>/<to>] [--b-range <from>/<to>] <location a> [<location b>]")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch kinesis [--start latest|trim_horizon] [--consumer <name>] <stream name or ARN>")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch serve [--addr :8080]")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch validate")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch selftest [--keep] [--timeout 1m]")
+		fmt.Fprintln(stderr, "       elb-logs-to-cloudwatch setup --principal <arn> [--apply] [--role <arn>]")
+		fmt.Fprintln(stderr, "-q, -v and -vv before a command apply to the command")
+		flags.PrintDefaults()
+	}
+	failuresOut := flags.String("failures-out", "", "write the objects that failed with their error as JSON to this file")
+	inventory := flags.String("inventory", "", "read the objects from the `manifest.json` of an S3 Inventory report instead of listing them, optionally limited to an S3 URL")
+	org := flags.Bool("org", false, "treat the S3 URL as the root of a central log bucket with AWSLogs/<account-id>/elasticloadbalancing/<region>/ prefixes, limited by ACCOUNTS and REGIONS")
+	date := flags.String("date", "", "with --org, only process the logs of this `yyyy/mm/dd` date, or a prefix of it such as yyyy/mm")
+	shift := flags.String("timestamp-shift", "", "move the timestamps of all entries by a `duration` such as 720h, or by now to end at the current time, keeping the original time in original_timestamp")
+	verify := flags.Bool("verify", false, "after sending an object, read its events back from CloudWatch Logs and report the object as unverified if any are missing or duplicated")
+	manifest := flags.String("manifest", h.config.Manifest, "write a manifest of the processed objects with their entries, bytes, time range and SHA-256 to this `file or s3:// URL`, a location ending with / gets a manifest per run")
+	watch := flags.Duration("watch", 0, "keep running and process new objects under the S3 URL every `interval`, such as 1m")
+	healthAddr := flags.String("health-addr", "", "with --watch, serve /healthz on this `address`, such as :8080")
+	staleAfter := flags.Duration("stale-after", defaultStaleAfter, "with --watch, report unhealthy when there was no progress for this `duration`")
+	report := flags.String("report", "", "write the status of every object as CSV to this file, objects that are done according to an existing report are skipped")
+	reportJSON := flags.String("report-json", "", "write the summary, the outcome of every object and the configuration of the run as JSON to this `file`")
+	order := flags.String("order", "", "process the objects `newest-first` or oldest-first by the time in their file name instead of in the listed order")
+	maxObjects := flags.Int("max-objects", 0, "stop after this `number` of objects and print the resume marker")
+	maxBytes := flags.Int64("max-bytes", 0, "stop before the objects exceed this compressed size in `bytes` and print the resume marker")
+	yes := flags.Bool("yes", false, "process the objects in an S3 bucket without asking for confirmation, required without a terminal")
+	progress := flags.String("progress", "", "write progress events as JSON lines to stderr, the only `format` is json")
+	progressInterval := flags.Duration("progress-interval", defaultProgressInterval, "with --progress, write an event every `interval`")
+	quiet := flags.Bool("q", false, "only write errors")
+	verbose := flags.Bool("v", false, "also write the details of every object")
+	debug := flags.Bool("vv", false, "also write the details of every object and of every batch sent to CloudWatch")
+	resumeMarker := flags.String("start-after", "", "resume after the object with this `key`, as printed when --max-objects or --max-bytes was reached, with the same arguments")
+	if err := flags.Parse(args); err != nil {
+		return exitTotalFailure
+	}
+	if (*inventory == "" && flags.NArg() != 1) || flags.NArg() > 1 {
+		flags.Usage()
+		return exitTotalFailure
+	}
+	// Before or after the other flags
+	levelFlags.quiet = levelFlags.quiet || *quiet
+	levelFlags.verbose = levelFlags.verbose || *verbose
+	levelFlags.debug = levelFlags.debug || *debug
+	verbosity = levelFlags.level()
+	if fields, err := NewFields(h.config.Fields); err == nil {
+		logEffectiveConfig(h.config, fields)
+	}
+	if _, err := ParseObjectOrder(*order); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	if err := ParseProgressFormat(*progress); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+
+	if err := h.setUpDestinations(); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	h.config.Manifest = *manifest
+	if *watch > 0 {
+		return runWatch(h, flags.Arg(0), *watch, *healthAddr, *staleAfter)
+	}
+
+	started := time.Now()
+	var s3Objects []S3ObjectInfo
+	var err error
+	switch {
+	case *inventory != "":
+		s3Objects, err = h.listInventoryObjects(*inventory, flags.Arg(0))
+	case *org:
+		s3Objects, err = h.listOrgObjects(flags.Arg(0), *date)
+	case strings.HasPrefix(flags.Arg(0), "s3://"):
+		s3Objects, err = h.listS3URL(flags.Arg(0))
+	default:
+		s3Objects, err = listLocalObjects(flags.Arg(0))
+	}
+	if err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	var offset time.Duration
+	if *shift != "" {
+		offset, err = timestampShift(*shift, s3Objects, time.Now())
+		if err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+		logf(verbosityNormal, "shifting timestamps by %s", offset)
+		for i := range s3Objects {
+			s3Objects[i].TimestampShift = offset
+		}
+	}
+	for i := range s3Objects {
+		s3Objects[i].Verify = *verify
+	}
+	sortObjects(s3Objects, *order)
+	if *resumeMarker != "" {
+		if s3Objects, err = startAfter(s3Objects, *resumeMarker); err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+	}
+	var done []ObjectStatus
+	if *report != "" {
+		previous, err := readReport(*report)
+		if err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+		s3Objects, done = resumeObjects(s3Objects, previous)
+		if len(done) > 0 {
+			logf(verbosityNormal, "skipping %d objects that are done according to %s", len(done), *report)
+		}
+	}
+
+	s3Objects, remaining := Budget{MaxObjects: *maxObjects, MaxBytes: *maxBytes}.Apply(s3Objects)
+
+	remote := *inventory != "" || *org || strings.HasPrefix(flags.Arg(0), "s3://")
+	if remote && len(s3Objects) > 0 {
+		if !*yes || verbosity >= verbosityNormal {
+			newVolumePreview(s3Objects).Write(stderr)
+		}
+		if !*yes {
+			if err := confirmRun(stderr); err != nil {
+				log.Println(err)
+				return exitTotalFailure
+			}
+		}
+	}
+
+	if *report != "" {
+		if h.checkpoint, err = newReportCheckpoint(*report, done); err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+	}
+	if *progress != "" {
+		h.progress = NewProgressEvents(stderr, *progressInterval, len(s3Objects))
+		h.progress.Start()
+	}
+	result, statuses, err := h.processS3ObjectsWithStatuses(s3Objects)
+	h.progress.Finish()
+	if len(remaining) > 0 {
+		logf(verbosityNormal, "budget reached, %d objects were not processed, continue with --start-after %s", len(remaining), s3Objects[len(s3Objects)-1].Key)
+	}
+	if *failuresOut != "" {
+		if err := writeFailures(*failuresOut, result.Failures); err != nil {
+			log.Println(err)
+		}
+	}
+	if *report != "" {
+		if err := h.checkpoint.close(); err != nil {
+			log.Println(err)
+		}
+		if err := writeReport(*report, append(done, statuses...)); err != nil {
+			log.Println(err)
+		}
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	code := exitCode(result, err)
+	if *reportJSON != "" {
+		jsonReport := newJSONReport(started, time.Now(), h.config, offset, result, append(done, statuses...), len(done), code)
+		jsonReport.Config.Verify = *verify
+		if err := writeJSONReport(*reportJSON, jsonReport); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return code
+}
+
+// runWatch processes new objects under the S3 URL every interval until the process is interrupted or terminated
+func runWatch(h *Handler, url string, interval time.Duration, healthAddr string, staleAfter time.Duration) int {
+	bucket, prefix, err := ParseS3URL(url)
+	if err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	h.health = NewHealth(staleAfter)
+	if healthAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", h.health)
+		server := &http.Server{Addr: healthAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		listener, err := net.Listen("tcp", healthAddr)
+		if err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+		go func() { _ = server.Serve(listener) }()
+		defer server.Close()
+		log.Printf("serving /healthz on %s", listener.Addr())
+	}
+	watcher := &Watcher{Handler: h, Bucket: bucket, Prefix: prefix, Interval: interval, Health: h.health}
+	if h.config.LeaseTable != "" {
+		// The lease outlives a few missed renewals, so it only moves to another replica when this one is gone
+		watcher.Lease = NewLease(h.dynamoDB, h.config.LeaseTable, url, 3*interval)
+		log.Printf("using lease %s in %s as %s", url, h.config.LeaseTable, watcher.Lease.Owner)
+	}
+	watcher.Run(stopSignal())
+
+	return exitSuccess
+}
+
+// runKinesis processes the records of the Kinesis stream given in args until the process is interrupted or terminated
+func runKinesis(h *Handler, args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("elb-logs-to-cloudwatch kinesis", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch kinesis [--start latest|trim_horizon] [--consumer <name>] <stream name or ARN>")
+		flags.PrintDefaults()
+	}
+	start := flags.String("start", "latest", "read the shards from the `position` latest, or trim_horizon for the oldest records")
+	consumerName := flags.String("consumer", "", "use enhanced fan-out with a consumer of this `name`, registered if needed")
+	if err := flags.Parse(args); err != nil {
+		return exitTotalFailure
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitTotalFailure
+	}
+	iteratorType := strings.ToUpper(*start)
+	if iteratorType != kinesis.ShardIteratorTypeLatest && iteratorType != kinesis.ShardIteratorTypeTrimHorizon {
+		fmt.Fprintf(stderr, "invalid --start %q, must be latest or trim_horizon\n", *start)
+		return exitTotalFailure
+	}
+	processor, ok := h.lp.(DataProcessor)
+	if !ok {
+		log.Println("the log processor can't process Kinesis records")
+		return exitTotalFailure
+	}
+	if err := h.setUpDestinations(); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	consumer := &KinesisConsumer{
+		Client:    kinesis.New(h.session),
+		Stream:    flags.Arg(0),
+		Processor: processor,
+		Start:     iteratorType,
+		Consumer:  *consumerName,
+	}
+	log.Printf("reading Kinesis stream %s", consumer.Stream)
+	if err := consumer.Run(stopSignal()); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+
+	return exitSuccess
+}
+
+// runServe receives logs pushed to /logs until the process is interrupted or terminated
+func runServe(h *Handler, args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("elb-logs-to-cloudwatch serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", ":8080", "listen on this `address`")
+	if err := flags.Parse(args); err != nil {
+		return exitTotalFailure
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch serve [--addr :8080]")
+		return exitTotalFailure
+	}
+	if h.config.ReceiverToken == "" {
+		fmt.Fprintln(stderr, "RECEIVER_TOKEN is required to receive logs")
+		return exitTotalFailure
+	}
+	processor, ok := h.lp.(interface {
+		DataProcessor
+		NDJSONProcessor
+	})
+	if !ok {
+		log.Println("the log processor can't process pushed logs")
+		return exitTotalFailure
+	}
+	if err := h.setUpDestinations(); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/logs", &Receiver{Logs: processor, NDJSON: processor, Token: h.config.ReceiverToken})
+	server := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	log.Printf("receiving logs on %s/logs", listener.Addr())
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-stopSignal()
+		// Finish the requests that are being processed
+		_ = server.Shutdown(context.Background())
+	}()
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	<-shutdown
+
+	return exitSuccess
+}
+
+// runStats writes how the bytes that would be sent for the objects given in args are divided over the fields and
+// the most frequent values of the traffic, without sending anything, and returns the exit code
+func runStats(h *Handler, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("elb-logs-to-cloudwatch stats", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch stats [--top 10] s3://<bucket>/<prefix>|<file or directory>|-")
+		flags.PrintDefaults()
+	}
+	top := flags.Int("top", 10, "also report the `n` most frequent paths, user agents, client IPs and target groups with their number of unique values, 0 disables it")
+	if err := flags.Parse(args); err != nil {
+		return exitTotalFailure
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitTotalFailure
+	}
+	args = flags.Args()
+	fields, err := NewFields(h.config.Fields)
+	if err != nil {
+		log.Printf("invalid FIELDS: %v", err)
+		return exitTotalFailure
+	}
+	var s3Objects []S3ObjectInfo
+	if strings.HasPrefix(args[0], "s3://") {
+		s3Objects, err = h.listS3URL(args[0])
+	} else {
+		s3Objects, err = listLocalObjects(args[0])
+	}
+	if err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	analyzer := &Analyzer{Source: NewSources(h.s3Client), Fields: fields, Config: h.config}
+	if *top > 0 {
+		analyzer.Profile = &TrafficProfile{Top: *top}
+	}
+	costs, err := analyzer.Analyze(s3Objects)
+	if err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	if err := costs.Write(stdout); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	if analyzer.Profile != nil {
+		if err := analyzer.Profile.Write(stdout); err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+	}
+
+	return exitSuccess
+}
+
+// runTail writes the entries of new objects under the S3 URL given in args until the process is interrupted or
+// terminated, see Tailer
+func runTail(h *Handler, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("elb-logs-to-cloudwatch tail", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch tail [--interval 30s] [--filter <field><operator><value>]... [--columns <fields>] [--format text|human] s3://<bucket>/<prefix>")
+		flags.PrintDefaults()
+	}
+	interval := flags.Duration("interval", 30*time.Second, "list the new objects every `interval`")
+	var filters entryFilters
+	flags.Var(&filters, "filter", "only write the entries that pass this `expression`, such as elb_status_code>=500 or request~/api/, with the operators = != > >= < <= and ~ for a regular expression, repeat for entries that pass all")
+	columns := flags.String("columns", "", "the comma separated `fields` to write, by default all fields of the entry")
+	format := flags.String("format", tailText, "write field=value pairs (text), or aligned columns with colored status codes and slow processing times (human)")
+	if err := flags.Parse(args); err != nil {
+		return exitTotalFailure
+	}
+	if flags.NArg() != 1 || *interval <= 0 {
+		flags.Usage()
+		return exitTotalFailure
+	}
+	if _, err := ParseTailFormat(*format); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	bucket, prefix, err := ParseS3URL(flags.Arg(0))
+	if err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	fields, err := NewFields(h.config.Fields)
+	if err != nil {
+		log.Printf("invalid FIELDS: %v", err)
+		return exitTotalFailure
+	}
+	tailer := &Tailer{
+		Handler:  h,
+		Source:   NewSources(h.s3Client),
+		Fields:   fields,
+		Config:   h.config,
+		Bucket:   bucket,
+		Prefix:   prefix,
+		Interval: *interval,
+		Filters:  filters,
+		Format:   *format,
+		Color:    colorTerminal(stdout),
+		Out:      stdout,
+	}
+	if *columns != "" {
+		for _, column := range strings.Split(*columns, ",") {
+			tailer.Columns = append(tailer.Columns, strings.TrimSpace(column))
+		}
+	}
+	tailer.Run(stopSignal())
+
+	return exitSuccess
+}
+
+// runCompare writes the request counts, status mix and latency percentiles of two locations or time ranges and the
+// change between them, see Comparer, and returns the exit code
+func runCompare(h *Handler, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("elb-logs-to-cloudwatch compare", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch compare [--a-range <from>/<to>] [--b-range <from>/<to>] <location a> [<location b>]")
+		fmt.Fprintln(stderr, "locations are s3://<bucket>/<prefix>, a file or a directory, b is a when left out")
+		flags.PrintDefaults()
+	}
+	rangeA := flags.String("a-range", "", "only measure the requests of a in this `range` of RFC 3339 times, such as 2024-03-21T13:00:00Z/2024-03-21T14:00:00Z, either end may be left out")
+	rangeB := flags.String("b-range", "", "only measure the requests of b in this `range`, like --a-range")
+	if err := flags.Parse(args); err != nil {
+		return exitTotalFailure
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return exitTotalFailure
+	}
+	locations := []string{flags.Arg(0), flags.Arg(0)}
+	if flags.NArg() == 2 {
+		locations[1] = flags.Arg(1)
+	} else if *rangeA == "" || *rangeB == "" {
+		fmt.Fprintln(stderr, "--a-range and --b-range are required to compare a location with itself")
+		return exitTotalFailure
+	}
+	var ranges [2]TimeRange
+	for i, value := range []string{*rangeA, *rangeB} {
+		if value == "" {
+			continue
+		}
+		var err error
+		if ranges[i], err = ParseTimeRange(value); err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+	}
+	fields, err := NewFields(h.config.Fields)
+	if err != nil {
+		log.Printf("invalid FIELDS: %v", err)
+		return exitTotalFailure
+	}
+	comparer := &Comparer{Source: NewSources(h.s3Client), Fields: fields, Config: h.config}
+	var metrics [2]*TrafficMetrics
+	for i, location := range locations {
+		var s3Objects []S3ObjectInfo
+		if strings.HasPrefix(location, "s3://") {
+			s3Objects, err = h.listS3URL(location)
+		} else {
+			s3Objects, err = listLocalObjects(location)
+		}
+		if err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+		if metrics[i], err = comparer.Measure(s3Objects, ranges[i]); err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+	}
+	if err := WriteComparison(stdout, metrics[0], metrics[1]); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+
+	return exitSuccess
+}
+
+// runValidate checks that the configured destinations can be written with the current credentials and returns
+// the exit code
+func runValidate(h *Handler, args []string, stdout, stderr io.Writer) int {
+	if len(args) != 0 {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch validate")
+		return exitTotalFailure
+	}
+	destinations := preflightDestinations(h.config)
+	if len(destinations) == 0 {
+		fmt.Fprintln(stdout, "nothing to validate, the log groups are derived from the object keys")
+		return exitSuccess
+	}
+	failed := 0
+	for _, destination := range destinations {
+		name := destination.LogGroupName
+		if destination.LogStreamName != "" {
+			name += " " + destination.LogStreamName
+		}
+		if destination.RoleARN != "" {
+			name += " as " + destination.RoleARN
+		}
+		err := PreflightDestination(h.roleClients.Client(destination.RoleARN, h.cwClient), destination)
+		if err != nil {
+			failed++
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Fprintf(stdout, "FAIL %s: %s\n", name, line)
+			}
+			continue
+		}
+		fmt.Fprintf(stdout, "ok   %s\n", name)
+	}
+	if failed > 0 {
+		return exitTotalFailure
+	}
+
+	return exitSuccess
+}
+
+// runSelfTest ships synthetic entries to a temporary log group and reads them back, see SelfTest, and returns the
+// exit code
+func runSelfTest(h *Handler, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("elb-logs-to-cloudwatch selftest", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	keep := flags.Bool("keep", false, "keep the temporary log group instead of deleting it")
+	timeout := flags.Duration("timeout", time.Minute, "wait this `duration` for the entries to be readable")
+	if err := flags.Parse(args); err != nil {
+		return exitTotalFailure
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch selftest [--keep] [--timeout 1m]")
+		return exitTotalFailure
+	}
+	fields, err := NewFields(h.config.Fields)
+	if err != nil {
+		log.Printf("invalid FIELDS: %v", err)
+		return exitTotalFailure
+	}
+	selfTest := &SelfTest{
+		Client:  cloudwatchlogs.New(h.session),
+		Config:  h.config,
+		Fields:  fields,
+		Timeout: *timeout,
+		Keep:    *keep,
+		Out:     stdout,
+	}
+	if err := selfTest.Run(time.Now()); err != nil {
+		return exitTotalFailure
+	}
+
+	return exitSuccess
+}
+
+// runSetup writes the policies of the roles of the account routes as JSON, and applies them with --apply
+func runSetup(h *Handler, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("elb-logs-to-cloudwatch setup", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	principal := flags.String("principal", "", "`ARN` of the role or user that ships the logs, e.g. the role of the Lambda function")
+	apply := flags.Bool("apply", false, "create or update the roles with the current credentials, which must be of the account of the roles")
+	role := flags.String("role", "", "only set up the role with this `ARN`")
+	if err := flags.Parse(args); err != nil {
+		return exitTotalFailure
+	}
+	if *principal == "" || flags.NArg() != 0 {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch setup --principal <arn> [--apply] [--role <arn>]")
+		return exitTotalFailure
+	}
+	region := ""
+	if h.session != nil {
+		region = aws.StringValue(h.session.Config.Region)
+	}
+	setup, err := NewCrossAccountSetup(h.config, *principal, region)
+	if err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	if *role != "" {
+		var roles []CrossAccountRole
+		for _, r := range setup.Roles {
+			if r.RoleARN == *role {
+				roles = append(roles, r)
+			}
+		}
+		if len(roles) == 0 {
+			fmt.Fprintf(stderr, "role %s is not used by ACCOUNT_ROUTES\n", *role)
+			return exitTotalFailure
+		}
+		setup.Roles = roles
+	}
+	if len(setup.Roles) == 0 {
+		fmt.Fprintln(stderr, "no account routes with a role, nothing to set up")
+		return exitSuccess
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(setup); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	if !*apply {
+		return exitSuccess
+	}
+	client := iam.New(h.session)
+	for _, r := range setup.Roles {
+		if err := r.Apply(client); err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+	}
+
+	return exitSuccess
+}
+
+// stopSignal returns a channel that is closed when the process is interrupted or terminated
+func stopSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		close(stop)
+	}()
+
+	return stop
+}
+
+// runReplay sends the batches in the spool given in args, or in the SPOOL, to CloudWatch and returns the exit code
+func runReplay(h *Handler, args []string, stderr io.Writer) int {
+	if len(args) > 1 {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch replay [<directory>|s3://<bucket>/<prefix>]")
+		return exitTotalFailure
+	}
+	spool := h.spool
+	if len(args) == 1 {
+		var err error
+		if spool, err = NewSpool(args[0], h.s3Client); err != nil {
+			log.Println(err)
+			return exitTotalFailure
+		}
+	}
+	if spool == nil {
+		fmt.Fprintln(stderr, "nothing to replay, give the spool location or set SPOOL")
+		return exitTotalFailure
+	}
+	replayer := &Replayer{Client: h.cwClient, RoleClients: h.roleClients, Limiter: h.limiter}
+	result, err := replayer.Replay(spool)
+	log.Printf("replayed %d events in %d batches", result.Events, result.Batches)
+	if result.TooOld+result.TooNew > 0 {
+		log.Printf("%d events could no longer be ingested: %d older than %s, %d more than %s ahead",
+			result.TooOld+result.TooNew, result.TooOld, maxEventAge, result.TooNew, maxEventLead)
+	}
+	if err != nil {
+		log.Println(err)
+		if result.Batches > 0 {
+			return exitPartialFailure
+		}
+		return exitTotalFailure
+	}
+
+	return exitSuccess
+}
+
+// runExport writes the entries of the objects given in args as newline delimited JSON, CSV, TSV, Arrow IPC,
+// Parquet, aligned columns or to a SQLite database and returns the exit code
+func runExport(h *Handler, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("elb-logs-to-cloudwatch export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elb-logs-to-cloudwatch export [--anonymize] [--format ndjson|csv|tsv|sqlite|arrow|parquet|human] [--columns <fields>] [--out <file>] s3://<bucket>/<prefix>|<file or directory>|-")
+		flags.PrintDefaults()
+	}
+	anonymize := flags.Bool("anonymize", false, "mask IP addresses, redact query parameter values and consistently rename hosts, load balancers and accounts")
+	out := flags.String("out", "", "write to this `file` instead of standard output")
+	format := flags.String("format", exportNDJSON, "write newline delimited JSON (ndjson), CSV or TSV with a header row (csv, tsv), a SQLite database to --out (sqlite), an Arrow IPC or Parquet file with typed columns (arrow, parquet), or aligned columns to read in a terminal (human)")
+	columns := flags.String("columns", "", "with a format other than ndjson, the comma separated `fields` to write as columns, by default the fields of the first entry")
+	rawFormulas := flags.Bool("raw-formulas", false, "with csv or tsv, write values starting with = + - or @ as is, instead of prefixed with ' so spreadsheets don't run them as formulas")
+	if err := flags.Parse(args); err != nil {
+		return exitTotalFailure
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitTotalFailure
+	}
+	if _, err := ParseExportFormat(*format); err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	if *format == exportSQLite && *out == "" {
+		fmt.Fprintln(stderr, "--out is required for the sqlite format")
+		return exitTotalFailure
+	}
+	fields, err := NewFields(h.config.Fields)
+	if err != nil {
+		log.Printf("invalid FIELDS: %v", err)
+		return exitTotalFailure
+	}
+	var s3Objects []S3ObjectInfo
+	if strings.HasPrefix(flags.Arg(0), "s3://") {
+		s3Objects, err = h.listS3URL(flags.Arg(0))
+	} else {
+		s3Objects, err = listLocalObjects(flags.Arg(0))
+	}
+	if err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+	exporter := &Exporter{Source: NewSources(h.s3Client), Fields: fields, Config: h.config, Anonymize: *anonymize, RawFormulas: *rawFormulas, Format: *format}
+	if *columns != "" {
+		for _, column := range strings.Split(*columns, ",") {
+			exporter.Columns = append(exporter.Columns, strings.TrimSpace(column))
+		}
+	}
+	var entries int
+	if *format == exportSQLite {
+		entries, err = exporter.ExportSQLite(s3Objects, *out)
+	} else {
+		w := stdout
+		if *out != "" {
+			file, err := os.Create(*out)
+			if err != nil {
+				log.Println(err)
+				return exitTotalFailure
+			}
+			defer file.Close()
+			w = file
+		}
+		exporter.Color = colorTerminal(w)
+		entries, err = exporter.Export(s3Objects, w)
+	}
+	log.Printf("exported %d log entries from %d objects", entries, len(s3Objects))
+	if err != nil {
+		log.Println(err)
+		return exitTotalFailure
+	}
+
+	return exitSuccess
+}
+
+// exitCode maps the result of a run to the exit code of the CLI
+func exitCode(result RunResult, err error) int {
+	if err == nil {
+		if len(result.Unverified) > 0 {
+			return exitPartialFailure
+		}
+		return exitSuccess
+	}
+	if len(result.Failures) == 0 || result.Processed+result.Empty+result.AlreadyProcessed == 0 {
+		return exitTotalFailure
+	}
+
+	return exitPartialFailure
+}
+
+// writeFailures writes the failed objects to a JSON file, an empty list is written if nothing failed
+func writeFailures(path string, failures []ObjectFailure) error {
+	if failures == nil {
+		failures = []ObjectFailure{}
+	}
+	data, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal failures: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write failures to %s: %v", path, err)
+	}
+
+	return nil
+}
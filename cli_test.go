@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCLI(t *testing.T) {
+	newHandler := func(failingKeys ...string) *Handler {
+		mockS3Api := new(MockS3Api)
+		mockS3Api.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{
+			Contents: []*s3.Object{
+				{Key: aws.String("prefix/object1")},
+				{Key: aws.String("prefix/object2")},
+			},
+		}, nil)
+		mockProcessor := new(MockLogProcessor)
+		for _, key := range []string{"prefix/object1", "prefix/object2"} {
+			var err error
+			for _, failingKey := range failingKeys {
+				if key == failingKey {
+					err = fmt.Errorf("access denied")
+				}
+			}
+			mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "bucket", Key: key}).Return(ObjectResult{}, err)
+		}
+		return &Handler{lp: mockProcessor, s3Client: mockS3Api}
+	}
+
+	t.Run("All succeeded", func(t *testing.T) {
+		failuresOut := filepath.Join(t.TempDir(), "failures.json")
+		code := runCLI(newHandler(), []string{"--yes", "--failures-out", failuresOut, "s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitSuccess, code)
+
+		data, err := os.ReadFile(failuresOut)
+		require.NoError(t, err)
+		assert.Equal(t, "[]\n", string(data))
+	})
+
+	t.Run("Partial failure", func(t *testing.T) {
+		failuresOut := filepath.Join(t.TempDir(), "failures.json")
+		code := runCLI(newHandler("prefix/object2"), []string{"--yes", "--failures-out", failuresOut, "s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitPartialFailure, code)
+
+		data, err := os.ReadFile(failuresOut)
+		require.NoError(t, err)
+		var failures []ObjectFailure
+		require.NoError(t, json.Unmarshal(data, &failures))
+		assert.Equal(t, []ObjectFailure{{Bucket: "bucket", Key: "prefix/object2", Error: "access denied"}}, failures)
+	})
+
+	t.Run("Total failure", func(t *testing.T) {
+		code := runCLI(newHandler("prefix/object1", "prefix/object2"), []string{"--yes", "s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitTotalFailure, code)
+	})
+
+	t.Run("Resume from report", func(t *testing.T) {
+		report := filepath.Join(t.TempDir(), "report.csv")
+		h := newHandler("prefix/object2")
+		code := runCLI(h, []string{"--yes", "--report", report, "s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitPartialFailure, code)
+
+		// The second run only processes the failed object
+		mockProcessor := new(MockLogProcessor)
+		mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "bucket", Key: "prefix/object2"}).Return(ObjectResult{Entries: 3}, nil)
+		h.lp = mockProcessor
+		code = runCLI(h, []string{"--yes", "--report", report, "s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitSuccess, code)
+		mockProcessor.AssertNumberOfCalls(t, "ProcessLogs", 1)
+
+		data, err := os.ReadFile(report)
+		require.NoError(t, err)
+		assert.Equal(t, "bucket,key,status,entries,error\nbucket,prefix/object1,processed,0,\nbucket,prefix/object2,processed,3,\n", string(data))
+	})
+
+	t.Run("Budget and resume marker", func(t *testing.T) {
+		h := newHandler()
+		code := runCLI(h, []string{"--yes", "--max-objects", "1", "s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitSuccess, code)
+		h.lp.(*MockLogProcessor).AssertNumberOfCalls(t, "ProcessLogs", 1)
+
+		mockProcessor := new(MockLogProcessor)
+		mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "bucket", Key: "prefix/object2"}).Return(ObjectResult{}, nil)
+		h.lp = mockProcessor
+		code = runCLI(h, []string{"--yes", "--max-objects", "1", "--start-after", "prefix/object1", "s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitSuccess, code)
+		mockProcessor.AssertNumberOfCalls(t, "ProcessLogs", 1)
+
+		code = runCLI(h, []string{"--yes", "--start-after", "prefix/unknown", "s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitTotalFailure, code)
+	})
+
+	t.Run("Confirmation", func(t *testing.T) {
+		defer func(original func() (io.Reader, bool)) { terminalInput = original }(terminalInput)
+
+		// Without a terminal, --yes is required
+		terminalInput = func() (io.Reader, bool) { return strings.NewReader(""), false }
+		h := newHandler()
+		var stderr bytes.Buffer
+		code := runCLI(h, []string{"s3://bucket/prefix/"}, &bytes.Buffer{}, &stderr)
+		assert.Equal(t, exitTotalFailure, code)
+		assert.Contains(t, stderr.String(), "2 objects, 0 B compressed (2 objects of unknown size)")
+		h.lp.(*MockLogProcessor).AssertNotCalled(t, "ProcessLogs", mock.Anything)
+
+		terminalInput = func() (io.Reader, bool) { return strings.NewReader("n\n"), true }
+		code = runCLI(h, []string{"s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitTotalFailure, code)
+		h.lp.(*MockLogProcessor).AssertNotCalled(t, "ProcessLogs", mock.Anything)
+
+		terminalInput = func() (io.Reader, bool) { return strings.NewReader("yes\n"), true }
+		code = runCLI(h, []string{"s3://bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitSuccess, code)
+		h.lp.(*MockLogProcessor).AssertNumberOfCalls(t, "ProcessLogs", 2)
+	})
+
+	t.Run("Missing S3 URL", func(t *testing.T) {
+		var stderr bytes.Buffer
+		code := runCLI(newHandler(), []string{}, &bytes.Buffer{}, &stderr)
+		assert.Equal(t, exitTotalFailure, code)
+		assert.Contains(t, stderr.String(), "usage: elb-logs-to-cloudwatch")
+	})
+
+	t.Run("Invalid S3 URL", func(t *testing.T) {
+		code := runCLI(newHandler(), []string{"bucket/prefix/"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitTotalFailure, code)
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	clientClassHuman   = "human"
+	clientClassBot     = "bot"
+	clientClassCrawler = "known_crawler"
+)
+
+// ClientPatterns are case-insensitive regular expressions matched against user agents. Crawlers are checked
+// before bots, as the user agents of most crawlers also match the generic bot patterns.
+type ClientPatterns struct {
+	Crawlers []string `json:"crawlers"`
+	Bots     []string `json:"bots"`
+}
+
+// defaultClientPatterns are the well-known search engine, social media and SEO crawlers, and generic patterns
+// for HTTP libraries, command line tools, monitoring and other automated clients
+var defaultClientPatterns = ClientPatterns{
+	Crawlers: []string{
+		`Googlebot`, `Google-InspectionTool`, `Storebot-Google`, `AdsBot-Google`, `Mediapartners-Google`,
+		`bingbot`, `BingPreview`, `DuckDuckBot`, `Baiduspider`, `YandexBot`, `Applebot`, `Slurp`,
+		`facebookexternalhit`, `Twitterbot`, `LinkedInBot`, `Slackbot`, `Discordbot`, `WhatsApp`, `TelegramBot`,
+		`AhrefsBot`, `SemrushBot`, `MJ12bot`, `DotBot`, `PetalBot`, `GPTBot`, `ClaudeBot`, `CCBot`,
+	},
+	Bots: []string{
+		`bot\b`, `crawl`, `spider`, `scrap`, `^curl/`, `^Wget/`, `^python-`, `^Python-urllib`, `^Go-http-client`,
+		`^Java/`, `^okhttp`, `^axios`, `^node-fetch`, `^libwww-perl`, `^Apache-HttpClient`, `HeadlessChrome`,
+		`^ELB-HealthChecker`, `Pingdom`, `UptimeRobot`, `StatusCake`, `Datadog`, `^Amazon CloudFront`,
+	},
+}
+
+// ClientClassifier classifies user agents as known_crawler, bot or human
+type ClientClassifier struct {
+	Patterns ClientPatterns
+	crawlers *regexp.Regexp
+	bots     *regexp.Regexp
+}
+
+// NewClientClassifier compiles the patterns into one regular expression per class
+func NewClientClassifier(patterns ClientPatterns) (*ClientClassifier, error) {
+	crawlers, err := compileClientPatterns(patterns.Crawlers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid crawler pattern: %v", err)
+	}
+	bots, err := compileClientPatterns(patterns.Bots)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bot pattern: %v", err)
+	}
+
+	return &ClientClassifier{Patterns: patterns, crawlers: crawlers, bots: bots}, nil
+}
+
+func compileClientPatterns(patterns []string) (*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return regexp.MustCompile(`(?i)(?:` + strings.Join(patterns, `)|(?:`) + `)`), nil
+}
+
+// LoadClientClassifier returns the classifier with the default patterns if enabled, or with the patterns of value,
+// a JSON file or the JSON object itself, which replace the default patterns
+func LoadClientClassifier(enabled bool, value string) (*ClientClassifier, error) {
+	if strings.TrimSpace(value) == "" {
+		if !enabled {
+			return nil, nil
+		}
+		return NewClientClassifier(defaultClientPatterns)
+	}
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return nil, fmt.Errorf("failed to read client patterns: %v", err)
+		}
+	}
+	var patterns ClientPatterns
+	if err := json.Unmarshal(data, &patterns); err != nil {
+		return nil, fmt.Errorf("invalid client patterns: %v", err)
+	}
+
+	return NewClientClassifier(patterns)
+}
+
+// Classify returns the class of a user agent. Clients without a user agent are bots, as browsers always send one.
+func (c *ClientClassifier) Classify(userAgent string) string {
+	switch {
+	case userAgent == "" || userAgent == "-":
+		return clientClassBot
+	case c.crawlers != nil && c.crawlers.MatchString(userAgent):
+		return clientClassCrawler
+	case c.bots != nil && c.bots.MatchString(userAgent):
+		return clientClassBot
+	}
+
+	return clientClassHuman
+}
+
+func (c *ClientClassifier) Transform(record []string, entry *LogEntry) {
+	entry.Data["client_class"] = c.Classify(recordValue(record, "user_agent"))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientClassifier(t *testing.T) {
+	classifier, err := LoadClientClassifier(true, "")
+	require.NoError(t, err)
+
+	for userAgent, class := range map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36": "human",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                        "known_crawler",
+		"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)":                                         "known_crawler",
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)":                                       "known_crawler",
+		"Mozilla/5.0 (compatible; SomeNewBot/1.0)":                                                                        "bot",
+		"curl/8.4.0":                  "bot",
+		"python-requests/2.31.0":      "bot",
+		"ELB-HealthChecker/2.0":       "bot",
+		"-":                           "bot",
+		"Robotics Lab Browser/1.0":    "human",
+		"Mozilla/5.0 (iPhone) Mobile": "human",
+	} {
+		assert.Equal(t, class, classifier.Classify(userAgent), userAgent)
+	}
+
+	entry := LogEntry{Data: map[string]interface{}{}}
+	record := make([]string, len(fieldNames))
+	record[getFieldIndex("user_agent")] = "curl/8.4.0"
+	classifier.Transform(record, &entry)
+	assert.Equal(t, "bot", entry.Data["client_class"])
+}
+
+func TestLoadClientClassifier(t *testing.T) {
+	classifier, err := LoadClientClassifier(false, "")
+	assert.NoError(t, err)
+	assert.Nil(t, classifier)
+
+	t.Run("Patterns replace the defaults", func(t *testing.T) {
+		classifier, err := LoadClientClassifier(false, `{"crawlers": ["^internal-indexer/"], "bots": ["^k6/"]}`)
+		require.NoError(t, err)
+		assert.Equal(t, "known_crawler", classifier.Classify("internal-indexer/1.0"))
+		assert.Equal(t, "bot", classifier.Classify("k6/0.47"))
+		assert.Equal(t, "human", classifier.Classify("Mozilla/5.0 (compatible; Googlebot/2.1)"))
+	})
+
+	t.Run("File", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "clients.json")
+		require.NoError(t, os.WriteFile(file, []byte(`{"bots": ["^k6/"]}`), 0o644))
+		classifier, err := LoadClientClassifier(true, file)
+		require.NoError(t, err)
+		assert.Equal(t, "bot", classifier.Classify("k6/0.47"))
+		assert.Equal(t, "human", classifier.Classify("curl/8.4.0"))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := LoadClientClassifier(true, `{"bots": ["("]}`)
+		assert.ErrorContains(t, err, "invalid bot pattern")
+		_, err = LoadClientClassifier(true, filepath.Join(t.TempDir(), "missing.json"))
+		assert.ErrorContains(t, err, "failed to read client patterns")
+	})
+}
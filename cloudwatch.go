@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
-	"log"
 	"sort"
+	"sync"
+	"time"
 )
 
 type CloudWatchLogsAPI interface {
@@ -13,6 +17,8 @@ type CloudWatchLogsAPI interface {
 	CreateLogStream(*cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error)
 	DescribeLogGroups(*cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error)
 	DescribeLogStreams(*cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error)
+	DescribeSubscriptionFilters(*cloudwatchlogs.DescribeSubscriptionFiltersInput) (*cloudwatchlogs.DescribeSubscriptionFiltersOutput, error)
+	PutSubscriptionFilter(*cloudwatchlogs.PutSubscriptionFilterInput) (*cloudwatchlogs.PutSubscriptionFilterOutput, error)
 }
 
 func EnsureLogGroupAndLogStreamExists(client CloudWatchLogsAPI, logConfig LogConfig) error {
@@ -26,60 +32,232 @@ func EnsureLogGroupAndLogStreamExists(client CloudWatchLogsAPI, logConfig LogCon
 }
 
 func ensureLogGroupExists(client CloudWatchLogsAPI, name string) error {
-	resp, err := client.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{})
+	logGroup, err := findLogGroup(client, name)
 	if err != nil {
-		return err
+		return permissionError(err, "logs:DescribeLogGroups", "log group "+name)
 	}
-	for _, logGroup := range resp.LogGroups {
-		if *logGroup.LogGroupName == name {
-			return nil
-		}
+	if logGroup != nil {
+		return nil
 	}
-	log.Printf("creating log group %s", name)
+	logf(verbosityVerbose, "creating log group %s", name)
 	_, err = client.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
 		LogGroupName: aws.String(name),
 	})
 
-	return err
+	return permissionError(ignoreAlreadyExists(err), "logs:CreateLogGroup", "log group "+name)
 }
 
 func ensureLogStreamExists(client CloudWatchLogsAPI, logGroupName, logStreamName string) error {
-	resp, err := client.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
-		LogGroupName: aws.String(logGroupName),
-	})
+	logStream, err := findLogStream(client, logGroupName, logStreamName)
 	if err != nil {
-		return err
+		return permissionError(err, "logs:DescribeLogStreams", "log group "+logGroupName)
 	}
-	for _, logStream := range resp.LogStreams {
-		if *logStream.LogStreamName == logStreamName {
-			return nil
-		}
+	if logStream != nil {
+		return nil
 	}
-	log.Printf("creating log stream %s in log group %s", logStreamName, logGroupName)
+	logf(verbosityVerbose, "creating log stream %s in log group %s", logStreamName, logGroupName)
 	_, err = client.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
 		LogGroupName:  aws.String(logGroupName),
 		LogStreamName: aws.String(logStreamName),
 	})
 
+	return permissionError(ignoreAlreadyExists(err), "logs:CreateLogStream", "log group "+logGroupName)
+}
+
+// findLogGroup describes the log groups with the name as prefix page by page until the one with the name is found,
+// and returns nil if it doesn't exist
+func findLogGroup(client CloudWatchLogsAPI, name string) (*cloudwatchlogs.LogGroup, error) {
+	input := &cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: aws.String(name)}
+	for {
+		resp, err := client.DescribeLogGroups(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, logGroup := range resp.LogGroups {
+			if aws.StringValue(logGroup.LogGroupName) == name {
+				return logGroup, nil
+			}
+		}
+		if resp.NextToken == nil {
+			return nil, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+// findLogStream describes the log streams of a group with the name as prefix page by page until the one with the
+// name is found, and returns nil if it doesn't exist
+func findLogStream(client CloudWatchLogsAPI, logGroupName, name string) (*cloudwatchlogs.LogStream, error) {
+	input := &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(logGroupName),
+		LogStreamNamePrefix: aws.String(name),
+	}
+	for {
+		resp, err := client.DescribeLogStreams(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, logStream := range resp.LogStreams {
+			if aws.StringValue(logStream.LogStreamName) == name {
+				return logStream, nil
+			}
+		}
+		if resp.NextToken == nil {
+			return nil, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+// isResourceNotFound reports whether a request failed because the log group or stream doesn't exist
+func isResourceNotFound(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException
+}
+
+// ignoreAlreadyExists ignores the error when a log group or stream was created by another process
+// (e.g. a concurrent Lambda invocation) between describing and creating it
+func ignoreAlreadyExists(err error) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+		return nil
+	}
+
 	return err
 }
 
+// DestinationCache remembers which log groups and streams have been created, so that routed
+// destinations are only checked once per process. Concurrent calls for the same destination share a
+// single check, and failures are remembered for FailureTTL so that a destination that can't be created
+// doesn't cause a storm of Describe and Create calls. The zero value is ready to use and doesn't
+// remember failures.
+type DestinationCache struct {
+	FailureTTL time.Duration
+
+	mu       sync.Mutex
+	ensured  map[LogConfig]bool
+	failures map[LogConfig]destinationFailure
+	calls    map[LogConfig]*ensureCall
+	now      func() time.Time // Overridden in tests
+}
+
+// destinationFailure is a failed check that is returned until the given time
+type destinationFailure struct {
+	err   error
+	until time.Time
+}
+
+// ensureCall is a check in progress, err is set when done is closed
+type ensureCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Ensure calls ensureFn unless the destination was ensured successfully before, failed less than FailureTTL ago,
+// or is being ensured by another goroutine, in which case its result is returned
+func (c *DestinationCache) Ensure(destination LogConfig, ensureFn func() error) error {
+	c.mu.Lock()
+	if c.ensured[destination] {
+		c.mu.Unlock()
+		return nil
+	}
+	if failure, ok := c.failures[destination]; ok && c.clock().Before(failure.until) {
+		c.mu.Unlock()
+		return failure.err
+	}
+	if call, ok := c.calls[destination]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	if c.ensured == nil {
+		c.ensured = make(map[LogConfig]bool)
+		c.failures = make(map[LogConfig]destinationFailure)
+		c.calls = make(map[LogConfig]*ensureCall)
+	}
+	call := &ensureCall{done: make(chan struct{})}
+	c.calls[destination] = call
+	c.mu.Unlock()
+
+	call.err = ensureFn()
+
+	c.mu.Lock()
+	delete(c.calls, destination)
+	if call.err == nil {
+		c.ensured[destination] = true
+		delete(c.failures, destination)
+	} else if c.FailureTTL > 0 {
+		c.failures[destination] = destinationFailure{err: call.err, until: c.clock().Add(c.FailureTTL)}
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+// Forget makes the next Ensure check the destination again, e.g. after it was deleted. A failure that is still
+// remembered is kept, so a destination that can't be created again isn't checked for every batch.
+func (c *DestinationCache) Forget(destination LogConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ensured, destination)
+}
+
+func (c *DestinationCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+
+	return time.Now()
+}
+
 func SendEventsToCloudWatch(client CloudWatchLogsAPI, logConfig LogConfig, events []*cloudwatchlogs.InputLogEvent) error {
 	// Log events in a single PutLogEvents request must be in chronological order
 	sort.Slice(events, func(i, j int) bool {
 		return aws.Int64Value(events[i].Timestamp) < aws.Int64Value(events[j].Timestamp)
 	})
-	_, err := client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+	resp, err := client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
 		LogEvents:     events,
 		LogGroupName:  aws.String(logConfig.LogGroupName),
 		LogStreamName: aws.String(logConfig.LogStreamName),
 	})
+	if err != nil {
+		return err
+	}
+	if rejected := rejectedEvents(resp.RejectedLogEventsInfo, len(events)); rejected > 0 {
+		return &RejectedEventsError{Rejected: rejected, Events: len(events)}
+	}
 
-	return err
+	return nil
+}
+
+// RejectedEventsError is returned when PutLogEvents accepted a batch but rejected some of its events, e.g.
+// because they were too old or too new. The other events were stored.
+type RejectedEventsError struct {
+	Rejected int
+	Events   int
+}
+
+func (e *RejectedEventsError) Error() string {
+	return fmt.Sprintf("%d of %d events were rejected as too old, too new or expired", e.Rejected, e.Events)
+}
+
+// rejectedEvents returns the number of events rejected according to the indexes of a PutLogEvents response. Events
+// are sorted by timestamp, so rejected events are at the start (too old or expired) and at the end (too new).
+func rejectedEvents(info *cloudwatchlogs.RejectedLogEventsInfo, events int) int {
+	if info == nil {
+		return 0
+	}
+	rejected := max(int(aws.Int64Value(info.TooOldLogEventEndIndex)), int(aws.Int64Value(info.ExpiredLogEventEndIndex)))
+	if info.TooNewLogEventStartIndex != nil {
+		rejected += events - int(aws.Int64Value(info.TooNewLogEventStartIndex))
+	}
+
+	return min(rejected, events)
 }
 
 func EstimateEventSize(event *cloudwatchlogs.InputLogEvent) int {
 	// Request size to CloudWatch is calculated as the sum of all event messages in UTF-8, plus 26 bytes for each log event
 	// https://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/cloudwatch_limits_cwl.html
-	return len(*event.Message) + 26
+	return len(*event.Message) + eventOverhead
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -39,6 +44,21 @@ func (m *MockCloudWatchLogsClient) DescribeLogStreams(input *cloudwatchlogs.Desc
 	return args.Get(0).(*cloudwatchlogs.DescribeLogStreamsOutput), args.Error(1)
 }
 
+func (m *MockCloudWatchLogsClient) DescribeSubscriptionFilters(input *cloudwatchlogs.DescribeSubscriptionFiltersInput) (*cloudwatchlogs.DescribeSubscriptionFiltersOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*cloudwatchlogs.DescribeSubscriptionFiltersOutput), args.Error(1)
+}
+
+func (m *MockCloudWatchLogsClient) PutSubscriptionFilter(input *cloudwatchlogs.PutSubscriptionFilterInput) (*cloudwatchlogs.PutSubscriptionFilterOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*cloudwatchlogs.PutSubscriptionFilterOutput), args.Error(1)
+}
+
+func (m *MockCloudWatchLogsClient) FilterLogEvents(input *cloudwatchlogs.FilterLogEventsInput) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*cloudwatchlogs.FilterLogEventsOutput), args.Error(1)
+}
+
 func TestEnsureLogGroupAndLogStreamExists(t *testing.T) {
 
 	logConfig := LogConfig{
@@ -77,7 +97,8 @@ func TestEnsureLogGroupAndLogStreamExists(t *testing.T) {
 		}).Return(&cloudwatchlogs.CreateLogGroupOutput{}, nil)
 
 		mockClient.On("DescribeLogStreams", &cloudwatchlogs.DescribeLogStreamsInput{
-			LogGroupName: aws.String("test-log-group"),
+			LogGroupName:        aws.String("test-log-group"),
+			LogStreamNamePrefix: aws.String("test-log-stream"),
 		}).Return(&cloudwatchlogs.DescribeLogStreamsOutput{
 			LogStreams: []*cloudwatchlogs.LogStream{
 				{LogStreamName: aws.String("test-log-stream")},
@@ -99,7 +120,8 @@ func TestEnsureLogGroupAndLogStreamExists(t *testing.T) {
 		}, nil)
 
 		mockClient.On("DescribeLogStreams", &cloudwatchlogs.DescribeLogStreamsInput{
-			LogGroupName: aws.String("test-log-group"),
+			LogGroupName:        aws.String("test-log-group"),
+			LogStreamNamePrefix: aws.String("test-log-stream"),
 		}).Return(&cloudwatchlogs.DescribeLogStreamsOutput{
 			LogStreams: []*cloudwatchlogs.LogStream{},
 		}, nil)
@@ -114,6 +136,40 @@ func TestEnsureLogGroupAndLogStreamExists(t *testing.T) {
 
 		mockClient.AssertExpectations(t)
 	})
+
+	t.Run("Log group and stream on a later page", func(t *testing.T) {
+		mockClient := new(MockCloudWatchLogsClient)
+		mockClient.On("DescribeLogGroups", &cloudwatchlogs.DescribeLogGroupsInput{
+			LogGroupNamePrefix: aws.String("test-log-group"),
+		}).Return(&cloudwatchlogs.DescribeLogGroupsOutput{
+			LogGroups: []*cloudwatchlogs.LogGroup{{LogGroupName: aws.String("test-log-group-old")}},
+			NextToken: aws.String("page-2"),
+		}, nil)
+		mockClient.On("DescribeLogGroups", &cloudwatchlogs.DescribeLogGroupsInput{
+			LogGroupNamePrefix: aws.String("test-log-group"),
+			NextToken:          aws.String("page-2"),
+		}).Return(&cloudwatchlogs.DescribeLogGroupsOutput{
+			LogGroups: []*cloudwatchlogs.LogGroup{{LogGroupName: aws.String("test-log-group")}},
+		}, nil)
+		mockClient.On("DescribeLogStreams", &cloudwatchlogs.DescribeLogStreamsInput{
+			LogGroupName:        aws.String("test-log-group"),
+			LogStreamNamePrefix: aws.String("test-log-stream"),
+		}).Return(&cloudwatchlogs.DescribeLogStreamsOutput{NextToken: aws.String("page-2")}, nil)
+		mockClient.On("DescribeLogStreams", &cloudwatchlogs.DescribeLogStreamsInput{
+			LogGroupName:        aws.String("test-log-group"),
+			LogStreamNamePrefix: aws.String("test-log-stream"),
+			NextToken:           aws.String("page-2"),
+		}).Return(&cloudwatchlogs.DescribeLogStreamsOutput{
+			LogStreams: []*cloudwatchlogs.LogStream{{LogStreamName: aws.String("test-log-stream")}},
+		}, nil)
+
+		err := EnsureLogGroupAndLogStreamExists(mockClient, logConfig)
+		require.NoError(t, err)
+
+		mockClient.AssertExpectations(t)
+		mockClient.AssertNotCalled(t, "CreateLogGroup", mock.Anything)
+		mockClient.AssertNotCalled(t, "CreateLogStream", mock.Anything)
+	})
 }
 
 func TestSendEventsToCloudWatch(t *testing.T) {
@@ -144,6 +200,85 @@ func TestSendEventsToCloudWatch(t *testing.T) {
 	})
 }
 
+func TestDestinationCache(t *testing.T) {
+	var cache DestinationCache
+	destination := LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}
+
+	calls := 0
+	failing := func() error { calls++; return fmt.Errorf("access denied") }
+	succeeding := func() error { calls++; return nil }
+
+	// Failures are not cached, so the next call tries again
+	require.Error(t, cache.Ensure(destination, failing))
+	require.NoError(t, cache.Ensure(destination, succeeding))
+	require.NoError(t, cache.Ensure(destination, succeeding))
+	assert.Equal(t, 2, calls)
+}
+
+func TestDestinationCacheFailures(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 0, 0, 0, time.UTC)
+	cache := &DestinationCache{FailureTTL: 30 * time.Second, now: func() time.Time { return now }}
+	destination := LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}
+
+	calls := 0
+	failing := func() error { calls++; return fmt.Errorf("access denied") }
+	succeeding := func() error { calls++; return nil }
+
+	// A failure is returned without calling ensureFn until the TTL has passed
+	require.Error(t, cache.Ensure(destination, failing))
+	err := cache.Ensure(destination, succeeding)
+	require.Error(t, err)
+	assert.Equal(t, "access denied", err.Error())
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(31 * time.Second)
+	require.NoError(t, cache.Ensure(destination, succeeding))
+	require.NoError(t, cache.Ensure(destination, failing))
+	assert.Equal(t, 2, calls)
+}
+
+func TestDestinationCacheConcurrentCalls(t *testing.T) {
+	var cache DestinationCache
+	destination := LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	ensure := func() error {
+		calls.Add(1)
+		<-release
+		return nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, cache.Ensure(destination, ensure))
+		}()
+	}
+	// Wait until the first call is in progress before releasing it
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Other destinations are checked independently
+	other := LogConfig{LogGroupName: "test-log-group", LogStreamName: "other"}
+	require.NoError(t, cache.Ensure(other, func() error { calls.Add(1); return nil }))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestEnsureLogStreamAlreadyExists(t *testing.T) {
+	mockClient := new(MockCloudWatchLogsClient)
+	mockClient.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{}, nil)
+	mockClient.On("CreateLogStream", mock.Anything).Return(&cloudwatchlogs.CreateLogStreamOutput{},
+		awserr.New(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "The specified log stream already exists", nil))
+
+	// Created by another process in the meantime
+	require.NoError(t, ensureLogStreamExists(mockClient, "test-log-group", "test-log-stream"))
+	mockClient.AssertExpectations(t)
+}
+
 func TestEstimateEventSize(t *testing.T) {
 	event := &cloudwatchlogs.InputLogEvent{
 		Message:   aws.String("test message"),
@@ -155,3 +290,9 @@ func TestEstimateEventSize(t *testing.T) {
 
 	assert.Equal(t, expectedSize, actualSize)
 }
+
+func TestRejectedEvents(t *testing.T) {
+	assert.Equal(t, 0, rejectedEvents(nil, 10))
+	assert.Equal(t, 3, rejectedEvents(&cloudwatchlogs.RejectedLogEventsInfo{TooOldLogEventEndIndex: aws.Int64(2), ExpiredLogEventEndIndex: aws.Int64(3)}, 10))
+	assert.Equal(t, 4, rejectedEvents(&cloudwatchlogs.RejectedLogEventsInfo{TooOldLogEventEndIndex: aws.Int64(1), TooNewLogEventStartIndex: aws.Int64(7)}, 10))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// compareKeyMargin is how far the end time in the name of a log file may be outside a time range while the file
+// can still have entries in it, as entries are written at the end of their request
+const compareKeyMargin = 10 * time.Minute
+
+// statusClasses are the classes of status codes reported by compare, in order
+var statusClasses = []string{"2xx", "3xx", "4xx", "5xx"}
+
+// comparePercentiles are the percentiles of the target processing time reported by compare
+var comparePercentiles = []float64{50, 90, 99}
+
+// TimeRange is a range of time from From up to but not including To, either may be zero for an open end
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// ParseTimeRange parses a range of two RFC 3339 times separated by a slash as in ISO 8601, such as
+// 2024-03-21T14:00:00Z/2024-03-21T15:00:00Z. Either time may be left out for an open end.
+func ParseTimeRange(value string) (TimeRange, error) {
+	from, to, ok := strings.Cut(value, "/")
+	if !ok {
+		return TimeRange{}, fmt.Errorf("invalid time range '%s', expected <from>/<to> in RFC 3339 such as 2024-03-21T14:00:00Z/2024-03-21T15:00:00Z", value)
+	}
+	var r TimeRange
+	var err error
+	if from != "" {
+		if r.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return TimeRange{}, fmt.Errorf("invalid time range '%s': %v", value, err)
+		}
+	}
+	if to != "" {
+		if r.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return TimeRange{}, fmt.Errorf("invalid time range '%s': %v", value, err)
+		}
+	}
+	if !r.From.IsZero() && !r.To.IsZero() && !r.From.Before(r.To) {
+		return TimeRange{}, fmt.Errorf("invalid time range '%s', the start must be before the end", value)
+	}
+
+	return r, nil
+}
+
+// Contains reports whether t is in the range
+func (r TimeRange) Contains(t time.Time) bool {
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || t.Before(r.To))
+}
+
+// mayContain reports whether the log file of an object may have entries in the range, by the end time in its name.
+// Objects with other names may.
+func (r TimeRange) mayContain(s3Object S3ObjectInfo) bool {
+	key, err := ParseELBObjectKey(s3Object.Key)
+	if err != nil {
+		return true
+	}
+
+	return (r.From.IsZero() || !key.EndTime.Add(compareKeyMargin).Before(r.From)) &&
+		(r.To.IsZero() || !key.EndTime.Add(-compareKeyMargin).After(r.To))
+}
+
+// String formats the range as parsed by ParseTimeRange, empty for a range without ends
+func (r TimeRange) String() string {
+	if r.From.IsZero() && r.To.IsZero() {
+		return ""
+	}
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	return format(r.From) + "/" + format(r.To)
+}
+
+// TrafficMetrics counts the requests in a time range by the class of their status code, and their target processing
+// times in a latencySketch for percentiles. It uses the records, so the metrics don't depend on the configured fields.
+type TrafficMetrics struct {
+	Range    TimeRange
+	Requests int
+	Statuses map[string]int // Requests by status class, such as 5xx, of the load balancer
+	latency  latencySketch  // Target processing times of requests that reached a target
+}
+
+func (m *TrafficMetrics) Transform(record []string, entry *LogEntry) {
+	if !m.Range.Contains(entry.Timestamp) {
+		return
+	}
+	if m.Statuses == nil {
+		m.Statuses = make(map[string]int)
+	}
+	m.Requests++
+	if status := recordValue(record, "elb_status_code"); len(status) == 3 {
+		m.Statuses[status[:1]+"xx"]++
+	}
+	if latency, ok := parseProcessingTime(recordValue(record, "target_processing_time")); ok {
+		m.latency.add(latency.Seconds())
+	}
+}
+
+// Share returns the percentage of the requests with a status code of the class
+func (m *TrafficMetrics) Share(class string) float64 {
+	if m.Requests == 0 {
+		return 0
+	}
+
+	return float64(m.Statuses[class]) / float64(m.Requests) * 100
+}
+
+// Percentile returns the p-th percentile of the target processing time by the nearest rank, and false without
+// requests that reached a target
+func (m *TrafficMetrics) Percentile(p float64) (time.Duration, bool) {
+	latency, ok := m.latency.percentile(p)
+
+	return time.Duration(latency * float64(time.Millisecond)), ok
+}
+
+// discardSink counts the entries of an object without sending them
+type discardSink struct {
+	entries int
+}
+
+func (s *discardSink) Send(entries <-chan LogEntry) error {
+	for range entries {
+		s.entries++
+	}
+
+	return nil
+}
+
+func (s *discardSink) Sent() (int, int64) {
+	return s.entries, 0
+}
+
+// Comparer measures the traffic of two sets of objects, such as the logs of an hour before and after a deployment,
+// to compare them. The configured filters and transformers don't apply, so the metrics describe all requests.
+type Comparer struct {
+	Source Source
+	Fields Fields
+	Config Config
+}
+
+// Measure returns the metrics of the requests in the range of the objects, objects of which the name shows that
+// they have no entries in the range are skipped
+func (c *Comparer) Measure(s3Objects []S3ObjectInfo, r TimeRange) (*TrafficMetrics, error) {
+	metrics := &TrafficMetrics{Range: r, Statuses: make(map[string]int)}
+	pipeline := &Pipeline{
+		Source: c.Source,
+		Parser: &RecordParser{Fields: c.Fields, Layouts: c.Config.TimestampLayouts},
+		Stages: func(object S3ObjectInfo, metadata ObjectMetadata) ([]Transformer, Sink) {
+			return []Transformer{metrics}, &discardSink{}
+		},
+	}
+	for _, s3Object := range s3Objects {
+		if !r.mayContain(s3Object) {
+			logf(verbosityVerbose, "skipped %s outside of %s", s3Object, r)
+			continue
+		}
+		result, err := pipeline.Run(s3Object)
+		if err == ErrEmptyObject {
+			continue
+		}
+		if err != nil {
+			return metrics, fmt.Errorf("error measuring %s: %w", s3Object, err)
+		}
+		logf(verbosityVerbose, "measured %d log entries from %s", result.Entries, s3Object)
+	}
+
+	return metrics, nil
+}
+
+// WriteComparison writes the metrics of a and b side by side with the change from a to b: counts with their
+// relative change, shares of status classes in percentage points and percentiles in milliseconds
+func WriteComparison(w io.Writer, a, b *TrafficMetrics) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\ta\tb\tchange\t")
+	fmt.Fprintf(tw, "requests\t%d\t%d\t%+d %s\t\n", a.Requests, b.Requests, b.Requests-a.Requests, relativeChange(float64(a.Requests), float64(b.Requests)))
+	for _, class := range statusClasses {
+		shareA, shareB := a.Share(class), b.Share(class)
+		fmt.Fprintf(tw, "%s\t%.1f%%\t%.1f%%\t%+.1fpp\t\n", class, shareA, shareB, shareB-shareA)
+	}
+	for _, p := range comparePercentiles {
+		latencyA, okA := a.Percentile(p)
+		latencyB, okB := b.Percentile(p)
+		change := "-"
+		if okA && okB {
+			change = fmt.Sprintf("%+.1fms %s", milliseconds(latencyB-latencyA), relativeChange(latencyA.Seconds(), latencyB.Seconds()))
+		}
+		fmt.Fprintf(tw, "p%g latency\t%s\t%s\t%s\t\n", p, formatLatency(latencyA, okA), formatLatency(latencyB, okB), change)
+	}
+
+	return tw.Flush()
+}
+
+// relativeChange formats the change from a to b in percent in parentheses, "(-)" when a is 0
+func relativeChange(a, b float64) string {
+	if a == 0 {
+		return "(-)"
+	}
+
+	return fmt.Sprintf("(%+.1f%%)", (b-a)/a*100)
+}
+
+// formatLatency formats a latency in milliseconds, "-" when unknown
+func formatLatency(latency time.Duration, ok bool) string {
+	if !ok {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.1fms", milliseconds(latency))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	r, err := ParseTimeRange("2024-03-21T14:00:00Z/2024-03-21T15:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, TimeRange{From: time.Date(2024, 3, 21, 14, 0, 0, 0, time.UTC), To: time.Date(2024, 3, 21, 15, 0, 0, 0, time.UTC)}, r)
+	assert.True(t, r.Contains(time.Date(2024, 3, 21, 14, 0, 0, 0, time.UTC)))
+	assert.False(t, r.Contains(time.Date(2024, 3, 21, 15, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "2024-03-21T14:00:00Z/2024-03-21T15:00:00Z", r.String())
+
+	r, err = ParseTimeRange("2024-03-21T14:00:00Z/")
+	require.NoError(t, err)
+	assert.True(t, r.Contains(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	_, err = ParseTimeRange("2024-03-21T14:00:00Z")
+	assert.Error(t, err)
+	_, err = ParseTimeRange("2024-03-21T15:00:00Z/2024-03-21T14:00:00Z")
+	assert.EqualError(t, err, "invalid time range '2024-03-21T15:00:00Z/2024-03-21T14:00:00Z', the start must be before the end")
+	_, err = ParseTimeRange("yesterday/")
+	assert.Error(t, err)
+}
+
+func TestTimeRangeMayContain(t *testing.T) {
+	r, err := ParseTimeRange("2024-03-21T14:00:00Z/2024-03-21T15:00:00Z")
+	require.NoError(t, err)
+	object := func(end string) S3ObjectInfo {
+		return S3ObjectInfo{Key: "AWSLogs/123456789012/elasticloadbalancing/eu-west-1/2024/03/21/123456789012_elasticloadbalancing_eu-west-1_app.my-lb.1234567890abcdef_" + end + "_10.0.0.1_abc.log.gz"}
+	}
+	assert.False(t, r.mayContain(object("20240321T1345Z")))
+	assert.True(t, r.mayContain(object("20240321T1355Z")))
+	assert.True(t, r.mayContain(object("20240321T1430Z")))
+	assert.True(t, r.mayContain(object("20240321T1505Z")))
+	assert.False(t, r.mayContain(object("20240321T1515Z")))
+	assert.True(t, r.mayContain(S3ObjectInfo{Key: "access.log"}))
+}
+
+func TestComparer(t *testing.T) {
+	// line returns a record at a minute past 16:00 with a status code and target processing time
+	line := func(minute int, status, latency string) string {
+		record := strings.Replace(testRecordLine(minute), "2024-03-21T16:10:26.071854Z", fmt.Sprintf("2024-03-21T16:%02d:00.000000Z", minute), 1)
+		return strings.Replace(record, " 0.004 0.024 0.003 203 203 ", " 0.004 "+latency+" 0.003 "+status+" "+status+" ", 1)
+	}
+	lines := []string{
+		// Before 16:30
+		line(0, "200", "0.010"), line(1, "200", "0.020"), line(2, "404", "0.030"), line(3, "200", "0.040"),
+		// After 16:30, slower with more errors
+		line(30, "200", "0.100"), line(31, "503", "-1"), line(32, "502", "0.300"),
+	}
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "a.log")
+	require.NoError(t, os.WriteFile(logFile, []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+	s3Objects, err := listLocalObjects(logFile)
+	require.NoError(t, err)
+	fields, err := NewFields("elb_status_code")
+	require.NoError(t, err)
+	comparer := &Comparer{Source: NewSources(nil), Fields: fields}
+
+	before, err := comparer.Measure(s3Objects, TimeRange{To: time.Date(2024, 3, 21, 16, 30, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	after, err := comparer.Measure(s3Objects, TimeRange{From: time.Date(2024, 3, 21, 16, 30, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	assert.Equal(t, 4, before.Requests)
+	assert.Equal(t, map[string]int{"2xx": 3, "4xx": 1}, before.Statuses)
+	assert.Equal(t, 3, after.Requests)
+	p50, ok := before.Percentile(50)
+	assert.True(t, ok)
+	assert.Equal(t, 20*time.Millisecond, p50)
+	p99, ok := after.Percentile(99)
+	assert.True(t, ok)
+	assert.Equal(t, 300*time.Millisecond, p99)
+	_, ok = (&TrafficMetrics{}).Percentile(50)
+	assert.False(t, ok)
+
+	var out bytes.Buffer
+	require.NoError(t, WriteComparison(&out, before, after))
+	assert.Equal(t, strings.Join([]string{
+		"                    a        b              change",
+		"     requests       4        3         -1 (-25.0%)",
+		"          2xx   75.0%    33.3%             -41.7pp",
+		"          3xx    0.0%     0.0%              +0.0pp",
+		"          4xx   25.0%     0.0%             -25.0pp",
+		"          5xx    0.0%    66.7%             +66.7pp",
+		"  p50 latency  20.0ms  100.0ms   +80.0ms (+400.0%)",
+		"  p90 latency  40.0ms  300.0ms  +260.0ms (+650.0%)",
+		"  p99 latency  40.0ms  300.0ms  +260.0ms (+650.0%)",
+	}, "\n")+"\n", out.String())
+}
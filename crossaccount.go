@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// crossAccountPolicyName is the name of the inline policy that lets a destination role write the logs
+const crossAccountPolicyName = "elb-logs-to-cloudwatch"
+
+type IAMApi interface {
+	CreateRole(*iam.CreateRoleInput) (*iam.CreateRoleOutput, error)
+	UpdateAssumeRolePolicy(*iam.UpdateAssumeRolePolicyInput) (*iam.UpdateAssumeRolePolicyOutput, error)
+	PutRolePolicy(*iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error)
+}
+
+// PolicyDocument is an IAM policy
+type PolicyDocument struct {
+	Version   string
+	Statement []PolicyStatement
+}
+
+type PolicyStatement struct {
+	Effect    string
+	Principal map[string]string `json:",omitempty"`
+	Action    []string
+	Resource  []string `json:",omitempty"`
+}
+
+// CrossAccountRole is the setup of a role that writes to log groups in another account, see AccountRoute.RoleARN.
+// CloudWatch Logs has no resource policies that let principals of other accounts write to a log group, so the
+// role in the destination account must trust the principal that ships the logs and allow writing to the log groups.
+type CrossAccountRole struct {
+	RoleARN           string         `json:"roleArn"`
+	LogGroups         []string       `json:"logGroups"`
+	TrustPolicy       PolicyDocument `json:"trustPolicy"`
+	PermissionsPolicy PolicyDocument `json:"permissionsPolicy"`
+}
+
+// CrossAccountSetup holds the policies needed for the account routes with a role
+type CrossAccountSetup struct {
+	Roles []CrossAccountRole `json:"roles"`
+	// SourcePolicy lets the shipping principal assume the roles
+	SourcePolicy PolicyDocument `json:"sourcePolicy"`
+}
+
+// logNamePlaceholder matches the placeholders of log group names, see expandLogName
+var logNamePlaceholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+// NewCrossAccountSetup returns the policies for the roles of the account routes, to be assumed by principal. Log
+// group names with placeholders become wildcards. An empty region allows every region.
+func NewCrossAccountSetup(config Config, principal, region string) (CrossAccountSetup, error) {
+	if region == "" {
+		region = "*"
+	}
+	logGroups := make(map[string]map[string]bool)
+	for _, route := range config.AccountRoutes {
+		if route.RoleARN == "" {
+			continue
+		}
+		logGroup := route.LogGroupName
+		if logGroup == "" {
+			logGroup = config.LogGroupName
+		}
+		if logGroups[route.RoleARN] == nil {
+			logGroups[route.RoleARN] = make(map[string]bool)
+		}
+		logGroups[route.RoleARN][logNamePlaceholder.ReplaceAllString(logGroup, "*")] = true
+	}
+	setup := CrossAccountSetup{Roles: []CrossAccountRole{}}
+	var roleARNs []string
+	for roleARN, groups := range logGroups {
+		account, err := roleAccount(roleARN)
+		if err != nil {
+			return CrossAccountSetup{}, err
+		}
+		role := CrossAccountRole{RoleARN: roleARN}
+		logGroupARN := fmt.Sprintf("arn:aws:logs:%s:%s:log-group:", region, account)
+		var resources []string
+		for group := range groups {
+			role.LogGroups = append(role.LogGroups, group)
+			resources = append(resources, logGroupARN+group, logGroupARN+group+":*")
+		}
+		sort.Strings(role.LogGroups)
+		sort.Strings(resources)
+		role.TrustPolicy = PolicyDocument{Version: "2012-10-17", Statement: []PolicyStatement{{
+			Effect:    "Allow",
+			Principal: map[string]string{"AWS": principal},
+			Action:    []string{"sts:AssumeRole"},
+		}}}
+		role.PermissionsPolicy = PolicyDocument{Version: "2012-10-17", Statement: []PolicyStatement{
+			{
+				// Log groups are found by listing with a prefix, which is authorized on all log groups
+				Effect:   "Allow",
+				Action:   []string{"logs:DescribeLogGroups"},
+				Resource: []string{logGroupARN + "*"},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"logs:CreateLogGroup", "logs:CreateLogStream", "logs:DescribeLogStreams", "logs:PutLogEvents"},
+				Resource: resources,
+			},
+		}}
+		setup.Roles = append(setup.Roles, role)
+		roleARNs = append(roleARNs, roleARN)
+	}
+	sort.Slice(setup.Roles, func(i, j int) bool { return setup.Roles[i].RoleARN < setup.Roles[j].RoleARN })
+	sort.Strings(roleARNs)
+	setup.SourcePolicy = PolicyDocument{Version: "2012-10-17", Statement: []PolicyStatement{{
+		Effect:   "Allow",
+		Action:   []string{"sts:AssumeRole"},
+		Resource: roleARNs,
+	}}}
+
+	return setup, nil
+}
+
+// roleAccount returns the account ID of a role ARN such as arn:aws:iam::111111111111:role/shipper
+func roleAccount(roleARN string) (string, error) {
+	parts := strings.SplitN(roleARN, ":", 6)
+	if len(parts) != 6 || parts[2] != "iam" || parts[4] == "" || !strings.HasPrefix(parts[5], "role/") {
+		return "", fmt.Errorf("invalid role ARN '%s'", roleARN)
+	}
+
+	return parts[4], nil
+}
+
+// Apply creates the role, or replaces its trust policy if it exists, and puts the permissions policy as inline
+// policy of the role. It needs credentials of the account of the role.
+func (r CrossAccountRole) Apply(client IAMApi) error {
+	path, name := "/", r.RoleARN[strings.Index(r.RoleARN, ":role/")+len(":role"):]
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		path, name = name[:i+1], name[i+1:]
+	}
+	trustPolicy, err := json.Marshal(r.TrustPolicy)
+	if err != nil {
+		return err
+	}
+	permissionsPolicy, err := json.Marshal(r.PermissionsPolicy)
+	if err != nil {
+		return err
+	}
+	_, err = client.UpdateAssumeRolePolicy(&iam.UpdateAssumeRolePolicyInput{
+		RoleName:       aws.String(name),
+		PolicyDocument: aws.String(string(trustPolicy)),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == iam.ErrCodeNoSuchEntityException {
+		log.Printf("creating role %s", r.RoleARN)
+		_, err = client.CreateRole(&iam.CreateRoleInput{
+			Path:                     aws.String(path),
+			RoleName:                 aws.String(name),
+			AssumeRolePolicyDocument: aws.String(string(trustPolicy)),
+			Description:              aws.String("Writes ELB access logs shipped by elb-logs-to-cloudwatch"),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set the trust policy of role %s: %v", r.RoleARN, err)
+	}
+	if _, err = client.PutRolePolicy(&iam.PutRolePolicyInput{
+		RoleName:       aws.String(name),
+		PolicyName:     aws.String(crossAccountPolicyName),
+		PolicyDocument: aws.String(string(permissionsPolicy)),
+	}); err != nil {
+		return fmt.Errorf("failed to put the policy of role %s: %v", r.RoleARN, err)
+	}
+	log.Printf("updated the policies of role %s", r.RoleARN)
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockIAMApi struct {
+	mock.Mock
+}
+
+func (m *MockIAMApi) CreateRole(input *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*iam.CreateRoleOutput), args.Error(1)
+}
+
+func (m *MockIAMApi) UpdateAssumeRolePolicy(input *iam.UpdateAssumeRolePolicyInput) (*iam.UpdateAssumeRolePolicyOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*iam.UpdateAssumeRolePolicyOutput), args.Error(1)
+}
+
+func (m *MockIAMApi) PutRolePolicy(input *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*iam.PutRolePolicyOutput), args.Error(1)
+}
+
+func TestNewCrossAccountSetup(t *testing.T) {
+	principal := "arn:aws:iam::999999999999:role/shipper"
+	config := Config{LogGroupName: "/elb/default", AccountRoutes: AccountRoutes{
+		"111111111111":           {LogGroupName: "/elb/{elb}", RoleARN: "arn:aws:iam::111111111111:role/logs/writer"},
+		"111111111111/us-east-1": {RoleARN: "arn:aws:iam::111111111111:role/logs/writer"},
+		"222222222222":           {LogGroupName: "/elb/team-b"},
+	}}
+	setup, err := NewCrossAccountSetup(config, principal, "eu-west-1")
+	require.NoError(t, err)
+
+	require.Len(t, setup.Roles, 1)
+	role := setup.Roles[0]
+	assert.Equal(t, "arn:aws:iam::111111111111:role/logs/writer", role.RoleARN)
+	assert.Equal(t, []string{"/elb/*", "/elb/default"}, role.LogGroups)
+	assert.Equal(t, map[string]string{"AWS": principal}, role.TrustPolicy.Statement[0].Principal)
+	assert.Equal(t, []string{
+		"arn:aws:logs:eu-west-1:111111111111:log-group:/elb/*",
+		"arn:aws:logs:eu-west-1:111111111111:log-group:/elb/*:*",
+		"arn:aws:logs:eu-west-1:111111111111:log-group:/elb/default",
+		"arn:aws:logs:eu-west-1:111111111111:log-group:/elb/default:*",
+	}, role.PermissionsPolicy.Statement[1].Resource)
+	assert.Equal(t, []string{"arn:aws:iam::111111111111:role/logs/writer"}, setup.SourcePolicy.Statement[0].Resource)
+
+	_, err = NewCrossAccountSetup(Config{AccountRoutes: AccountRoutes{"1": {RoleARN: "writer"}}}, principal, "")
+	assert.EqualError(t, err, "invalid role ARN 'writer'")
+}
+
+func TestCrossAccountRoleApply(t *testing.T) {
+	setup, err := NewCrossAccountSetup(Config{LogGroupName: "/elb/default", AccountRoutes: AccountRoutes{
+		"111111111111": {RoleARN: "arn:aws:iam::111111111111:role/logs/writer"},
+	}}, "arn:aws:iam::999999999999:role/shipper", "")
+	require.NoError(t, err)
+	trustPolicy, err := json.Marshal(setup.Roles[0].TrustPolicy)
+	require.NoError(t, err)
+
+	client := new(MockIAMApi)
+	client.On("UpdateAssumeRolePolicy", mock.Anything).Return(&iam.UpdateAssumeRolePolicyOutput{}, awserr.New(iam.ErrCodeNoSuchEntityException, "not found", nil))
+	client.On("CreateRole", mock.MatchedBy(func(input *iam.CreateRoleInput) bool {
+		return aws.StringValue(input.RoleName) == "writer" && aws.StringValue(input.Path) == "/logs/" &&
+			aws.StringValue(input.AssumeRolePolicyDocument) == string(trustPolicy)
+	})).Return(&iam.CreateRoleOutput{}, nil)
+	client.On("PutRolePolicy", mock.MatchedBy(func(input *iam.PutRolePolicyInput) bool {
+		return aws.StringValue(input.RoleName) == "writer" && aws.StringValue(input.PolicyName) == crossAccountPolicyName
+	})).Return(&iam.PutRolePolicyOutput{}, nil)
+
+	require.NoError(t, setup.Roles[0].Apply(client))
+	client.AssertExpectations(t)
+}
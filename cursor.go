@@ -0,0 +1,207 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// listingCursor lists the new objects under a prefix poll after poll. ELB writes access logs to a directory per
+// account and region, such as AWSLogs/123456789012/elasticloadbalancing/us-east-1/, with a directory per day under
+// it. Keys only grow within such a log directory, so the cursor keeps a position per log directory: the directory of
+// the oldest object that isn't done, from where the next listing starts. A single position for the prefix would
+// pass the directories of other accounts and regions that sort before the latest one, and miss their new objects.
+type listingCursor struct {
+	from   map[string]string   // Listing of a log directory starts after this key
+	done   map[string]bool     // Keys after the position of their directory that are done
+	listed map[string][]string // Keys of the last listing by log directory
+	legacy string              // Position of the whole prefix as saved before positions were kept per directory
+}
+
+// logDirectories lists the log directories under a prefix: directories with a directory of a year, or without
+// directories. This takes a listing per directory level, so they are listed again every poll to find new regions.
+// Objects next to the directories of accounts and regions, such as the ELBAccessLogTestFile that ELB writes when
+// logging is enabled, are not access logs and aren't listed.
+func logDirectories(h *Handler, bucket, prefix string) ([]string, error) {
+	var directories []string
+	pending := []string{prefix}
+	for len(pending) > 0 {
+		directory := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		children, err := h.listS3Directories(bucket, directory)
+		if err != nil {
+			return nil, err
+		}
+		if len(children) == 0 || containsDateDirectory(children) {
+			directories = append(directories, directory)
+		} else {
+			pending = append(pending, children...)
+		}
+	}
+	sort.Strings(directories)
+
+	return directories, nil
+}
+
+// containsDateDirectory reports whether one of the directories is named by a year, month or day, of up to 4
+// digits unlike an account ID
+func containsDateDirectory(directories []string) bool {
+	for _, directory := range directories {
+		name := path.Base(directory)
+		if len(name) <= 4 && strings.Trim(name, "0123456789") == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// list returns the objects after the position of every log directory that aren't done, in key order
+func (c *listingCursor) list(h *Handler, bucket, prefix string) ([]S3ObjectInfo, error) {
+	directories, err := logDirectories(h, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	c.restoreLegacy(directories)
+	c.listed = make(map[string][]string)
+	var s3Objects []S3ObjectInfo
+	for _, directory := range directories {
+		listed, _, err := h.listS3Objects(bucket, directory, c.from[directory], 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, s3Object := range listed {
+			c.listed[directory] = append(c.listed[directory], s3Object.Key)
+			if !c.done[s3Object.Key] {
+				s3Objects = append(s3Objects, s3Object)
+			}
+		}
+	}
+
+	return s3Objects, nil
+}
+
+// skipExisting marks the objects of the latest day of every log directory done and continues from there, so only
+// objects that arrive later are listed. Earlier days aren't listed at all.
+func (c *listingCursor) skipExisting(h *Handler, bucket, prefix string) error {
+	directories, err := logDirectories(h, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	for _, directory := range directories {
+		latest := directory
+		for {
+			children, err := h.listS3Directories(bucket, latest)
+			if err != nil {
+				return err
+			}
+			if !containsDateDirectory(children) {
+				break
+			}
+			for _, child := range children {
+				if containsDateDirectory([]string{child}) {
+					latest = child
+				}
+			}
+		}
+		existing, _, err := h.listS3Objects(bucket, latest, "", 0)
+		if err != nil {
+			return err
+		}
+		for _, s3Object := range existing {
+			c.markDone(s3Object.Key)
+		}
+		c.setFrom(directory, latest)
+	}
+
+	return nil
+}
+
+// markDone marks an object done, so it isn't listed again
+func (c *listingCursor) markDone(key string) {
+	if c.done == nil {
+		c.done = make(map[string]bool)
+	}
+	c.done[key] = true
+}
+
+// advance moves the position of every log directory of the last listing to the directory of its oldest object that
+// isn't done, or of its latest object if all are done
+func (c *listingCursor) advance() {
+	for directory, keys := range c.listed {
+		next := keys[len(keys)-1]
+		for _, key := range keys {
+			if !c.done[key] {
+				next = key
+				break
+			}
+		}
+		if from := path.Dir(next) + "/"; from > c.from[directory] && strings.HasPrefix(from, directory) {
+			c.setFrom(directory, from)
+		}
+	}
+}
+
+// setFrom sets the position of a log directory and forgets the keys before it that are done
+func (c *listingCursor) setFrom(directory, from string) {
+	if c.from == nil {
+		c.from = make(map[string]string)
+	}
+	c.from[directory] = from
+	for key := range c.done {
+		if strings.HasPrefix(key, directory) && key <= from {
+			delete(c.done, key)
+		}
+	}
+}
+
+// state returns the positions and the keys that are done, to continue elsewhere with restore
+func (c *listingCursor) state() WatchState {
+	state := WatchState{From: make(map[string]string)}
+	for directory, from := range c.from {
+		state.From[directory] = from
+	}
+	for key := range c.done {
+		state.Done = append(state.Done, key)
+	}
+	sort.Strings(state.Done)
+
+	return state
+}
+
+// restore continues from a saved state
+func (c *listingCursor) restore(state WatchState) {
+	c.from = make(map[string]string)
+	c.done = make(map[string]bool)
+	c.legacy = ""
+	for directory, from := range state.From {
+		if directory == "" {
+			c.legacy = from
+		} else {
+			c.from[directory] = from
+		}
+	}
+	for _, key := range state.Done {
+		c.done[key] = true
+	}
+}
+
+// restoreLegacy applies a position of the whole prefix to the log directories. It belongs to the log directory it
+// is in, and the other log directories continue from the same day, as they were listed up to about that day.
+func (c *listingCursor) restoreLegacy(directories []string) {
+	if c.legacy == "" {
+		return
+	}
+	day, found := "", false
+	for _, directory := range directories {
+		if strings.HasPrefix(c.legacy, directory) {
+			day, found = strings.TrimPrefix(c.legacy, directory), true
+		}
+	}
+	for _, directory := range directories {
+		if _, ok := c.from[directory]; !ok && found {
+			c.setFrom(directory, directory+day)
+		}
+	}
+	c.legacy = ""
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listingS3 lists its keys like S3 does, with the prefix, start after key and delimiter of the input. Other
+// calls go to the mock.
+type listingS3 struct {
+	*MockS3Api
+	keys []string
+}
+
+func newListingS3(keys ...string) *listingS3 {
+	s := &listingS3{MockS3Api: new(MockS3Api)}
+	s.add(keys...)
+	return s
+}
+
+func (s *listingS3) add(keys ...string) {
+	s.keys = append(s.keys, keys...)
+	sort.Strings(s.keys)
+}
+
+func (s *listingS3) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	prefix, startAfter, delimiter := aws.StringValue(input.Prefix), aws.StringValue(input.StartAfter), aws.StringValue(input.Delimiter)
+	output := &s3.ListObjectsV2Output{}
+	for _, key := range s.keys {
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			continue
+		}
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			commonPrefix := key[:len(prefix)+i+1]
+			if n := len(output.CommonPrefixes); n == 0 || aws.StringValue(output.CommonPrefixes[n-1].Prefix) != commonPrefix {
+				output.CommonPrefixes = append(output.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(commonPrefix)})
+			}
+			continue
+		}
+		output.Contents = append(output.Contents, &s3.Object{Key: aws.String(key)})
+	}
+
+	return output, nil
+}
+
+// Keys of the access logs of an account in two regions
+const (
+	testAccountDirectory = "logs/AWSLogs/123456789012/elasticloadbalancing/"
+	testEUDirectory      = testAccountDirectory + "eu-west-1/"
+	testUSDirectory      = testAccountDirectory + "us-east-1/"
+)
+
+func listedKeys(s3Objects []S3ObjectInfo) []string {
+	keys := []string{}
+	for _, s3Object := range s3Objects {
+		keys = append(keys, s3Object.Key)
+	}
+	return keys
+}
+
+func TestLogDirectories(t *testing.T) {
+	s3Client := newListingS3(
+		"logs/AWSLogs/123456789012/ELBAccessLogTestFile",
+		testEUDirectory+"2024/03/21/a-1",
+		testUSDirectory+"2024/03/21/b-1",
+		"logs/other/a-1",
+	)
+
+	directories, err := logDirectories(&Handler{s3Client: s3Client}, "bucket", "logs/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testEUDirectory, testUSDirectory, "logs/other/"}, directories)
+
+	// A prefix in the directory of a region
+	directories, err = logDirectories(&Handler{s3Client: s3Client}, "bucket", testUSDirectory+"2024/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testUSDirectory + "2024/"}, directories)
+}
+
+func TestListingCursor(t *testing.T) {
+	s3Client := newListingS3(
+		testEUDirectory+"2024/03/21/a-1",
+		testUSDirectory+"2024/03/21/b-1",
+		testUSDirectory+"2024/03/22/b-2",
+	)
+	h := &Handler{s3Client: s3Client}
+	cursor := &listingCursor{}
+
+	listed, err := cursor.list(h, "bucket", "logs/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testEUDirectory + "2024/03/21/a-1", testUSDirectory + "2024/03/21/b-1", testUSDirectory + "2024/03/22/b-2"}, listedKeys(listed))
+	for _, s3Object := range listed {
+		cursor.markDone(s3Object.Key)
+	}
+	cursor.advance()
+	assert.Equal(t, map[string]string{testEUDirectory: testEUDirectory + "2024/03/21/", testUSDirectory: testUSDirectory + "2024/03/22/"}, cursor.from)
+
+	// New objects in the region that sorts before the latest object are listed, a failed object is listed again
+	s3Client.add(testEUDirectory+"2024/03/21/a-2", testUSDirectory+"2024/03/22/b-3")
+	listed, err = cursor.list(h, "bucket", "logs/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testEUDirectory + "2024/03/21/a-2", testUSDirectory + "2024/03/22/b-3"}, listedKeys(listed))
+	cursor.markDone(testUSDirectory + "2024/03/22/b-3")
+	cursor.advance()
+	listed, err = cursor.list(h, "bucket", "logs/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testEUDirectory + "2024/03/21/a-2"}, listedKeys(listed))
+
+	// The state continues elsewhere
+	restored := &listingCursor{}
+	restored.restore(cursor.state())
+	listed, err = restored.list(h, "bucket", "logs/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testEUDirectory + "2024/03/21/a-2"}, listedKeys(listed))
+}
+
+func TestListingCursorSkipExisting(t *testing.T) {
+	s3Client := newListingS3(
+		testEUDirectory+"2024/03/21/a-1",
+		testUSDirectory+"2024/02/29/b-1",
+		testUSDirectory+"2024/03/21/b-2",
+	)
+	h := &Handler{s3Client: s3Client}
+	cursor := &listingCursor{}
+
+	require.NoError(t, cursor.skipExisting(h, "bucket", "logs/"))
+	assert.Equal(t, map[string]string{testEUDirectory: testEUDirectory + "2024/03/21/", testUSDirectory: testUSDirectory + "2024/03/21/"}, cursor.from)
+	listed, err := cursor.list(h, "bucket", "logs/")
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+
+	s3Client.add(testEUDirectory+"2024/03/21/a-2", testUSDirectory+"2024/03/22/b-3")
+	listed, err = cursor.list(h, "bucket", "logs/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testEUDirectory + "2024/03/21/a-2", testUSDirectory + "2024/03/22/b-3"}, listedKeys(listed))
+}
+
+func TestListingCursorLegacyState(t *testing.T) {
+	s3Client := newListingS3(
+		testEUDirectory+"2024/03/20/a-1",
+		testEUDirectory+"2024/03/21/a-2",
+		testUSDirectory+"2024/03/21/b-1",
+		testUSDirectory+"2024/03/21/b-2",
+	)
+	cursor := &listingCursor{}
+
+	// A position of the whole prefix continues every region from its day
+	cursor.restore(WatchState{From: map[string]string{"": testUSDirectory + "2024/03/21/"}, Done: []string{testUSDirectory + "2024/03/21/b-1"}})
+	listed, err := cursor.list(&Handler{s3Client: s3Client}, "bucket", "logs/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testEUDirectory + "2024/03/21/a-2", testUSDirectory + "2024/03/21/b-2"}, listedKeys(listed))
+}
@@ -0,0 +1,49 @@
+package main
+
+import "hash/fnv"
+
+// Deduplicator drops records that are identical to one of the last Window records, such as duplicates written
+// for retried requests, and counts them. Records are compared by a 64-bit hash of all their fields.
+type Deduplicator struct {
+	Window     int
+	hashes     []uint64 // Ring buffer with the hashes of the last Window records
+	next       int
+	seen       map[uint64]int // Number of occurrences of each hash in the ring buffer
+	duplicates int
+}
+
+func (d *Deduplicator) Keep(record []string) bool {
+	h := fnv.New64a()
+	for _, value := range record {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	sum := h.Sum64()
+	if d.seen[sum] > 0 {
+		d.duplicates++
+		return false
+	}
+	if d.seen == nil {
+		d.seen = make(map[uint64]int, d.Window)
+	}
+	if len(d.hashes) < d.Window {
+		d.hashes = append(d.hashes, sum)
+	} else {
+		evicted := d.hashes[d.next]
+		if d.seen[evicted]--; d.seen[evicted] == 0 {
+			delete(d.seen, evicted)
+		}
+		d.hashes[d.next] = sum
+		d.next = (d.next + 1) % d.Window
+	}
+	d.seen[sum]++
+
+	return true
+}
+
+// Transform does nothing, duplicates are dropped by Keep before the entry is created
+func (d *Deduplicator) Transform(record []string, entry *LogEntry) {}
+
+func (d *Deduplicator) Summarize(summary map[string]int) {
+	summary["duplicates"] = d.duplicates
+}
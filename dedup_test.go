@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicator(t *testing.T) {
+	d := &Deduplicator{Window: 2}
+
+	assert.True(t, d.Keep([]string{"a", "1"}))
+	assert.False(t, d.Keep([]string{"a", "1"}))
+	assert.True(t, d.Keep([]string{"a", "2"}))
+	assert.True(t, d.Keep([]string{"a1", ""}), "fields are separated when hashing")
+	// The first record left the window
+	assert.True(t, d.Keep([]string{"a", "1"}))
+	assert.False(t, d.Keep([]string{"a1", ""}))
+
+	summary := summarize([]Transformer{d})
+	assert.Equal(t, map[string]int{"duplicates": 2}, summary)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	// deliveryAtLeastOnce sends every record that parsed, possibly more than once: such records that can't be
+	// sent fail their object, objects resume where they failed and are retried, and failures are reported.
+	// Records that can't be parsed are skipped and counted unless STRICT, as a retry wouldn't parse them either.
+	deliveryAtLeastOnce = "at-least-once"
+	// deliveryBestEffort sends what can be sent once: records that can't be sent are skipped, failed objects are
+	// not retried and failures don't fail the invocation, so nothing is sent twice by a retry
+	deliveryBestEffort = "best-effort"
+	// defaultDeliveryRetries is the RETRY_FAILED_OBJECTS of at-least-once delivery when not configured
+	defaultDeliveryRetries = 2
+)
+
+// ParseDeliveryMode validates the DELIVERY_MODE setting, empty leaves every setting as configured
+func ParseDeliveryMode(value string) (string, error) {
+	switch value {
+	case "", deliveryAtLeastOnce, deliveryBestEffort:
+		return value, nil
+	}
+
+	return "", fmt.Errorf("invalid delivery mode '%s', expected at-least-once or best-effort", value)
+}
+
+// applyDeliveryMode sets the retry, progress, strictness and spool settings that the delivery mode implies, unless
+// they are configured, and fails when a configured setting contradicts the mode. isSet reports whether an
+// environment variable is configured.
+func applyDeliveryMode(config *Config, isSet func(name string) bool) error {
+	conflict := func(name string) error {
+		return fmt.Errorf("%s contradicts DELIVERY_MODE=%s", name, config.DeliveryMode)
+	}
+	switch config.DeliveryMode {
+	case deliveryAtLeastOnce:
+		config.StrictSending = true
+		if isSet("PROGRESS_TRACKING") && !config.ProgressTracking {
+			return conflict("PROGRESS_TRACKING=false")
+		}
+		config.ProgressTracking = true
+		if isSet("RETRY_FAILED_OBJECTS") && config.RetryFailedObjects == 0 {
+			return conflict("RETRY_FAILED_OBJECTS=0")
+		}
+		if !isSet("RETRY_FAILED_OBJECTS") {
+			config.RetryFailedObjects = defaultDeliveryRetries
+		}
+		if config.RateLimitSampling {
+			return conflict("RATE_LIMIT_SAMPLING")
+		}
+	case deliveryBestEffort:
+		if config.Strict {
+			return conflict("STRICT=true")
+		}
+		if config.RetryFailedObjects > 0 {
+			return conflict("RETRY_FAILED_OBJECTS")
+		}
+		if config.Spool != "" {
+			return conflict("SPOOL")
+		}
+	}
+
+	return nil
+}
+
+// isEnvSet reports whether an environment variable is set to a non-empty value
+func isEnvSet(name string) bool {
+	return os.Getenv(name) != ""
+}
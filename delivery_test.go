@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeliveryMode(t *testing.T) {
+	for _, value := range []string{"", "at-least-once", "best-effort"} {
+		mode, err := ParseDeliveryMode(value)
+		assert.NoError(t, err)
+		assert.Equal(t, value, mode)
+	}
+
+	_, err := ParseDeliveryMode("exactly-once")
+	assert.EqualError(t, err, "invalid delivery mode 'exactly-once', expected at-least-once or best-effort")
+}
+
+func TestApplyDeliveryMode(t *testing.T) {
+	isSet := func(names ...string) func(string) bool {
+		return func(name string) bool { return slices.Contains(names, name) }
+	}
+
+	t.Run("At least once", func(t *testing.T) {
+		config := Config{DeliveryMode: deliveryAtLeastOnce}
+		require.NoError(t, applyDeliveryMode(&config, isSet()))
+		// Records that parsed must be sent, records that can't be parsed are still skipped
+		assert.True(t, config.StrictSending)
+		assert.False(t, config.Strict)
+		assert.True(t, config.ProgressTracking)
+		assert.Equal(t, defaultDeliveryRetries, config.RetryFailedObjects)
+
+		config = Config{DeliveryMode: deliveryAtLeastOnce, RetryFailedObjects: 5}
+		require.NoError(t, applyDeliveryMode(&config, isSet("RETRY_FAILED_OBJECTS")))
+		assert.Equal(t, 5, config.RetryFailedObjects)
+	})
+
+	t.Run("At least once conflicts", func(t *testing.T) {
+		config := Config{DeliveryMode: deliveryAtLeastOnce, Strict: true}
+		require.NoError(t, applyDeliveryMode(&config, isSet("STRICT")))
+		assert.True(t, config.Strict)
+		config = Config{DeliveryMode: deliveryAtLeastOnce}
+		assert.EqualError(t, applyDeliveryMode(&config, isSet("RETRY_FAILED_OBJECTS")), "RETRY_FAILED_OBJECTS=0 contradicts DELIVERY_MODE=at-least-once")
+		config = Config{DeliveryMode: deliveryAtLeastOnce, RateLimitSampling: true}
+		assert.EqualError(t, applyDeliveryMode(&config, isSet()), "RATE_LIMIT_SAMPLING contradicts DELIVERY_MODE=at-least-once")
+	})
+
+	t.Run("Best effort", func(t *testing.T) {
+		config := Config{DeliveryMode: deliveryBestEffort}
+		require.NoError(t, applyDeliveryMode(&config, isSet()))
+		assert.Equal(t, Config{DeliveryMode: deliveryBestEffort}, config)
+
+		config = Config{DeliveryMode: deliveryBestEffort, Spool: "/tmp/spool"}
+		assert.EqualError(t, applyDeliveryMode(&config, isSet("SPOOL")), "SPOOL contradicts DELIVERY_MODE=best-effort")
+		config = Config{DeliveryMode: deliveryBestEffort, Strict: true}
+		assert.EqualError(t, applyDeliveryMode(&config, isSet("STRICT")), "STRICT=true contradicts DELIVERY_MODE=best-effort")
+	})
+
+	t.Run("Unset leaves the config", func(t *testing.T) {
+		config := Config{RetryFailedObjects: 1}
+		require.NoError(t, applyDeliveryMode(&config, isSet("RETRY_FAILED_OBJECTS")))
+		assert.Equal(t, Config{RetryFailedObjects: 1}, config)
+	})
+}
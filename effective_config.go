@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// redactedConfigFields are the fields of Config with secrets, logged as "[redacted]" when set
+var redactedConfigFields = map[string]bool{
+	"ReceiverToken": true,
+}
+
+// effectiveConfig returns the config as a single line JSON document after defaults and parsing, with secrets
+// redacted. Durations are formatted like 1m0s, and ResolvedFields lists the fields that FIELDS selects.
+func effectiveConfig(config Config, fields Fields) ([]byte, error) {
+	document := make(map[string]interface{})
+	value := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		field := value.Field(i)
+		switch {
+		case redactedConfigFields[name] && !field.IsZero():
+			document[name] = "[redacted]"
+		case field.Type() == reflect.TypeOf(time.Duration(0)):
+			document[name] = time.Duration(field.Int()).String()
+		default:
+			document[name] = field.Interface()
+		}
+	}
+	resolved := []string{}
+	for i, name := range fieldNames {
+		if fields != nil && fields.IncludeField(i) {
+			resolved = append(resolved, name)
+		}
+	}
+	document["ResolvedFields"] = resolved
+
+	return json.Marshal(document)
+}
+
+// logEffectiveConfig logs the effective config at startup, see effectiveConfig
+func logEffectiveConfig(config Config, fields Fields) {
+	data, err := effectiveConfig(config, fields)
+	if err != nil {
+		logf(verbosityNormal, "failed to format the configuration: %v", err)
+		return
+	}
+	logf(verbosityVerbose, "configuration: %s", data)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	config := Config{
+		LogGroupName:       "my-log-group",
+		Fields:             "time,request",
+		ReceiverToken:      "secret",
+		FlushInterval:      5 * time.Second,
+		AccountRoutes:      AccountRoutes{"111111111111": {LogGroupName: "/elb/team-a"}},
+		StreamWriterWindow: defaultStreamWriterWindow,
+	}
+	fields, err := NewFields(config.Fields)
+	require.NoError(t, err)
+
+	data, err := effectiveConfig(config, fields)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &document))
+	assert.Equal(t, "my-log-group", document["LogGroupName"])
+	assert.Equal(t, "[redacted]", document["ReceiverToken"])
+	assert.Equal(t, "5s", document["FlushInterval"])
+	assert.Equal(t, "1h0m0s", document["StreamWriterWindow"])
+	assert.Equal(t, []interface{}{"time", "request"}, document["ResolvedFields"])
+	assert.Equal(t, map[string]interface{}{"111111111111": map[string]interface{}{"logGroup": "/elb/team-a"}}, document["AccountRoutes"])
+
+	// An empty secret is shown as empty, so it is visible that it is not set
+	data, err = effectiveConfig(Config{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &document))
+	assert.Equal(t, "", document["ReceiverToken"])
+	assert.Equal(t, []interface{}{}, document["ResolvedFields"])
+}
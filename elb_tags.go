@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// elbTagsTTL is how long the tags of a load balancer are cached, tags rarely change
+const elbTagsTTL = time.Hour
+
+// ELBv2Api is the part of the Elastic Load Balancing v2 API used to look up the tags of load balancers
+type ELBv2Api interface {
+	DescribeTags(input *elbv2.DescribeTagsInput) (*elbv2.DescribeTagsOutput, error)
+}
+
+// ELBTagCache looks up selected tags of load balancers and caches them per load balancer, so DescribeTags is
+// called once an hour for every load balancer rather than for every object. Load balancers are described in the
+// region of their logs, without holding the lock, and concurrent objects of a load balancer share a single call.
+type ELBTagCache struct {
+	Keys      []string // Tags to look up, others are ignored
+	mu        sync.Mutex
+	clients   map[string]ELBv2Api
+	newClient func(region string) ELBv2Api
+	cache     map[string]cachedELBTags
+	describes flightGroup[map[string]string]
+	now       func() time.Time
+}
+
+type cachedELBTags struct {
+	tags    map[string]string
+	expires time.Time
+}
+
+// newELBTagCache returns a cache that describes load balancers using the credentials of the session
+func newELBTagCache(sess *session.Session, keys []string) *ELBTagCache {
+	return &ELBTagCache{Keys: keys, newClient: func(region string) ELBv2Api {
+		return elbv2.New(sess, aws.NewConfig().WithRegion(region))
+	}}
+}
+
+// Lookup returns the selected tags of the load balancer of an object key, tags that are not set are left out.
+// Classic load balancers have no tags to look up. Failures are cached like tags, so a load balancer that can't
+// be described, e.g. in another account, isn't described again for every object.
+func (c *ELBTagCache) Lookup(key ELBObjectKey) (map[string]string, error) {
+	arn := key.LoadBalancerARN()
+	if arn == "" {
+		return nil, nil
+	}
+	c.mu.Lock()
+	cached, ok := c.cache[arn]
+	c.mu.Unlock()
+	if ok && c.clock().Before(cached.expires) {
+		return cached.tags, nil
+	}
+
+	return c.describes.do(arn, func() (map[string]string, error) {
+		tags, err := c.describe(key.Region, arn)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.cache == nil {
+			c.cache = make(map[string]cachedELBTags)
+		}
+		c.cache[arn] = cachedELBTags{tags: tags, expires: c.clock().Add(elbTagsTTL)}
+
+		return tags, err
+	})
+}
+
+func (c *ELBTagCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+
+	return time.Now()
+}
+
+func (c *ELBTagCache) describe(region, arn string) (map[string]string, error) {
+	c.mu.Lock()
+	client, ok := c.clients[region]
+	if !ok {
+		client = c.newClient(region)
+		if c.clients == nil {
+			c.clients = make(map[string]ELBv2Api)
+		}
+		c.clients[region] = client
+	}
+	c.mu.Unlock()
+	resp, err := client.DescribeTags(&elbv2.DescribeTagsInput{ResourceArns: []*string{aws.String(arn)}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the tags of %s: %v", arn, err)
+	}
+	tags := make(map[string]string)
+	for _, description := range resp.TagDescriptions {
+		for _, tag := range description.Tags {
+			for _, key := range c.Keys {
+				if aws.StringValue(tag.Key) == key {
+					tags[key] = aws.StringValue(tag.Value)
+				}
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// ParseELBTagKeys parses a comma separated list of tag keys, e.g. "team,service,environment"
+func ParseELBTagKeys(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// ELBTagger adds the tags of the load balancer of an object as an elb_tags object to every entry, so ownership
+// and cost attribution are part of the log data
+type ELBTagger struct {
+	Tags map[string]string
+}
+
+func (t *ELBTagger) Transform(record []string, entry *LogEntry) {
+	tags := make(map[string]interface{}, len(t.Tags))
+	for key, value := range t.Tags {
+		tags[key] = value
+	}
+	entry.Data["elb_tags"] = tags
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockELBv2Api struct {
+	mock.Mock
+}
+
+func (m *MockELBv2Api) DescribeTags(input *elbv2.DescribeTagsInput) (*elbv2.DescribeTagsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*elbv2.DescribeTagsOutput), args.Error(1)
+}
+
+func TestELBTagCache(t *testing.T) {
+	const arn = "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/my-lb/1234567890abcdef"
+	key := ELBObjectKey{AccountID: "123456789012", Region: "eu-west-1", LoadBalancer: "my-lb", LoadBalancerID: "app/my-lb/1234567890abcdef"}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newCache := func(client *MockELBv2Api) *ELBTagCache {
+		return &ELBTagCache{
+			Keys:      []string{"team", "service", "environment"},
+			newClient: func(region string) ELBv2Api { return client },
+			now:       func() time.Time { return now },
+		}
+	}
+
+	t.Run("Selected tags are cached", func(t *testing.T) {
+		client := &MockELBv2Api{}
+		client.On("DescribeTags", &elbv2.DescribeTagsInput{ResourceArns: []*string{aws.String(arn)}}).Return(&elbv2.DescribeTagsOutput{
+			TagDescriptions: []*elbv2.TagDescription{{
+				ResourceArn: aws.String(arn),
+				Tags: []*elbv2.Tag{
+					{Key: aws.String("team"), Value: aws.String("payments")},
+					{Key: aws.String("environment"), Value: aws.String("production")},
+					{Key: aws.String("cost-center"), Value: aws.String("1234")},
+				},
+			}},
+		}, nil).Once()
+		cache := newCache(client)
+
+		for i := 0; i < 2; i++ {
+			tags, err := cache.Lookup(key)
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{"team": "payments", "environment": "production"}, tags)
+		}
+		client.AssertExpectations(t)
+	})
+
+	t.Run("Failures are cached", func(t *testing.T) {
+		client := &MockELBv2Api{}
+		client.On("DescribeTags", mock.Anything).Return((*elbv2.DescribeTagsOutput)(nil), errors.New("AccessDenied")).Once()
+		cache := newCache(client)
+
+		_, err := cache.Lookup(key)
+		assert.EqualError(t, err, "failed to describe the tags of "+arn+": AccessDenied")
+		tags, err := cache.Lookup(key)
+		assert.NoError(t, err)
+		assert.Empty(t, tags)
+
+		now = now.Add(elbTagsTTL)
+		client.On("DescribeTags", mock.Anything).Return(&elbv2.DescribeTagsOutput{}, nil).Once()
+		_, err = cache.Lookup(key)
+		assert.NoError(t, err)
+		client.AssertExpectations(t)
+	})
+
+	t.Run("Concurrent lookups", func(t *testing.T) {
+		const otherARN = "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/other-lb/abcdef1234567890"
+		other := ELBObjectKey{AccountID: "123456789012", Region: "eu-west-1", LoadBalancer: "other-lb", LoadBalancerID: "app/other-lb/abcdef1234567890"}
+		release := make(chan time.Time)
+		client := &MockELBv2Api{}
+		// A slow load balancer doesn't hold up others, and concurrent lookups of it share a single call
+		client.On("DescribeTags", &elbv2.DescribeTagsInput{ResourceArns: []*string{aws.String(arn)}}).Return(&elbv2.DescribeTagsOutput{}, nil).
+			WaitUntil(release).Once()
+		client.On("DescribeTags", &elbv2.DescribeTagsInput{ResourceArns: []*string{aws.String(otherARN)}}).Return(&elbv2.DescribeTagsOutput{
+			TagDescriptions: []*elbv2.TagDescription{{ResourceArn: aws.String(otherARN), Tags: []*elbv2.Tag{{Key: aws.String("team"), Value: aws.String("search")}}}},
+		}, nil).Once()
+		cache := newCache(client)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tags, err := cache.Lookup(key)
+				assert.NoError(t, err)
+				assert.Empty(t, tags)
+			}()
+		}
+		tags, err := cache.Lookup(other)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"team": "search"}, tags)
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		client.AssertExpectations(t)
+	})
+
+	t.Run("Classic load balancer", func(t *testing.T) {
+		tags, err := newCache(&MockELBv2Api{}).Lookup(ELBObjectKey{LoadBalancer: "my-classic-lb"})
+		assert.NoError(t, err)
+		assert.Nil(t, tags)
+	})
+}
+
+func TestParseELBTagKeys(t *testing.T) {
+	assert.Equal(t, []string{"team", "service", "environment"}, ParseELBTagKeys("team, service,,environment"))
+	assert.Nil(t, ParseELBTagKeys(""))
+}
+
+func TestELBTagger(t *testing.T) {
+	entry := LogEntry{Data: map[string]interface{}{}}
+	(&ELBTagger{Tags: map[string]string{"team": "payments"}}).Transform(nil, &entry)
+
+	assert.Equal(t, map[string]interface{}{"team": "payments"}, entry.Data["elb_tags"])
+}
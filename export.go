@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats of the export command
+const (
+	exportNDJSON  = "ndjson"
+	exportCSV     = "csv"
+	exportTSV     = "tsv"
+	exportSQLite  = "sqlite"
+	exportArrow   = "arrow"
+	exportParquet = "parquet"
+	exportHuman   = "human"
+)
+
+// ParseExportFormat validates the value of --format
+func ParseExportFormat(value string) (string, error) {
+	switch value {
+	case exportNDJSON, exportCSV, exportTSV, exportSQLite, exportArrow, exportParquet, exportHuman:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid format '%s', expected %s, %s, %s, %s, %s, %s or %s", value, exportNDJSON, exportCSV, exportTSV, exportSQLite, exportArrow, exportParquet, exportHuman)
+	}
+}
+
+// ndjsonSink writes the entries of an object as newline delimited JSON
+type ndjsonSink struct {
+	w       io.Writer
+	entries int
+	bytes   int64
+}
+
+func (s *ndjsonSink) Send(entries <-chan LogEntry) error {
+	var err error
+	for entry := range entries {
+		if err != nil {
+			continue
+		}
+		var n int
+		if n, err = fmt.Fprintln(s.w, entry.Message); err == nil {
+			s.entries++
+			s.bytes += int64(n)
+		}
+	}
+
+	return err
+}
+
+func (s *ndjsonSink) Sent() (int, int64) {
+	return s.entries, s.bytes
+}
+
+// Types of the columns of the typed formats, see columnType. They are named as in SQLite.
+const (
+	columnText      = "TEXT"
+	columnInteger   = "INTEGER"
+	columnReal      = "REAL"
+	columnTimestamp = "TIMESTAMP" // ISO 8601
+)
+
+// logFieldTypes are the column types of the log fields that are not text. A value of "-", such as the target status
+// code of a request that didn't reach a target, is missing.
+var logFieldTypes = map[string]string{
+	"time":                     columnTimestamp,
+	"request_processing_time":  columnReal,
+	"target_processing_time":   columnReal,
+	"response_processing_time": columnReal,
+	"elb_status_code":          columnInteger,
+	"target_status_code":       columnInteger,
+	"received_bytes":           columnInteger,
+	"sent_bytes":               columnInteger,
+	"matched_rule_priority":    columnInteger,
+	"request_creation_time":    columnTimestamp,
+}
+
+// columnType returns the column type of a field, by its value for fields that are not log fields, such as those
+// added by transformers
+func columnType(field string, value interface{}) string {
+	if columnType, ok := logFieldTypes[field]; ok {
+		return columnType
+	}
+	if _, ok := fieldIndexes[field]; ok {
+		return columnText
+	}
+	switch v := value.(type) {
+	case bool, int, int64:
+		return columnInteger
+	case float64:
+		return columnReal
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return columnInteger
+		}
+		return columnReal
+	}
+
+	return columnText
+}
+
+// typedValue converts the value of a field to the type of its column: nil for a missing value or "-", int64 for
+// integers and booleans, float64 for reals, time.Time for timestamps, and a string for text, with values other
+// than strings as JSON
+func typedValue(value interface{}, columnType string) (interface{}, error) {
+	if value == nil || value == "-" {
+		return nil, nil
+	}
+	switch v := value.(type) {
+	case string:
+		switch columnType {
+		case columnInteger:
+			return strconv.ParseInt(v, 10, 64)
+		case columnReal:
+			return strconv.ParseFloat(v, 64)
+		case columnTimestamp:
+			return time.Parse(time.RFC3339Nano, v)
+		}
+		return v, nil
+	case bool:
+		if columnType == columnText {
+			return strconv.FormatBool(v), nil
+		}
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case int:
+		return typedValue(int64(v), columnType)
+	case int64:
+		switch columnType {
+		case columnInteger:
+			return v, nil
+		case columnReal:
+			return float64(v), nil
+		}
+	case float64:
+		if columnType == columnReal {
+			return v, nil
+		}
+	case json.Number:
+		switch columnType {
+		case columnInteger:
+			return v.Int64()
+		case columnReal:
+			return v.Float64()
+		}
+		return v.String(), nil
+	}
+	if columnType != columnText {
+		return nil, fmt.Errorf("can't convert %v to %s", value, strings.ToLower(columnType))
+	}
+	data, err := json.Marshal(value)
+
+	return string(data), err
+}
+
+// rowWriter writes entries as rows with the same columns, see tableWriter, sqliteWriter, arrowWriter and humanWriter
+type rowWriter interface {
+	// write writes an entry and returns the bytes written
+	write(entry LogEntry) (int, error)
+	// omitted returns the fields of the entries that were left out as they are not a column
+	omitted() []string
+	// close completes the output
+	close() error
+}
+
+// rowColumns are the columns of a rowWriter. Without configured columns, these are the included log fields in the
+// order of the log format followed by the fields the transformers added to the first entry, as the columns can't
+// change once written. Fields of later entries that are not a column are left out and reported by omitted.
+type rowColumns struct {
+	columns  []string
+	isColumn map[string]bool // nil until the first entry
+	others   map[string]bool // Fields of entries that are not a column
+}
+
+// choose sets the columns for the first entry
+func (c *rowColumns) choose(entry LogEntry) {
+	if len(c.columns) == 0 {
+		c.columns = entryColumns(entry)
+	}
+	c.isColumn = make(map[string]bool)
+	for _, column := range c.columns {
+		c.isColumn[column] = true
+	}
+	c.others = make(map[string]bool)
+}
+
+// track records the fields of an entry that are not a column
+func (c *rowColumns) track(entry LogEntry) {
+	for field := range entry.Data {
+		if !c.isColumn[field] {
+			c.others[field] = true
+		}
+	}
+}
+
+// omitted returns the fields of the entries that were left out as they are not a column, sorted by name
+func (c *rowColumns) omitted() []string {
+	fields := make([]string, 0, len(c.others))
+	for field := range c.others {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// tableWriter writes entries as the rows of a CSV or TSV file, quoted as needed, after a header row with the
+// columns, see rowColumns. Cells that a spreadsheet would run as a formula are escaped, see escapeFormula.
+type tableWriter struct {
+	rowColumns
+	w           io.Writer
+	row         bytes.Buffer
+	csv         *csv.Writer
+	rawFormulas bool // Write cells that start like a formula as is
+}
+
+func newTableWriter(w io.Writer, format string, columns []string) *tableWriter {
+	t := &tableWriter{rowColumns: rowColumns{columns: columns}, w: w}
+	t.csv = csv.NewWriter(&t.row)
+	if format == exportTSV {
+		t.csv.Comma = '\t'
+	}
+
+	return t
+}
+
+// write writes an entry as a row and returns the bytes written, preceded by the header for the first entry
+func (t *tableWriter) write(entry LogEntry) (int, error) {
+	if t.isColumn == nil {
+		t.choose(entry)
+		if err := t.csv.Write(t.columns); err != nil {
+			return 0, err
+		}
+	}
+	t.track(entry)
+	record := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		value, err := tableValue(entry.Data[column])
+		if err != nil {
+			return 0, fmt.Errorf("failed to format %s: %v", column, err)
+		}
+		if !t.rawFormulas {
+			value = escapeFormula(value)
+		}
+		record[i] = value
+	}
+	if err := t.csv.Write(record); err != nil {
+		return 0, err
+	}
+	t.csv.Flush()
+	if err := t.csv.Error(); err != nil {
+		return 0, err
+	}
+	defer t.row.Reset()
+
+	return t.w.Write(t.row.Bytes())
+}
+
+// close does nothing, as the rows are written as they come
+func (t *tableWriter) close() error {
+	return nil
+}
+
+// entryColumns returns the log fields of an entry in the order of the log format, followed by the other fields
+// sorted by name
+func entryColumns(entry LogEntry) []string {
+	var columns []string
+	isLogField := make(map[string]bool)
+	for _, field := range fieldNames {
+		isLogField[field] = true
+		if _, ok := entry.Data[field]; ok {
+			columns = append(columns, field)
+		}
+	}
+	var others []string
+	for field := range entry.Data {
+		if !isLogField[field] {
+			others = append(others, field)
+		}
+	}
+	sort.Strings(others)
+
+	return append(columns, others...)
+}
+
+// tableValue formats the value of a field for a cell, strings as is, missing values as an empty cell and other
+// values as JSON
+func tableValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+}
+
+// escapeFormula prefixes a value that starts with =, +, - or @ with ', so a spreadsheet shows it as text instead
+// of running it as a formula. Values such as user agents and URLs come from clients, who could otherwise make a
+// spreadsheet run a formula of theirs. Numbers, such as a processing time of -1, and the - of a missing value are
+// kept as they are.
+func escapeFormula(value string) string {
+	if value == "" || value == "-" || !strings.ContainsRune("=+-@", rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+
+	return "'" + value
+}
+
+// tableSink writes the entries of an object as rows, with a rowWriter shared by all objects
+type tableSink struct {
+	rows    rowWriter
+	entries int
+	bytes   int64
+}
+
+func (s *tableSink) Send(entries <-chan LogEntry) error {
+	var err error
+	for entry := range entries {
+		if err != nil {
+			continue
+		}
+		var n int
+		if n, err = s.rows.write(entry); err == nil {
+			s.entries++
+			s.bytes += int64(n)
+		}
+	}
+
+	return err
+}
+
+func (s *tableSink) Sent() (int, int64) {
+	return s.entries, s.bytes
+}
+
+// Exporter writes the entries of objects as newline delimited JSON, as CSV or TSV for spreadsheets, to a SQLite
+// database, as Arrow IPC or Parquet for columnar analytics, or as aligned columns to preview in a terminal, with
+// the configured fields and transformers, optionally anonymized
+type Exporter struct {
+	Source    Source
+	Fields    Fields
+	Config    Config
+	Anonymize bool
+	// RawFormulas writes CSV and TSV cells that start like a formula as is, see escapeFormula
+	RawFormulas bool
+	Format      string   // exportNDJSON when empty
+	Columns     []string // Columns of the formats other than ndjson, see rowColumns
+	Color       bool     // Whether the human format is colored
+}
+
+// Export writes the entries of the objects in order and returns the number of entries written, see ExportSQLite
+// for the SQLite format
+func (e *Exporter) Export(s3Objects []S3ObjectInfo, w io.Writer) (int, error) {
+	buffered := bufio.NewWriter(w)
+	var rows rowWriter
+	switch e.Format {
+	case exportCSV, exportTSV:
+		table := newTableWriter(buffered, e.Format, e.Columns)
+		table.rawFormulas = e.RawFormulas
+		rows = table
+	case exportArrow, exportParquet:
+		rows = newArrowWriter(buffered, e.Format, e.Columns)
+	case exportHuman:
+		rows = newHumanWriter(buffered, e.Columns, e.Color, e.Config.SlowRequestThreshold)
+	}
+	entries, err := e.export(s3Objects, buffered, rows)
+	if err != nil {
+		return entries, err
+	}
+	if rows != nil {
+		if err := rows.close(); err != nil {
+			return entries, err
+		}
+	}
+
+	return entries, buffered.Flush()
+}
+
+// ExportSQLite writes the entries of the objects to a new SQLite database at path, see sqliteWriter, and returns
+// the number of entries written
+func (e *Exporter) ExportSQLite(s3Objects []S3ObjectInfo, path string) (int, error) {
+	rows, err := newSQLiteWriter(path, e.Columns)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := e.export(s3Objects, nil, rows)
+	if closeErr := rows.close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write %s: %v", path, closeErr)
+	}
+
+	return entries, err
+}
+
+// export runs the pipeline for the objects in order, writing the entries as rows, or as newline delimited JSON
+// to w without rows
+func (e *Exporter) export(s3Objects []S3ObjectInfo, w io.Writer, rows rowWriter) (int, error) {
+	// A single anonymizer renames consistently across all objects
+	anonymizer := &Anonymizer{}
+	pipeline := &Pipeline{
+		Source: e.Source,
+		Parser: &RecordParser{Fields: e.Fields, Layouts: e.Config.TimestampLayouts},
+		Stages: func(object S3ObjectInfo, metadata ObjectMetadata) ([]Transformer, Sink) {
+			transformers := NewTransformers(e.Config)
+			if e.Anonymize {
+				transformers = append(transformers, anonymizer)
+			}
+			if rows != nil {
+				return transformers, &tableSink{rows: rows}
+			}
+
+			return transformers, &ndjsonSink{w: w}
+		},
+	}
+	entries := 0
+	for _, s3Object := range s3Objects {
+		result, err := pipeline.Run(s3Object)
+		entries += result.Entries
+		if err == ErrEmptyObject {
+			continue
+		}
+		if err != nil {
+			return entries, fmt.Errorf("error exporting %s: %w", s3Object, err)
+		}
+		logf(verbosityVerbose, "exported %d log entries from %s", result.Entries, s3Object)
+	}
+	if rows == nil {
+		return entries, nil
+	}
+	if omitted := rows.omitted(); len(omitted) > 0 {
+		logf(verbosityNormal, "left out the fields %s that are not a column, select the columns with --columns", strings.Join(omitted, ", "))
+	}
+
+	return entries, nil
+}
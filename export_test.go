@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter(t *testing.T) {
+	line := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify?id=42 HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4 "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+	dir := t.TempDir()
+	for _, name := range []string{"a.log.gz", "b.log.gz"} {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(strings.Repeat(line+"\n", 2)))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0o644))
+	}
+	s3Objects, err := listLocalObjects(dir)
+	require.NoError(t, err)
+	fields, err := NewFields("client:port,request,domain_name,elb_status_code")
+	require.NoError(t, err)
+
+	export := func(anonymize bool) []map[string]interface{} {
+		exporter := &Exporter{Source: NewSources(nil), Fields: fields, Anonymize: anonymize}
+		var out bytes.Buffer
+		entries, err := exporter.Export(s3Objects, &out)
+		require.NoError(t, err)
+		assert.Equal(t, 4, entries)
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		require.Len(t, lines, 4)
+		var result []map[string]interface{}
+		for _, line := range lines {
+			var data map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &data))
+			result = append(result, data)
+		}
+		return result
+	}
+
+	t.Run("Plain", func(t *testing.T) {
+		for _, data := range export(false) {
+			assert.Equal(t, "192.0.2.104:36217", data["client:port"])
+			assert.Equal(t, "example.com", data["domain_name"])
+		}
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		exporter := &Exporter{Source: NewSources(nil), Fields: fields, Format: exportCSV}
+		var out bytes.Buffer
+		entries, err := exporter.Export(s3Objects, &out)
+		require.NoError(t, err)
+		assert.Equal(t, 4, entries)
+		// A single header for all objects, with the fields in the order of the log format
+		row := "192.0.2.104:36217,203,PUT https://example.com:443/api/modify?id=42 HTTP/1.1,example.com\n"
+		assert.Equal(t, "client:port,elb_status_code,request,domain_name\n"+strings.Repeat(row, 4), out.String())
+	})
+
+	t.Run("TSV with columns", func(t *testing.T) {
+		exporter := &Exporter{Source: NewSources(nil), Fields: fields, Format: exportTSV, Columns: []string{"domain_name", "client:port", "missing"}}
+		var out bytes.Buffer
+		_, err := exporter.Export(s3Objects, &out)
+		require.NoError(t, err)
+		assert.Equal(t, "domain_name\tclient:port\tmissing\n"+strings.Repeat("example.com\t192.0.2.104:36217\t\n", 4), out.String())
+	})
+
+	t.Run("Anonymized", func(t *testing.T) {
+		for _, data := range export(true) {
+			assert.Equal(t, "192.0.2.0:36217", data["client:port"])
+			assert.Equal(t, "PUT https://host-1.example:443/api/modify?id=redacted HTTP/1.1", data["request"])
+			assert.Equal(t, "host-1.example", data["domain_name"])
+			assert.Equal(t, "203", data["elb_status_code"])
+		}
+	})
+}
+
+func TestTableWriter(t *testing.T) {
+	var out bytes.Buffer
+	table := newTableWriter(&out, exportCSV, nil)
+	entries := []LogEntry{
+		{Data: map[string]interface{}{"user_agent": `Mozilla/5.0 (X11; Linux x86_64) "quoted", with comma`, "time": "2024-03-21T16:10:26.071854Z", "duration_ms": 4.5, "flags": []string{"a", "b"}}},
+		{Data: map[string]interface{}{"time": "2024-03-21T16:10:27.071854Z", "user_agent": "multi\nline", "extra": true}},
+	}
+	for _, entry := range entries {
+		_, err := table.write(entry)
+		require.NoError(t, err)
+	}
+
+	records, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"time", "user_agent", "duration_ms", "flags"},
+		{"2024-03-21T16:10:26.071854Z", `Mozilla/5.0 (X11; Linux x86_64) "quoted", with comma`, "4.5", `["a","b"]`},
+		{"2024-03-21T16:10:27.071854Z", "multi\nline", "", ""},
+	}, records)
+	assert.Equal(t, []string{"extra"}, table.omitted())
+
+	t.Run("Formulas", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{
+			"user_agent":              `=HYPERLINK("https://attacker.example","x")`,
+			"request":                 "+1+cmd|' /C calc'!A0",
+			"domain_name":             "@SUM(A1)",
+			"target_status_code":      "-",
+			"request_processing_time": "-1",
+			"ssl_cipher":              "-2+3",
+		}}
+		columns := []string{"user_agent", "request", "domain_name", "target_status_code", "request_processing_time", "ssl_cipher"}
+		for rawFormulas, want := range map[bool][]string{
+			false: {`'=HYPERLINK("https://attacker.example","x")`, "'+1+cmd|' /C calc'!A0", "'@SUM(A1)", "-", "-1", "'-2+3"},
+			true:  {`=HYPERLINK("https://attacker.example","x")`, "+1+cmd|' /C calc'!A0", "@SUM(A1)", "-", "-1", "-2+3"},
+		} {
+			var out bytes.Buffer
+			table := newTableWriter(&out, exportTSV, columns)
+			table.rawFormulas = rawFormulas
+			_, err := table.write(entry)
+			require.NoError(t, err)
+			reader := csv.NewReader(&out)
+			reader.Comma = '\t'
+			records, err := reader.ReadAll()
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{columns, want}, records)
+		}
+	})
+}
+
+func TestParseExportFormat(t *testing.T) {
+	for _, format := range []string{exportNDJSON, exportCSV, exportTSV, exportSQLite, exportArrow, exportParquet, exportHuman} {
+		_, err := ParseExportFormat(format)
+		assert.NoError(t, err)
+	}
+	_, err := ParseExportFormat("xlsx")
+	assert.EqualError(t, err, "invalid format 'xlsx', expected ndjson, csv, tsv, sqlite, arrow, parquet or human")
+}
+
+func TestTypedValue(t *testing.T) {
+	for _, test := range []struct {
+		value      interface{}
+		columnType string
+		want       interface{}
+	}{
+		{"203", columnInteger, int64(203)},
+		{"0.004", columnReal, 0.004},
+		{"2024-03-21T16:10:26.071854Z", columnTimestamp, time.Date(2024, 3, 21, 16, 10, 26, 71854000, time.UTC)},
+		{"-", columnInteger, nil},
+		{nil, columnText, nil},
+		{"GET / HTTP/1.1", columnText, "GET / HTTP/1.1"},
+		{json.Number("12"), columnInteger, int64(12)},
+		{json.Number("1.5"), columnReal, 1.5},
+		{4, columnReal, 4.0},
+		{true, columnInteger, int64(1)},
+		{true, columnText, "true"},
+		{[]string{"a", "b"}, columnText, `["a","b"]`},
+	} {
+		got, err := typedValue(test.value, test.columnType)
+		require.NoError(t, err)
+		assert.Equal(t, test.want, got, "%v as %s", test.value, test.columnType)
+	}
+	_, err := typedValue("abc", columnInteger)
+	assert.Error(t, err)
+	_, err = typedValue([]string{"a"}, columnReal)
+	assert.EqualError(t, err, "can't convert [a] to real")
+
+	assert.Equal(t, columnReal, columnType("duration_ms", 4.5))
+	assert.Equal(t, columnInteger, columnType("is_slow", true))
+	assert.Equal(t, columnText, columnType("request", "GET / HTTP/1.1"))
+	assert.Equal(t, columnInteger, columnType("elb_status_code", "200"))
+	assert.Equal(t, columnTimestamp, columnType("time", "2024-03-21T16:10:26.071854Z"))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+type LambdaApi interface {
+	Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error)
+}
+
+// chunkS3Objects splits objects into chunks of at most size objects
+func chunkS3Objects(s3Objects []S3ObjectInfo, size int) [][]S3ObjectInfo {
+	var chunks [][]S3ObjectInfo
+	for size < len(s3Objects) {
+		s3Objects, chunks = s3Objects[size:], append(chunks, s3Objects[:size])
+	}
+
+	return append(chunks, s3Objects)
+}
+
+// defaultInvocationChunkSize is the number of objects per asynchronous invocation if no chunk size is configured,
+// which keeps payloads well below the 256 KB limit for asynchronous invocations
+const defaultInvocationChunkSize = 500
+
+// fanOut asynchronously invokes the function itself once for every chunk of objects, so processing a
+// large prefix is spread over many invocations instead of being limited by a single Lambda timeout. The objects
+// of chunks that could not be handed over are failures of the result, so only they are retried.
+func (h *Handler) fanOut(s3Objects []S3ObjectInfo) (RunResult, error) {
+	failed, err := h.invokeAsync(s3Objects, h.config.FanOutChunkSize, 0)
+	result := RunResult{Requeued: len(s3Objects) - len(failed), Failures: invokeFailures(failed, err)}
+	result.sortFailures()
+
+	return result, err
+}
+
+// invokeAsync asynchronously invokes the function itself for every chunk of at most chunkSize objects,
+// attempt is the number of times the objects have been retried after failing. A chunk that can't be invoked
+// doesn't stop the others, it returns the objects of the failed chunks with the errors.
+func (h *Handler) invokeAsync(s3Objects []S3ObjectInfo, chunkSize, attempt int) ([]S3ObjectInfo, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultInvocationChunkSize
+	}
+	chunks := chunkS3Objects(s3Objects, chunkSize)
+	var failed []S3ObjectInfo
+	var errs []error
+	for i, chunk := range chunks {
+		payload, err := json.Marshal(LambdaEvent{Objects: chunk, Attempt: attempt})
+		if err != nil {
+			return s3Objects, fmt.Errorf("failed to marshal fan-out payload: %v", err)
+		}
+		_, err = h.lambdaClient.Invoke(&lambda.InvokeInput{
+			FunctionName:   aws.String(h.functionName),
+			InvocationType: aws.String(lambda.InvocationTypeEvent),
+			Payload:        payload,
+		})
+		if err != nil {
+			failed = append(failed, chunk...)
+			errs = append(errs, fmt.Errorf("failed to invoke %s for chunk %d of %d: %v", h.functionName, i+1, len(chunks), err))
+		}
+	}
+	log.Printf("fanned out %d objects over %d invocations", len(s3Objects)-len(failed), len(chunks)-len(errs))
+
+	return failed, errors.Join(errs...)
+}
+
+// invokeFailures returns the failures of objects that could not be handed over to another invocation
+func invokeFailures(s3Objects []S3ObjectInfo, err error) []ObjectFailure {
+	var failures []ObjectFailure
+	for _, s3Object := range s3Objects {
+		failures = append(failures, ObjectFailure{Bucket: s3Object.Bucket, Key: s3Object.Key, Error: err.Error()})
+	}
+
+	return failures
+}
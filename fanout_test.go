@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockLambdaApi struct {
+	mock.Mock
+}
+
+func (m *MockLambdaApi) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*lambda.InvokeOutput), args.Error(1)
+}
+
+func TestChunkS3Objects(t *testing.T) {
+	s3Objects := make([]S3ObjectInfo, 5)
+	for i := range s3Objects {
+		s3Objects[i] = S3ObjectInfo{Bucket: "bucket", Key: fmt.Sprintf("key%d", i)}
+	}
+
+	chunks := chunkS3Objects(s3Objects, 2)
+	require.Len(t, chunks, 3)
+	assert.Equal(t, s3Objects[0:2], chunks[0])
+	assert.Equal(t, s3Objects[2:4], chunks[1])
+	assert.Equal(t, s3Objects[4:], chunks[2])
+
+	assert.Len(t, chunkS3Objects(s3Objects, 5), 1)
+}
+
+func TestFanOut(t *testing.T) {
+	mockS3Api := new(MockS3Api)
+	mockS3Api.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{
+			{Key: aws.String("prefix/object1")},
+			{Key: aws.String("prefix/object2")},
+			{Key: aws.String("prefix/object3")},
+		},
+	}, nil)
+
+	var payloads []LambdaEvent
+	mockLambda := new(MockLambdaApi)
+	mockLambda.On("Invoke", mock.Anything).Return(&lambda.InvokeOutput{}, nil).Run(func(args mock.Arguments) {
+		input := args.Get(0).(*lambda.InvokeInput)
+		assert.Equal(t, "my-function", *input.FunctionName)
+		assert.Equal(t, lambda.InvocationTypeEvent, *input.InvocationType)
+		var payload LambdaEvent
+		require.NoError(t, json.Unmarshal(input.Payload, &payload))
+		payloads = append(payloads, payload)
+	})
+
+	mockProcessor := new(MockLogProcessor)
+	handler := &Handler{
+		lp:           mockProcessor,
+		s3Client:     mockS3Api,
+		config:       Config{FanOutChunkSize: 2},
+		lambdaClient: mockLambda,
+		functionName: "my-function",
+	}
+
+	_, err := handler.HandleLambdaInvocation(context.Background(), LambdaEvent{S3URL: "s3://bucket/prefix/"})
+	require.NoError(t, err)
+
+	require.Len(t, payloads, 2)
+	assert.Equal(t, []S3ObjectInfo{{Bucket: "bucket", Key: "prefix/object1"}, {Bucket: "bucket", Key: "prefix/object2"}}, payloads[0].Objects)
+	assert.Equal(t, []S3ObjectInfo{{Bucket: "bucket", Key: "prefix/object3"}}, payloads[1].Objects)
+	mockProcessor.AssertNotCalled(t, "ProcessLogs", mock.Anything)
+
+	// The invocations process their chunk directly
+	mockProcessor.On("ProcessLogs", mock.Anything).Return(ObjectResult{}, nil)
+	_, err = handler.HandleLambdaInvocation(context.Background(), payloads[1])
+	require.NoError(t, err)
+	mockProcessor.AssertCalled(t, "ProcessLogs", S3ObjectInfo{Bucket: "bucket", Key: "prefix/object3"})
+
+	t.Run("Only the chunks that could not be invoked fail", func(t *testing.T) {
+		var invoked []LambdaEvent
+		mockLambda := new(MockLambdaApi)
+		mockLambda.On("Invoke", mock.Anything).Return((*lambda.InvokeOutput)(nil), fmt.Errorf("TooManyRequestsException")).Once()
+		mockLambda.On("Invoke", mock.Anything).Return(&lambda.InvokeOutput{}, nil).Run(func(args mock.Arguments) {
+			var payload LambdaEvent
+			require.NoError(t, json.Unmarshal(args.Get(0).(*lambda.InvokeInput).Payload, &payload))
+			invoked = append(invoked, payload)
+		})
+		handler.lambdaClient = mockLambda
+		handler.config.RetryFailedObjects = 1
+
+		response, err := handler.HandleLambdaInvocation(context.Background(), LambdaEvent{S3URL: "s3://bucket/prefix/"})
+		require.NoError(t, err)
+
+		// The second chunk was handed over, then the first one by the retry
+		assert.Equal(t, 3, response.Requeued)
+		require.Len(t, invoked, 2)
+		assert.Equal(t, []S3ObjectInfo{{Bucket: "bucket", Key: "prefix/object3"}}, invoked[0].Objects)
+		assert.Equal(t, LambdaEvent{Objects: []S3ObjectInfo{{Bucket: "bucket", Key: "prefix/object1"}, {Bucket: "bucket", Key: "prefix/object2"}}, Attempt: 1}, invoked[1])
+	})
+}
+
+func TestRetryFailedObjects(t *testing.T) {
+	event := LambdaEvent{Objects: []S3ObjectInfo{{Bucket: "bucket", Key: "object1"}, {Bucket: "bucket", Key: "object2"}}}
+	newHandler := func() (*Handler, *[]LambdaEvent) {
+		mockProcessor := new(MockLogProcessor)
+		mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "bucket", Key: "object1"}).Return(ObjectResult{Entries: 1}, nil)
+		mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "bucket", Key: "object2"}).Return(ObjectResult{}, fmt.Errorf("throttled"))
+		var payloads []LambdaEvent
+		mockLambda := new(MockLambdaApi)
+		mockLambda.On("Invoke", mock.Anything).Return(&lambda.InvokeOutput{}, nil).Run(func(args mock.Arguments) {
+			var payload LambdaEvent
+			require.NoError(t, json.Unmarshal(args.Get(0).(*lambda.InvokeInput).Payload, &payload))
+			payloads = append(payloads, payload)
+		})
+		return &Handler{lp: mockProcessor, lambdaClient: mockLambda, functionName: "my-function", config: Config{RetryFailedObjects: 2}}, &payloads
+	}
+
+	t.Run("Only failed objects are retried", func(t *testing.T) {
+		h, payloads := newHandler()
+		response, err := h.HandleLambdaInvocation(context.Background(), event)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, response.Processed)
+		assert.Equal(t, 1, response.Requeued)
+		assert.Empty(t, response.Failures)
+		assert.Equal(t, []LambdaEvent{{Objects: []S3ObjectInfo{{Bucket: "bucket", Key: "object2"}}, Attempt: 1}}, *payloads)
+	})
+
+	t.Run("Only failed objects that could not be handed over remain failures", func(t *testing.T) {
+		h, _ := newHandler()
+		mockLambda := new(MockLambdaApi)
+		mockLambda.On("Invoke", mock.Anything).Return((*lambda.InvokeOutput)(nil), fmt.Errorf("TooManyRequestsException"))
+		h.lambdaClient = mockLambda
+		response, err := h.HandleLambdaInvocation(context.Background(), event)
+		require.ErrorContains(t, err, "TooManyRequestsException")
+
+		assert.Equal(t, 0, response.Requeued)
+		assert.Equal(t, []ObjectFailure{{Bucket: "bucket", Key: "object2", Error: "throttled"}}, response.Failures)
+	})
+
+	t.Run("Retries exhausted", func(t *testing.T) {
+		h, payloads := newHandler()
+		retry := event
+		retry.Attempt = 2
+		_, err := h.HandleLambdaInvocation(context.Background(), retry)
+		require.ErrorContains(t, err, "throttled")
+
+		assert.Empty(t, *payloads)
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// jsonSyntaxField is the pseudo field that the braces and commas of the messages and the per-event overhead of
+// CloudWatch are attributed to
+const jsonSyntaxField = "(json syntax and event overhead)"
+
+// FieldCost is the number of entries with a field and the bytes of the field in their messages
+type FieldCost struct {
+	Name    string
+	Entries int
+	Bytes   int64
+}
+
+// FieldCosts attributes the bytes that CloudWatch charges for the entries to their fields. A field costs its
+// JSON encoded name and value with the colon between them, what remains of the messages and the per-event
+// overhead is attributed to jsonSyntaxField, so the costs add up to the ingested bytes exactly.
+type FieldCosts struct {
+	Entries int
+	fields  map[string]*FieldCost
+}
+
+// add attributes the bytes of an encoded entry to its fields
+func (c *FieldCosts) add(entry LogEntry) error {
+	if c.fields == nil {
+		c.fields = map[string]*FieldCost{jsonSyntaxField: {Name: jsonSyntaxField}}
+	}
+	var fieldBytes int
+	for name, value := range entry.Data {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		cost, ok := c.fields[name]
+		if !ok {
+			cost = &FieldCost{Name: name}
+			c.fields[name] = cost
+		}
+		n := len(key) + 1 + len(encoded)
+		cost.Entries++
+		cost.Bytes += int64(n)
+		fieldBytes += n
+	}
+	c.fields[jsonSyntaxField].Entries++
+	c.fields[jsonSyntaxField].Bytes += int64(entry.Size - fieldBytes)
+	c.Entries++
+
+	return nil
+}
+
+// Total returns the bytes of all entries as counted by CloudWatch
+func (c *FieldCosts) Total() int64 {
+	var total int64
+	for _, cost := range c.fields {
+		total += cost.Bytes
+	}
+
+	return total
+}
+
+// Sorted returns the costs of the fields, the most expensive first
+func (c *FieldCosts) Sorted() []FieldCost {
+	costs := make([]FieldCost, 0, len(c.fields))
+	for _, cost := range c.fields {
+		costs = append(costs, *cost)
+	}
+	sort.Slice(costs, func(i, j int) bool {
+		if costs[i].Bytes != costs[j].Bytes {
+			return costs[i].Bytes > costs[j].Bytes
+		}
+		return costs[i].Name < costs[j].Name
+	})
+
+	return costs
+}
+
+// Write writes the costs as a table with the share of every field in the total
+func (c *FieldCosts) Write(w io.Writer) error {
+	total := c.Total()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "bytes\tshare\tentries\t\tfield")
+	for _, cost := range c.Sorted() {
+		share := 0.0
+		if total > 0 {
+			share = float64(cost.Bytes) / float64(total) * 100
+		}
+		fmt.Fprintf(tw, "%d\t%.1f%%\t%d\t\t%s\n", cost.Bytes, share, cost.Entries, cost.Name)
+	}
+	fmt.Fprintf(tw, "%d\t100.0%%\t%d\t\ttotal\n", total, c.Entries)
+
+	return tw.Flush()
+}
+
+// fieldCostSink adds the entries of an object to the field costs instead of sending them
+type fieldCostSink struct {
+	costs   *FieldCosts
+	entries int
+	bytes   int64
+}
+
+func (s *fieldCostSink) Send(entries <-chan LogEntry) error {
+	var err error
+	for entry := range entries {
+		if err != nil {
+			continue
+		}
+		if err = s.costs.add(entry); err == nil {
+			s.entries++
+			s.bytes += int64(entry.Size)
+		}
+	}
+
+	return err
+}
+
+func (s *fieldCostSink) Sent() (int, int64) {
+	return s.entries, s.bytes
+}
+
+// Analyzer processes objects like they are sent, with the configured fields and transformers, but only
+// attributes the bytes of the entries to their fields, see FieldCosts, and profiles the traffic if Profile is set
+type Analyzer struct {
+	Source  Source
+	Fields  Fields
+	Config  Config
+	Profile *TrafficProfile // Optional, shared by all objects
+}
+
+// Analyze returns the field costs of the entries of all objects
+func (a *Analyzer) Analyze(s3Objects []S3ObjectInfo) (*FieldCosts, error) {
+	costs := &FieldCosts{}
+	pipeline := &Pipeline{
+		Source: a.Source,
+		Parser: &RecordParser{Fields: a.Fields, Layouts: a.Config.TimestampLayouts},
+		Stages: func(object S3ObjectInfo, metadata ObjectMetadata) ([]Transformer, Sink) {
+			transformers := NewTransformers(a.Config)
+			if a.Profile != nil {
+				transformers = append(transformers, a.Profile)
+			}
+
+			return transformers, &fieldCostSink{costs: costs}
+		},
+	}
+	for _, s3Object := range s3Objects {
+		result, err := pipeline.Run(s3Object)
+		if err == ErrEmptyObject {
+			continue
+		}
+		if err != nil {
+			return costs, fmt.Errorf("error analyzing %s: %w", s3Object, err)
+		}
+		logf(verbosityVerbose, "analyzed %d log entries from %s", result.Entries, s3Object)
+	}
+
+	return costs, nil
+}
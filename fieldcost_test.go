@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldCosts(t *testing.T) {
+	costs := &FieldCosts{}
+	var total int64
+	for _, data := range []map[string]interface{}{
+		{"request": "GET https://example.com/<a> HTTP/1.1", "elb_status_code": "200", "target_processing_time": 0.004},
+		{"request": "GET https://example.com/ HTTP/1.1", "elb_status_code": "502"},
+	} {
+		entry := LogEntry{Data: data}
+		require.NoError(t, entry.encode())
+		require.NoError(t, costs.add(entry))
+		total += int64(entry.Size)
+	}
+
+	assert.Equal(t, 2, costs.Entries)
+	assert.Equal(t, total, costs.Total())
+	sorted := costs.Sorted()
+	require.Len(t, sorted, 4)
+	// The < and > are escaped like in the messages
+	assert.Equal(t, FieldCost{Name: "request", Entries: 2, Bytes: int64(len(`"request":"GET https://example.com/\u003ca\u003e HTTP/1.1"`) + len(`"request":"GET https://example.com/ HTTP/1.1"`))}, sorted[0])
+	assert.Equal(t, FieldCost{Name: jsonSyntaxField, Entries: 2, Bytes: 2*(2+26) + 2 + 1}, sorted[1])
+	assert.Equal(t, FieldCost{Name: "elb_status_code", Entries: 2, Bytes: 2 * int64(len(`"elb_status_code":"200"`))}, sorted[2])
+	assert.Equal(t, FieldCost{Name: "target_processing_time", Entries: 1, Bytes: int64(len(`"target_processing_time":0.004`))}, sorted[3])
+
+	var out bytes.Buffer
+	require.NoError(t, costs.Write(&out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 6)
+	assert.True(t, strings.HasSuffix(lines[1], " request"))
+	assert.Contains(t, lines[5], "100.0%")
+}
+
+func TestAnalyzer(t *testing.T) {
+	line := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify?id=42 HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4 "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+	path := filepath.Join(t.TempDir(), "a.log")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat(line+"\n", 3)), 0o644))
+	s3Objects, err := listLocalObjects(path)
+	require.NoError(t, err)
+	fields, err := NewFields("")
+	require.NoError(t, err)
+
+	profile := &TrafficProfile{Top: 5}
+	analyzer := &Analyzer{Source: NewSources(nil), Fields: fields, Profile: profile}
+	costs, err := analyzer.Analyze(s3Objects)
+	require.NoError(t, err)
+	assert.Equal(t, 3, costs.Entries)
+	found := false
+	for _, cost := range costs.Sorted() {
+		if cost.Name == "chosen_cert_arn" {
+			found = true
+			assert.Equal(t, int64(3*len(`"chosen_cert_arn":"arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"`)), cost.Bytes)
+		}
+	}
+	assert.True(t, found)
+
+	var out bytes.Buffer
+	require.NoError(t, profile.Write(&out))
+	assert.Contains(t, out.String(), "top 5 paths of ~1 unique\n  3  100.0%  /api/modify\n")
+	assert.Contains(t, out.String(), "top 5 target groups of ~1 unique\n  3  100.0%  example-prod-tg\n")
+}
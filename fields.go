@@ -39,6 +39,45 @@ var fieldNames = []string{
 	"conn_trace_id", // https listener
 }
 
+// fieldIndexes maps each field name to its position in a log record
+var fieldIndexes = func() map[string]int {
+	indexes := make(map[string]int, len(fieldNames))
+	for i, field := range fieldNames {
+		indexes[field] = i
+	}
+
+	return indexes
+}()
+
+// recordValue returns the value of the named field in a log record, or an empty string if it is not present
+func recordValue(record []string, field string) string {
+	index, ok := fieldIndexes[field]
+	if !ok || index >= len(record) {
+		return ""
+	}
+
+	return record[index]
+}
+
+// slimFields are the fields of the slim preset: when, where and how fast a request was answered
+var slimFields = []string{
+	"time",
+	"elb",
+	"request",
+	"elb_status_code",
+	"target_status_code",
+	"request_processing_time",
+	"target_processing_time",
+	"response_processing_time",
+}
+
+// fieldPresets are named lists of fields for common use cases, selected with @name in FIELDS
+var fieldPresets = map[string][]string{
+	"slim":     slimFields,
+	"security": append(append([]string{}, slimFields...), "client:port", "user_agent", "ssl_cipher", "ssl_protocol", "trace_id"),
+	"full":     fieldNames,
+}
+
 type Fields interface {
 	GetFieldNameByIndex(index int) (string, error)
 	IncludeField(index int) bool
@@ -64,10 +103,20 @@ func NewFields(fieldsConfig string) (*IncludedFields, error) {
 
 		return fs, nil
 	}
-	// Include only the fields that are provided in config:
+	// Include only the fields that are provided in config, presets can be combined with other fields:
 	fields := strings.Split(fieldsConfig, ",")
 	for _, field := range fields {
 		field = strings.TrimSpace(field)
+		if name, ok := strings.CutPrefix(field, "@"); ok {
+			preset, ok := fieldPresets[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid field preset '%s' provided, expected @slim, @security or @full", field)
+			}
+			for _, field := range preset {
+				fs.includedFieldsMap[field] = true
+			}
+			continue
+		}
 		if _, ok := validFieldMap[field]; !ok {
 			return nil, fmt.Errorf("invalid field name '%s' provided", field)
 		}
@@ -28,6 +28,29 @@ func TestNewFields(t *testing.T) {
 		assert.False(t, fields.IncludeField(getFieldIndex("client:port")))
 	})
 
+	t.Run("Presets", func(t *testing.T) {
+		fields, err := NewFields("@slim")
+		require.NoError(t, err)
+		assert.True(t, fields.IncludeField(getFieldIndex("target_processing_time")))
+		assert.False(t, fields.IncludeField(getFieldIndex("user_agent")))
+
+		fields, err = NewFields("@security, domain_name")
+		require.NoError(t, err)
+		for _, field := range []string{"time", "client:port", "user_agent", "ssl_protocol", "trace_id", "domain_name"} {
+			assert.True(t, fields.IncludeField(getFieldIndex(field)), field)
+		}
+		assert.False(t, fields.IncludeField(getFieldIndex("redirect_url")))
+
+		fields, err = NewFields("@full")
+		require.NoError(t, err)
+		for _, field := range fieldNames {
+			assert.True(t, fields.IncludeField(getFieldIndex(field)), field)
+		}
+
+		_, err = NewFields("@everything")
+		assert.EqualError(t, err, "invalid field preset '@everything' provided, expected @slim, @security or @full")
+	})
+
 	t.Run("Invalid field provided", func(t *testing.T) {
 		_, err := NewFields("invalid_field")
 		require.Error(t, err)
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// browserFamilies are checked in order, as browsers include the tokens of the browsers they derive from, e.g.
+// Edge user agents contain Chrome and Safari, and Chrome user agents contain Safari
+var browserFamilies = []struct {
+	token  string
+	family string
+}{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+// UserAgentFamily returns the browser of a user agent, or the name of its first product for other clients, e.g.
+// "curl" for "curl/8.4.0" and "python-requests" for "python-requests/2.31.0". Versions are left out, so all
+// releases of a client are in the same family. Empty user agents have an empty family.
+func UserAgentFamily(userAgent string) string {
+	if userAgent == "" || userAgent == "-" {
+		return ""
+	}
+	if strings.HasPrefix(userAgent, "Mozilla/") {
+		for _, browser := range browserFamilies {
+			if strings.Contains(userAgent, browser.token) {
+				return browser.family
+			}
+		}
+	}
+	product, _, _ := strings.Cut(userAgent, " ")
+	name, _, _ := strings.Cut(product, "/")
+
+	return name
+}
+
+// RequestFingerprinter adds a request_fingerprint field, a hash of the method, normalized path and user agent
+// family of the request. Requests of the same shape have the same fingerprint across objects and runs, e.g. to
+// find the requests a scraper repeats or that are worth caching.
+type RequestFingerprinter struct{}
+
+func (f *RequestFingerprinter) Transform(record []string, entry *LogEntry) {
+	request := recordValue(record, "request")
+	method, _, _ := strings.Cut(request, " ")
+	path := RequestPath(request)
+	if method == "" || method == "-" || path == "" {
+		return
+	}
+	h := fnv.New64a()
+	for _, value := range []string{method, NormalizePath(path), UserAgentFamily(recordValue(record, "user_agent"))} {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	entry.Data["request_fingerprint"] = fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAgentFamily(t *testing.T) {
+	for userAgent, family := range map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36":             "Chrome",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0": "Edge",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15":       "Safari",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":                                                      "Firefox",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                                    "Mozilla",
+		"curl/8.4.0":             "curl",
+		"python-requests/2.31.0": "python-requests",
+		"-":                      "",
+	} {
+		assert.Equal(t, family, UserAgentFamily(userAgent), userAgent)
+	}
+}
+
+func TestRequestFingerprinter(t *testing.T) {
+	fingerprint := func(request, userAgent string) interface{} {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("request")] = request
+		record[getFieldIndex("user_agent")] = userAgent
+		entry := LogEntry{Data: map[string]interface{}{}}
+		(&RequestFingerprinter{}).Transform(record, &entry)
+		return entry.Data["request_fingerprint"]
+	}
+
+	users1 := fingerprint("GET https://example.com:443/users/1?page=2 HTTP/1.1", "curl/8.4.0")
+	assert.Len(t, users1, 16)
+	assert.Equal(t, users1, fingerprint("GET https://example.com:443/users/2 HTTP/1.1", "curl/7.88.1"))
+	assert.NotEqual(t, users1, fingerprint("POST https://example.com:443/users/1 HTTP/1.1", "curl/8.4.0"))
+	assert.NotEqual(t, users1, fingerprint("GET https://example.com:443/orders/1 HTTP/1.1", "curl/8.4.0"))
+	assert.NotEqual(t, users1, fingerprint("GET https://example.com:443/users/1 HTTP/1.1", "python-requests/2.31.0"))
+	assert.Nil(t, fingerprint("- http://example.com:80- \"-\"", "-"))
+}
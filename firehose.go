@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"log"
+)
+
+// Results of the transformation of a Firehose record
+const (
+	firehoseOk               = "Ok"
+	firehoseDropped          = "Dropped"
+	firehoseProcessingFailed = "ProcessingFailed"
+)
+
+// FirehoseEvent is the payload of a Kinesis Data Firehose data transformation invocation
+type FirehoseEvent struct {
+	InvocationID      string           `json:"invocationId"`
+	DeliveryStreamArn string           `json:"deliveryStreamArn"`
+	Records           []FirehoseRecord `json:"records"`
+}
+
+type FirehoseRecord struct {
+	RecordID string `json:"recordId"`
+	Data     []byte `json:"data"`
+}
+
+// FirehoseResponse returns the transformed data and the result of every record of a FirehoseEvent
+type FirehoseResponse struct {
+	Records []FirehoseResult `json:"records"`
+}
+
+type FirehoseResult struct {
+	RecordID string `json:"recordId"`
+	Result   string `json:"result"`
+	Data     []byte `json:"data"`
+}
+
+// FirehoseTransformer transforms the records of a Firehose delivery stream with the configured fields and
+// transformers, so logs delivered to S3 or OpenSearch by Firehose get the same fields as logs sent to
+// CloudWatch. Records contain access log lines, as decoded by decodeKinesisRecord, and are transformed into
+// newline delimited JSON. Records without entries after filtering are dropped, records that can't be parsed
+// fail and are delivered to the error output of the delivery stream as is.
+type FirehoseTransformer struct {
+	Parser Parser
+	Config Config
+}
+
+// NewFirehoseTransformer returns a transformer with the fields and timestamp layouts of the config
+func NewFirehoseTransformer(config Config) (*FirehoseTransformer, error) {
+	fields, err := NewFields(config.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FirehoseTransformer{Parser: &RecordParser{Fields: fields, Layouts: config.TimestampLayouts}, Config: config}, nil
+}
+
+// HandleFirehoseEvent transforms the records of an invocation. The transformers are shared by the records of
+// an invocation, so e.g. duplicates are detected across records.
+func (f *FirehoseTransformer) HandleFirehoseEvent(event FirehoseEvent) (FirehoseResponse, error) {
+	transformers := NewTransformers(f.Config)
+	response := FirehoseResponse{Records: make([]FirehoseResult, 0, len(event.Records))}
+	counts := make(map[string]int)
+	for _, record := range event.Records {
+		result := FirehoseResult{RecordID: record.RecordID, Result: firehoseOk}
+		data, entries, err := f.transform(record.Data, transformers)
+		switch {
+		case err != nil:
+			log.Printf("failed to transform record %s: %v", record.RecordID, err)
+			result.Result = firehoseProcessingFailed
+			result.Data = record.Data
+		case entries == 0:
+			result.Result = firehoseDropped
+		default:
+			result.Data = data
+		}
+		counts[result.Result]++
+		response.Records = append(response.Records, result)
+	}
+	log.Printf("transformed %d records: %d ok, %d dropped, %d failed",
+		len(event.Records), counts[firehoseOk], counts[firehoseDropped], counts[firehoseProcessingFailed])
+
+	return response, nil
+}
+
+// transform returns the entries of a record as newline delimited JSON and the number of entries
+func (f *FirehoseTransformer) transform(data []byte, transformers []Transformer) ([]byte, int, error) {
+	lines, err := decodeKinesisRecord(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	entryChan := make(chan LogEntry, maxBatchCount)
+	var out bytes.Buffer
+	entries := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for entry := range entryChan {
+			out.WriteString(entry.Message)
+			out.WriteByte('\n')
+			entries++
+		}
+	}()
+	err = f.Parser.Parse(bytes.NewReader(lines), entryChan, transformers)
+	close(entryChan)
+	<-done
+
+	return out.Bytes(), entries, err
+}
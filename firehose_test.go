@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirehoseTransformer(t *testing.T) {
+	line := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify?id=42 HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4 "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+	transformer, err := NewFirehoseTransformer(Config{Fields: "elb_status_code,domain_name", DedupWindow: 10})
+	require.NoError(t, err)
+
+	response, err := transformer.HandleFirehoseEvent(FirehoseEvent{Records: []FirehoseRecord{
+		{RecordID: "1", Data: gzipBytes(t, line+"\n")},
+		{RecordID: "2", Data: []byte(line + "\n")},
+		{RecordID: "3", Data: []byte("not an access log\n")},
+		{RecordID: "4", Data: []byte{}},
+	}})
+	require.NoError(t, err)
+	require.Len(t, response.Records, 4)
+
+	assert.Equal(t, FirehoseResult{RecordID: "1", Result: firehoseOk, Data: response.Records[0].Data}, response.Records[0])
+	lines := strings.Split(strings.TrimSuffix(string(response.Records[0].Data), "\n"), "\n")
+	require.Len(t, lines, 1)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, map[string]interface{}{"elb_status_code": "203", "domain_name": "example.com"}, entry)
+
+	// The duplicate of the first record is dropped
+	assert.Equal(t, FirehoseResult{RecordID: "2", Result: firehoseDropped}, response.Records[1])
+	assert.Equal(t, FirehoseResult{RecordID: "3", Result: firehoseProcessingFailed, Data: []byte("not an access log\n")}, response.Records[2])
+	assert.Equal(t, FirehoseResult{RecordID: "4", Result: firehoseDropped}, response.Records[3])
+}
@@ -0,0 +1,43 @@
+package main
+
+import "sync"
+
+// flightGroup shares the result of a slow call, such as a lookup in an AWS API, between the goroutines that make it
+// for the same key at the same time, so it is made once and calls for other keys don't wait for it. The zero
+// value is ready to use.
+type flightGroup[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[V]
+}
+
+// flightCall is a call in progress, value and err are set when done is closed
+type flightCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// do calls fn unless a call for the key is in progress, in which case it waits for that call and returns its result
+func (g *flightGroup[V]) do(key string, fn func() (V, error)) (V, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[V])
+	}
+	call := &flightCall[V]{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.value, call.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+
+	return call.value, call.err
+}
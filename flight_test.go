@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var group flightGroup[string]
+	var calls int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = group.do("a", func() (string, error) {
+			atomic.AddInt32(&calls, 1)
+			close(started)
+			<-release
+			return "value of a", nil
+		})
+	}()
+	<-started
+
+	// Calls for other keys don't wait
+	value, err := group.do("b", func() (string, error) { return "value of b", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "value of b", value)
+
+	// Calls for the same key share the call in progress
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = group.do("a", func() (string, error) {
+				atomic.AddInt32(&calls, 1)
+				return "value of a", nil
+			})
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []string{"value of a", "value of a", "value of a", "value of a", "value of a"}, results)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
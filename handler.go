@@ -1,108 +1,480 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"log"
+	"os"
 	"sync"
+	"time"
 )
 
 type Handler struct {
-	lp       LogProcessor
-	s3Client S3Api
+	lp           LogProcessor
+	s3Client     S3Api
+	config       Config
+	lambdaClient LambdaApi // Only set when running in Lambda
+	functionName string
+	cwClient     CloudWatchLogsAPI
+	roleClients  *RoleClients
+	spool        Spool             // nil if spooling is disabled
+	limiter      *RateLimiter      // nil if unlimited
+	health       *Health           // Only set when watching
+	progress     *ProgressEvents   // Only set with --progress json
+	checkpoint   *reportCheckpoint // Only set with --report
+	stop         <-chan struct{}   // Closed to start no more objects, see Watcher.pollWithLease
+	dynamoDB     DynamoDBApi       // Only set when PROGRESS_TABLE or LEASE_TABLE is configured
+	session      *session.Session
+	memory       *MemoryMonitor // nil if the peak heap is not reported
+	watermarks   *Watermarks    // nil if INGESTION_LAG_METRIC is disabled
 }
 
 type S3ObjectInfo struct {
-	Bucket string
-	Key    string
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Size   *int64 `json:"size,omitempty"` // Size in bytes if known from the event or listing
+	ETag   string `json:"etag,omitempty"`
+	// RequestID is the ID of the Lambda invocation processing the object
+	RequestID string `json:"-"`
+	// TimestampShift moves the timestamps of the entries, see TimeShifter
+	TimestampShift time.Duration `json:"-"`
+	// Verify reads the events back from CloudWatch Logs after sending them, see Verifier
+	Verify bool `json:"-"`
 }
 
-// concurrency is the max number of concurrent log processing operations
+// concurrency is the max number of concurrent log processing operations, see objectConcurrency
 const concurrency = 10
 
+// maxListKeys is the maximum number of keys ListObjectsV2 returns per request
+const maxListKeys = 1000
+
 func NewHandler() (*Handler, error) {
-	sess := session.Must(session.NewSession())
 	config, err := LoadConfigFromEnv()
 	if err != nil {
 		return nil, err
 	}
-	lp, err := NewLogProcessor(config)
+	state, err := NewState(config, os.Getenv("AWS_LAMBDA_FUNCTION_NAME"))
 	if err != nil {
 		return nil, err
 	}
-	return &Handler{lp: lp, s3Client: s3.New(sess)}, nil
+	if state.FunctionName != "" {
+		// Logged before creating the log group and stream, so the configuration of a failing cold start is known
+		logEffectiveConfig(config, state.Fields)
+	}
+	lp, err := NewLogProcessor(state)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		lp:           lp,
+		s3Client:     state.S3Client,
+		config:       config,
+		lambdaClient: state.LambdaClient,
+		functionName: state.FunctionName,
+		cwClient:     state.CWClient,
+		roleClients:  state.RoleClients,
+		spool:        state.Spool,
+		limiter:      state.Limiter,
+		dynamoDB:     state.DynamoDB,
+		session:      state.Session,
+		memory:       &MemoryMonitor{},
+		watermarks:   state.Watermarks,
+	}, nil
+}
+
+// setUpDestinations checks and creates the configured log group and stream and those of the routes, see
+// NewLogProcessor. Commands that send entries call it at startup, so they fail before reading anything when a
+// destination can't be set up.
+func (h *Handler) setUpDestinations() error {
+	if lp, ok := h.lp.(*CloudWatchLogProcessor); ok {
+		return lp.prepare(true)
+	}
+
+	return nil
+}
+
+// objectOutcome is the result of processing a single object
+type objectOutcome struct {
+	s3Object S3ObjectInfo
+	result   ObjectResult
+	err      error
+}
+
+// processS3Objects processes all objects concurrently. Every object is processed even if others fail,
+// the returned error combines the errors of all failed objects.
+func (h *Handler) processS3Objects(s3Objects []S3ObjectInfo) (RunResult, error) {
+	result, _, err := h.processS3ObjectsWithStatuses(s3Objects)
+	return result, err
+}
+
+// processS3ObjectsWithStatuses is processS3Objects that also returns the status of every object, in no particular
+// order. The statuses are written to the MANIFEST if configured.
+func (h *Handler) processS3ObjectsWithStatuses(s3Objects []S3ObjectInfo) (RunResult, []ObjectStatus, error) {
+	started := time.Now()
+	result, statuses, err := h.runS3Objects(s3Objects)
+	var metrics io.Writer
+	if h.lambdaClient != nil {
+		metrics = os.Stdout
+	}
+	emitIngestionLag(metrics, h.watermarks, started, time.Now())
+	if h.config.Manifest != "" {
+		var requestID string
+		if len(s3Objects) > 0 {
+			requestID = s3Objects[0].RequestID
+		}
+		manifest := newManifest(started, time.Now(), requestID, statuses)
+		if location, err := writeManifest(h.config.Manifest, h.s3Client, manifest); err != nil {
+			log.Println(err)
+		} else {
+			logf(verbosityNormal, "wrote manifest of %d objects to %s", len(manifest.Objects), location)
+		}
+	}
+
+	return result, statuses, err
 }
 
-func (h *Handler) processS3Objects(s3Objects []S3ObjectInfo) error {
-	errs := make(chan error)
+// runS3Objects processes the objects, see processS3ObjectsWithStatuses
+func (h *Handler) runS3Objects(s3Objects []S3ObjectInfo) (RunResult, []ObjectStatus, error) {
+	outcomes := make(chan objectOutcome, len(s3Objects))
+	entries := SafeCounter{}
+	var remaining []S3ObjectInfo
 	var wg sync.WaitGroup
-	concurrent := make(chan int, concurrency) // limit concurrent processing
-	for _, s3obj := range s3Objects {
-		wg.Add(1)
+	concurrent := make(chan int, objectConcurrency(h.config.MemoryLimit)) // limit concurrent processing
+	if h.memory != nil {
+		h.memory.start()
+	}
+	for i, s3obj := range s3Objects {
 		concurrent <- 1
+		if h.workLimitReached(i, entries.Value()) {
+			<-concurrent
+			remaining = s3Objects[i:]
+			break
+		}
+		// The objects that weren't started get no status, so they are neither done nor failed
+		if h.stopped() {
+			<-concurrent
+			log.Printf("stopped before %d objects", len(s3Objects)-i)
+			break
+		}
+		wg.Add(1)
 		go func(s3obj S3ObjectInfo) {
 			defer func() { wg.Done(); <-concurrent }()
-			err := h.lp.ProcessLogs(s3obj)
-			if err != nil {
-				errs <- fmt.Errorf("error processing logs for s3://%s/%s: %w", s3obj.Bucket, s3obj.Key, err)
-			}
+			result, err := h.lp.ProcessLogs(s3obj)
+			h.health.objectDone(result, err)
+			h.progress.objectDone(result, err)
+			h.checkpoint.objectDone(newObjectStatus(s3obj, result, err))
+			entries.Increment(result.Entries)
+			outcomes <- objectOutcome{s3Object: s3obj, result: result, err: err}
 		}(s3obj)
 	}
-	go func() {
-		wg.Wait()
-		close(errs)
-	}()
-	for err := range errs {
+	wg.Wait()
+	close(outcomes)
+
+	var runResult RunResult
+	if h.memory != nil {
+		runResult.PeakHeapBytes = h.memory.finish()
+		runResult.MemoryLimitBytes = h.config.MemoryLimit
+	}
+	var statuses []ObjectStatus
+	var errs []error
+	loadBalancers := make(loadBalancerResults)
+	for outcome := range outcomes {
+		runResult.Entries += outcome.result.Entries
+		runResult.CompressedBytes += outcome.result.CompressedBytes
+		runResult.DecompressedBytes += outcome.result.DecompressedBytes
+		runResult.SentBytes += outcome.result.SentBytes
+		status := newObjectStatus(outcome.s3Object, outcome.result, outcome.err)
+		switch status.Status {
+		case statusEmpty:
+			runResult.Empty++
+		case statusAlreadyProcessed:
+			runResult.AlreadyProcessed++
+		case statusFailed:
+			errs = append(errs, fmt.Errorf("error processing logs for %s: %w", outcome.s3Object, outcome.err))
+			runResult.Failures = append(runResult.Failures, ObjectFailure{Bucket: status.Bucket, Key: status.Key, Error: status.Error})
+		case statusUnverified:
+			log.Printf("%s was sent, but %s", outcome.s3Object, status.Error)
+			runResult.Processed++
+			runResult.Unverified = append(runResult.Unverified, ObjectFailure{Bucket: status.Bucket, Key: status.Key, Error: status.Error})
+		default:
+			runResult.Processed++
+		}
+		loadBalancers.add(outcome.s3Object.Key, outcome.result, status.Status == statusFailed)
+		statuses = append(statuses, status)
+	}
+	runResult.sortFailures()
+	runResult.LoadBalancers = loadBalancers.sorted()
+	// The remaining objects are handed over even if some objects failed, as only the failures are retried
+	if len(remaining) > 0 {
+		logf(verbosityNormal, "work limit reached after %d objects and %d entries, re-enqueueing %d objects", len(s3Objects)-len(remaining), entries.Value(), len(remaining))
+		failed, err := h.invokeAsync(remaining, h.config.MaxObjectsPerInvocation, 0)
+		notHandedOver := make(map[S3ObjectInfo]bool, len(failed))
+		for _, s3Object := range failed {
+			notHandedOver[s3Object] = true
+		}
+		for _, s3Object := range remaining {
+			if !notHandedOver[s3Object] {
+				runResult.Requeued++
+				statuses = append(statuses, ObjectStatus{Bucket: s3Object.Bucket, Key: s3Object.Key, Status: statusRequeued})
+			}
+		}
+		// Only the objects of the chunks that weren't handed over fail, so only they are retried
 		if err != nil {
-			return err
+			for _, failure := range invokeFailures(failed, err) {
+				statuses = append(statuses, ObjectStatus{Bucket: failure.Bucket, Key: failure.Key, Status: statusFailed, Error: failure.Error})
+				runResult.Failures = append(runResult.Failures, failure)
+			}
+			runResult.sortFailures()
+			errs = append(errs, err)
 		}
 	}
+	if len(errs) > 0 {
+		return runResult, statuses, errors.Join(errs...)
+	}
+	runResult.logSummary()
 
-	return nil
+	return runResult, statuses, nil
+}
+
+// stopped reports whether no more objects should be started
+func (h *Handler) stopped() bool {
+	select {
+	case <-h.stop:
+		return true
+	default:
+		return false
+	}
 }
 
-func (h *Handler) HandleLambdaEvent(event S3ObjectCreatedEvent) error {
+// workLimitReached reports whether the per-invocation limits have been reached, in which case no new objects are
+// started. Objects that are already being processed are finished, so the entries limit may be exceeded slightly.
+// The limits only apply in Lambda, where the remaining objects can be handed over to a new invocation.
+func (h *Handler) workLimitReached(objects, entries int) bool {
+	if h.lambdaClient == nil {
+		return false
+	}
+	if h.config.MaxObjectsPerInvocation > 0 && objects >= h.config.MaxObjectsPerInvocation {
+		return true
+	}
+
+	return h.config.MaxEntriesPerInvocation > 0 && entries >= h.config.MaxEntriesPerInvocation
+}
+
+func (h *Handler) HandleLambdaEvent(event S3ObjectCreatedEvent) (RunResult, error) {
+	return h.processS3Objects(s3ObjectsFromEvent(event))
+}
+
+// s3ObjectsFromEvent returns the objects of an S3 event notification
+func s3ObjectsFromEvent(event S3ObjectCreatedEvent) []S3ObjectInfo {
 	var s3Objects []S3ObjectInfo
 	for _, record := range event.Records {
 		s3Objects = append(s3Objects, S3ObjectInfo{
 			Bucket: record.S3.Bucket.Name,
 			Key:    record.S3.Object.Key,
+			Size:   record.S3.Object.Size,
 		})
 	}
+
+	return s3Objects
+}
+
+// HandleLambdaInvocation handles S3 event notifications as well as direct invocations. A failed invocation returns
+// the error together with the response of what was done, such as the objects that failed in RunResult.Failures.
+// Lambda only reports the error, which names every failed object.
+func (h *Handler) HandleLambdaInvocation(ctx context.Context, event LambdaEvent) (*LambdaResponse, error) {
+	invocation := newInvocationInfo(ctx)
+	if invocation.RequestID != "" {
+		log.Printf("invocation %s started with %s remaining", invocation.RequestID, invocation.remaining().Round(time.Millisecond))
+	}
+
+	var response *LambdaResponse
+	var err error
+	if event.Prefix != "" {
+		response, err = h.handleBackfillChunk(event.Prefix, event.StartAfter, event.MaxObjects, invocation.RequestID)
+	} else {
+		var result RunResult
+		switch {
+		case event.S3URL != "":
+			result, err = h.handleS3URL(event.S3URL, invocation.RequestID)
+		case len(event.Objects) > 0:
+			result, err = h.processS3Objects(invocation.stampObjects(event.Objects))
+		default:
+			result, err = h.processS3Objects(invocation.stampObjects(s3ObjectsFromEvent(event.S3ObjectCreatedEvent)))
+		}
+		if err != nil {
+			result, err = h.retryFailedObjects(result, err, event.Attempt)
+		}
+		// Failing the invocation would make Lambda retry the event and send its successful objects again
+		if err != nil && h.config.DeliveryMode == deliveryBestEffort {
+			log.Printf("giving up on %d failed objects with best-effort delivery: %v", len(result.Failures), err)
+			err = nil
+		}
+		response = &LambdaResponse{RunResult: result, Done: true}
+	}
+	if response == nil {
+		response = &LambdaResponse{}
+	}
+	response.RequestID = invocation.RequestID
+	response.RemainingTimeMillis = invocation.remaining().Milliseconds()
+	if invocation.RequestID != "" {
+		log.Printf("invocation %s finished with %dms remaining", invocation.RequestID, response.RemainingTimeMillis)
+	}
+
+	return response, err
+}
+
+// retryFailedObjects isolates failed objects from the successful ones: instead of failing the invocation, which
+// makes Lambda retry the whole event and re-ship the successful objects, only the failed objects are handed over
+// to a new asynchronous invocation. Once the retries are exhausted the invocation fails with the original error.
+func (h *Handler) retryFailedObjects(result RunResult, err error, attempt int) (RunResult, error) {
+	if h.lambdaClient == nil || len(result.Failures) == 0 || attempt >= h.config.RetryFailedObjects {
+		return result, err
+	}
+	s3Objects := make([]S3ObjectInfo, len(result.Failures))
+	for i, failure := range result.Failures {
+		s3Objects[i] = S3ObjectInfo{Bucket: failure.Bucket, Key: failure.Key}
+	}
+	log.Printf("retrying %d failed objects in a new invocation (attempt %d of %d): %v", len(s3Objects), attempt+1, h.config.RetryFailedObjects, err)
+	failed, invokeErr := h.invokeAsync(s3Objects, defaultInvocationChunkSize, attempt+1)
+	result.Requeued += len(s3Objects) - len(failed)
+	// The objects that were handed over no longer fail this invocation, so they aren't retried twice
+	notHandedOver := make(map[S3ObjectInfo]bool, len(failed))
+	for _, s3Object := range failed {
+		notHandedOver[s3Object] = true
+	}
+	var failures []ObjectFailure
+	for _, failure := range result.Failures {
+		if notHandedOver[S3ObjectInfo{Bucket: failure.Bucket, Key: failure.Key}] {
+			failures = append(failures, failure)
+		}
+	}
+	result.Failures = failures
+	if invokeErr != nil {
+		return result, errors.Join(err, invokeErr)
+	}
+
+	return result, nil
+}
+
+func (h *Handler) HandleS3URL(url string) (RunResult, error) {
+	return h.handleS3URL(url, "")
+}
+
+func (h *Handler) handleS3URL(url, requestID string) (RunResult, error) {
+	s3Objects, err := h.listS3URL(url)
+	if err != nil {
+		return RunResult{}, err
+	}
+	s3Objects = invocationInfo{RequestID: requestID}.stampObjects(s3Objects)
+	if h.lambdaClient != nil && h.config.FanOutChunkSize > 0 && len(s3Objects) > h.config.FanOutChunkSize {
+		return h.fanOut(s3Objects)
+	}
+
 	return h.processS3Objects(s3Objects)
 }
 
-func (h *Handler) HandleS3URL(url string) error {
+// HandleBackfillChunk processes up to maxObjects objects under the prefix of an S3 URL, starting after the
+// given key. It is designed to be called repeatedly, e.g. from a Step Functions loop or Map state, passing
+// the returned LastKey as startAfter until Done is true.
+func (h *Handler) HandleBackfillChunk(url, startAfter string, maxObjects int) (*LambdaResponse, error) {
+	return h.handleBackfillChunk(url, startAfter, maxObjects, "")
+}
+
+func (h *Handler) handleBackfillChunk(url, startAfter string, maxObjects int, requestID string) (*LambdaResponse, error) {
+	if maxObjects <= 0 {
+		return nil, fmt.Errorf("maxObjects must be greater than 0")
+	}
 	bucket, prefix, err := ParseS3URL(url)
 	if err != nil {
-		return fmt.Errorf("failed to parse S3 URL: %v", err)
+		return nil, fmt.Errorf("failed to parse S3 URL: %v", err)
+	}
+	s3Objects, more, err := h.listS3Objects(bucket, prefix, startAfter, maxObjects)
+	if err != nil {
+		return nil, err
+	}
+	s3Objects = invocationInfo{RequestID: requestID}.stampObjects(s3Objects)
+	// Failed objects are returned with the response, so callers can inspect the failures and decide to continue
+	result, err := h.processS3Objects(s3Objects)
+	response := &LambdaResponse{RunResult: result, LastKey: startAfter, Done: !more}
+	if len(s3Objects) > 0 {
+		response.LastKey = s3Objects[len(s3Objects)-1].Key
 	}
 
-	var s3Objects []S3ObjectInfo
-	var continuationToken *string
+	return response, err
+}
+
+// listS3URL lists all objects under the prefix of an S3 URL
+func (h *Handler) listS3URL(url string) ([]S3ObjectInfo, error) {
+	bucket, prefix, err := ParseS3URL(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse S3 URL: %v", err)
+	}
+	s3Objects, _, err := h.listS3Objects(bucket, prefix, "", 0)
+
+	return s3Objects, err
+}
+
+// listS3Objects lists the objects under a prefix in key order, starting after the given key (if any).
+// A limit of 0 lists all objects, otherwise more reports whether objects remain after the limit.
+func (h *Handler) listS3Objects(bucket, prefix, startAfter string, limit int) (s3Objects []S3ObjectInfo, more bool, err error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	if startAfter != "" {
+		input.StartAfter = aws.String(startAfter)
+	}
 	for {
-		resp, err := h.s3Client.ListObjectsV2(&s3.ListObjectsV2Input{
-			Bucket:            aws.String(bucket),
-			Prefix:            aws.String(prefix),
-			ContinuationToken: continuationToken,
-		})
+		if limit > 0 {
+			input.MaxKeys = aws.Int64(int64(min(limit-len(s3Objects), maxListKeys)))
+		}
+		resp, err := h.s3Client.ListObjectsV2(input)
 		if err != nil {
-			return fmt.Errorf("failed to list objects: %v", err)
+			return nil, false, fmt.Errorf("failed to list objects: %v", err)
 		}
 
 		for _, item := range resp.Contents {
 			s3Objects = append(s3Objects, S3ObjectInfo{
 				Bucket: bucket,
 				Key:    *item.Key,
+				Size:   item.Size,
 			})
 		}
 
-		if resp.IsTruncated == nil || !*resp.IsTruncated {
+		more = resp.IsTruncated != nil && *resp.IsTruncated
+		if !more || (limit > 0 && len(s3Objects) >= limit) {
 			break
 		}
-		continuationToken = resp.NextContinuationToken
+		input.ContinuationToken = resp.NextContinuationToken
 	}
 
-	return h.processS3Objects(s3Objects)
+	return s3Objects, more, nil
+}
+
+// listS3Directories lists the directories directly under a prefix, the common prefixes of a listing delimited by /
+func (h *Handler) listS3Directories(bucket, prefix string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+	var directories []string
+	for {
+		resp, err := h.s3Client.ListObjectsV2(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list directories: %v", err)
+		}
+		for _, commonPrefix := range resp.CommonPrefixes {
+			directories = append(directories, aws.StringValue(commonPrefix.Prefix))
+		}
+		if resp.IsTruncated == nil || !*resp.IsTruncated {
+			return directories, nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
 }
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sync"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -17,9 +19,9 @@ type MockLogProcessor struct {
 	mock.Mock
 }
 
-func (m *MockLogProcessor) ProcessLogs(s3obj S3ObjectInfo) error {
+func (m *MockLogProcessor) ProcessLogs(s3obj S3ObjectInfo) (ObjectResult, error) {
 	args := m.Called(s3obj)
-	return args.Error(0)
+	return args.Get(0).(ObjectResult), args.Error(1)
 }
 
 func TestHandleLambdaEvent(t *testing.T) {
@@ -48,13 +50,13 @@ func TestHandleLambdaEvent(t *testing.T) {
 		mockProcessor.On("ProcessLogs", S3ObjectInfo{
 			Bucket: "my-bucket",
 			Key:    "my-folder/my-object.txt",
-		}).Return(nil)
+		}).Return(ObjectResult{}, nil)
 
 		// Create handler with mock processor
 		handler := &Handler{lp: mockProcessor}
 
 		// Call the function under test
-		err = handler.HandleLambdaEvent(event)
+		_, err = handler.HandleLambdaEvent(event)
 		require.NoError(t, err)
 
 		// Assert that the ProcessLogs method was called with the correct parameters
@@ -89,13 +91,13 @@ func TestHandleLambdaEvent(t *testing.T) {
 		mockProcessor.On("ProcessLogs", S3ObjectInfo{
 			Bucket: "my-bucket",
 			Key:    "my-folder/my-object.txt",
-		}).Return(fmt.Errorf("process logs error"))
+		}).Return(ObjectResult{}, fmt.Errorf("process logs error"))
 
 		// Create handler with mock processor
 		handler := &Handler{lp: mockProcessor}
 
 		// Call the function under test
-		err = handler.HandleLambdaEvent(event)
+		_, err = handler.HandleLambdaEvent(event)
 
 		// Assert that an error was returned
 		require.Error(t, err)
@@ -149,18 +151,18 @@ func TestHandleLambdaEvent(t *testing.T) {
 		mockProcessor.On("ProcessLogs", S3ObjectInfo{
 			Bucket: "my-bucket",
 			Key:    "my-folder/my-object1.txt",
-		}).Return(nil).Run(func(args mock.Arguments) {
+		}).Return(ObjectResult{}, nil).Run(func(args mock.Arguments) {
 			wg.Done()
 		})
 		mockProcessor.On("ProcessLogs", S3ObjectInfo{
 			Bucket: "my-bucket",
 			Key:    "my-folder/my-object2.txt",
-		}).Return(nil).Run(func(args mock.Arguments) {
+		}).Return(ObjectResult{}, nil).Run(func(args mock.Arguments) {
 			wg.Done()
 		})
 
 		go func() {
-			err := handler.HandleLambdaEvent(event)
+			_, err := handler.HandleLambdaEvent(event)
 			require.NoError(t, err)
 		}()
 
@@ -178,6 +180,18 @@ func TestHandleLambdaEvent(t *testing.T) {
 	})
 }
 
+func TestHandleEmptyObjects(t *testing.T) {
+	mockProcessor := new(MockLogProcessor)
+	mockProcessor.On("ProcessLogs", mock.Anything).Return(ObjectResult{}, ErrEmptyObject)
+
+	handler := &Handler{lp: mockProcessor}
+	result, err := handler.HandleLambdaEvent(S3ObjectCreatedEvent{Records: make([]S3Record, 3)})
+	require.NoError(t, err)
+	assert.Equal(t, RunResult{Empty: 3}, result)
+
+	mockProcessor.AssertNumberOfCalls(t, "ProcessLogs", 3)
+}
+
 func TestHandleS3URL(t *testing.T) {
 	t.Run("Successful Processing", func(t *testing.T) {
 		// Mock the LogProcessor
@@ -185,7 +199,7 @@ func TestHandleS3URL(t *testing.T) {
 		mockProcessor.On("ProcessLogs", S3ObjectInfo{
 			Bucket: "mock-bucket",
 			Key:    "mock-key",
-		}).Return(nil)
+		}).Return(ObjectResult{}, nil)
 
 		// Mock the S3Api
 		mockS3Api := new(MockS3Api)
@@ -199,7 +213,7 @@ func TestHandleS3URL(t *testing.T) {
 		handler := &Handler{lp: mockProcessor, s3Client: mockS3Api}
 
 		// Call the function under test
-		err := handler.HandleS3URL("s3://mock-bucket/mock-prefix")
+		_, err := handler.HandleS3URL("s3://mock-bucket/mock-prefix")
 		require.NoError(t, err)
 
 		// Assert that the ProcessLogs method was called with the correct parameters
@@ -215,7 +229,7 @@ func TestHandleS3URL(t *testing.T) {
 		mockProcessor.On("ProcessLogs", S3ObjectInfo{
 			Bucket: "mock-bucket",
 			Key:    "mock-key",
-		}).Return(fmt.Errorf("process logs error"))
+		}).Return(ObjectResult{}, fmt.Errorf("process logs error"))
 
 		// Mock the S3Api
 		mockS3Api := new(MockS3Api)
@@ -229,7 +243,7 @@ func TestHandleS3URL(t *testing.T) {
 		handler := &Handler{lp: mockProcessor, s3Client: mockS3Api}
 
 		// Call the function under test
-		err := handler.HandleS3URL("s3://mock-bucket/mock-prefix")
+		_, err := handler.HandleS3URL("s3://mock-bucket/mock-prefix")
 
 		// Assert that an error was returned
 		require.Error(t, err)
@@ -262,18 +276,18 @@ func TestHandleS3URL(t *testing.T) {
 		mockProcessor.On("ProcessLogs", S3ObjectInfo{
 			Bucket: "mock-bucket",
 			Key:    "mock-prefix/object1",
-		}).Return(nil).Run(func(args mock.Arguments) {
+		}).Return(ObjectResult{}, nil).Run(func(args mock.Arguments) {
 			wg.Done()
 		})
 		mockProcessor.On("ProcessLogs", S3ObjectInfo{
 			Bucket: "mock-bucket",
 			Key:    "mock-prefix/object2",
-		}).Return(nil).Run(func(args mock.Arguments) {
+		}).Return(ObjectResult{}, nil).Run(func(args mock.Arguments) {
 			wg.Done()
 		})
 
 		go func() {
-			err := handler.HandleS3URL("s3://mock-bucket/mock-prefix")
+			_, err := handler.HandleS3URL("s3://mock-bucket/mock-prefix")
 			require.NoError(t, err)
 		}()
 
@@ -290,3 +304,206 @@ func TestHandleS3URL(t *testing.T) {
 		})
 	})
 }
+
+func TestHandleBackfillChunk(t *testing.T) {
+	t.Run("More objects remaining", func(t *testing.T) {
+		mockProcessor := new(MockLogProcessor)
+		mockProcessor.On("ProcessLogs", mock.Anything).Return(ObjectResult{}, nil)
+
+		mockS3Api := new(MockS3Api)
+		mockS3Api.On("ListObjectsV2", &s3.ListObjectsV2Input{
+			Bucket:     aws.String("mock-bucket"),
+			Prefix:     aws.String("mock-prefix/"),
+			StartAfter: aws.String("mock-prefix/object0"),
+			MaxKeys:    aws.Int64(2),
+		}).Return(&s3.ListObjectsV2Output{
+			Contents: []*s3.Object{
+				{Key: aws.String("mock-prefix/object1")},
+				{Key: aws.String("mock-prefix/object2")},
+			},
+			IsTruncated: aws.Bool(true),
+		}, nil)
+
+		handler := &Handler{lp: mockProcessor, s3Client: mockS3Api}
+		response, err := handler.HandleLambdaInvocation(context.Background(), LambdaEvent{
+			Prefix:     "s3://mock-bucket/mock-prefix/",
+			StartAfter: "mock-prefix/object0",
+			MaxObjects: 2,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, &LambdaResponse{RunResult: RunResult{Processed: 2}, LastKey: "mock-prefix/object2", Done: false}, response)
+		mockProcessor.AssertNumberOfCalls(t, "ProcessLogs", 2)
+	})
+
+	t.Run("Last chunk", func(t *testing.T) {
+		mockProcessor := new(MockLogProcessor)
+		mockProcessor.On("ProcessLogs", mock.Anything).Return(ObjectResult{}, nil)
+
+		mockS3Api := new(MockS3Api)
+		mockS3Api.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{
+			Contents: []*s3.Object{
+				{Key: aws.String("mock-prefix/object3")},
+			},
+			IsTruncated: aws.Bool(false),
+		}, nil)
+
+		handler := &Handler{lp: mockProcessor, s3Client: mockS3Api}
+		response, err := handler.HandleBackfillChunk("s3://mock-bucket/mock-prefix/", "mock-prefix/object2", 2)
+		require.NoError(t, err)
+		assert.Equal(t, &LambdaResponse{RunResult: RunResult{Processed: 1}, LastKey: "mock-prefix/object3", Done: true}, response)
+	})
+
+	t.Run("Processing error", func(t *testing.T) {
+		mockProcessor := new(MockLogProcessor)
+		mockProcessor.On("ProcessLogs", mock.Anything).Return(ObjectResult{}, fmt.Errorf("process logs error"))
+
+		mockS3Api := new(MockS3Api)
+		mockS3Api.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{
+			Contents: []*s3.Object{
+				{Key: aws.String("mock-prefix/object1")},
+			},
+		}, nil)
+
+		handler := &Handler{lp: mockProcessor, s3Client: mockS3Api}
+		response, err := handler.HandleBackfillChunk("s3://mock-bucket/mock-prefix/", "", 2)
+		require.ErrorContains(t, err, "process logs error")
+		// The response tells which objects failed and where to continue
+		assert.Equal(t, &LambdaResponse{
+			RunResult: RunResult{Failures: []ObjectFailure{{Bucket: "mock-bucket", Key: "mock-prefix/object1", Error: "process logs error"}}},
+			LastKey:   "mock-prefix/object1",
+			Done:      true,
+		}, response)
+	})
+}
+
+func TestWorkLimits(t *testing.T) {
+	t.Run("Remaining objects are re-enqueued", func(t *testing.T) {
+		mockProcessor := new(MockLogProcessor)
+		mockProcessor.On("ProcessLogs", mock.Anything).Return(ObjectResult{Entries: 10}, nil)
+
+		// The remaining objects are spread over invocations that each stay within the limit
+		var requeued []S3ObjectInfo
+		mockLambda := new(MockLambdaApi)
+		mockLambda.On("Invoke", mock.Anything).Return(&lambda.InvokeOutput{}, nil).Run(func(args mock.Arguments) {
+			var payload LambdaEvent
+			input := args.Get(0).(*lambda.InvokeInput)
+			require.NoError(t, json.Unmarshal(input.Payload, &payload))
+			require.Len(t, payload.Objects, 1)
+			requeued = append(requeued, payload.Objects...)
+		})
+
+		handler := &Handler{
+			lp:           mockProcessor,
+			config:       Config{MaxObjectsPerInvocation: 1},
+			lambdaClient: mockLambda,
+			functionName: "my-function",
+		}
+		_, err := handler.HandleLambdaInvocation(context.Background(), LambdaEvent{Objects: []S3ObjectInfo{
+			{Bucket: "my-bucket", Key: "object1"},
+			{Bucket: "my-bucket", Key: "object2"},
+			{Bucket: "my-bucket", Key: "object3"},
+		}})
+		require.NoError(t, err)
+
+		mockProcessor.AssertNumberOfCalls(t, "ProcessLogs", 1)
+		mockProcessor.AssertCalled(t, "ProcessLogs", S3ObjectInfo{Bucket: "my-bucket", Key: "object1"})
+		assert.Equal(t, []S3ObjectInfo{{Bucket: "my-bucket", Key: "object2"}, {Bucket: "my-bucket", Key: "object3"}}, requeued)
+	})
+
+	t.Run("Remaining objects are re-enqueued when an object failed", func(t *testing.T) {
+		mockProcessor := new(MockLogProcessor)
+		mockProcessor.On("ProcessLogs", mock.Anything).Return(ObjectResult{}, fmt.Errorf("throttled"))
+		var requeued []S3ObjectInfo
+		mockLambda := new(MockLambdaApi)
+		mockLambda.On("Invoke", mock.Anything).Return(&lambda.InvokeOutput{}, nil).Run(func(args mock.Arguments) {
+			var payload LambdaEvent
+			require.NoError(t, json.Unmarshal(args.Get(0).(*lambda.InvokeInput).Payload, &payload))
+			requeued = append(requeued, payload.Objects...)
+		})
+		handler := &Handler{
+			lp:           mockProcessor,
+			config:       Config{MaxObjectsPerInvocation: 1},
+			lambdaClient: mockLambda,
+			functionName: "my-function",
+		}
+		result, _, err := handler.runS3Objects([]S3ObjectInfo{
+			{Bucket: "my-bucket", Key: "object1"},
+			{Bucket: "my-bucket", Key: "object2"},
+		})
+		assert.Error(t, err)
+		assert.Len(t, result.Failures, 1)
+		assert.Equal(t, 1, result.Requeued)
+		assert.Equal(t, []S3ObjectInfo{{Bucket: "my-bucket", Key: "object2"}}, requeued)
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		handler := &Handler{
+			config:       Config{MaxObjectsPerInvocation: 10, MaxEntriesPerInvocation: 1000},
+			lambdaClient: new(MockLambdaApi),
+		}
+		assert.False(t, handler.workLimitReached(9, 999))
+		assert.True(t, handler.workLimitReached(10, 0))
+		assert.True(t, handler.workLimitReached(0, 1000))
+
+		// Limits don't apply outside of Lambda
+		handler.lambdaClient = nil
+		assert.False(t, handler.workLimitReached(10, 1000))
+	})
+}
+
+func TestRunResult(t *testing.T) {
+	mockProcessor := new(MockLogProcessor)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "my-bucket", Key: "object1"}).Return(ObjectResult{Entries: 5}, nil)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "my-bucket", Key: "object2"}).Return(ObjectResult{}, ErrEmptyObject)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "my-bucket", Key: "object3"}).Return(ObjectResult{}, fmt.Errorf("access denied"))
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "my-bucket", Key: "object4"}).Return(ObjectResult{Entries: 7}, nil)
+
+	handler := &Handler{lp: mockProcessor}
+	result, err := handler.processS3Objects([]S3ObjectInfo{
+		{Bucket: "my-bucket", Key: "object1"},
+		{Bucket: "my-bucket", Key: "object2"},
+		{Bucket: "my-bucket", Key: "object3"},
+		{Bucket: "my-bucket", Key: "object4"},
+	})
+
+	// All objects are processed, even though one of them fails
+	require.Error(t, err)
+	assert.Equal(t, "error processing logs for s3://my-bucket/object3: access denied", err.Error())
+	assert.Equal(t, RunResult{
+		Processed: 2,
+		Entries:   12,
+		Empty:     1,
+		Failures:  []ObjectFailure{{Bucket: "my-bucket", Key: "object3", Error: "access denied"}},
+	}, result)
+}
+
+func TestRunResultPerLoadBalancer(t *testing.T) {
+	key := func(lb string, n int) string {
+		return fmt.Sprintf("AWSLogs/123456789012/elasticloadbalancing/eu-west-1/2024/03/21/123456789012_elasticloadbalancing_eu-west-1_app.%s.50dc6c495c0c9188_20240321T161%dZ_192.0.2.1_abcdefgh.log.gz", lb, n)
+	}
+	mockProcessor := new(MockLogProcessor)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "logs", Key: key("web", 0)}).Return(ObjectResult{Entries: 90, ServerErrors: 9}, nil)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "logs", Key: key("web", 5)}).Return(ObjectResult{Entries: 10, ServerErrors: 1}, nil)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "logs", Key: key("api", 0)}).Return(ObjectResult{Entries: 50}, nil)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "logs", Key: key("api", 5)}).Return(ObjectResult{}, fmt.Errorf("access denied"))
+
+	handler := &Handler{lp: mockProcessor}
+	result, err := handler.processS3Objects([]S3ObjectInfo{
+		{Bucket: "logs", Key: key("web", 0)},
+		{Bucket: "logs", Key: key("web", 5)},
+		{Bucket: "logs", Key: key("api", 0)},
+		{Bucket: "logs", Key: key("api", 5)},
+	})
+
+	require.Error(t, err)
+	assert.Equal(t, []LoadBalancerResult{
+		{Name: "api", Objects: 2, Failed: 1, Entries: 50},
+		{Name: "web", Objects: 2, Entries: 100, ServerErrors: 10},
+	}, result.LoadBalancers)
+	assert.Equal(t, 0.1, result.LoadBalancers[1].ServerErrorRate())
+
+	// A single load balancer is not broken down
+	result, err = handler.processS3Objects([]S3ObjectInfo{{Bucket: "logs", Key: key("web", 0)}})
+	require.NoError(t, err)
+	assert.Nil(t, result.LoadBalancers)
+}
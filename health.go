@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultStaleAfter is how long a watcher may go without progress before it is reported unhealthy
+const defaultStaleAfter = 15 * time.Minute
+
+// Health tracks the state of a Watcher for liveness and readiness probes, it is served as /healthz. The
+// watcher is unhealthy when listing the prefix fails, or when nothing happened for StaleAfter: no successful
+// listing, or no processed object while objects are waiting. Methods on a nil Health do nothing.
+type Health struct {
+	StaleAfter time.Duration
+	now        func() time.Time
+
+	mu       sync.Mutex
+	started  time.Time
+	listedAt time.Time // Last successful listing
+	listErr  error     // Error of the last listing
+	sentAt   time.Time // Last object of which entries were sent
+	progress time.Time // Last processed object, or when objects started waiting
+	backlog  int       // Objects waiting to be processed, including failed objects that are retried
+	leader   *bool     // Whether the lease is held, nil without a lease
+	leaseAt  time.Time // Last successful check of the lease
+	leaseErr error     // Error of the last check of the lease
+}
+
+// NewHealth returns the health of a watcher that starts now
+func NewHealth(staleAfter time.Duration) *Health {
+	h := &Health{StaleAfter: staleAfter, now: time.Now}
+	h.started = h.now()
+
+	return h
+}
+
+// HealthStatus is the JSON response of /healthz
+type HealthStatus struct {
+	Healthy    bool       `json:"healthy"`
+	Problems   []string   `json:"problems,omitempty"`
+	LastListed *time.Time `json:"lastListed,omitempty"` // Last successful listing of S3
+	ListError  string     `json:"listError,omitempty"`
+	LastSent   *time.Time `json:"lastSent,omitempty"` // Last object of which entries were sent to CloudWatch
+	Backlog    int        `json:"backlog"`            // Objects listed but not processed yet
+	Leader     *bool      `json:"leader,omitempty"`   // Whether this replica holds the lease, if any
+	LeaseError string     `json:"leaseError,omitempty"`
+}
+
+// listed records a listing of the n objects that are waiting to be processed
+func (h *Health) listed(n int, err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listErr = err
+	if err != nil {
+		return
+	}
+	h.listedAt = h.now()
+	if h.backlog == 0 && n > 0 {
+		h.progress = h.listedAt
+	}
+	h.backlog = n
+}
+
+// leaseChecked records whether the lease is held. Replicas that don't hold it don't list S3, they are
+// healthy as long as the lease can be checked.
+func (h *Health) leaseChecked(leader bool, err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leaseErr = err
+	if err != nil {
+		return
+	}
+	h.leaseAt = h.now()
+	h.leader = &leader
+	if !leader {
+		h.backlog = 0
+	}
+}
+
+// objectDone records that an object was processed, failed objects stay in the backlog to be retried
+func (h *Health) objectDone(result ObjectResult, err error) {
+	if h == nil || (err != nil && !errors.Is(err, ErrEmptyObject) && !errors.Is(err, ErrAlreadyProcessed)) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.backlog = max(h.backlog-1, 0)
+	h.progress = h.now()
+	if err == nil && result.Entries > 0 {
+		h.sentAt = h.progress
+	}
+}
+
+// Status returns the current health
+func (h *Health) Status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	status := HealthStatus{Backlog: h.backlog}
+	if !h.listedAt.IsZero() {
+		listedAt := h.listedAt.UTC()
+		status.LastListed = &listedAt
+	}
+	if !h.sentAt.IsZero() {
+		sentAt := h.sentAt.UTC()
+		status.LastSent = &sentAt
+	}
+	status.Leader = h.leader
+	if h.leaseErr != nil {
+		status.LeaseError = h.leaseErr.Error()
+		status.Problems = append(status.Problems, "checking the lease failed")
+	}
+	if h.listErr != nil {
+		status.ListError = h.listErr.Error()
+		status.Problems = append(status.Problems, "listing S3 failed")
+	}
+	listedAt := h.listedAt
+	if h.leader != nil && !*h.leader {
+		listedAt = h.leaseAt
+	}
+	if listedAt.IsZero() {
+		listedAt = h.started
+	}
+	if now.Sub(listedAt) > h.StaleAfter {
+		status.Problems = append(status.Problems, "no successful listing within "+h.StaleAfter.String())
+	}
+	if h.backlog > 0 && now.Sub(h.progress) > h.StaleAfter {
+		status.Problems = append(status.Problems, "no progress on the backlog within "+h.StaleAfter.String())
+	}
+	status.Healthy = len(status.Problems) == 0
+
+	return status
+}
+
+// ServeHTTP responds with the status as JSON, with status code 503 when unhealthy
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := h.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 0, 0, 0, time.UTC)
+	health := NewHealth(15 * time.Minute)
+	health.now = func() time.Time { return now }
+	health.started = now
+
+	get := func() (int, HealthStatus) {
+		recorder := httptest.NewRecorder()
+		health.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		var status HealthStatus
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+		return recorder.Code, status
+	}
+
+	code, status := get()
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Healthy)
+
+	health.listed(0, errors.New("access denied"))
+	code, status = get()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"listing S3 failed"}, status.Problems)
+	assert.Equal(t, "access denied", status.ListError)
+
+	health.listed(2, nil)
+	health.objectDone(ObjectResult{Entries: 10}, nil)
+	code, status = get()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, status.Backlog)
+	assert.Equal(t, now, *status.LastSent)
+
+	// Stuck on the remaining object
+	now = now.Add(20 * time.Minute)
+	code, status = get()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"no successful listing within 15m0s", "no progress on the backlog within 15m0s"}, status.Problems)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// histogramBucket counts the values up to and including its upper bound
+type histogramBucket struct {
+	name  string
+	upper float64
+}
+
+var (
+	sentBytesBuckets = []histogramBucket{{"1kb", 1e3}, {"10kb", 1e4}, {"100kb", 1e5}, {"1mb", 1e6}}
+	latencyBuckets   = []histogramBucket{{"10ms", 0.01}, {"50ms", 0.05}, {"100ms", 0.1}, {"500ms", 0.5}, {"1s", 1}, {"5s", 5}}
+)
+
+// histogram counts values in fixed buckets, values above the last bucket are counted as overflow
+type histogram struct {
+	buckets  []histogramBucket
+	counts   []int
+	overflow int
+}
+
+func newHistogram(buckets []histogramBucket) *histogram {
+	return &histogram{buckets: buckets, counts: make([]int, len(buckets))}
+}
+
+func (h *histogram) add(value float64) {
+	for i, bucket := range h.buckets {
+		if value <= bucket.upper {
+			h.counts[i]++
+			return
+		}
+	}
+	h.overflow++
+}
+
+// summarize adds the non-empty buckets to the summary as <name>_le_<bucket> and <name>_gt_<last bucket>
+func (h *histogram) summarize(name string, summary map[string]int) {
+	for i, bucket := range h.buckets {
+		if h.counts[i] > 0 {
+			summary[name+"_le_"+bucket.name] = h.counts[i]
+		}
+	}
+	if h.overflow > 0 {
+		summary[name+"_gt_"+h.buckets[len(h.buckets)-1].name] = h.overflow
+	}
+}
+
+// latencySketch counts latencies rounded to three significant digits of a millisecond, for percentiles within 0.5%
+// in at most 900 buckets per power of ten rather than a value per request. The millisecond times of access logs are
+// kept exactly up to a second.
+type latencySketch struct {
+	counts map[sketchBucket]int
+	total  int
+}
+
+// sketchBucket is a latency of mantissa * 10^exponent milliseconds
+type sketchBucket struct {
+	mantissa int
+	exponent int
+}
+
+func (s *latencySketch) add(seconds float64) {
+	if s.counts == nil {
+		s.counts = make(map[sketchBucket]int)
+	}
+	var bucket sketchBucket
+	if ms := seconds * 1000; ms > 0 {
+		bucket.exponent = int(math.Floor(math.Log10(ms))) - 2
+		bucket.mantissa = int(math.Round(ms / math.Pow10(bucket.exponent)))
+		// Rounded up to the next power of ten, such as 999.7ms to 1000ms
+		if bucket.mantissa == 1000 {
+			bucket.mantissa, bucket.exponent = 100, bucket.exponent+1
+		}
+	}
+	s.counts[bucket]++
+	s.total++
+}
+
+// milliseconds returns the latency of the bucket, dividing by a power of ten where possible so that 20ms isn't
+// 20.000000000000004ms
+func (b sketchBucket) milliseconds() float64 {
+	if b.exponent < 0 {
+		return float64(b.mantissa) / math.Pow10(-b.exponent)
+	}
+
+	return float64(b.mantissa) * math.Pow10(b.exponent)
+}
+
+// percentile returns the p-th percentile in milliseconds by the nearest rank, and false without latencies
+func (s *latencySketch) percentile(p float64) (float64, bool) {
+	if s.total == 0 {
+		return 0, false
+	}
+	buckets := make([]sketchBucket, 0, len(s.counts))
+	for bucket := range s.counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].milliseconds() < buckets[j].milliseconds() })
+	rank := int(math.Ceil(p / 100 * float64(s.total)))
+	rank = min(max(rank, 1), s.total)
+	for _, bucket := range buckets {
+		if rank -= s.counts[bucket]; rank <= 0 {
+			return bucket.milliseconds(), true
+		}
+	}
+
+	return buckets[len(buckets)-1].milliseconds(), true
+}
+
+// ObjectHistograms counts the sent_bytes and target_processing_time of all records of an object in buckets,
+// which are added to the summary of the object. It is a filter that keeps every record, so it runs before
+// sampling and deduplication and the histograms describe all traffic, also when only a sample is sent.
+type ObjectHistograms struct {
+	sentBytes *histogram
+	latency   *histogram
+}
+
+func (h *ObjectHistograms) Keep(record []string) bool {
+	if h.sentBytes == nil {
+		h.sentBytes = newHistogram(sentBytesBuckets)
+		h.latency = newHistogram(latencyBuckets)
+	}
+	if sentBytes, err := strconv.ParseFloat(recordValue(record, "sent_bytes"), 64); err == nil {
+		h.sentBytes.add(sentBytes)
+	}
+	// Requests that were not dispatched to a target have no latency
+	if latency, ok := parseProcessingTime(recordValue(record, "target_processing_time")); ok {
+		h.latency.add(latency.Seconds())
+	}
+
+	return true
+}
+
+// Transform does nothing, records are counted by Keep before sampling
+func (h *ObjectHistograms) Transform(record []string, entry *LogEntry) {}
+
+func (h *ObjectHistograms) Summarize(summary map[string]int) {
+	if h.sentBytes == nil {
+		return
+	}
+	h.sentBytes.summarize("sent_bytes", summary)
+	h.latency.summarize("latency", summary)
+}
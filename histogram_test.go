@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectHistograms(t *testing.T) {
+	histograms := &ObjectHistograms{}
+	summary := map[string]int{}
+	histograms.Summarize(summary)
+	assert.Empty(t, summary)
+
+	for _, values := range [][2]string{
+		{"512", "0.001"},
+		{"1000", "0.01"},
+		{"20000", "0.2"},
+		{"20000", "-1"},
+		{"5000000", "12.5"},
+	} {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("sent_bytes")] = values[0]
+		record[getFieldIndex("target_processing_time")] = values[1]
+		assert.True(t, histograms.Keep(record))
+	}
+	histograms.Summarize(summary)
+
+	assert.Equal(t, map[string]int{
+		"sent_bytes_le_1kb":   2,
+		"sent_bytes_le_100kb": 2,
+		"sent_bytes_gt_1mb":   1,
+		"latency_le_10ms":     2,
+		"latency_le_500ms":    1,
+		"latency_gt_5s":       1,
+	}, summary)
+}
+
+func TestLatencySketch(t *testing.T) {
+	sketch := &latencySketch{}
+	_, ok := sketch.percentile(50)
+	assert.False(t, ok)
+
+	for _, seconds := range []float64{0, 0.001, 0.024, 0.9997, 1.2345, 12.5} {
+		sketch.add(seconds)
+	}
+	// Three significant digits of a millisecond
+	for p, want := range map[float64]float64{1: 0, 30: 1, 50: 24, 60: 1000, 80: 1230, 100: 12500} {
+		value, ok := sketch.percentile(p)
+		assert.True(t, ok)
+		assert.Equal(t, want, value, p)
+	}
+	// A bucket per value rather than per request
+	for i := 0; i < 1000; i++ {
+		sketch.add(0.024)
+	}
+	assert.Len(t, sketch.counts, 6)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// hostPlaceholder is replaced with the domain_name of the entry in the log group and stream of a HostRoute
+const hostPlaceholder = "{host}"
+
+// HostRoute sends the entries of requests for matching hosts to another log group and/or stream
+type HostRoute struct {
+	Host          string `json:"host"`                // Pattern as in path.Match, e.g. "*.customer-a.com"
+	LogGroupName  string `json:"logGroup,omitempty"`  // Empty keeps the log group
+	LogStreamName string `json:"logStream,omitempty"` // Empty keeps the log stream
+}
+
+// HostRoutes are tried in order, the first route matching the host of an entry is used
+type HostRoutes []HostRoute
+
+// ParseHostRoutes parses routes given as JSON,
+// e.g. [{"host": "*.customer-a.com", "logGroup": "/elb/customer-a"}, {"host": "*", "logStream": "{host}"}]
+func ParseHostRoutes(value string) (HostRoutes, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var routes HostRoutes
+	if err := json.Unmarshal([]byte(value), &routes); err != nil {
+		return nil, fmt.Errorf("invalid host routes: %v", err)
+	}
+	for _, route := range routes {
+		if _, err := path.Match(route.Host, ""); err != nil || route.Host == "" {
+			return nil, fmt.Errorf("invalid host route pattern '%s'", route.Host)
+		}
+		if route.LogGroupName == "" && route.LogStreamName == "" {
+			return nil, fmt.Errorf("invalid host route '%s', expected a logGroup or logStream", route.Host)
+		}
+	}
+
+	return routes, nil
+}
+
+// Lookup returns the first route matching a host, hosts are compared case-insensitively
+func (r HostRoutes) Lookup(host string) (HostRoute, bool) {
+	host = strings.ToLower(host)
+	for _, route := range r {
+		if matched, _ := path.Match(strings.ToLower(route.Host), host); matched {
+			return route, true
+		}
+	}
+
+	return HostRoute{}, false
+}
+
+// HostRouter routes entries by the domain_name of the request, for load balancers serving many domains, e.g. one
+// per customer of a SaaS. Entries without a domain name, such as requests without SNI, are left untouched.
+type HostRouter struct {
+	Routes HostRoutes
+}
+
+func (r *HostRouter) Transform(record []string, entry *LogEntry) {
+	host := recordValue(record, "domain_name")
+	if host == "" || host == "-" {
+		return
+	}
+	route, ok := r.Routes.Lookup(host)
+	if !ok {
+		return
+	}
+	host = strings.ToLower(host)
+	if route.LogGroupName != "" {
+		entry.Destination.LogGroupName = strings.ReplaceAll(route.LogGroupName, hostPlaceholder, host)
+	}
+	if route.LogStreamName != "" {
+		entry.Destination.LogStreamName = strings.ReplaceAll(route.LogStreamName, hostPlaceholder, host)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHostRoutes(t *testing.T) {
+	routes, err := ParseHostRoutes(`[{"host": "*.customer-a.com", "logGroup": "/elb/customer-a"}, {"host": "*", "logStream": "{host}"}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, HostRoutes{
+		{Host: "*.customer-a.com", LogGroupName: "/elb/customer-a"},
+		{Host: "*", LogStreamName: "{host}"},
+	}, routes)
+
+	routes, err = ParseHostRoutes("")
+	assert.NoError(t, err)
+	assert.Nil(t, routes)
+
+	_, err = ParseHostRoutes(`[{"host": "[a", "logGroup": "/elb/a"}]`)
+	assert.EqualError(t, err, "invalid host route pattern '[a'")
+
+	_, err = ParseHostRoutes(`[{"host": "a.com"}]`)
+	assert.EqualError(t, err, "invalid host route 'a.com', expected a logGroup or logStream")
+
+	_, err = ParseHostRoutes(`{}`)
+	assert.Error(t, err)
+}
+
+func TestHostRouter(t *testing.T) {
+	router := &HostRouter{Routes: HostRoutes{
+		{Host: "*.customer-a.com", LogGroupName: "/elb/customer-a"},
+		{Host: "api.example.com", LogGroupName: "/elb/api", LogStreamName: "api"},
+		{Host: "*", LogStreamName: "{host}"},
+	}}
+	transform := func(host string) LogEntry {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("domain_name")] = host
+		entry := LogEntry{Data: map[string]interface{}{}}
+		router.Transform(record, &entry)
+		return entry
+	}
+
+	assert.Equal(t, LogConfig{LogGroupName: "/elb/customer-a"}, transform("shop.Customer-A.com").Destination)
+	assert.Equal(t, LogConfig{LogGroupName: "/elb/api", LogStreamName: "api"}, transform("api.example.com").Destination)
+	assert.Equal(t, LogConfig{LogStreamName: "www.example.org"}, transform("WWW.example.org").Destination)
+	assert.Equal(t, LogConfig{}, transform("-").Destination)
+}
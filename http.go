@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// defaultDialKeepAlive matches the TCP keep-alive period of http.DefaultTransport
+const defaultDialKeepAlive = 30 * time.Second
+
+// newSession creates the AWS session shared by the S3, CloudWatch Logs and Lambda clients
+func newSession(config Config) *session.Session {
+	awsConfig := &aws.Config{HTTPClient: newHTTPClient(config)}
+	if retryer := newRetryer(config); retryer != nil {
+		awsConfig.Retryer = retryer
+	}
+	if config.Endpoint != "" {
+		// Emulators serve all buckets on a single host
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	sess := session.Must(session.NewSession(awsConfig))
+	if config.RetryMode == retryModeAdaptive {
+		limiter := &adaptiveLimiter{}
+		sess.Handlers.Send.PushFront(func(r *request.Request) { limiter.wait() })
+		sess.Handlers.CompleteAttempt.PushBack(func(r *request.Request) { limiter.observe(r.IsErrorThrottle()) })
+	}
+
+	return sess
+}
+
+// Retry modes of RETRY_MODE
+const (
+	retryModeStandard = "standard"
+	retryModeAdaptive = "adaptive"
+)
+
+// ParseRetryMode validates the RETRY_MODE setting, empty is standard
+func ParseRetryMode(value string) (string, error) {
+	switch value {
+	case "":
+		return retryModeStandard, nil
+	case retryModeStandard, retryModeAdaptive:
+		return value, nil
+	}
+
+	return "", fmt.Errorf("invalid retry mode '%s', expected standard or adaptive", value)
+}
+
+// maxAdaptiveDelay limits the time between requests in the adaptive retry mode
+const maxAdaptiveDelay = 5 * time.Second
+
+// adaptiveLimiter spaces the requests of all clients of a session after throttling errors, as the adaptive retry
+// mode of the newer AWS SDKs does, which the SDK for Go v1 doesn't have. A throttled attempt doubles the time
+// between requests, from 50ms up to maxAdaptiveDelay, and an attempt that isn't throttled halves it until requests
+// are no longer spaced.
+type adaptiveLimiter struct {
+	mu    sync.Mutex
+	delay time.Duration // Time between the starts of requests, 0 when they aren't spaced
+	next  time.Time     // Earliest start of the next request
+	sleep func(time.Duration)
+}
+
+// wait blocks until the request may start
+func (l *adaptiveLimiter) wait() {
+	l.mu.Lock()
+	if l.delay == 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.delay)
+	sleep := l.sleep
+	l.mu.Unlock()
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	sleep(start.Sub(now))
+}
+
+// observe adjusts the time between requests to the outcome of an attempt
+func (l *adaptiveLimiter) observe(throttled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case throttled:
+		l.delay = min(max(2*l.delay, 50*time.Millisecond), maxAdaptiveDelay)
+	case l.delay < 50*time.Millisecond:
+		l.delay = 0
+	default:
+		l.delay /= 2
+	}
+}
+
+// newRetryer returns the SDK default retryer with the configured attempts and throttle delay, or nil to keep
+// the defaults of each client. Throttling includes S3 503 SlowDown responses and CloudWatch Logs throttling.
+func newRetryer(config Config) *client.DefaultRetryer {
+	if config.RetryMaxAttempts == 0 && config.RetryMinThrottleDelay == 0 {
+		return nil
+	}
+	retryer := &client.DefaultRetryer{
+		NumMaxRetries:    client.DefaultRetryerMaxNumRetries,
+		MinThrottleDelay: config.RetryMinThrottleDelay,
+	}
+	if config.RetryMaxAttempts > 0 {
+		retryer.NumMaxRetries = config.RetryMaxAttempts - 1
+	}
+
+	return retryer
+}
+
+// newHTTPClient returns an HTTP client based on http.DefaultTransport with the configured tuning applied.
+// The proxy is taken from HTTPS_PROXY, HTTP_PROXY and NO_PROXY. No overall client timeout is set, as
+// downloading large objects can legitimately take long; HTTPReadTimeout limits the wait for response headers.
+func newHTTPClient(config Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if config.HTTPConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: config.HTTPConnectTimeout, KeepAlive: defaultDialKeepAlive}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = config.HTTPConnectTimeout
+	}
+	if config.HTTPReadTimeout > 0 {
+		transport.ResponseHeaderTimeout = config.HTTPReadTimeout
+	}
+	if config.HTTPMaxIdleConns > 0 {
+		// All requests go to a few AWS endpoints, so allow all idle connections to be kept per host
+		transport.MaxIdleConns = config.HTTPMaxIdleConns
+		transport.MaxIdleConnsPerHost = config.HTTPMaxIdleConns
+	}
+	if config.HTTPIdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.HTTPIdleConnTimeout
+	}
+
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		transport := newHTTPClient(Config{}).Transport.(*http.Transport)
+		defaultTransport := http.DefaultTransport.(*http.Transport)
+
+		assert.NotNil(t, transport.Proxy)
+		assert.Equal(t, defaultTransport.MaxIdleConns, transport.MaxIdleConns)
+		assert.Equal(t, defaultTransport.IdleConnTimeout, transport.IdleConnTimeout)
+		assert.Equal(t, time.Duration(0), transport.ResponseHeaderTimeout)
+	})
+
+	t.Run("Tuned", func(t *testing.T) {
+		client := newHTTPClient(Config{
+			HTTPConnectTimeout:  2 * time.Second,
+			HTTPReadTimeout:     10 * time.Second,
+			HTTPMaxIdleConns:    50,
+			HTTPIdleConnTimeout: 5 * time.Minute,
+		})
+		transport := client.Transport.(*http.Transport)
+
+		assert.Equal(t, time.Duration(0), client.Timeout)
+		assert.Equal(t, 2*time.Second, transport.TLSHandshakeTimeout)
+		assert.Equal(t, 10*time.Second, transport.ResponseHeaderTimeout)
+		assert.Equal(t, 50, transport.MaxIdleConns)
+		assert.Equal(t, 50, transport.MaxIdleConnsPerHost)
+		assert.Equal(t, 5*time.Minute, transport.IdleConnTimeout)
+	})
+}
+
+func TestNewRetryer(t *testing.T) {
+	assert.Nil(t, newRetryer(Config{}))
+
+	retryer := newRetryer(Config{RetryMaxAttempts: 10})
+	assert.Equal(t, 9, retryer.MaxRetries())
+	assert.Equal(t, time.Duration(0), retryer.MinThrottleDelay)
+
+	retryer = newRetryer(Config{RetryMinThrottleDelay: 2 * time.Second})
+	assert.Equal(t, client.DefaultRetryerMaxNumRetries, retryer.MaxRetries())
+	assert.Equal(t, 2*time.Second, retryer.MinThrottleDelay)
+}
+
+func TestNewSessionEndpoint(t *testing.T) {
+	sess := newSession(Config{Endpoint: "http://localhost:4566"})
+	assert.Equal(t, "http://localhost:4566", aws.StringValue(sess.Config.Endpoint))
+	assert.True(t, aws.BoolValue(sess.Config.S3ForcePathStyle))
+
+	sess = newSession(Config{})
+	assert.Nil(t, sess.Config.Endpoint)
+}
+
+func TestParseRetryMode(t *testing.T) {
+	mode, err := ParseRetryMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, retryModeStandard, mode)
+	mode, err = ParseRetryMode("adaptive")
+	assert.NoError(t, err)
+	assert.Equal(t, retryModeAdaptive, mode)
+	_, err = ParseRetryMode("legacy")
+	assert.EqualError(t, err, "invalid retry mode 'legacy', expected standard or adaptive")
+
+	standard := newSession(Config{RetryMode: retryModeStandard})
+	adaptive := newSession(Config{RetryMode: retryModeAdaptive})
+	assert.Equal(t, standard.Handlers.Send.Len()+1, adaptive.Handlers.Send.Len())
+	assert.Equal(t, standard.Handlers.CompleteAttempt.Len()+1, adaptive.Handlers.CompleteAttempt.Len())
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	var slept []time.Duration
+	limiter := &adaptiveLimiter{sleep: func(d time.Duration) { slept = append(slept, d) }}
+
+	// Requests aren't spaced until they are throttled
+	limiter.wait()
+	assert.Empty(t, slept)
+
+	limiter.observe(true)
+	limiter.observe(true)
+	assert.Equal(t, 100*time.Millisecond, limiter.delay)
+	limiter.wait()
+	limiter.wait()
+	require.Len(t, slept, 2)
+	assert.Equal(t, time.Duration(0), slept[0])
+	assert.InDelta(t, float64(100*time.Millisecond), float64(slept[1]), float64(10*time.Millisecond))
+
+	for i := 0; i < 10; i++ {
+		limiter.observe(true)
+	}
+	assert.Equal(t, maxAdaptiveDelay, limiter.delay)
+
+	// Requests that succeed stop the spacing
+	for i := 0; i < 8; i++ {
+		limiter.observe(false)
+	}
+	assert.Equal(t, time.Duration(0), limiter.delay)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ANSI escape codes of the human format
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+const (
+	// humanDefaultWidth is the width of the columns of the human format without a width in humanWidths
+	humanDefaultWidth = 20
+	// humanMaxWidth is the width at which the values of the last column are truncated, as it isn't padded
+	humanMaxWidth = 80
+)
+
+// humanWidths are the widths of the columns of the human format that are narrower or wider than the default, long
+// enough for most values. Longer values are truncated, and a column is at least as wide as its name.
+var humanWidths = map[string]int{
+	"time":                     27,
+	"request_processing_time":  8,
+	"target_processing_time":   8,
+	"response_processing_time": 8,
+	"elb_status_code":          3,
+	"target_status_code":       3,
+	"received_bytes":           8,
+	"sent_bytes":               8,
+	"client:port":              21,
+	"target:port":              21,
+	"request":                  60,
+	"user_agent":               30,
+}
+
+// humanWriter writes entries as aligned columns for reading in a terminal, after a header row with the columns,
+// see rowColumns. Values that don't fit their column are truncated with an ellipsis. With color, status codes are
+// colored by class and processing times above the slow threshold are highlighted.
+type humanWriter struct {
+	rowColumns
+	w      io.Writer
+	color  bool
+	slow   time.Duration // Processing times above this are highlighted
+	widths []int
+}
+
+func newHumanWriter(w io.Writer, columns []string, color bool, slow time.Duration) *humanWriter {
+	return &humanWriter{rowColumns: rowColumns{columns: columns}, w: w, color: color, slow: slow}
+}
+
+// write writes an entry as a row and returns the bytes written, preceded by the header for the first entry
+func (h *humanWriter) write(entry LogEntry) (int, error) {
+	var row strings.Builder
+	if h.isColumn == nil {
+		h.choose(entry)
+		for _, column := range h.columns {
+			width := humanDefaultWidth
+			if w, ok := humanWidths[column]; ok {
+				width = w
+			}
+			h.widths = append(h.widths, max(width, utf8.RuneCountInString(column)))
+		}
+		h.writeRow(&row, h.columns, func(int, string) string { return ansiBold })
+	}
+	h.track(entry)
+	values := make([]string, len(h.columns))
+	for i, column := range h.columns {
+		value, err := tableValue(entry.Data[column])
+		if err != nil {
+			return 0, err
+		}
+		values[i] = value
+	}
+	h.writeRow(&row, values, func(i int, value string) string { return h.valueColor(h.columns[i], value) })
+
+	return io.WriteString(h.w, row.String())
+}
+
+// writeRow writes the cells of a row padded to the width of their column, the last cell is not padded. Cells are
+// wrapped in the escape code returned by color, if any, when writing in color.
+func (h *humanWriter) writeRow(row *strings.Builder, cells []string, color func(i int, value string) string) {
+	for i, cell := range cells {
+		width := h.widths[i]
+		if i == len(cells)-1 {
+			width = max(width, humanMaxWidth)
+		}
+		cell = truncateCell(cell, width)
+		if i > 0 {
+			row.WriteString("  ")
+		}
+		code := ""
+		if h.color {
+			code = color(i, cell)
+		}
+		if code != "" {
+			row.WriteString(code + cell + ansiReset)
+		} else {
+			row.WriteString(cell)
+		}
+		if i < len(cells)-1 {
+			row.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(cell)))
+		}
+	}
+	row.WriteByte('\n')
+}
+
+// valueColor returns the escape code of the value of a column: status codes by class, green for success, cyan for
+// redirects, yellow for client errors and red for server errors, and processing times above the slow threshold in
+// bold red
+func (h *humanWriter) valueColor(column, value string) string {
+	switch column {
+	case "elb_status_code", "target_status_code":
+		switch {
+		case strings.HasPrefix(value, "2"):
+			return ansiGreen
+		case strings.HasPrefix(value, "3"):
+			return ansiCyan
+		case strings.HasPrefix(value, "4"):
+			return ansiYellow
+		case strings.HasPrefix(value, "5"):
+			return ansiRed
+		}
+	case "request_processing_time", "target_processing_time", "response_processing_time":
+		if latency, ok := parseProcessingTime(value); ok && h.slow > 0 && latency > h.slow {
+			return ansiBold + ansiRed
+		}
+	}
+
+	return ""
+}
+
+// close does nothing, as the rows are written as they come
+func (h *humanWriter) close() error {
+	return nil
+}
+
+// truncateCell shortens a value to width characters, ending with an ellipsis when cut, and replaces line breaks
+// and tabs with spaces so rows stay on a line
+func truncateCell(value string, width int) string {
+	value = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", "\t", " ").Replace(value)
+	if utf8.RuneCountInString(value) <= width {
+		return value
+	}
+	runes := []rune(value)
+
+	return string(runes[:width-1]) + "…"
+}
+
+// colorTerminal reports whether w is a terminal that shows colors, which can be turned off with NO_COLOR as
+// described on https://no-color.org
+func colorTerminal(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHumanWriter(t *testing.T) {
+	entries := []LogEntry{
+		{Data: map[string]interface{}{
+			"time":                   "2024-03-21T16:10:26.071854Z",
+			"elb_status_code":        "200",
+			"target_processing_time": "0.024",
+			"request":                "GET https://example.com:443/ HTTP/1.1",
+		}},
+		{Data: map[string]interface{}{
+			"time":                   "2024-03-21T16:10:27.071854Z",
+			"elb_status_code":        "503",
+			"target_processing_time": "2.5",
+			"request":                "GET https://example.com:443/" + strings.Repeat("a", 100) + " HTTP/1.1",
+			"extra":                  "x",
+		}},
+	}
+
+	t.Run("Plain", func(t *testing.T) {
+		var out bytes.Buffer
+		rows := newHumanWriter(&out, nil, false, time.Second)
+		for _, entry := range entries {
+			_, err := rows.write(entry)
+			require.NoError(t, err)
+		}
+		require.NoError(t, rows.close())
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		require.Len(t, lines, 3)
+		// Columns are aligned, and the header determines the width of narrow columns
+		assert.Equal(t, "time                         target_processing_time  elb_status_code  request", lines[0])
+		assert.Equal(t, "2024-03-21T16:10:26.071854Z  0.024                   200              GET https://example.com:443/ HTTP/1.1", lines[1])
+		assert.Equal(t, 80, len([]rune(lines[2]))-len("2024-03-21T16:10:27.071854Z  2.5                     503              "))
+		assert.True(t, strings.HasSuffix(lines[2], "aaa…"))
+		assert.Equal(t, []string{"extra"}, rows.omitted())
+	})
+
+	t.Run("Color", func(t *testing.T) {
+		var out bytes.Buffer
+		rows := newHumanWriter(&out, []string{"elb_status_code", "target_processing_time"}, true, time.Second)
+		for _, entry := range entries {
+			_, err := rows.write(entry)
+			require.NoError(t, err)
+		}
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		require.Len(t, lines, 3)
+		assert.Equal(t, ansiBold+"elb_status_code"+ansiReset+"  "+ansiBold+"target_processing_time"+ansiReset, lines[0])
+		assert.Equal(t, ansiGreen+"200"+ansiReset+"              0.024", lines[1])
+		assert.Equal(t, ansiRed+"503"+ansiReset+"              "+ansiBold+ansiRed+"2.5"+ansiReset, lines[2])
+	})
+}
+
+func TestTruncateCell(t *testing.T) {
+	assert.Equal(t, "short", truncateCell("short", 5))
+	assert.Equal(t, "shor…", truncateCell("shorter", 5))
+	assert.Equal(t, "a b", truncateCell("a\nb", 5))
+	assert.Equal(t, "héll…", truncateCell("héllo wörld", 5))
+}
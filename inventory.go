@@ -0,0 +1,126 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// InventoryManifest is the manifest.json of an S3 Inventory report, listing the files of the report
+type InventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"` // ARN of the bucket the report is written to
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"` // Comma separated columns of the files, e.g. "Bucket, Key, Size"
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// listInventoryObjects reads the objects from the S3 Inventory report of the manifest at the given S3 URL,
+// which avoids listing buckets with many objects. Only objects under the prefix of filterURL are returned
+// if it is set. Only CSV reports are supported, the files are read row by row as they are downloaded.
+func (h *Handler) listInventoryObjects(manifestURL, filterURL string) ([]S3ObjectInfo, error) {
+	bucket, key, err := ParseS3URL(manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inventory manifest URL: %v", err)
+	}
+	var filterBucket, filterPrefix string
+	if filterURL != "" {
+		if filterBucket, filterPrefix, err = ParseS3URL(filterURL); err != nil {
+			return nil, fmt.Errorf("failed to parse S3 URL: %v", err)
+		}
+	}
+	obj, err := h.s3Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory manifest: %v", err)
+	}
+	defer obj.Body.Close()
+	var manifest InventoryManifest
+	if err := json.NewDecoder(obj.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode inventory manifest: %v", err)
+	}
+	if manifest.FileFormat != "CSV" {
+		return nil, fmt.Errorf("inventory report %s is in %s format, which is not supported: configure the inventory with the CSV output format", manifestURL, manifest.FileFormat)
+	}
+	columns := make(map[string]int)
+	for i, column := range strings.Split(manifest.FileSchema, ",") {
+		columns[strings.TrimSpace(column)] = i
+	}
+	bucketColumn, hasBucket := columns["Bucket"]
+	keyColumn, hasKey := columns["Key"]
+	if !hasBucket || !hasKey {
+		return nil, fmt.Errorf("inventory schema '%s' lacks the Bucket or Key column", manifest.FileSchema)
+	}
+	sizeColumn, hasSize := columns["Size"]
+	destinationBucket := strings.TrimPrefix(manifest.DestinationBucket, "arn:aws:s3:::")
+
+	var s3Objects []S3ObjectInfo
+	for _, file := range manifest.Files {
+		err := h.readInventoryFile(destinationBucket, file.Key, func(row []string) error {
+			if len(row) != len(columns) {
+				return fmt.Errorf("invalid inventory row in %s: expected %d columns, got %d", file.Key, len(columns), len(row))
+			}
+			// Keys are URL encoded in inventory reports
+			objectKey, err := url.QueryUnescape(row[keyColumn])
+			if err != nil {
+				return fmt.Errorf("invalid key '%s' in inventory file %s: %v", row[keyColumn], file.Key, err)
+			}
+			s3Object := S3ObjectInfo{Bucket: row[bucketColumn], Key: objectKey}
+			if filterURL != "" && (s3Object.Bucket != filterBucket || !strings.HasPrefix(s3Object.Key, filterPrefix)) {
+				return nil
+			}
+			if hasSize {
+				if size, err := strconv.ParseInt(row[sizeColumn], 10, 64); err == nil {
+					s3Object.Size = aws.Int64(size)
+				}
+			}
+			s3Objects = append(s3Objects, s3Object)
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s3Objects, nil
+}
+
+// readInventoryFile reads a gzip compressed CSV file of an inventory report and passes every row to handle while
+// downloading it, so a file is never held in memory as a whole. The row is reused for the next row.
+func (h *Handler) readInventoryFile(bucket, key string, handle func(row []string) error) error {
+	obj, err := h.s3Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("failed to get inventory file %s: %v", key, err)
+	}
+	defer obj.Body.Close()
+	gzipReader, err := gzip.NewReader(obj.Body)
+	if err != nil {
+		return fmt.Errorf("failed to decompress inventory file %s: %v", key, err)
+	}
+	defer gzipReader.Close()
+	reader := csv.NewReader(gzipReader)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read inventory file %s: %v", key, err)
+		}
+		if err := handle(row); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListInventoryObjects(t *testing.T) {
+	gzipped := func(data string) io.ReadCloser {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		return io.NopCloser(&buf)
+	}
+	newHandler := func(manifest string) *Handler {
+		mockS3Api := new(MockS3Api)
+		mockS3Api.On("GetObject", &s3.GetObjectInput{
+			Bucket: aws.String("inventory-bucket"),
+			Key:    aws.String("logs/inventory/manifest.json"),
+		}).Return(&s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(manifest))}, nil)
+		mockS3Api.On("GetObject", &s3.GetObjectInput{
+			Bucket: aws.String("inventory-bucket"),
+			Key:    aws.String("logs/inventory/data/1.csv.gz"),
+		}).Return(&s3.GetObjectOutput{Body: gzipped(
+			"\"log-bucket\",\"AWSLogs/1/elasticloadbalancing/a%20b.log.gz\",\"123\"\n" +
+				"\"log-bucket\",\"other/object\",\"5\"\n",
+		)}, nil)
+		return &Handler{s3Client: mockS3Api}
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		h := newHandler(`{"sourceBucket": "log-bucket", "destinationBucket": "arn:aws:s3:::inventory-bucket", "fileFormat": "CSV", "fileSchema": "Bucket, Key, Size", "files": [{"key": "logs/inventory/data/1.csv.gz"}]}`)
+
+		s3Objects, err := h.listInventoryObjects("s3://inventory-bucket/logs/inventory/manifest.json", "s3://log-bucket/AWSLogs/")
+		require.NoError(t, err)
+		assert.Equal(t, []S3ObjectInfo{{Bucket: "log-bucket", Key: "AWSLogs/1/elasticloadbalancing/a b.log.gz", Size: aws.Int64(123)}}, s3Objects)
+	})
+
+	t.Run("Parquet", func(t *testing.T) {
+		h := newHandler(`{"fileFormat": "Parquet", "fileSchema": "message s3.inventory { required binary bucket; }"}`)
+
+		_, err := h.listInventoryObjects("s3://inventory-bucket/logs/inventory/manifest.json", "")
+		require.EqualError(t, err, "inventory report s3://inventory-bucket/logs/inventory/manifest.json is in Parquet format, which is not supported: configure the inventory with the CSV output format")
+		h.s3Client.(*MockS3Api).AssertNotCalled(t, "GetObject", mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+			return *input.Key == "logs/inventory/data/1.csv.gz"
+		}))
+	})
+}
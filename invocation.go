@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// invocationInfo holds the metadata of the Lambda invocation, it is empty outside of Lambda
+type invocationInfo struct {
+	RequestID string
+	Deadline  time.Time
+}
+
+func newInvocationInfo(ctx context.Context) invocationInfo {
+	var info invocationInfo
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		info.RequestID = lc.AwsRequestID
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		info.Deadline = deadline
+	}
+
+	return info
+}
+
+// remaining returns the time left before the invocation times out, or 0 if there is no deadline
+func (info invocationInfo) remaining() time.Duration {
+	if info.Deadline.IsZero() {
+		return 0
+	}
+
+	return time.Until(info.Deadline)
+}
+
+// stampObjects returns the objects with the request ID of the invocation set
+func (info invocationInfo) stampObjects(s3Objects []S3ObjectInfo) []S3ObjectInfo {
+	if info.RequestID == "" {
+		return s3Objects
+	}
+	stamped := make([]S3ObjectInfo, len(s3Objects))
+	for i, s3Object := range s3Objects {
+		s3Object.RequestID = info.RequestID
+		stamped[i] = s3Object
+	}
+
+	return stamped
+}
+
+// RequestIDField adds the ID of the Lambda invocation that shipped an entry as lambda_request_id
+type RequestIDField struct {
+	RequestID string
+}
+
+func (f *RequestIDField) Transform(record []string, entry *LogEntry) {
+	entry.Data["lambda_request_id"] = f.RequestID
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvocationInfo(t *testing.T) {
+	t.Run("Lambda context", func(t *testing.T) {
+		ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-id"})
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		info := newInvocationInfo(ctx)
+		assert.Equal(t, "request-id", info.RequestID)
+		assert.InDelta(t, time.Minute, info.remaining(), float64(time.Second))
+
+		stamped := info.stampObjects([]S3ObjectInfo{{Bucket: "bucket", Key: "key"}})
+		assert.Equal(t, []S3ObjectInfo{{Bucket: "bucket", Key: "key", RequestID: "request-id"}}, stamped)
+	})
+
+	t.Run("Outside of Lambda", func(t *testing.T) {
+		info := newInvocationInfo(context.Background())
+		assert.Equal(t, invocationInfo{}, info)
+		assert.Equal(t, time.Duration(0), info.remaining())
+	})
+}
+
+func TestHandleLambdaInvocationRequestID(t *testing.T) {
+	mockProcessor := new(MockLogProcessor)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "my-bucket", Key: "my-key", RequestID: "request-id"}).Return(ObjectResult{Entries: 1}, nil)
+
+	handler := &Handler{lp: mockProcessor}
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-id"})
+	response, err := handler.HandleLambdaInvocation(ctx, LambdaEvent{Objects: []S3ObjectInfo{{Bucket: "my-bucket", Key: "my-key"}}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "request-id", response.RequestID)
+	assert.Equal(t, 1, response.Entries)
+	mockProcessor.AssertExpectations(t)
+	mockProcessor.AssertNotCalled(t, "ProcessLogs", mock.MatchedBy(func(s3Object S3ObjectInfo) bool { return s3Object.RequestID == "" }))
+}
+
+func TestHandleLambdaInvocationFailure(t *testing.T) {
+	mockProcessor := new(MockLogProcessor)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "my-bucket", Key: "ok"}).Return(ObjectResult{Entries: 1}, nil)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "my-bucket", Key: "failed"}).Return(ObjectResult{}, fmt.Errorf("throttled"))
+
+	// The response of a failed invocation is returned with the error
+	handler := &Handler{lp: mockProcessor}
+	response, err := handler.HandleLambdaInvocation(context.Background(), LambdaEvent{Objects: []S3ObjectInfo{
+		{Bucket: "my-bucket", Key: "ok"},
+		{Bucket: "my-bucket", Key: "failed"},
+	}})
+	require.Error(t, err)
+	require.NotNil(t, response)
+	assert.Equal(t, 1, response.Processed)
+	assert.Equal(t, []ObjectFailure{{Bucket: "my-bucket", Key: "failed", Error: "throttled"}}, response.Failures)
+}
+
+func TestRequestIDField(t *testing.T) {
+	entry := LogEntry{Data: map[string]interface{}{}}
+	transformer := &RequestIDField{RequestID: "request-id"}
+	transformer.Transform(nil, &entry)
+
+	assert.Equal(t, "request-id", entry.Data["lambda_request_id"])
+}
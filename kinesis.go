@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+type KinesisApi interface {
+	ListShards(input *kinesis.ListShardsInput) (*kinesis.ListShardsOutput, error)
+	GetShardIterator(input *kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error)
+	GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error)
+	DescribeStreamSummary(input *kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error)
+	RegisterStreamConsumer(input *kinesis.RegisterStreamConsumerInput) (*kinesis.RegisterStreamConsumerOutput, error)
+	DescribeStreamConsumer(input *kinesis.DescribeStreamConsumerInput) (*kinesis.DescribeStreamConsumerOutput, error)
+	SubscribeToShard(input *kinesis.SubscribeToShardInput) (*kinesis.SubscribeToShardOutput, error)
+}
+
+// DataProcessor processes log data that is received rather than read from a source, see
+// CloudWatchLogProcessor.ProcessData
+type DataProcessor interface {
+	ProcessData(name string, data []byte) (ObjectResult, error)
+}
+
+// Timing of the Kinesis consumer
+const (
+	kinesisShardRefresh = time.Minute            // Interval to discover new shards after resharding
+	kinesisIdleDelay    = time.Second            // Delay between GetRecords calls when the shard is caught up
+	kinesisBusyDelay    = 200 * time.Millisecond // Delay between GetRecords calls, GetRecords is limited to 5 calls per second per shard
+	kinesisMaxBackoff   = time.Minute            // Maximum delay between retries of a failed batch
+)
+
+// KinesisConsumer reads ELB access log records from a Kinesis stream and processes every batch of records like
+// a log file. Shards are read concurrently, either by polling with GetRecords on the shared throughput of the
+// stream, or with enhanced fan-out when Consumer is set. Failed calls and batches are retried until they succeed,
+// so records are processed at least once. The position in the stream is not kept between runs.
+type KinesisConsumer struct {
+	Client    KinesisApi
+	Stream    string // Name or ARN of the stream
+	Processor DataProcessor
+	// Start is the position in the shards that exist at start, kinesis.ShardIteratorTypeLatest or
+	// kinesis.ShardIteratorTypeTrimHorizon. Shards created later by resharding are read from the start.
+	Start string
+	// Consumer is the name of the enhanced fan-out consumer to register and subscribe with, empty to poll
+	Consumer string
+
+	consumerARN string
+	shards      map[string]bool // Shards that are or were read
+	wg          sync.WaitGroup
+}
+
+// Run reads the shards of the stream until stop is closed
+func (c *KinesisConsumer) Run(stop <-chan struct{}) error {
+	if c.Consumer != "" {
+		if err := c.registerConsumer(stop); err != nil {
+			return err
+		}
+	}
+	start := c.Start
+	ticker := time.NewTicker(kinesisShardRefresh)
+	defer ticker.Stop()
+	for {
+		if err := c.startShards(start, stop); err != nil {
+			log.Println(err)
+		} else {
+			start = kinesis.ShardIteratorTypeTrimHorizon
+		}
+		select {
+		case <-stop:
+			c.wg.Wait()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// startShards starts reading the shards that are not read yet
+func (c *KinesisConsumer) startShards(start string, stop <-chan struct{}) error {
+	shards, err := c.listShards()
+	if err != nil {
+		return err
+	}
+	if c.shards == nil {
+		c.shards = make(map[string]bool)
+	}
+	for _, shardID := range shards {
+		if c.shards[shardID] {
+			continue
+		}
+		c.shards[shardID] = true
+		c.wg.Add(1)
+		go func(shardID string) {
+			defer c.wg.Done()
+			if c.consumerARN != "" {
+				c.subscribeShard(shardID, start, stop)
+			} else {
+				c.pollShard(shardID, start, stop)
+			}
+		}(shardID)
+	}
+
+	return nil
+}
+
+func (c *KinesisConsumer) listShards() ([]string, error) {
+	var shards []string
+	input := &kinesis.ListShardsInput{}
+	c.setStream(&input.StreamName, &input.StreamARN)
+	for {
+		resp, err := c.Client.ListShards(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list shards of %s: %v", c.Stream, err)
+		}
+		for _, shard := range resp.Shards {
+			shards = append(shards, aws.StringValue(shard.ShardId))
+		}
+		if resp.NextToken == nil {
+			return shards, nil
+		}
+		// The stream must not be set together with a next token
+		input = &kinesis.ListShardsInput{NextToken: resp.NextToken}
+	}
+}
+
+// setStream sets either the name or the ARN of the stream in an input
+func (c *KinesisConsumer) setStream(name, arn **string) {
+	if strings.HasPrefix(c.Stream, "arn:") {
+		*arn = aws.String(c.Stream)
+	} else {
+		*name = aws.String(c.Stream)
+	}
+}
+
+// pollShard reads a shard with GetRecords until it is closed by resharding or stop is closed. Errors are
+// retried from the last record that was processed.
+func (c *KinesisConsumer) pollShard(shardID, start string, stop <-chan struct{}) {
+	iteratorInput := &kinesis.GetShardIteratorInput{ShardId: aws.String(shardID), ShardIteratorType: aws.String(start)}
+	c.setStream(&iteratorInput.StreamName, &iteratorInput.StreamARN)
+	var iterator *string
+	backoff := newKinesisBackoff()
+	for {
+		if iterator == nil {
+			requested := time.Now()
+			resp, err := c.Client.GetShardIterator(iteratorInput)
+			if err != nil {
+				log.Printf("failed to get iterator of shard %s: %v", shardID, err)
+				if !backoff.wait(stop) {
+					return
+				}
+				continue
+			}
+			iterator = resp.ShardIterator
+			if aws.StringValue(iteratorInput.ShardIteratorType) == kinesis.ShardIteratorTypeLatest {
+				// A new iterator starts where this one did, not at the records that arrived in the meantime
+				iteratorInput.ShardIteratorType = aws.String(kinesis.ShardIteratorTypeAtTimestamp)
+				iteratorInput.Timestamp = aws.Time(requested)
+			}
+		}
+		records, err := c.Client.GetRecords(&kinesis.GetRecordsInput{ShardIterator: iterator})
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == kinesis.ErrCodeExpiredIteratorException {
+			// Iterators expire after 5 minutes, e.g. when retrying a batch took long. The new iterator starts after
+			// the last record read, or where the first iterator started.
+			iterator = nil
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to get records of shard %s: %v", shardID, err)
+			if !backoff.wait(stop) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+		if len(records.Records) > 0 {
+			if !c.processBatch(shardID, records.Records, stop) {
+				return
+			}
+			iteratorInput.ShardIteratorType = aws.String(kinesis.ShardIteratorTypeAfterSequenceNumber)
+			iteratorInput.StartingSequenceNumber = records.Records[len(records.Records)-1].SequenceNumber
+			iteratorInput.Timestamp = nil
+		}
+		if records.NextShardIterator == nil {
+			log.Printf("shard %s is closed", shardID)
+			return
+		}
+		iterator = records.NextShardIterator
+		delay := kinesisBusyDelay
+		if aws.Int64Value(records.MillisBehindLatest) == 0 {
+			delay = kinesisIdleDelay
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// registerConsumer registers the enhanced fan-out consumer, or uses the existing one, and waits until it is active
+func (c *KinesisConsumer) registerConsumer(stop <-chan struct{}) error {
+	streamARN := c.Stream
+	if !strings.HasPrefix(streamARN, "arn:") {
+		summary, err := c.Client.DescribeStreamSummary(&kinesis.DescribeStreamSummaryInput{StreamName: aws.String(c.Stream)})
+		if err != nil {
+			return fmt.Errorf("failed to describe stream %s: %v", c.Stream, err)
+		}
+		streamARN = aws.StringValue(summary.StreamDescriptionSummary.StreamARN)
+	}
+	registered, err := c.Client.RegisterStreamConsumer(&kinesis.RegisterStreamConsumerInput{
+		ConsumerName: aws.String(c.Consumer),
+		StreamARN:    aws.String(streamARN),
+	})
+	var awsErr awserr.Error
+	switch {
+	case err == nil:
+		c.consumerARN = aws.StringValue(registered.Consumer.ConsumerARN)
+		log.Printf("registered consumer %s", c.consumerARN)
+	case errors.As(err, &awsErr) && awsErr.Code() == kinesis.ErrCodeResourceInUseException:
+		// Registered before, e.g. by a previous run
+	default:
+		return fmt.Errorf("failed to register consumer %s: %v", c.Consumer, err)
+	}
+	for {
+		input := &kinesis.DescribeStreamConsumerInput{ConsumerName: aws.String(c.Consumer), StreamARN: aws.String(streamARN)}
+		if c.consumerARN != "" {
+			input = &kinesis.DescribeStreamConsumerInput{ConsumerARN: aws.String(c.consumerARN)}
+		}
+		resp, err := c.Client.DescribeStreamConsumer(input)
+		if err != nil {
+			return fmt.Errorf("failed to describe consumer %s: %v", c.Consumer, err)
+		}
+		c.consumerARN = aws.StringValue(resp.ConsumerDescription.ConsumerARN)
+		if aws.StringValue(resp.ConsumerDescription.ConsumerStatus) == kinesis.ConsumerStatusActive {
+			return nil
+		}
+		select {
+		case <-stop:
+			return errors.New("stopped while waiting for the consumer to become active")
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// subscribeShard reads a shard with enhanced fan-out until it is closed by resharding or stop is closed.
+// Subscriptions expire after 5 minutes, after which the shard is subscribed to again from the last record.
+func (c *KinesisConsumer) subscribeShard(shardID, start string, stop <-chan struct{}) {
+	position := &kinesis.StartingPosition{Type: aws.String(start)}
+	backoff := newKinesisBackoff()
+	for {
+		resp, err := c.Client.SubscribeToShard(&kinesis.SubscribeToShardInput{
+			ConsumerARN:      aws.String(c.consumerARN),
+			ShardId:          aws.String(shardID),
+			StartingPosition: position,
+		})
+		if err != nil {
+			log.Printf("failed to subscribe to shard %s: %v", shardID, err)
+			if !backoff.wait(stop) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+		stream := resp.GetStream()
+		done := c.readSubscription(shardID, stream.Events(), &position, stop)
+		stream.Close()
+		if done {
+			return
+		}
+		if err := stream.Err(); err != nil {
+			log.Printf("subscription to shard %s ended: %v", shardID, err)
+		}
+	}
+}
+
+// readSubscription processes the events of a subscription and advances the position. It returns true when the
+// shard is closed or stop is closed, and false when the subscription ended.
+func (c *KinesisConsumer) readSubscription(shardID string, events <-chan kinesis.SubscribeToShardEventStreamEvent, position **kinesis.StartingPosition, stop <-chan struct{}) bool {
+	for {
+		select {
+		case <-stop:
+			return true
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			shardEvent, ok := event.(*kinesis.SubscribeToShardEvent)
+			if !ok {
+				continue
+			}
+			if len(shardEvent.Records) > 0 && !c.processBatch(shardID, shardEvent.Records, stop) {
+				return true
+			}
+			if shardEvent.ContinuationSequenceNumber == nil {
+				log.Printf("shard %s is closed", shardID)
+				return true
+			}
+			*position = &kinesis.StartingPosition{
+				Type:           aws.String(kinesis.ShardIteratorTypeAfterSequenceNumber),
+				SequenceNumber: shardEvent.ContinuationSequenceNumber,
+			}
+		}
+	}
+}
+
+// processBatch processes the records of a batch as a single log file, retrying with backoff until it succeeds.
+// It returns false if stop was closed before the batch was processed.
+func (c *KinesisConsumer) processBatch(shardID string, records []*kinesis.Record, stop <-chan struct{}) bool {
+	var data bytes.Buffer
+	for _, record := range records {
+		lines, err := decodeKinesisRecord(record.Data)
+		if err != nil {
+			log.Printf("skipping record %s of shard %s: %v", aws.StringValue(record.SequenceNumber), shardID, err)
+			continue
+		}
+		data.Write(lines)
+	}
+	name := fmt.Sprintf("kinesis:%s/%s/%s", c.Stream, shardID, aws.StringValue(records[0].SequenceNumber))
+	backoff := newKinesisBackoff()
+	for {
+		_, err := c.Processor.ProcessData(name, data.Bytes())
+		if err == nil || errors.Is(err, ErrEmptyObject) {
+			return true
+		}
+		log.Printf("error processing %s, retrying in %s: %v", name, backoff.delay, err)
+		if !backoff.wait(stop) {
+			return false
+		}
+	}
+}
+
+// kinesisBackoff doubles the delay between retries up to kinesisMaxBackoff
+type kinesisBackoff struct {
+	delay time.Duration
+}
+
+func newKinesisBackoff() *kinesisBackoff {
+	return &kinesisBackoff{delay: time.Second}
+}
+
+// wait sleeps for the delay and doubles it, it returns false if stop was closed
+func (b *kinesisBackoff) wait(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(b.delay):
+	}
+	b.delay = min(2*b.delay, kinesisMaxBackoff)
+
+	return true
+}
+
+func (b *kinesisBackoff) reset() {
+	b.delay = time.Second
+}
+
+// cloudWatchLogsData is the payload of a CloudWatch Logs subscription, see
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/SubscriptionFilters.html
+type cloudWatchLogsData struct {
+	MessageType string `json:"messageType"`
+	LogEvents   []struct {
+		Message string `json:"message"`
+	} `json:"logEvents"`
+}
+
+// decodeKinesisRecord returns the log lines of a record, each terminated by a newline. Records contain log lines,
+// optionally gzipped, or the gzipped JSON payload of a CloudWatch Logs subscription with a log line per event.
+func decodeKinesisRecord(data []byte) ([]byte, error) {
+	data, err := gunzip(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var payload cloudWatchLogsData
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return nil, fmt.Errorf("invalid CloudWatch Logs subscription data: %v", err)
+		}
+		var lines bytes.Buffer
+		if payload.MessageType == "CONTROL_MESSAGE" {
+			return nil, nil
+		}
+		for _, event := range payload.LogEvents {
+			lines.WriteString(strings.TrimSuffix(event.Message, "\n"))
+			lines.WriteByte('\n')
+		}
+
+		return lines.Bytes(), nil
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+
+	return data, nil
+}
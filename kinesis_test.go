@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockKinesisApi struct {
+	mock.Mock
+}
+
+func (m *MockKinesisApi) ListShards(input *kinesis.ListShardsInput) (*kinesis.ListShardsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kinesis.ListShardsOutput), args.Error(1)
+}
+
+func (m *MockKinesisApi) GetShardIterator(input *kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kinesis.GetShardIteratorOutput), args.Error(1)
+}
+
+func (m *MockKinesisApi) GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kinesis.GetRecordsOutput), args.Error(1)
+}
+
+func (m *MockKinesisApi) DescribeStreamSummary(input *kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kinesis.DescribeStreamSummaryOutput), args.Error(1)
+}
+
+func (m *MockKinesisApi) RegisterStreamConsumer(input *kinesis.RegisterStreamConsumerInput) (*kinesis.RegisterStreamConsumerOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kinesis.RegisterStreamConsumerOutput), args.Error(1)
+}
+
+func (m *MockKinesisApi) DescribeStreamConsumer(input *kinesis.DescribeStreamConsumerInput) (*kinesis.DescribeStreamConsumerOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kinesis.DescribeStreamConsumerOutput), args.Error(1)
+}
+
+func (m *MockKinesisApi) SubscribeToShard(input *kinesis.SubscribeToShardInput) (*kinesis.SubscribeToShardOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kinesis.SubscribeToShardOutput), args.Error(1)
+}
+
+// memoryDataProcessor collects the data it processes
+type memoryDataProcessor struct {
+	mu      sync.Mutex
+	batches map[string]string
+}
+
+func (p *memoryDataProcessor) ProcessData(name string, data []byte) (ObjectResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.batches == nil {
+		p.batches = make(map[string]string)
+	}
+	p.batches[name] = string(data)
+	return ObjectResult{Entries: bytes.Count(data, []byte("\n"))}, nil
+}
+
+func gzipBytes(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestKinesisConsumer(t *testing.T) {
+	mockKinesis := new(MockKinesisApi)
+	mockKinesis.On("ListShards", &kinesis.ListShardsInput{StreamName: aws.String("elb-logs")}).Return(&kinesis.ListShardsOutput{
+		Shards: []*kinesis.Shard{{ShardId: aws.String("shard-1")}},
+	}, nil)
+	mockKinesis.On("GetShardIterator", &kinesis.GetShardIteratorInput{
+		StreamName:        aws.String("elb-logs"),
+		ShardId:           aws.String("shard-1"),
+		ShardIteratorType: aws.String(kinesis.ShardIteratorTypeTrimHorizon),
+	}).Return(&kinesis.GetShardIteratorOutput{ShardIterator: aws.String("iterator-1")}, nil)
+	// The shard is closed after the first records
+	mockKinesis.On("GetRecords", &kinesis.GetRecordsInput{ShardIterator: aws.String("iterator-1")}).Return(&kinesis.GetRecordsOutput{
+		Records: []*kinesis.Record{
+			{SequenceNumber: aws.String("1"), Data: []byte("line 1\nline 2")},
+			{SequenceNumber: aws.String("2"), Data: gzipBytes(t, "line 3\n")},
+		},
+	}, nil)
+	processor := &memoryDataProcessor{}
+	consumer := &KinesisConsumer{Client: mockKinesis, Stream: "elb-logs", Processor: processor, Start: kinesis.ShardIteratorTypeTrimHorizon}
+
+	require.NoError(t, consumer.startShards(consumer.Start, make(chan struct{})))
+	consumer.wg.Wait()
+	assert.Equal(t, map[string]string{"kinesis:elb-logs/shard-1/1": "line 1\nline 2\nline 3\n"}, processor.batches)
+
+	// Shards that were read are not read again
+	require.NoError(t, consumer.startShards(kinesis.ShardIteratorTypeTrimHorizon, make(chan struct{})))
+	consumer.wg.Wait()
+	mockKinesis.AssertNumberOfCalls(t, "GetShardIterator", 1)
+}
+
+func TestPollShardExpiredIterator(t *testing.T) {
+	mockKinesis := new(MockKinesisApi)
+	started := time.Now()
+	mockKinesis.On("GetShardIterator", &kinesis.GetShardIteratorInput{
+		StreamName:        aws.String("elb-logs"),
+		ShardId:           aws.String("shard-1"),
+		ShardIteratorType: aws.String(kinesis.ShardIteratorTypeLatest),
+	}).Return(&kinesis.GetShardIteratorOutput{ShardIterator: aws.String("iterator-1")}, nil).Once()
+	expired := awserr.New(kinesis.ErrCodeExpiredIteratorException, "Iterator expired", nil)
+	mockKinesis.On("GetRecords", &kinesis.GetRecordsInput{ShardIterator: aws.String("iterator-1")}).Return((*kinesis.GetRecordsOutput)(nil), expired)
+	// Before the first record, the new iterator starts at the time the first one was requested instead of LATEST
+	mockKinesis.On("GetShardIterator", mock.MatchedBy(func(input *kinesis.GetShardIteratorInput) bool {
+		return aws.StringValue(input.ShardIteratorType) == kinesis.ShardIteratorTypeAtTimestamp &&
+			!aws.TimeValue(input.Timestamp).Before(started) && !aws.TimeValue(input.Timestamp).After(time.Now())
+	})).Return(&kinesis.GetShardIteratorOutput{ShardIterator: aws.String("iterator-2")}, nil).Once()
+	mockKinesis.On("GetRecords", &kinesis.GetRecordsInput{ShardIterator: aws.String("iterator-2")}).Return(&kinesis.GetRecordsOutput{
+		Records:            []*kinesis.Record{{SequenceNumber: aws.String("5"), Data: []byte("line 1\n")}},
+		MillisBehindLatest: aws.Int64(1),
+		NextShardIterator:  aws.String("iterator-3"),
+	}, nil)
+	mockKinesis.On("GetRecords", &kinesis.GetRecordsInput{ShardIterator: aws.String("iterator-3")}).Return((*kinesis.GetRecordsOutput)(nil), expired)
+	// After a record, the new iterator starts after the last record read
+	mockKinesis.On("GetShardIterator", &kinesis.GetShardIteratorInput{
+		StreamName:             aws.String("elb-logs"),
+		ShardId:                aws.String("shard-1"),
+		ShardIteratorType:      aws.String(kinesis.ShardIteratorTypeAfterSequenceNumber),
+		StartingSequenceNumber: aws.String("5"),
+	}).Return(&kinesis.GetShardIteratorOutput{ShardIterator: aws.String("iterator-4")}, nil).Once()
+	mockKinesis.On("GetRecords", &kinesis.GetRecordsInput{ShardIterator: aws.String("iterator-4")}).Return(&kinesis.GetRecordsOutput{}, nil)
+	processor := &memoryDataProcessor{}
+	consumer := &KinesisConsumer{Client: mockKinesis, Stream: "elb-logs", Processor: processor, Start: kinesis.ShardIteratorTypeLatest}
+
+	consumer.pollShard("shard-1", kinesis.ShardIteratorTypeLatest, make(chan struct{}))
+	assert.Equal(t, map[string]string{"kinesis:elb-logs/shard-1/5": "line 1\n"}, processor.batches)
+	mockKinesis.AssertNumberOfCalls(t, "GetShardIterator", 3)
+}
+
+func TestDecodeKinesisRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"Lines", []byte("line 1\nline 2\n"), "line 1\nline 2\n"},
+		{"Without trailing newline", []byte("line 1"), "line 1\n"},
+		{"Gzipped", gzipBytes(t, "line 1\n"), "line 1\n"},
+		{"Subscription", gzipBytes(t, `{"messageType":"DATA_MESSAGE","logEvents":[{"id":"1","timestamp":1,"message":"line 1"},{"id":"2","timestamp":2,"message":"line 2\n"}]}`), "line 1\nline 2\n"},
+		{"Control message", gzipBytes(t, `{"messageType":"CONTROL_MESSAGE","logEvents":[{"message":"CWL CONTROL MESSAGE: Checking health of destination"}]}`), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := decodeKinesisRecord(tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(lines))
+		})
+	}
+
+	_, err := decodeKinesisRecord([]byte("{not json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	latencyUnitMilliseconds = "ms"
+	latencyUnitMicroseconds = "us"
+)
+
+// processingTimeFields are the fields with a processing time in seconds
+var processingTimeFields = []string{"request_processing_time", "target_processing_time", "response_processing_time"}
+
+// ParseLatencyUnit validates the LATENCY_UNIT setting, empty disables the conversion
+func ParseLatencyUnit(value string) (string, error) {
+	switch value {
+	case "", latencyUnitMilliseconds, latencyUnitMicroseconds:
+		return value, nil
+	}
+
+	return "", fmt.Errorf("invalid latency unit '%s', expected ms or us", value)
+}
+
+// LatencyConverter adds the processing times as integers in milliseconds or microseconds, in fields named after
+// the original with the unit as suffix, e.g. target_processing_time_ms. Queries can then compare and aggregate
+// them without parsing floats. A processing time of -1, for requests that were not dispatched or got no
+// response, stays -1.
+type LatencyConverter struct {
+	Unit string
+}
+
+func (c *LatencyConverter) Transform(record []string, entry *LogEntry) {
+	factor := 1e3
+	if c.Unit == latencyUnitMicroseconds {
+		factor = 1e6
+	}
+	for _, field := range processingTimeFields {
+		if value, ok := processingTimeIn(recordValue(record, field), factor); ok {
+			entry.Data[field+"_"+c.Unit] = value
+		}
+	}
+}
+
+// LatencyBreakdown replaces the processing times with a latency object in milliseconds, as most APM tools model
+// latency: {"request_ms": 1, "target_ms": 24, "response_ms": 0, "total_ms": 25}. Times of -1 are left out of the
+// object and the total, the object is left out entirely when no time is known.
+type LatencyBreakdown struct{}
+
+var latencyBreakdownKeys = map[string]string{
+	"request_processing_time":  "request_ms",
+	"target_processing_time":   "target_ms",
+	"response_processing_time": "response_ms",
+}
+
+func (b *LatencyBreakdown) Transform(record []string, entry *LogEntry) {
+	latency := make(map[string]interface{})
+	total := int64(0)
+	for _, field := range processingTimeFields {
+		delete(entry.Data, field)
+		if value, ok := processingTimeIn(recordValue(record, field), 1e3); ok && value >= 0 {
+			latency[latencyBreakdownKeys[field]] = value
+			total += value
+		}
+	}
+	if len(latency) == 0 {
+		return
+	}
+	latency["total_ms"] = total
+	entry.Data["latency"] = latency
+}
+
+// processingTimeIn converts a processing time in seconds to an integer in the unit given by factor, e.g. 1e3 for
+// milliseconds. A processing time of -1 stays -1.
+func processingTimeIn(value string, factor float64) (int64, bool) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	if seconds < 0 {
+		return -1, true
+	}
+
+	return int64(math.Round(seconds * factor)), true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLatencyUnit(t *testing.T) {
+	for _, value := range []string{"", "ms", "us"} {
+		unit, err := ParseLatencyUnit(value)
+		assert.NoError(t, err)
+		assert.Equal(t, value, unit)
+	}
+
+	_, err := ParseLatencyUnit("s")
+	assert.EqualError(t, err, "invalid latency unit 's', expected ms or us")
+}
+
+func TestLatencyConverter(t *testing.T) {
+	record := make([]string, len(fieldNames))
+	record[getFieldIndex("request_processing_time")] = "0.001"
+	record[getFieldIndex("target_processing_time")] = "0.024"
+	record[getFieldIndex("response_processing_time")] = "-1"
+
+	t.Run("Milliseconds", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		(&LatencyConverter{Unit: "ms"}).Transform(record, &entry)
+
+		assert.Equal(t, map[string]interface{}{
+			"request_processing_time_ms":  int64(1),
+			"target_processing_time_ms":   int64(24),
+			"response_processing_time_ms": int64(-1),
+		}, entry.Data)
+	})
+
+	t.Run("Microseconds", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		(&LatencyConverter{Unit: "us"}).Transform(record, &entry)
+
+		assert.Equal(t, int64(1000), entry.Data["request_processing_time_us"])
+		assert.Equal(t, int64(24000), entry.Data["target_processing_time_us"])
+		assert.Equal(t, int64(-1), entry.Data["response_processing_time_us"])
+	})
+
+	t.Run("Invalid value is skipped", func(t *testing.T) {
+		invalid := make([]string, len(fieldNames))
+		invalid[getFieldIndex("target_processing_time")] = "n/a"
+		entry := LogEntry{Data: map[string]interface{}{}}
+		(&LatencyConverter{Unit: "ms"}).Transform(invalid, &entry)
+
+		assert.Empty(t, entry.Data)
+	})
+}
+
+func TestLatencyBreakdown(t *testing.T) {
+	newRecord := func(request, target, response string) []string {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("request_processing_time")] = request
+		record[getFieldIndex("target_processing_time")] = target
+		record[getFieldIndex("response_processing_time")] = response
+		return record
+	}
+
+	t.Run("Replaces the processing times", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{
+			"request_processing_time":  "0.001",
+			"target_processing_time":   "0.024",
+			"response_processing_time": "0.0",
+			"elb_status_code":          "200",
+		}}
+		(&LatencyBreakdown{}).Transform(newRecord("0.001", "0.024", "0.0"), &entry)
+
+		assert.Equal(t, map[string]interface{}{
+			"elb_status_code": "200",
+			"latency": map[string]interface{}{
+				"request_ms":  int64(1),
+				"target_ms":   int64(24),
+				"response_ms": int64(0),
+				"total_ms":    int64(25),
+			},
+		}, entry.Data)
+	})
+
+	t.Run("Leaves out unknown times", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		(&LatencyBreakdown{}).Transform(newRecord("-1", "-1", "-1"), &entry)
+		assert.Empty(t, entry.Data)
+
+		(&LatencyBreakdown{}).Transform(newRecord("0.002", "-1", "-1"), &entry)
+		assert.Equal(t, map[string]interface{}{"request_ms": int64(2), "total_ms": int64(2)}, entry.Data["latency"])
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Lease lets one of multiple watcher replicas process a prefix at a time. The lease is an item in a DynamoDB
+// table with the string partition key "lease", held by its owner until it expires. The item also keeps the
+// position of the watcher, so the replica that takes over continues where the previous one stopped.
+type Lease struct {
+	Client   DynamoDBApi
+	Table    string
+	Name     string // Identifies the lease, e.g. the watched S3 URL
+	Owner    string // Identifies this replica
+	Duration time.Duration
+	now      func() time.Time
+}
+
+// WatchState is the position of a Watcher, see listingCursor
+type WatchState struct {
+	From map[string]string // Position by log directory, "" for a position of the whole prefix saved by earlier versions
+	Done []string
+}
+
+// NewLease returns the lease of a name in a table, owned by this process
+func NewLease(client DynamoDBApi, table, name string, duration time.Duration) *Lease {
+	return &Lease{Client: client, Table: table, Name: name, Owner: newLeaseOwner(), Duration: duration, now: time.Now}
+}
+
+// newLeaseOwner returns the host name with a random suffix, unique for every process
+func newLeaseOwner() string {
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	hostname, _ := os.Hostname()
+
+	return fmt.Sprintf("%s-%s", hostname, hex.EncodeToString(random))
+}
+
+// Acquire takes the lease if it is free or expired, or extends it if already held, and returns whether it is
+// held with the last saved state
+func (l *Lease) Acquire() (bool, WatchState, error) {
+	now := l.now()
+	resp, err := l.Client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.Table),
+		Key:                 map[string]*dynamodb.AttributeValue{"lease": {S: aws.String(l.Name)}},
+		UpdateExpression:    aws.String("SET #owner = :owner, #expires = :expires"),
+		ConditionExpression: aws.String("attribute_not_exists(#owner) OR #owner = :owner OR #expires < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner":   aws.String("owner"),
+			"#expires": aws.String("expires"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner":   {S: aws.String(l.Owner)},
+			":expires": {N: aws.String(strconv.FormatInt(now.Add(l.Duration).UnixMilli(), 10))},
+			":now":     {N: aws.String(strconv.FormatInt(now.UnixMilli(), 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if isConditionalCheckFailed(err) {
+		return false, WatchState{}, nil
+	}
+	if err != nil {
+		return false, WatchState{}, fmt.Errorf("failed to acquire lease %s: %v", l.Name, err)
+	}
+	state := WatchState{}
+	if from := resp.Attributes["from"]; from != nil {
+		state.From = make(map[string]string)
+		if from.S != nil {
+			state.From[""] = aws.StringValue(from.S)
+		}
+		for directory, position := range from.M {
+			state.From[directory] = aws.StringValue(position.S)
+		}
+	}
+	if done := resp.Attributes["done"]; done != nil && len(done.B) > 0 {
+		if state.Done, err = decodeKeys(done.B); err != nil {
+			return true, WatchState{}, fmt.Errorf("invalid state in lease %s: %v", l.Name, err)
+		}
+	}
+
+	return true, state, nil
+}
+
+// Save extends the lease and stores the state, it fails if the lease is held by another owner
+func (l *Lease) Save(state WatchState) error {
+	done, err := encodeKeys(state.Done)
+	if err != nil {
+		return err
+	}
+	from := make(map[string]*dynamodb.AttributeValue)
+	for directory, position := range state.From {
+		from[directory] = &dynamodb.AttributeValue{S: aws.String(position)}
+	}
+	_, err = l.Client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.Table),
+		Key:                 map[string]*dynamodb.AttributeValue{"lease": {S: aws.String(l.Name)}},
+		UpdateExpression:    aws.String("SET #expires = :expires, #from = :from, #done = :done"),
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner":   aws.String("owner"),
+			"#expires": aws.String("expires"),
+			"#from":    aws.String("from"),
+			"#done":    aws.String("done"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner":   {S: aws.String(l.Owner)},
+			":expires": {N: aws.String(strconv.FormatInt(l.now().Add(l.Duration).UnixMilli(), 10))},
+			":from":    {M: from},
+			":done":    {B: done},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return fmt.Errorf("lease %s was taken over by another replica", l.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save lease %s: %v", l.Name, err)
+	}
+
+	return nil
+}
+
+// Release lets the lease expire immediately, so another replica can take over without waiting
+func (l *Lease) Release() error {
+	_, err := l.Client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                aws.String(l.Table),
+		Key:                      map[string]*dynamodb.AttributeValue{"lease": {S: aws.String(l.Name)}},
+		UpdateExpression:         aws.String("SET #expires = :expires"),
+		ConditionExpression:      aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{"#owner": aws.String("owner"), "#expires": aws.String("expires")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner":   {S: aws.String(l.Owner)},
+			":expires": {N: aws.String("0")},
+		},
+	})
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("failed to release lease %s: %v", l.Name, err)
+	}
+
+	return nil
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var awsErr awserr.Error
+
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// encodeKeys compresses keys as gzipped lines, the keys of a day compress well as they share most of their name
+func encodeKeys(keys []string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(gz, strings.Join(keys, "\n")); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeKeys(data []byte) ([]string, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	lines, err := io.ReadAll(gz)
+	if err != nil || len(lines) == 0 {
+		return nil, err
+	}
+
+	return strings.Split(string(lines), "\n"), nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLease(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 0, 0, 0, time.UTC)
+	newLease := func(client DynamoDBApi) *Lease {
+		lease := NewLease(client, "leases", "s3://bucket/logs/", time.Minute)
+		lease.Owner = "replica-1"
+		lease.now = func() time.Time { return now }
+		return lease
+	}
+
+	t.Run("Acquire", func(t *testing.T) {
+		done, err := encodeKeys([]string{"logs/day1/a-1", "logs/day1/b-1"})
+		require.NoError(t, err)
+		mockDynamoDB := new(MockDynamoDBApi)
+		mockDynamoDB.On("UpdateItem", mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+			return aws.StringValue(input.TableName) == "leases" &&
+				aws.StringValue(input.Key["lease"].S) == "s3://bucket/logs/" &&
+				aws.StringValue(input.ExpressionAttributeValues[":owner"].S) == "replica-1" &&
+				aws.StringValue(input.ExpressionAttributeValues[":expires"].N) == "1711036860000"
+		})).Return(&dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{
+			"from": {M: map[string]*dynamodb.AttributeValue{"logs/eu-west-1/": {S: aws.String("logs/eu-west-1/day1/")}}},
+			"done": {B: done},
+		}}, nil)
+
+		leader, state, err := newLease(mockDynamoDB).Acquire()
+		require.NoError(t, err)
+		assert.True(t, leader)
+		assert.Equal(t, WatchState{From: map[string]string{"logs/eu-west-1/": "logs/eu-west-1/day1/"}, Done: []string{"logs/day1/a-1", "logs/day1/b-1"}}, state)
+	})
+
+	t.Run("Acquire a position of the whole prefix", func(t *testing.T) {
+		mockDynamoDB := new(MockDynamoDBApi)
+		mockDynamoDB.On("UpdateItem", mock.Anything).Return(&dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{
+			"from": {S: aws.String("logs/day1/")},
+		}}, nil)
+
+		_, state, err := newLease(mockDynamoDB).Acquire()
+		require.NoError(t, err)
+		assert.Equal(t, WatchState{From: map[string]string{"": "logs/day1/"}}, state)
+	})
+
+	t.Run("Held by another replica", func(t *testing.T) {
+		mockDynamoDB := new(MockDynamoDBApi)
+		mockDynamoDB.On("UpdateItem", mock.Anything).Return((*dynamodb.UpdateItemOutput)(nil),
+			awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil))
+		lease := newLease(mockDynamoDB)
+
+		leader, _, err := lease.Acquire()
+		require.NoError(t, err)
+		assert.False(t, leader)
+		assert.EqualError(t, lease.Save(WatchState{}), "lease s3://bucket/logs/ was taken over by another replica")
+		assert.NoError(t, lease.Release())
+	})
+}
+
+func TestWatcherLease(t *testing.T) {
+	done, err := encodeKeys([]string{testUSDirectory + "2024/03/21/a-1"})
+	require.NoError(t, err)
+	mockDynamoDB := new(MockDynamoDBApi)
+	mockDynamoDB.On("UpdateItem", mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+		return input.ReturnValues != nil
+	})).Return(&dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{
+		"from": {M: map[string]*dynamodb.AttributeValue{testUSDirectory: {S: aws.String(testUSDirectory + "2024/03/21/")}}},
+		"done": {B: done},
+	}}, nil)
+	var saved WatchState
+	mockDynamoDB.On("UpdateItem", mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+		return input.ExpressionAttributeValues[":from"] != nil
+	})).Run(func(args mock.Arguments) {
+		input := args.Get(0).(*dynamodb.UpdateItemInput)
+		saved.From = make(map[string]string)
+		for directory, from := range input.ExpressionAttributeValues[":from"].M {
+			saved.From[directory] = aws.StringValue(from.S)
+		}
+		saved.Done, _ = decodeKeys(input.ExpressionAttributeValues[":done"].B)
+	}).Return(&dynamodb.UpdateItemOutput{}, nil)
+	s3Client := newListingS3(testUSDirectory+"2024/03/20/a-0", testUSDirectory+"2024/03/21/a-1", testUSDirectory+"2024/03/21/a-2")
+	mockProcessor := new(MockLogProcessor)
+	mockProcessor.On("ProcessLogs", S3ObjectInfo{Bucket: "bucket", Key: testUSDirectory + "2024/03/21/a-2"}).Return(ObjectResult{Entries: 1}, nil)
+	watcher := &Watcher{
+		Handler:  &Handler{lp: mockProcessor, s3Client: s3Client},
+		Bucket:   "bucket",
+		Prefix:   "logs/",
+		Interval: time.Minute,
+		Lease:    NewLease(mockDynamoDB, "leases", "s3://bucket/logs/", 3*time.Minute),
+	}
+
+	// The replica continues from the position saved by the previous leader, a-0 and a-1 are not processed again
+	require.True(t, watcher.acquireLease())
+	require.NoError(t, watcher.pollWithLease())
+	mockProcessor.AssertExpectations(t)
+	assert.Equal(t, WatchState{
+		From: map[string]string{testUSDirectory: testUSDirectory + "2024/03/21/"},
+		Done: []string{testUSDirectory + "2024/03/21/a-1", testUSDirectory + "2024/03/21/a-2"},
+	}, saved)
+}
+
+func TestWatcherLeaseLost(t *testing.T) {
+	conditionFailed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	lost := make(chan time.Time)
+	mockDynamoDB := new(MockDynamoDBApi)
+	acquire := mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool { return input.ReturnValues != nil })
+	mockDynamoDB.On("UpdateItem", acquire).Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	// Another replica took the lease over while the first objects were processed
+	mockDynamoDB.On("UpdateItem", acquire).Return((*dynamodb.UpdateItemOutput)(nil), conditionFailed).Once().Run(func(mock.Arguments) {
+		close(lost)
+	})
+	mockDynamoDB.On("UpdateItem", mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+		return input.ExpressionAttributeValues[":from"] != nil
+	})).Return((*dynamodb.UpdateItemOutput)(nil), conditionFailed)
+	s3Client := newListingS3(testUSDirectory+"2024/03/21/a-1", testUSDirectory+"2024/03/21/a-2", testUSDirectory+"2024/03/21/a-3")
+	mockProcessor := new(MockLogProcessor)
+	mockProcessor.On("ProcessLogs", mock.Anything).Return(ObjectResult{Entries: 1}, nil).WaitUntil(lost)
+	watcher := &Watcher{
+		// Two objects are processed at a time
+		Handler:  &Handler{lp: mockProcessor, s3Client: s3Client, config: Config{MemoryLimit: 128 << 20}},
+		Bucket:   "bucket",
+		Prefix:   "logs/",
+		Interval: time.Minute,
+		Lease:    NewLease(mockDynamoDB, "leases", "s3://bucket/logs/", 30*time.Millisecond),
+	}
+
+	require.True(t, watcher.acquireLease())
+	err := watcher.pollWithLease()
+	assert.ErrorContains(t, err, "stopped polling after failing to extend lease s3://bucket/logs/")
+	assert.ErrorContains(t, err, "taken over by another replica")
+	mockProcessor.AssertNumberOfCalls(t, "ProcessLogs", 2)
+	mockProcessor.AssertNotCalled(t, "ProcessLogs", S3ObjectInfo{Bucket: "bucket", Key: testUSDirectory + "2024/03/21/a-3"})
+	assert.False(t, watcher.leader)
+	assert.Nil(t, watcher.Handler.stop)
+}
+
+func TestEncodeKeys(t *testing.T) {
+	for _, keys := range [][]string{nil, {"a"}, {"logs/day1/a-1", "logs/day1/a-2"}} {
+		data, err := encodeKeys(keys)
+		require.NoError(t, err)
+		decoded, err := decodeKeys(data)
+		require.NoError(t, err)
+		assert.Equal(t, keys, decoded)
+	}
+}
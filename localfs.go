@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// isDirLocation reports whether a local path or S3 URL names a directory or prefix, by a trailing slash, or
+// also a backslash for local paths on Windows
+func isDirLocation(location string) bool {
+	if strings.HasSuffix(location, "/") {
+		return true
+	}
+
+	return location != "" && !strings.HasPrefix(location, "s3://") && os.IsPathSeparator(location[len(location)-1])
+}
+
+// lockFile takes a lock by creating a file that must not exist. Unlike flock, exclusive creation works the same
+// on Linux, macOS and Windows, including network drives. A process that is killed leaves the file behind, so
+// the error asks to remove it.
+func lockFile(path string) (unlock func() error, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%s is locked by another process, remove it if that process is no longer running", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, _ = fmt.Fprintf(file, "%d\n", os.Getpid())
+	// Closed before removing, as Windows can't remove open files
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	return func() error { return os.Remove(path) }, nil
+}
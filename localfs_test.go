@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsDirLocation(t *testing.T) {
+	assert.True(t, isDirLocation("manifests/"))
+	assert.True(t, isDirLocation("s3://bucket/manifests/"))
+	assert.True(t, isDirLocation("manifests"+string(filepath.Separator)))
+	assert.False(t, isDirLocation("manifest.json"))
+	assert.False(t, isDirLocation(`s3://bucket/manifests\`))
+	assert.False(t, isDirLocation(""))
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	unlock, err := lockFile(path)
+	require.NoError(t, err)
+
+	_, err = lockFile(path)
+	assert.ErrorContains(t, err, "locked by another process")
+
+	require.NoError(t, unlock())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	unlock, err = lockFile(path)
+	require.NoError(t, err)
+	assert.NoError(t, unlock())
+}
@@ -11,15 +11,16 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
-	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
-		lambda.Start(h.HandleLambdaEvent)
-	} else {
-		if len(os.Args) < 2 {
-			log.Fatalln("s3 url is required as an argument")
-		}
-		err := h.HandleS3URL(os.Args[1])
+	switch {
+	case os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" && h.config.FirehoseTransform:
+		transformer, err := NewFirehoseTransformer(h.config)
 		if err != nil {
 			log.Fatalln(err)
 		}
+		lambda.Start(transformer.HandleFirehoseEvent)
+	case os.Getenv("AWS_LAMBDA_RUNTIME_API") != "":
+		lambda.Start(h.HandleLambdaInvocation)
+	default:
+		os.Exit(runCLI(h, os.Args[1:], os.Stdout, os.Stderr))
 	}
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Manifest lists every object of a run with what was ingested from it, so it can be audited that every
+// delivered log file was ingested exactly once
+type Manifest struct {
+	Started   time.Time        `json:"started"`
+	Finished  time.Time        `json:"finished"`
+	RequestID string           `json:"request_id,omitempty"` // ID of the Lambda invocation
+	Objects   []ManifestObject `json:"objects"`
+}
+
+// ManifestObject is the outcome of a single object in a Manifest
+type ManifestObject struct {
+	Bucket            string     `json:"bucket,omitempty"`
+	Key               string     `json:"key"`
+	ETag              string     `json:"etag,omitempty"`
+	Status            string     `json:"status"`
+	Entries           int        `json:"entries"`
+	CompressedBytes   int64      `json:"compressed_bytes"`
+	DecompressedBytes int64      `json:"decompressed_bytes"`
+	SentBytes         int64      `json:"sent_bytes"`
+	FirstTimestamp    *time.Time `json:"first_timestamp,omitempty"`
+	LastTimestamp     *time.Time `json:"last_timestamp,omitempty"`
+	SHA256            string     `json:"sha256,omitempty"`
+	Error             string     `json:"error,omitempty"`
+}
+
+// newManifest returns the manifest of the statuses of a run, ordered by bucket and key
+func newManifest(started, finished time.Time, requestID string, statuses []ObjectStatus) Manifest {
+	manifest := Manifest{Started: started.UTC(), Finished: finished.UTC(), RequestID: requestID, Objects: []ManifestObject{}}
+	for _, status := range statuses {
+		object := ManifestObject{
+			Bucket:            status.Bucket,
+			Key:               status.Key,
+			ETag:              status.Result.ETag,
+			Status:            status.Status,
+			Entries:           status.Entries,
+			CompressedBytes:   status.Result.CompressedBytes,
+			DecompressedBytes: status.Result.DecompressedBytes,
+			SentBytes:         status.Result.SentBytes,
+			SHA256:            status.Result.SHA256,
+			Error:             status.Error,
+		}
+		if first := status.Result.FirstTimestamp; !first.IsZero() {
+			first = first.UTC()
+			object.FirstTimestamp = &first
+		}
+		if last := status.Result.LastTimestamp; !last.IsZero() {
+			last = last.UTC()
+			object.LastTimestamp = &last
+		}
+		manifest.Objects = append(manifest.Objects, object)
+	}
+	sort.Slice(manifest.Objects, func(i, j int) bool {
+		if manifest.Objects[i].Bucket != manifest.Objects[j].Bucket {
+			return manifest.Objects[i].Bucket < manifest.Objects[j].Bucket
+		}
+		return manifest.Objects[i].Key < manifest.Objects[j].Key
+	})
+
+	return manifest
+}
+
+// manifestName returns the name of the manifest of a run that started at a time, names sort chronologically
+func manifestName(started time.Time) string {
+	return fmt.Sprintf("manifest-%s.json", started.UTC().Format("20060102T150405.000000000Z"))
+}
+
+// writeManifest writes the manifest to a local file or an S3 URL. A location ending with a slash is a
+// directory or prefix, in which every run writes its own manifest.
+func writeManifest(location string, s3Client S3Api, manifest Manifest) (string, error) {
+	if isDirLocation(location) {
+		location += manifestName(manifest.Started)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(location, "s3://") {
+		if err := os.MkdirAll(filepath.Dir(location), 0o755); err != nil {
+			return "", fmt.Errorf("failed to write manifest: %v", err)
+		}
+		if err := os.WriteFile(location, data, 0o644); err != nil {
+			return "", fmt.Errorf("failed to write manifest: %v", err)
+		}
+
+		return location, nil
+	}
+	bucket, key, err := ParseS3URL(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid manifest location: %v", err)
+	}
+	_, err = s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write manifest to %s: %v", location, err)
+	}
+
+	return location, nil
+}
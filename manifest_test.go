@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewManifest(t *testing.T) {
+	started := time.Date(2024, 3, 21, 16, 0, 0, 0, time.UTC)
+	first := time.Date(2024, 3, 21, 15, 55, 0, 0, time.UTC)
+	last := first.Add(5 * time.Minute)
+	manifest := newManifest(started, started.Add(time.Minute), "request-1", []ObjectStatus{
+		{Bucket: "bucket", Key: "b", Status: statusFailed, Error: "failed to get object"},
+		{Bucket: "bucket", Key: "a", Status: statusProcessed, Entries: 2, Result: ObjectResult{
+			Entries: 2, CompressedBytes: 100, DecompressedBytes: 400, SentBytes: 500,
+			ETag: `"etag"`, SHA256: "abc", FirstTimestamp: first, LastTimestamp: last,
+		}},
+	})
+
+	assert.Equal(t, "request-1", manifest.RequestID)
+	assert.Equal(t, []ManifestObject{
+		{Bucket: "bucket", Key: "a", ETag: `"etag"`, Status: statusProcessed, Entries: 2, CompressedBytes: 100,
+			DecompressedBytes: 400, SentBytes: 500, FirstTimestamp: &first, LastTimestamp: &last, SHA256: "abc"},
+		{Bucket: "bucket", Key: "b", Status: statusFailed, Error: "failed to get object"},
+	}, manifest.Objects)
+}
+
+func TestWriteManifest(t *testing.T) {
+	manifest := newManifest(time.Date(2024, 3, 21, 16, 0, 0, 0, time.UTC), time.Now(), "", nil)
+
+	t.Run("File", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "manifests", "run.json")
+		location, err := writeManifest(path, nil, manifest)
+		require.NoError(t, err)
+		assert.Equal(t, path, location)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var written Manifest
+		require.NoError(t, json.Unmarshal(data, &written))
+		assert.Equal(t, manifest.Started, written.Started)
+		assert.Empty(t, written.Objects)
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		dir := t.TempDir() + "/"
+		location, err := writeManifest(dir, nil, manifest)
+		require.NoError(t, err)
+		assert.Equal(t, dir+"manifest-20240321T160000.000000000Z.json", location)
+		assert.FileExists(t, location)
+	})
+
+	t.Run("S3", func(t *testing.T) {
+		mockS3 := new(MockS3Api)
+		mockS3.On("PutObject", mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+			return aws.StringValue(input.Bucket) == "bucket" && aws.StringValue(input.Key) == "manifests/manifest-20240321T160000.000000000Z.json"
+		})).Return(&s3.PutObjectOutput{}, nil)
+		location, err := writeManifest("s3://bucket/manifests/", mockS3, manifest)
+		require.NoError(t, err)
+		assert.Equal(t, "s3://bucket/manifests/manifest-20240321T160000.000000000Z.json", location)
+		mockS3.AssertExpectations(t)
+	})
+}
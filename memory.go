@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// lambdaMemoryShare is the part of the memory of a Lambda function used as limit for the Go heap, the rest is
+	// left for the runtime, goroutine stacks and the Lambda extensions
+	lambdaMemoryShare = 0.9
+	// smallMemory and mediumMemory are the limits below which fewer objects are processed concurrently and fewer
+	// entries are buffered, so e.g. a function of 128 MB isn't killed when ten large objects arrive at once
+	smallMemory  = 256 << 20
+	mediumMemory = 512 << 20
+	// heapSampleInterval is how often the heap in use is sampled to find the peak of a run
+	heapSampleInterval = 100 * time.Millisecond
+)
+
+// heapObjectsMetric is the memory occupied by live and not yet swept objects, cheap to read without stopping the world
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// memoryLimit returns the memory available to the process in bytes, 0 when unknown. The limit of GOMEMLIMIT
+// (as returned by debug.SetMemoryLimit, math.MaxInt64 when not set) takes precedence over the memory size of
+// the Lambda function in MB.
+func memoryLimit(goMemLimit int64, lambdaMemorySize string) int64 {
+	if goMemLimit > 0 && goMemLimit < math.MaxInt64 {
+		return goMemLimit
+	}
+	size, err := strconv.ParseInt(lambdaMemorySize, 10, 64)
+	if err != nil || size <= 0 {
+		return 0
+	}
+
+	return int64(float64(size<<20) * lambdaMemoryShare)
+}
+
+// applyMemoryLimit makes the garbage collector keep the heap below the memory size of the Lambda function when
+// GOMEMLIMIT is not set, rather than letting the function be killed, and logs when the work is scaled down
+func applyMemoryLimit(limit int64, buffer int) {
+	if limit <= 0 {
+		return
+	}
+	if os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(limit)
+	}
+	if objectConcurrency(limit) < concurrency {
+		logf(verbosityNormal, "processing %d objects concurrently and buffering %d entries per object for the memory limit of %d MiB",
+			objectConcurrency(limit), entryBuffer(limit, buffer), limit>>20)
+	}
+}
+
+// objectConcurrency returns the max number of objects processed concurrently for a memory limit
+func objectConcurrency(limit int64) int {
+	switch {
+	case limit <= 0:
+		return concurrency
+	case limit < smallMemory:
+		return 2
+	case limit < mediumMemory:
+		return 5
+	}
+
+	return concurrency
+}
+
+// entryBuffer returns the number of parsed entries buffered per object before they are sent, the buffer of the
+// performance profile (0 for the default) limited for the memory limit
+func entryBuffer(limit int64, buffer int) int {
+	if buffer == 0 {
+		buffer = defaultEntryBuffer
+	}
+	switch {
+	case limit <= 0:
+		return buffer
+	case limit < smallMemory:
+		return min(buffer, maxBatchCount/4)
+	case limit < mediumMemory:
+		return min(buffer, maxBatchCount/2)
+	}
+
+	return buffer
+}
+
+// MemoryMonitor samples the heap in use while objects are processed, as the runtime doesn't track its peak
+type MemoryMonitor struct {
+	mu      sync.Mutex
+	running int // Number of runs in progress, the sampler runs while it is positive
+	peak    uint64
+	stop    chan struct{}
+}
+
+// start begins sampling for a run, runs may overlap when watching
+func (m *MemoryMonitor) start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running++
+	if m.running > 1 {
+		return
+	}
+	m.peak = heapInUse()
+	m.stop = make(chan struct{})
+	go m.sample(m.stop)
+}
+
+// finish ends the sampling of a run and returns the peak heap in use since the first run in progress started
+func (m *MemoryMonitor) finish() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.peak = max(m.peak, heapInUse())
+	m.running--
+	if m.running == 0 {
+		close(m.stop)
+	}
+
+	return int64(m.peak)
+}
+
+func (m *MemoryMonitor) sample(stop chan struct{}) {
+	ticker := time.NewTicker(heapSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			heap := heapInUse()
+			m.mu.Lock()
+			m.peak = max(m.peak, heap)
+			m.mu.Unlock()
+		}
+	}
+}
+
+// heapInUse returns the bytes of heap objects
+func heapInUse() uint64 {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return sample[0].Value.Uint64()
+}
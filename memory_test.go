@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	assert.Equal(t, int64(0), memoryLimit(math.MaxInt64, ""))
+	assert.Equal(t, int64(0), memoryLimit(math.MaxInt64, "invalid"))
+	assert.Equal(t, int64(120_795_955), memoryLimit(math.MaxInt64, "128"))
+	// GOMEMLIMIT takes precedence over the memory size of the function
+	assert.Equal(t, int64(100<<20), memoryLimit(100<<20, "1024"))
+}
+
+func TestMemoryScaling(t *testing.T) {
+	tests := []struct {
+		limit       int64
+		concurrency int
+		buffer      int
+	}{
+		{0, concurrency, 12_500},
+		{115 << 20, 2, maxBatchCount / 4},
+		{460 << 20, 5, maxBatchCount / 2},
+		{1 << 30, concurrency, 12_500},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.concurrency, objectConcurrency(tt.limit), "limit %d", tt.limit)
+		assert.Equal(t, tt.buffer, entryBuffer(tt.limit, 0), "limit %d", tt.limit)
+	}
+}
+
+func TestMemoryMonitor(t *testing.T) {
+	monitor := &MemoryMonitor{}
+	monitor.start()
+	monitor.start()
+	// Allocate and keep more than any sampling noise while both runs are in progress
+	buffer := make([]byte, 64<<20)
+	buffer[len(buffer)-1] = 1
+	first := monitor.finish()
+	runtime.KeepAlive(buffer)
+	second := monitor.finish()
+
+	assert.GreaterOrEqual(t, first, int64(64<<20))
+	assert.GreaterOrEqual(t, second, first)
+
+	// A new run starts over
+	monitor.start()
+	assert.Greater(t, monitor.finish(), int64(0))
+}
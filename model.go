@@ -6,7 +6,8 @@ type S3Record struct {
 			Name string `json:"name"`
 		} `json:"bucket"`
 		Object struct {
-			Key string `json:"key"`
+			Key  string `json:"key"`
+			Size *int64 `json:"size"`
 		} `json:"object"`
 	} `json:"s3"`
 }
@@ -14,3 +15,29 @@ type S3Record struct {
 type S3ObjectCreatedEvent struct {
 	Records []S3Record `json:"Records"`
 }
+
+// LambdaEvent is the payload the Lambda function accepts: an S3 event notification, or a direct
+// invocation with either an S3 URL to list, an explicit list of objects (as used by fan-out),
+// or a chunk of a backfill (as used by Step Functions)
+type LambdaEvent struct {
+	S3ObjectCreatedEvent
+	S3URL   string         `json:"s3_url,omitempty"`
+	Objects []S3ObjectInfo `json:"objects,omitempty"`
+	// Attempt is the number of times the objects have been retried, see retryFailedObjects
+	Attempt int `json:"attempt,omitempty"`
+
+	// Prefix is the S3 URL of a chunked backfill, processing at most MaxObjects objects after StartAfter
+	Prefix     string `json:"prefix,omitempty"`
+	StartAfter string `json:"startAfter,omitempty"`
+	MaxObjects int    `json:"maxObjects,omitempty"`
+}
+
+// LambdaResponse is returned by every invocation. For chunked backfills LastKey is the startAfter of the next
+// chunk and Done reports whether the prefix is exhausted, other invocations are always done.
+type LambdaResponse struct {
+	RunResult
+	LastKey             string `json:"lastKey,omitempty"`
+	Done                bool   `json:"done"`
+	RequestID           string `json:"requestId,omitempty"`
+	RemainingTimeMillis int64  `json:"remainingTimeMillis,omitempty"`
+}
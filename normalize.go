@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// PathNormalizer adds a path_normalized field in which IDs in the request path are replaced with placeholders
+type PathNormalizer struct{}
+
+func (n *PathNormalizer) Transform(record []string, entry *LogEntry) {
+	path := RequestPath(recordValue(record, "request"))
+	if path == "" {
+		return
+	}
+	entry.Data["path_normalized"] = NormalizePath(path)
+}
+
+// RequestPath extracts the URL path from a request field, e.g. "GET https://example.com:443/users/1 HTTP/1.1"
+func RequestPath(request string) string {
+	parts := strings.Split(request, " ")
+	if len(parts) < 2 || parts[1] == "-" {
+		return ""
+	}
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return ""
+	}
+
+	return u.Path
+}
+
+// NormalizePath replaces numeric and UUID path segments with {id} and {uuid} placeholders
+func NormalizePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case numericSegment.MatchString(segment):
+			segments[i] = "{id}"
+		case uuidSegment.MatchString(segment):
+			segments[i] = "{uuid}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
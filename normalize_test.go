@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePath(t *testing.T) {
+	t.Run("Numeric segments", func(t *testing.T) {
+		assert.Equal(t, "/users/{id}/orders/{id}", NormalizePath("/users/42/orders/1337"))
+	})
+
+	t.Run("UUID segments", func(t *testing.T) {
+		assert.Equal(t, "/sessions/{uuid}", NormalizePath("/sessions/3f2504e0-4f89-11d3-9a0c-0305e82c3301"))
+	})
+
+	t.Run("Nothing to normalize", func(t *testing.T) {
+		assert.Equal(t, "/api/modify", NormalizePath("/api/modify"))
+		assert.Equal(t, "/v2/items", NormalizePath("/v2/items"))
+	})
+}
+
+func TestRequestPath(t *testing.T) {
+	t.Run("Full request", func(t *testing.T) {
+		assert.Equal(t, "/api/modify", RequestPath("PUT https://example.com:443/api/modify?user_ids=1 HTTP/1.1"))
+	})
+
+	t.Run("Missing request", func(t *testing.T) {
+		assert.Equal(t, "", RequestPath("- - -"))
+		assert.Equal(t, "", RequestPath(""))
+	})
+}
+
+func TestPathNormalizer(t *testing.T) {
+	record := make([]string, len(fieldNames))
+	record[getFieldIndex("request")] = "GET https://example.com:443/users/42 HTTP/1.1"
+	entry := LogEntry{Data: map[string]interface{}{}}
+
+	normalizer := &PathNormalizer{}
+	normalizer.Transform(record, &entry)
+
+	assert.Equal(t, "/users/{id}", entry.Data["path_normalized"])
+}
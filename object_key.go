@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// ELBObjectKey holds the components of the file name of an ELB access log object, e.g.
+// AWSLogs/123456789012/elasticloadbalancing/eu-west-1/2024/01/01/123456789012_elasticloadbalancing_eu-west-1_app.my-lb.1234567890abcdef_20240101T0005Z_10.0.0.1_2x9kdk1n.log.gz
+type ELBObjectKey struct {
+	AccountID    string
+	Region       string
+	LoadBalancer string
+	// LoadBalancerID is the resource ID of an application or network load balancer, e.g. app/my-lb/1234567890abcdef,
+	// empty for classic load balancers
+	LoadBalancerID string
+	Date           string    // Date the log file ends in UTC, formatted as 2006-01-02
+	EndTime        time.Time // End of the interval of the log file
+}
+
+// ParseELBObjectKey parses the file name of an ELB access log object. Both the names of application and network
+// load balancers (prefixed with their type and followed by their ID) and of classic load balancers are supported.
+func ParseELBObjectKey(key string) (ELBObjectKey, error) {
+	// Local files on Windows have backslashes in their path, which never occur in the name of ELB log files
+	parts := strings.Split(key[strings.LastIndexAny(key, `/\`)+1:], "_")
+	if len(parts) < 5 || parts[1] != "elasticloadbalancing" {
+		return ELBObjectKey{}, fmt.Errorf("not an ELB access log key '%s'", key)
+	}
+	endTime, err := time.Parse("20060102T1504Z", parts[4])
+	if err != nil {
+		return ELBObjectKey{}, fmt.Errorf("not an ELB access log key '%s'", key)
+	}
+	loadBalancer, loadBalancerID := parts[3], ""
+	if lbParts := strings.Split(loadBalancer, "."); len(lbParts) == 3 {
+		loadBalancer = lbParts[1]
+		loadBalancerID = strings.Join(lbParts, "/")
+	}
+
+	return ELBObjectKey{
+		AccountID:      parts[0],
+		Region:         parts[2],
+		LoadBalancer:   loadBalancer,
+		LoadBalancerID: loadBalancerID,
+		Date:           endTime.Format(time.DateOnly),
+		EndTime:        endTime,
+	}, nil
+}
+
+// LoadBalancerARN returns the ARN of the load balancer of an application or network load balancer, or an empty
+// string for classic load balancers
+func (k ELBObjectKey) LoadBalancerARN() string {
+	if k.LoadBalancerID == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("arn:%s:elasticloadbalancing:%s:%s:loadbalancer/%s", regionPartition(k.Region), k.Region, k.AccountID, k.LoadBalancerID)
+}
+
+// regionPartition returns the partition of a region for its ARNs, such as aws-us-gov for us-gov-west-1 and aws-cn
+// for cn-north-1. Regions the SDK doesn't know are taken to be in the aws partition.
+func regionPartition(region string) string {
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return partition.ID()
+	}
+
+	return endpoints.AwsPartitionID
+}
+
+// isLogNameTemplate reports whether a log group or stream name contains placeholders
+func isLogNameTemplate(name string) bool {
+	return strings.Contains(name, "{")
+}
+
+// dayPlaceholder is replaced with the UTC date of every entry, unlike {date} which is the date of the log file
+const dayPlaceholder = "{day}"
+
+// isObjectTemplate reports whether a log group or stream name contains placeholders taken from the object key
+func isObjectTemplate(name string) bool {
+	return isLogNameTemplate(strings.ReplaceAll(name, dayPlaceholder, ""))
+}
+
+// expandDay replaces the {day} placeholder in a log group or stream name with the UTC date of a timestamp
+func expandDay(name string, timestamp time.Time) string {
+	if !strings.Contains(name, dayPlaceholder) {
+		return name
+	}
+
+	return strings.ReplaceAll(name, dayPlaceholder, timestamp.UTC().Format(time.DateOnly))
+}
+
+// expandLogName replaces the {account}, {region}, {elb} and {date} placeholders in a log group or stream name
+func expandLogName(template string, key ELBObjectKey) string {
+	return strings.NewReplacer(
+		"{account}", key.AccountID,
+		"{region}", key.Region,
+		"{elb}", key.LoadBalancer,
+		"{date}", key.Date,
+	).Replace(template)
+}
+
+// ObjectDestination routes the entries of an object to the log group and stream derived from its key. Entries that
+// were already routed to a stream by another transformer, such as by target group, keep their stream.
+type ObjectDestination struct {
+	Destination LogConfig
+}
+
+func (d *ObjectDestination) Transform(record []string, entry *LogEntry) {
+	if entry.Destination.LogGroupName == "" {
+		entry.Destination.LogGroupName = d.Destination.LogGroupName
+		entry.Destination.RoleARN = d.Destination.RoleARN
+	}
+	if entry.Destination.LogStreamName == "" {
+		entry.Destination.LogStreamName = d.Destination.LogStreamName
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseELBObjectKey(t *testing.T) {
+	t.Run("Application load balancer", func(t *testing.T) {
+		key, err := ParseELBObjectKey("AWSLogs/123456789012/elasticloadbalancing/eu-west-1/2024/01/01/123456789012_elasticloadbalancing_eu-west-1_app.my-lb.1234567890abcdef_20240101T0005Z_10.0.0.1_2x9kdk1n.log.gz")
+		require.NoError(t, err)
+		assert.Equal(t, ELBObjectKey{
+			AccountID:      "123456789012",
+			Region:         "eu-west-1",
+			LoadBalancer:   "my-lb",
+			LoadBalancerID: "app/my-lb/1234567890abcdef",
+			Date:           "2024-01-01",
+			EndTime:        time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC),
+		}, key)
+		assert.Equal(t, "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/my-lb/1234567890abcdef", key.LoadBalancerARN())
+	})
+
+	t.Run("Partitions", func(t *testing.T) {
+		for region, arn := range map[string]string{
+			"us-gov-west-1": "arn:aws-us-gov:elasticloadbalancing:us-gov-west-1:123456789012:loadbalancer/app/my-lb/1234567890abcdef",
+			"cn-north-1":    "arn:aws-cn:elasticloadbalancing:cn-north-1:123456789012:loadbalancer/app/my-lb/1234567890abcdef",
+			"eu-south-2":    "arn:aws:elasticloadbalancing:eu-south-2:123456789012:loadbalancer/app/my-lb/1234567890abcdef",
+		} {
+			key := ELBObjectKey{AccountID: "123456789012", Region: region, LoadBalancerID: "app/my-lb/1234567890abcdef"}
+			assert.Equal(t, arn, key.LoadBalancerARN(), region)
+		}
+	})
+
+	t.Run("Classic load balancer", func(t *testing.T) {
+		key, err := ParseELBObjectKey("123456789012_elasticloadbalancing_us-east-1_my-classic-lb_20240215T2340Z_172.160.1.192_20sg8hgm.log")
+		require.NoError(t, err)
+		assert.Equal(t, "my-classic-lb", key.LoadBalancer)
+		assert.Empty(t, key.LoadBalancerARN())
+		assert.Equal(t, "2024-02-15", key.Date)
+	})
+
+	t.Run("Local Windows path", func(t *testing.T) {
+		key, err := ParseELBObjectKey(`C:\logs\2024_03\123456789012_elasticloadbalancing_eu-west-1_app.my-lb.1234567890abcdef_20240321T1615Z_10.0.0.1_abc.log.gz`)
+		require.NoError(t, err)
+		assert.Equal(t, "123456789012", key.AccountID)
+		assert.Equal(t, "my-lb", key.LoadBalancer)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, key := range []string{"", "logs/access.log.gz", "123456789012_elasticloadbalancing_eu-west-1_app.my-lb.1234567890abcdef_yesterday_10.0.0.1.log.gz"} {
+			_, err := ParseELBObjectKey(key)
+			assert.Error(t, err, key)
+		}
+	})
+}
+
+func TestExpandLogName(t *testing.T) {
+	key := ELBObjectKey{AccountID: "123456789012", Region: "eu-west-1", LoadBalancer: "my-lb", Date: "2024-01-01"}
+
+	assert.True(t, isLogNameTemplate("{elb}/{date}"))
+	assert.False(t, isLogNameTemplate("my-stream"))
+	assert.Equal(t, "my-lb/2024-01-01", expandLogName("{elb}/{date}", key))
+	assert.Equal(t, "/elb/123456789012/eu-west-1", expandLogName("/elb/{account}/{region}", key))
+
+	assert.True(t, isObjectTemplate("{elb}/{day}"))
+	assert.False(t, isObjectTemplate("history/{day}"))
+	assert.Equal(t, "my-lb/2024-03-21", expandDay("my-lb/{day}", time.Date(2024, 3, 22, 1, 0, 0, 0, time.FixedZone("CET", 2*60*60))))
+	assert.Equal(t, "my-stream", expandDay("my-stream", time.Now()))
+}
+
+func TestObjectDestination(t *testing.T) {
+	transformer := &ObjectDestination{Destination: LogConfig{LogGroupName: "/aws/elb/my-lb", LogStreamName: "my-lb/2024-01-01"}}
+
+	entry := LogEntry{Data: map[string]interface{}{}}
+	transformer.Transform(nil, &entry)
+	assert.Equal(t, LogConfig{LogGroupName: "/aws/elb/my-lb", LogStreamName: "my-lb/2024-01-01"}, entry.Destination)
+
+	routed := LogEntry{Data: map[string]interface{}{}, Destination: LogConfig{LogStreamName: "my-service"}}
+	transformer.Transform(nil, &routed)
+	assert.Equal(t, LogConfig{LogGroupName: "/aws/elb/my-lb", LogStreamName: "my-service"}, routed.Destination)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Processing orders of the CLI, see sortObjects
+const (
+	orderListed      = ""
+	orderNewestFirst = "newest-first"
+	orderOldestFirst = "oldest-first"
+)
+
+// ParseObjectOrder validates the value of --order
+func ParseObjectOrder(value string) (string, error) {
+	switch value {
+	case orderListed, orderNewestFirst, orderOldestFirst:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid order '%s', expected %s or %s", value, orderNewestFirst, orderOldestFirst)
+	}
+}
+
+// sortObjects sorts the objects by the end time in their ELB access log file name, as objects are processed in
+// the order of the slice. Objects with other names have no time and are sorted by key, before the log files when
+// oldest first and after them when newest first. The listed order is kept for orderListed.
+func sortObjects(s3Objects []S3ObjectInfo, order string) {
+	if order == orderListed {
+		return
+	}
+	less := func(a, b S3ObjectInfo) bool {
+		keyA, _ := ParseELBObjectKey(a.Key)
+		keyB, _ := ParseELBObjectKey(b.Key)
+		if !keyA.EndTime.Equal(keyB.EndTime) {
+			return keyA.EndTime.Before(keyB.EndTime)
+		}
+		return a.Key < b.Key
+	}
+	sort.SliceStable(s3Objects, func(i, j int) bool {
+		if order == orderNewestFirst {
+			return less(s3Objects[j], s3Objects[i])
+		}
+		return less(s3Objects[i], s3Objects[j])
+	})
+}
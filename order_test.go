@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortObjects(t *testing.T) {
+	keys := func(s3Objects []S3ObjectInfo) []string {
+		var keys []string
+		for _, s3Object := range s3Objects {
+			keys = append(keys, s3Object.Key)
+		}
+		return keys
+	}
+	const (
+		march21 = "AWSLogs/123456789012/elasticloadbalancing/eu-west-1/2024/03/21/123456789012_elasticloadbalancing_eu-west-1_app.my-lb.1234567890abcdef_20240321T2355Z_10.0.0.1_abc.log.gz"
+		march22 = "AWSLogs/123456789012/elasticloadbalancing/eu-west-1/2024/03/22/123456789012_elasticloadbalancing_eu-west-1_app.my-lb.1234567890abcdef_20240322T0005Z_10.0.0.1_abc.log.gz"
+		// Another load balancer in a prefix listed before the first one
+		march21Other = "AWSLogs/123456789012/elasticloadbalancing/eu-central-1/2024/03/21/123456789012_elasticloadbalancing_eu-central-1_app.other-lb.1234567890abcdef_20240321T2300Z_10.0.0.1_abc.log.gz"
+		local        = "access.log"
+	)
+	listed := []S3ObjectInfo{{Key: march21Other}, {Key: march21}, {Key: local}, {Key: march22}}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{orderListed, []string{march21Other, march21, local, march22}},
+		{orderOldestFirst, []string{local, march21Other, march21, march22}},
+		{orderNewestFirst, []string{march22, march21, march21Other, local}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			s3Objects := append([]S3ObjectInfo(nil), listed...)
+			sortObjects(s3Objects, tt.order)
+			assert.Equal(t, tt.want, keys(s3Objects))
+		})
+	}
+
+	_, err := ParseObjectOrder("random")
+	assert.ErrorContains(t, err, "invalid order 'random'")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// listOrgPrefixes returns the prefixes AWSLogs/<account-id>/elasticloadbalancing/<region>/ under the root prefix of
+// a central log bucket, limited to the configured accounts and regions if set
+func (h *Handler) listOrgPrefixes(bucket, root string) ([]string, error) {
+	accountPrefixes, err := h.listCommonPrefixes(bucket, root+"AWSLogs/")
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for _, accountPrefix := range accountPrefixes {
+		accountID := lastPathSegment(accountPrefix)
+		if !selected(h.config.Accounts, accountID) {
+			continue
+		}
+		regionPrefixes, err := h.listCommonPrefixes(bucket, accountPrefix+"elasticloadbalancing/")
+		if err != nil {
+			return nil, err
+		}
+		for _, regionPrefix := range regionPrefixes {
+			if selected(h.config.Regions, lastPathSegment(regionPrefix)) {
+				prefixes = append(prefixes, regionPrefix)
+			}
+		}
+	}
+
+	return prefixes, nil
+}
+
+// listOrgObjects lists the objects of all selected accounts and regions under the root prefix of an S3 URL.
+// The date, formatted as 2006/01/02 or a prefix of it, limits the objects to that period.
+func (h *Handler) listOrgObjects(url, date string) ([]S3ObjectInfo, error) {
+	bucket, root, err := ParseS3URL(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse S3 URL: %v", err)
+	}
+	prefixes, err := h.listOrgPrefixes(bucket, root)
+	if err != nil {
+		return nil, err
+	}
+	var s3Objects []S3ObjectInfo
+	for _, prefix := range prefixes {
+		objects, _, err := h.listS3Objects(bucket, prefix+date, "", 0)
+		if err != nil {
+			return nil, err
+		}
+		s3Objects = append(s3Objects, objects...)
+	}
+
+	return s3Objects, nil
+}
+
+// listCommonPrefixes lists the "subdirectories" directly under a prefix
+func (h *Handler) listCommonPrefixes(bucket, prefix string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+	var prefixes []string
+	for {
+		resp, err := h.s3Client.ListObjectsV2(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prefixes: %v", err)
+		}
+		for _, commonPrefix := range resp.CommonPrefixes {
+			prefixes = append(prefixes, aws.StringValue(commonPrefix.Prefix))
+		}
+		if !aws.BoolValue(resp.IsTruncated) {
+			return prefixes, nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
+// lastPathSegment returns the last segment of a prefix ending with a slash, e.g. "123" for "AWSLogs/123/"
+func lastPathSegment(prefix string) string {
+	segments := strings.Split(strings.TrimSuffix(prefix, "/"), "/")
+	return segments[len(segments)-1]
+}
+
+// selected reports whether a value is in the selection, an empty selection selects everything
+func selected(selection []string, value string) bool {
+	if len(selection) == 0 {
+		return true
+	}
+	for _, s := range selection {
+		if s == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AccountIDField adds the ID of the account owning the load balancer, taken from the object key, as account_id
+type AccountIDField struct {
+	AccountID string
+}
+
+func (f *AccountIDField) Transform(record []string, entry *LogEntry) {
+	entry.Data["account_id"] = f.AccountID
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListOrgObjects(t *testing.T) {
+	commonPrefixes := func(prefixes ...string) *s3.ListObjectsV2Output {
+		output := &s3.ListObjectsV2Output{}
+		for _, prefix := range prefixes {
+			output.CommonPrefixes = append(output.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(prefix)})
+		}
+		return output
+	}
+	listInput := func(prefix string, delimiter bool) interface{} {
+		return mock.MatchedBy(func(input *s3.ListObjectsV2Input) bool {
+			return *input.Prefix == prefix && (input.Delimiter != nil) == delimiter
+		})
+	}
+	mockS3Api := new(MockS3Api)
+	mockS3Api.On("ListObjectsV2", listInput("root/AWSLogs/", true)).Return(commonPrefixes("root/AWSLogs/111/", "root/AWSLogs/222/"), nil)
+	mockS3Api.On("ListObjectsV2", listInput("root/AWSLogs/111/elasticloadbalancing/", true)).Return(commonPrefixes(
+		"root/AWSLogs/111/elasticloadbalancing/eu-west-1/",
+		"root/AWSLogs/111/elasticloadbalancing/us-east-1/",
+	), nil)
+	mockS3Api.On("ListObjectsV2", listInput("root/AWSLogs/111/elasticloadbalancing/eu-west-1/2024/01/01", false)).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{{Key: aws.String("root/AWSLogs/111/elasticloadbalancing/eu-west-1/2024/01/01/object")}},
+	}, nil)
+
+	h := &Handler{s3Client: mockS3Api, config: Config{Accounts: []string{"111"}, Regions: []string{"eu-west-1"}}}
+	s3Objects, err := h.listOrgObjects("s3://bucket/root/", "2024/01/01")
+	require.NoError(t, err)
+	assert.Equal(t, []S3ObjectInfo{{Bucket: "bucket", Key: "root/AWSLogs/111/elasticloadbalancing/eu-west-1/2024/01/01/object"}}, s3Objects)
+
+	mockS3Api.AssertNotCalled(t, "ListObjectsV2", listInput("root/AWSLogs/222/elasticloadbalancing/", true))
+}
+
+func TestAccountIDField(t *testing.T) {
+	entry := LogEntry{Data: map[string]interface{}{}}
+	(&AccountIDField{AccountID: "111"}).Transform(nil, &entry)
+
+	assert.Equal(t, "111", entry.Data["account_id"])
+}
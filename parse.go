@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// parseChunkSize is the approximate size of the chunks of a log file that are parsed concurrently, chunks end
+// at a line boundary
+const parseChunkSize = 1 << 20
+
+// recordStage applies the filters and transformers to the records of a log file in order. Filters and
+// transformers may keep state, so the stage is never run concurrently.
+type recordStage struct {
+	filters      []Filter
+	transformers []Transformer
+	invalid      InvalidRecordHandler // nil fails on the first invalid record
+	records      int                  // Number of records seen, including dropped records
+}
+
+func newRecordStage(transformers []Transformer) *recordStage {
+	stage := &recordStage{transformers: transformers}
+	for _, transformer := range transformers {
+		if filter, ok := transformer.(Filter); ok {
+			stage.filters = append(stage.filters, filter)
+		}
+		if handler, ok := transformer.(InvalidRecordHandler); ok {
+			stage.invalid = handler
+		}
+	}
+
+	return stage
+}
+
+// keep counts the record and reports whether it passes all filters
+func (s *recordStage) keep(record []string) bool {
+	s.records++
+	for _, filter := range s.filters {
+		if !filter.Keep(record) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// invalidRecord handles a record of which no entry could be created, a nil error skips the record
+func (s *recordStage) invalidRecord(record []string, err error) error {
+	if s.invalid == nil {
+		return err
+	}
+
+	return s.invalid.Invalid(s.records, record, err)
+}
+
+// unencodable handles an entry that failed to encode, a nil error skips the entry
+func (s *recordStage) unencodable(entry LogEntry, err error) error {
+	if s.invalid == nil {
+		return err
+	}
+
+	return s.invalid.Unencodable(entry.Record+1, err)
+}
+
+// transform runs the transformers on the entry of the record last passed to keep
+func (s *recordStage) transform(record []string, entry *LogEntry) {
+	entry.Record = s.records - 1
+	for _, transformer := range s.transformers {
+		transformer.Transform(record, entry)
+	}
+}
+
+// ParallelRecordParser parses large log files with multiple workers. The decompressed stream is split into
+// chunks on line boundaries, the workers split the records of a chunk into fields and create their entries, the
+// filters and transformers run on the entries in the original order, and the workers encode the entries of the
+// chunk. The entries are sent in the same order and are the same as with a RecordParser.
+type ParallelRecordParser struct {
+	Fields  Fields
+	Layouts TimestampLayouts
+	Workers int
+}
+
+// parsedRecord is a record of a chunk with its entry, or the error creating the entry
+type parsedRecord struct {
+	record []string
+	entry  LogEntry
+	err    error
+}
+
+// parsedChunk holds the records of a chunk, err is set when splitting the records failed after the last record
+type parsedChunk struct {
+	records []parsedRecord
+	err     error
+}
+
+// parseJob is a chunk to parse, the result is sent to the buffered channel
+type parseJob struct {
+	chunk  []byte
+	result chan parsedChunk
+}
+
+func (p *ParallelRecordParser) Parse(reader io.Reader, entries chan<- LogEntry, transformers []Transformer) error {
+	workers := max(p.Workers, 1)
+	jobs := make(chan parseJob)
+	// Results are received in the order of the chunks, the buffer limits the chunks in flight
+	parsed := make(chan chan parsedChunk, 2*workers)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	var readErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(parsed)
+		defer close(jobs)
+		readErr = readChunks(reader, func(chunk []byte) bool {
+			job := parseJob{chunk: chunk, result: make(chan parsedChunk, 1)}
+			select {
+			case parsed <- job.result:
+			case <-done:
+				return false
+			}
+			jobs <- job
+
+			return true
+		})
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				job.result <- p.parseChunk(job.chunk)
+			}
+		}()
+	}
+
+	// The encoded chunks are sent in order until the first error, entries that failed to encode are handled by
+	// the stage
+	stage := newRecordStage(transformers)
+	encoded := make(chan chan encodedChunk, 2*workers)
+	var sendErr error
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for result := range encoded {
+			chunk := <-result
+			for i := 0; i < len(chunk.entries) && sendErr == nil; i++ {
+				if chunk.errs != nil && chunk.errs[i] != nil {
+					sendErr = stage.unencodable(chunk.entries[i], chunk.errs[i])
+					continue
+				}
+				entries <- chunk.entries[i]
+			}
+		}
+	}()
+
+	err := transformChunks(parsed, encoded, stage)
+	close(encoded)
+	<-sent
+	// Stop reading and wait for the workers, the reader must not be used after Parse returns
+	close(done)
+	for range parsed {
+	}
+	wg.Wait()
+	// An encoding error precedes any error of later records
+	if sendErr != nil {
+		return sendErr
+	}
+	if err == nil && readErr != nil {
+		err = fmt.Errorf("error reading a record: %v", readErr)
+	}
+
+	return err
+}
+
+// encodedChunk holds the entries of a chunk, errs is nil when all entries were encoded
+type encodedChunk struct {
+	entries []LogEntry
+	errs    []error // The error encoding the entry with the same index
+}
+
+// transformChunks runs the record stage on the parsed chunks in order until the first error, and encodes the
+// entries of every chunk concurrently
+func transformChunks(parsed <-chan chan parsedChunk, encoded chan<- chan encodedChunk, stage *recordStage) error {
+	for result := range parsed {
+		chunk := <-result
+		var chunkEntries []LogEntry
+		var err error
+		for _, record := range chunk.records {
+			if !stage.keep(record.record) {
+				continue
+			}
+			if record.err != nil {
+				if err = stage.invalidRecord(record.record, record.err); err != nil {
+					break
+				}
+				continue
+			}
+			stage.transform(record.record, &record.entry)
+			chunkEntries = append(chunkEntries, record.entry)
+		}
+		if err == nil && chunk.err != nil {
+			err = fmt.Errorf("error reading a record: %v", chunk.err)
+		}
+		encodedResult := make(chan encodedChunk, 1)
+		encoded <- encodedResult
+		go func() {
+			encodedResult <- encodeChunk(chunkEntries)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeChunk encodes the entries of a chunk
+func encodeChunk(entries []LogEntry) encodedChunk {
+	chunk := encodedChunk{entries: entries}
+	for i := range entries {
+		if err := entries[i].encode(); err != nil {
+			if chunk.errs == nil {
+				chunk.errs = make([]error, len(entries))
+			}
+			chunk.errs[i] = err
+		}
+	}
+
+	return chunk
+}
+
+// parseChunk splits the records of a chunk into fields and creates their entries
+func (p *ParallelRecordParser) parseChunk(chunk []byte) parsedChunk {
+	csvReader := csv.NewReader(bytes.NewReader(chunk))
+	csvReader.Comma = ' '
+	// The number of fields is checked when creating the entry, a chunk may start at any record
+	csvReader.FieldsPerRecord = -1
+	var parsed parsedChunk
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return parsed
+		}
+		if err != nil {
+			parsed.err = err
+			return parsed
+		}
+		entry, err := recordToLogEntry(record, p.Fields, p.Layouts)
+		parsed.records = append(parsed.records, parsedRecord{record: record, entry: entry, err: err})
+	}
+}
+
+// readChunks reads chunks of about parseChunkSize bytes that end at a line boundary and passes them to handle,
+// until the reader is exhausted or handle returns false
+func readChunks(reader io.Reader, handle func(chunk []byte) bool) error {
+	buffered := bufio.NewReaderSize(reader, parseChunkSize)
+	for {
+		chunk := make([]byte, parseChunkSize)
+		n, err := io.ReadFull(buffered, chunk)
+		chunk = chunk[:n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if n > 0 {
+				handle(chunk)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Complete the last line of the chunk
+		rest, err := buffered.ReadBytes('\n')
+		chunk = append(chunk, rest...)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if !handle(chunk) || err != nil {
+			return nil
+		}
+	}
+}
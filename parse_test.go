@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRecordLine returns a log line with a unique trace ID
+func testRecordLine(i int) string {
+	return fmt.Sprintf(`https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify?user_ids=xxxxx4-xxxx-xxxx-xxxx-xxxxxxxxxxxx&ref_date= HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4 "Root=1-xxxxxx4-%024d" "example.com" "arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`, i)
+}
+
+// parseAll collects the entries of a parser, the transformers are created for every run
+func parseAll(t *testing.T, parser Parser, data string, transformers func() []Transformer) ([]LogEntry, error) {
+	entries := make(chan LogEntry)
+	var collected []LogEntry
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for entry := range entries {
+			collected = append(collected, entry)
+		}
+	}()
+	err := parser.Parse(strings.NewReader(data), entries, transformers())
+	close(entries)
+	<-done
+
+	return collected, err
+}
+
+func TestParallelRecordParser(t *testing.T) {
+	fieldStore, err := NewFields("")
+	require.NoError(t, err)
+	// Spans multiple chunks, with duplicates and an empty line
+	var lines []string
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, testRecordLine(i%4000))
+		if i == 2500 {
+			lines = append(lines, "")
+		}
+	}
+	data := strings.Join(lines, "\n") + "\n"
+	require.Greater(t, len(data), 2*parseChunkSize)
+	transformers := func() []Transformer {
+		return []Transformer{&ResumeFilter{Records: 10}, &Sampler{Rate: 0.5}, &Deduplicator{Window: 5000}, &TraceFields{}}
+	}
+
+	t.Run("Same entries as sequential parsing", func(t *testing.T) {
+		want, err := parseAll(t, &RecordParser{Fields: fieldStore}, data, transformers)
+		require.NoError(t, err)
+		got, err := parseAll(t, &ParallelRecordParser{Fields: fieldStore, Workers: 4}, data, transformers)
+		require.NoError(t, err)
+		require.NotEmpty(t, want)
+		assert.Equal(t, len(want), len(got))
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("Entries before an invalid record are sent", func(t *testing.T) {
+		invalid := strings.Join(lines[:2000], "\n") + "\n" + `https "unterminated` + "\n" + strings.Join(lines[2000:], "\n")
+		got, err := parseAll(t, &ParallelRecordParser{Fields: fieldStore, Workers: 4}, invalid, func() []Transformer { return nil })
+		require.Error(t, err)
+		assert.Len(t, got, 2000)
+	})
+
+	t.Run("Invalid entry", func(t *testing.T) {
+		invalid := strings.Join(lines[:10], "\n") + "\nhttps 2024-03-21T16:10:26Z\n" + strings.Join(lines[10:20], "\n")
+		got, err := parseAll(t, &ParallelRecordParser{Fields: fieldStore, Workers: 2}, invalid, func() []Transformer { return nil })
+		require.Error(t, err)
+		assert.Equal(t, fmt.Sprintf("invalid log format: expected %d fields, got 2", len(fieldNames)), err.Error())
+		assert.Len(t, got, 10)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		got, err := parseAll(t, &ParallelRecordParser{Fields: fieldStore, Workers: 2}, "", func() []Transformer { return nil })
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+}
+
+func TestReadChunks(t *testing.T) {
+	line := testRecordLine(0) + "\n"
+	// The last line has no trailing newline
+	data := strings.Repeat(line, 2*parseChunkSize/len(line)+10) + "last"
+	var chunks [][]byte
+	require.NoError(t, readChunks(strings.NewReader(data), func(chunk []byte) bool {
+		chunks = append(chunks, chunk)
+		return true
+	}))
+	require.Len(t, chunks, 3)
+	for _, chunk := range chunks[:2] {
+		assert.True(t, bytes.HasSuffix(chunk, []byte("\n")))
+		assert.True(t, bytes.HasPrefix(chunk, []byte("https ")))
+	}
+	assert.Equal(t, data, string(bytes.Join(chunks, nil)))
+
+	// Reading stops when a chunk is not handled
+	var handled int
+	require.NoError(t, readChunks(strings.NewReader(data), func(chunk []byte) bool {
+		handled++
+		return false
+	}))
+	assert.Equal(t, 1, handled)
+}
+
+// repeatReader repeats a line until size bytes have been read, a multiple of the line length, to benchmark large files without holding them in memory
+type repeatReader struct {
+	line []byte
+	size int
+	read int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.read >= r.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && r.read < r.size {
+		c := copy(p[n:min(len(p), n+r.size-r.read)], r.line[r.read%len(r.line):])
+		n += c
+		r.read += c
+	}
+
+	return n, nil
+}
+
+// benchmarkParser parses a 256 MB log file into a channel with the given buffer, e.g. go test -bench Parser -benchtime 3x
+func benchmarkParser(b *testing.B, parser Parser, buffer int) {
+	line := []byte(testRecordLine(0) + "\n")
+	size := (256 << 20) / len(line) * len(line)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries := make(chan LogEntry, buffer)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for range entries {
+			}
+		}()
+		require.NoError(b, parser.Parse(&repeatReader{line: line, size: size}, entries, nil))
+		close(entries)
+		<-done
+	}
+}
+
+func BenchmarkRecordParser(b *testing.B) {
+	fieldStore, err := NewFields("")
+	require.NoError(b, err)
+	benchmarkParser(b, &RecordParser{Fields: fieldStore}, 1000)
+}
+
+func BenchmarkParallelRecordParser(b *testing.B) {
+	fieldStore, err := NewFields("")
+	require.NoError(b, err)
+	for _, workers := range []int{2, 4, 8} {
+		b.Run(fmt.Sprintf("%d workers", workers), func(b *testing.B) {
+			benchmarkParser(b, &ParallelRecordParser{Fields: fieldStore, Workers: workers}, 1000)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+// maxProfileParseWorkers limits the parse workers a profile picks, more workers mostly add contention on the
+// entry channel as sending to CloudWatch can't keep up
+const maxProfileParseWorkers = 4
+
+// PerformanceProfile holds the defaults of the settings whose best value depends on the CPU architecture. It is
+// part of the effective configuration, so the startup log shows which profile was chosen.
+type PerformanceProfile struct {
+	Name string
+	// ParseWorkers is the default of PARSE_WORKERS
+	ParseWorkers int
+	// EntryBuffer is the number of parsed entries buffered per object before they are sent, see Pipeline
+	EntryBuffer int
+}
+
+// performanceProfile returns the profile for an architecture (runtime.GOARCH) and number of CPUs. A vCPU of an
+// arm64 (Graviton) machine is a physical core, so parsing a large object in parallel scales with the vCPUs, and
+// a larger buffer keeps the workers busy while a batch is sent. On other architectures a vCPU is usually a
+// hyperthread sharing a core, where a single parser is more efficient. Compare the profiles on your hardware
+// with go test -run - -bench PerformanceProfiles -benchtime 3x.
+func performanceProfile(arch string, cpus int) PerformanceProfile {
+	if arch == "arm64" && cpus > 1 {
+		return PerformanceProfile{
+			Name:         "arm64",
+			ParseWorkers: min(cpus, maxProfileParseWorkers),
+			EntryBuffer:  2 * maxBatchCount,
+		}
+	}
+
+	return PerformanceProfile{Name: "default", ParseWorkers: 1, EntryBuffer: defaultEntryBuffer}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerformanceProfile(t *testing.T) {
+	assert.Equal(t, PerformanceProfile{Name: "default", ParseWorkers: 1, EntryBuffer: defaultEntryBuffer}, performanceProfile("amd64", 8))
+	assert.Equal(t, PerformanceProfile{Name: "default", ParseWorkers: 1, EntryBuffer: defaultEntryBuffer}, performanceProfile("arm64", 1))
+	assert.Equal(t, PerformanceProfile{Name: "arm64", ParseWorkers: 2, EntryBuffer: 2 * maxBatchCount}, performanceProfile("arm64", 2))
+	assert.Equal(t, PerformanceProfile{Name: "arm64", ParseWorkers: 4, EntryBuffer: 2 * maxBatchCount}, performanceProfile("arm64", 6))
+
+	// The memory limit takes precedence over the buffer of the profile
+	assert.Equal(t, 2*maxBatchCount, entryBuffer(0, 2*maxBatchCount))
+	assert.Equal(t, maxBatchCount/4, entryBuffer(115<<20, 2*maxBatchCount))
+
+	t.Run("Defaults", func(t *testing.T) {
+		t.Setenv("LOG_GROUP_NAME", "test-log-group")
+		t.Setenv("LOG_STREAM_NAME", "test-log-stream")
+		config, err := LoadConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, performanceProfile(runtime.GOARCH, runtime.NumCPU()), config.Performance)
+		assert.Equal(t, config.Performance.ParseWorkers, config.ParseWorkers)
+
+		t.Setenv("PARSE_WORKERS", "3")
+		config, err = LoadConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, 3, config.ParseWorkers)
+	})
+}
+
+// BenchmarkPerformanceProfiles parses a 256 MB log file with the settings of every profile for 4 vCPUs. On a
+// single-core amd64 Xeon, with go1.27 and -benchtime 3x -cpu 1,4, the arm64 profile is as fast as the default one
+// and takes 28% more memory for its chunks and buffer, so it costs little where it doesn't help:
+//
+//	BenchmarkPerformanceProfiles/amd64      11962043984 ns/op  22.44 MB/s  3568343378 B/op  44671154 allocs/op
+//	BenchmarkPerformanceProfiles/amd64-4    12776850728 ns/op  21.01 MB/s  3572702538 B/op  44681763 allocs/op
+//	BenchmarkPerformanceProfiles/arm64      10963472641 ns/op  24.48 MB/s  4583521685 B/op  44684137 allocs/op
+//	BenchmarkPerformanceProfiles/arm64-4    11523247943 ns/op  23.30 MB/s  4583542370 B/op  44684418 allocs/op
+//
+// Add the numbers of a Graviton machine, where the arm64 profile should be faster, when they are measured.
+func BenchmarkPerformanceProfiles(b *testing.B) {
+	fieldStore, err := NewFields("")
+	require.NoError(b, err)
+	for _, arch := range []string{"amd64", "arm64"} {
+		profile := performanceProfile(arch, 4)
+		b.Run(arch, func(b *testing.B) {
+			var parser Parser = &RecordParser{Fields: fieldStore}
+			if profile.ParseWorkers > 1 {
+				parser = &ParallelRecordParser{Fields: fieldStore, Workers: profile.ParseWorkers}
+			}
+			benchmarkParser(b, parser, profile.EntryBuffer)
+		})
+	}
+}
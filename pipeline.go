@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Parser parses a decompressed log file into entries, passing every entry through the transformers
+type Parser interface {
+	Parse(reader io.Reader, entries chan<- LogEntry, transformers []Transformer) error
+}
+
+// RecordParser parses the space separated records of access logs, see processRecords
+type RecordParser struct {
+	Fields  Fields
+	Layouts TimestampLayouts
+}
+
+func (p *RecordParser) Parse(reader io.Reader, entries chan<- LogEntry, transformers []Transformer) error {
+	return processRecords(reader, entries, p.Fields, p.Layouts, transformers)
+}
+
+// Sink sends the entries of an object to their destination
+type Sink interface {
+	// Send consumes the entries until the channel is closed and returns the first error, entries
+	// received after an error are discarded
+	Send(entries <-chan LogEntry) error
+	// Sent returns the number of entries and bytes that were sent
+	Sent() (entries int, bytes int64)
+}
+
+// gzipMagic are the first bytes of gzip compressed data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// errDecompressedTooLarge is returned by gunzip when the decompressed data exceeds the limit
+var errDecompressedTooLarge = errors.New("decompressed data exceeds the limit")
+
+// gunzip decompresses gzipped data of up to limit bytes after decompression, or without limit when 0. Other data
+// is returned as is.
+func gunzip(data []byte, limit int64) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	if limit == 0 {
+		return io.ReadAll(gz)
+	}
+	// A byte more than the limit tells whether it is exceeded
+	decompressed, err := io.ReadAll(io.LimitReader(gz, limit+1))
+	if err == nil && int64(len(decompressed)) > limit {
+		return nil, fmt.Errorf("%w of %d bytes", errDecompressedTooLarge, limit)
+	}
+
+	return decompressed, err
+}
+
+// defaultEntryBuffer is 1.25 times the max batch count, so parsing doesn't block while a full batch is sent
+const defaultEntryBuffer = maxBatchCount * 5 / 4
+
+// Pipeline processes a single gzipped log file in stages: the Source opens the object, which is decompressed and
+// parsed into entries by the Parser, and the entries pass through the transformers to the Sink. Decompression,
+// parsing and sending of the object run concurrently. A parse error stops parsing but the entries parsed before it
+// are still sent, an error of the Sink fails the run. Records of which no entry can be created are skipped and
+// counted, unless Strict, which fails the run on such a record or on any other parse error. Entries that can't be
+// encoded are skipped and counted unless Strict or StrictSending. Running objects concurrently, limiting them and
+// what to do with failed objects is up to the caller, such as runS3Objects.
+type Pipeline struct {
+	Source        Source
+	Parser        Parser
+	Strict        bool
+	StrictSending bool
+	// EntryBuffer is the number of parsed entries buffered before they are sent, 0 uses defaultEntryBuffer
+	EntryBuffer int
+	// Stages returns the transformers and the sink for an opened object, both may keep per-object state
+	Stages func(object S3ObjectInfo, metadata ObjectMetadata) ([]Transformer, Sink)
+}
+
+// PipelineResult describes a run of a pipeline for a single object
+type PipelineResult struct {
+	ObjectResult
+	Summary map[string]int // Statistics of the transformers, see Summarizer
+}
+
+// Run processes a single object. ErrEmptyObject is returned for objects without any data.
+func (p *Pipeline) Run(object S3ObjectInfo) (PipelineResult, error) {
+	body, metadata, err := p.Source.Open(object)
+	if err != nil {
+		return PipelineResult{}, fmt.Errorf("failed to get object: %v", err)
+	}
+	defer body.Close()
+	if metadata.Size != nil && *metadata.Size == 0 {
+		return PipelineResult{}, ErrEmptyObject
+	}
+
+	reader, writer := io.Pipe()
+
+	// Decompress the gzip file in a goroutine, data without a gzip header is passed as is, the number of decompressed bytes is sent when done (-1 on error)
+	decompressed := make(chan int64, 1)
+	hash := sha256.New()
+	compressed := &countingReader{r: io.TeeReader(body, hash)}
+	go func() {
+		var n int64
+		defer func() { decompressed <- n }()
+		buffered := bufio.NewReader(compressed)
+		magic, err := buffered.Peek(2)
+		if len(magic) == 0 && err == io.EOF {
+			// Empty file without a gzip header
+			writer.Close()
+
+			return
+		}
+		var decompressor io.Reader = buffered
+		if bytes.Equal(magic, gzipMagic) {
+			gzipReader, err := gzip.NewReader(buffered)
+			if err != nil {
+				n = -1
+				writer.CloseWithError(err)
+
+				return
+			}
+			defer gzipReader.Close()
+			decompressor = gzipReader
+		}
+		// Copy decompressed data to writer
+		if n, err = io.Copy(writer, decompressor); err != nil {
+			n = -1
+			writer.CloseWithError(err)
+
+			return
+		}
+		// Read any trailing data so the hash covers the whole object
+		_, _ = io.Copy(io.Discard, compressed)
+		writer.Close()
+	}()
+
+	transformers, sink := p.Stages(object, metadata)
+	stats := &objectStats{}
+	transformers = append(transformers, stats, &InvalidRecords{Strict: p.Strict, StrictSending: p.StrictSending})
+
+	buffer := p.EntryBuffer
+	if buffer == 0 {
+		buffer = defaultEntryBuffer
+	}
+	entryChan := make(chan LogEntry, buffer)
+	var sendErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sendErr = sink.Send(entryChan)
+	}()
+
+	parseErr := p.Parser.Parse(reader, entryChan, transformers)
+	if parseErr != nil {
+		log.Println("error processing records", parseErr)
+	}
+
+	close(entryChan)
+	wg.Wait()
+	// Drain the pipe in case parsing stopped early, so the decompression goroutine can finish
+	_, _ = io.Copy(io.Discard, reader)
+	decompressedBytes := <-decompressed
+	if decompressedBytes == 0 {
+		return PipelineResult{}, ErrEmptyObject
+	}
+	entries, sentBytes := sink.Sent()
+	result := PipelineResult{
+		ObjectResult: ObjectResult{
+			Entries:           entries,
+			CompressedBytes:   compressed.n,
+			DecompressedBytes: max(decompressedBytes, 0),
+			SentBytes:         sentBytes,
+			ETag:              metadata.ETag,
+			SHA256:            hex.EncodeToString(hash.Sum(nil)),
+			ServerErrors:      stats.serverErrors,
+			FirstTimestamp:    stats.first,
+			LastTimestamp:     stats.last,
+		},
+		Summary: summarize(transformers),
+	}
+	if s, ok := sink.(Summarizer); ok {
+		s.Summarize(result.Summary)
+	}
+
+	strict := p.Strict || p.StrictSending && errors.Is(parseErr, errUnencodable)
+	if strict && parseErr != nil && sendErr == nil {
+		return result, fmt.Errorf("failed to parse records: %v", parseErr)
+	}
+
+	return result, sendErr
+}
+
+// objectStats keeps the earliest and latest timestamp and the number of 5xx responses of the entries of an
+// object, it runs after all other transformers so shifted timestamps are taken into account
+type objectStats struct {
+	first, last  time.Time
+	serverErrors int
+}
+
+func (r *objectStats) Transform(record []string, entry *LogEntry) {
+	if strings.HasPrefix(recordValue(record, "elb_status_code"), "5") {
+		r.serverErrors++
+	}
+	if entry.Timestamp.IsZero() {
+		return
+	}
+	if r.first.IsZero() || entry.Timestamp.Before(r.first) {
+		r.first = entry.Timestamp
+	}
+	if entry.Timestamp.After(r.last) {
+		r.last = entry.Timestamp
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink collects the entries it receives, failing after failAfter entries if set
+type memorySink struct {
+	entries   []LogEntry
+	failAfter int
+}
+
+func (s *memorySink) Send(entries <-chan LogEntry) error {
+	var err error
+	for entry := range entries {
+		if err != nil {
+			continue
+		}
+		if s.failAfter > 0 && len(s.entries) == s.failAfter {
+			err = errors.New("sink is full")
+			continue
+		}
+		s.entries = append(s.entries, entry)
+	}
+
+	return err
+}
+
+func (s *memorySink) Sent() (int, int64) {
+	var size int64
+	for _, entry := range s.entries {
+		size += int64(entry.Size)
+	}
+
+	return len(s.entries), size
+}
+
+// unencodableRecord makes the entry of a record impossible to encode
+type unencodableRecord struct {
+	record int
+}
+
+func (u *unencodableRecord) Transform(record []string, entry *LogEntry) {
+	if entry.Record == u.record {
+		entry.Data["unencodable"] = make(chan int)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	line := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4 "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(strings.Repeat(line+"\n", 3)))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	fieldStore, err := NewFields("elb_status_code")
+	require.NoError(t, err)
+
+	newPipeline := func(body []byte, sink *memorySink) *Pipeline {
+		return &Pipeline{
+			Source: &ReaderSource{Reader: bytes.NewReader(body)},
+			Parser: &RecordParser{Fields: fieldStore},
+			Stages: func(object S3ObjectInfo, metadata ObjectMetadata) ([]Transformer, Sink) {
+				return []Transformer{&SeverityLevel{}, &TLSReporter{}}, sink
+			},
+		}
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		sink := &memorySink{}
+		result, err := newPipeline(buf.Bytes(), sink).Run(S3ObjectInfo{Key: stdinKey})
+		require.NoError(t, err)
+		require.Len(t, sink.entries, 3)
+		assert.Equal(t, `{"elb_status_code":"203","level":"INFO"}`, sink.entries[0].Message)
+		assert.Equal(t, 2, sink.entries[2].Record)
+		assert.Equal(t, 3, result.Entries)
+		assert.Equal(t, int64(buf.Len()), result.CompressedBytes)
+		assert.Equal(t, int64(3*(len(line)+1)), result.DecompressedBytes)
+		assert.Equal(t, int64(3*sink.entries[0].Size), result.SentBytes)
+		assert.Equal(t, map[string]int{"insecure_tls": 0}, result.Summary)
+		sum := sha256.Sum256(buf.Bytes())
+		assert.Equal(t, hex.EncodeToString(sum[:]), result.SHA256)
+		assert.Equal(t, time.Date(2024, 3, 21, 16, 10, 26, 71854000, time.UTC), result.FirstTimestamp.UTC())
+		assert.Equal(t, result.FirstTimestamp, result.LastTimestamp)
+	})
+
+	t.Run("Sink error", func(t *testing.T) {
+		sink := &memorySink{failAfter: 1}
+		result, err := newPipeline(buf.Bytes(), sink).Run(S3ObjectInfo{Key: stdinKey})
+		require.Error(t, err)
+		assert.Equal(t, "sink is full", err.Error())
+		assert.Equal(t, 1, result.Entries)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		_, err := newPipeline(nil, &memorySink{}).Run(S3ObjectInfo{Key: stdinKey})
+		assert.ErrorIs(t, err, ErrEmptyObject)
+	})
+
+	t.Run("Invalid records", func(t *testing.T) {
+		body := []byte(line + "\ninvalid record\n" + line + "\n")
+		sink := &memorySink{}
+		result, err := newPipeline(body, sink).Run(S3ObjectInfo{Key: stdinKey})
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.Entries)
+		assert.Equal(t, 1, result.Summary["invalid_records"])
+
+		strict := newPipeline(body, &memorySink{})
+		strict.Strict = true
+		_, err = strict.Run(S3ObjectInfo{Key: stdinKey})
+		assert.ErrorContains(t, err, "invalid record 2")
+	})
+
+	t.Run("Unencodable entries", func(t *testing.T) {
+		for _, parser := range []Parser{&RecordParser{Fields: fieldStore}, &ParallelRecordParser{Fields: fieldStore, Workers: 2}} {
+			newUnencodable := func(sink *memorySink) *Pipeline {
+				return &Pipeline{
+					Source: &ReaderSource{Reader: bytes.NewReader(buf.Bytes())},
+					Parser: parser,
+					Stages: func(object S3ObjectInfo, metadata ObjectMetadata) ([]Transformer, Sink) {
+						return []Transformer{&unencodableRecord{record: 1}}, sink
+					},
+				}
+			}
+			sink := &memorySink{}
+			result, err := newUnencodable(sink).Run(S3ObjectInfo{Key: stdinKey})
+			require.NoError(t, err)
+			assert.Equal(t, 2, result.Entries)
+			assert.Equal(t, []int{0, 2}, []int{sink.entries[0].Record, sink.entries[1].Record})
+			assert.Equal(t, 1, result.Summary["unencodable_dropped"])
+
+			strict := newUnencodable(&memorySink{})
+			strict.StrictSending = true
+			result, err = strict.Run(S3ObjectInfo{Key: stdinKey})
+			assert.ErrorContains(t, err, "unencodable record 2")
+			assert.Equal(t, 1, result.Entries)
+		}
+	})
+
+	t.Run("Not gzipped", func(t *testing.T) {
+		sink := &memorySink{}
+		result, err := newPipeline([]byte(line+"\n"), sink).Run(S3ObjectInfo{Key: stdinKey})
+		require.NoError(t, err)
+		assert.Len(t, sink.entries, 1)
+		assert.Equal(t, 1, result.Entries)
+		assert.Equal(t, result.CompressedBytes, result.DecompressedBytes)
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// PermissionError is an AccessDenied error of CloudWatch Logs with the action and resource that were denied
+type PermissionError struct {
+	Action   string // E.g. logs:PutLogEvents
+	Resource string // The ARN from the error message, or a description of the destination
+	Err      error
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("missing %s on %s", e.Action, e.Resource)
+}
+
+func (e *PermissionError) Unwrap() error {
+	return e.Err
+}
+
+// deniedResource matches the resource in messages such as "User: arn:aws:sts::...:assumed-role/... is not
+// authorized to perform: logs:PutLogEvents on resource: arn:aws:logs:...:log-group:...:log-stream:..."
+var deniedResource = regexp.MustCompile(`on resource: (\S+)`)
+
+// permissionError returns a PermissionError for an AccessDenied error of an action, other errors are returned
+// as is. The resource is taken from the error message, or else is the given description.
+func permissionError(err error, action, resource string) error {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) || awsErr.Code() != "AccessDeniedException" {
+		return err
+	}
+	if match := deniedResource.FindStringSubmatch(awsErr.Message()); match != nil {
+		resource = match[1]
+	}
+
+	return &PermissionError{Action: action, Resource: resource, Err: err}
+}
+
+// putLogEventsWithOptions is implemented by the CloudWatch Logs client of the SDK
+type putLogEventsWithOptions interface {
+	PutLogEventsWithContext(aws.Context, *cloudwatchlogs.PutLogEventsInput, ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error)
+}
+
+// PreflightDestination checks that a log group and stream exist and that the credentials may describe them and
+// put events in the stream, so a missing permission fails at startup instead of halfway through sending a log
+// file. PutLogEvents is checked with a request without events: CloudWatch rejects it as invalid when the
+// permission is granted, and with AccessDenied when not, so nothing is written. The stream is not checked if
+// its name is empty. All problems found are returned.
+func PreflightDestination(client CloudWatchLogsAPI, destination LogConfig) error {
+	groupName := aws.String(destination.LogGroupName)
+	logGroup, err := findLogGroup(client, destination.LogGroupName)
+	if err != nil {
+		return permissionError(err, "logs:DescribeLogGroups", "log group "+destination.LogGroupName)
+	}
+	if logGroup == nil {
+		return fmt.Errorf("log group %s does not exist", destination.LogGroupName)
+	}
+	if destination.LogStreamName == "" {
+		return nil
+	}
+	// The ARN of a log group ends with :*, the ARN of a stream appends :log-stream:<name> to the group
+	streamResource := strings.TrimSuffix(aws.StringValue(logGroup.Arn), ":*") + ":log-stream:" + destination.LogStreamName
+	if logGroup.Arn == nil {
+		streamResource = fmt.Sprintf("log stream %s in log group %s", destination.LogStreamName, destination.LogGroupName)
+	}
+
+	var errs []error
+	logStream, err := findLogStream(client, destination.LogGroupName, destination.LogStreamName)
+	if err != nil {
+		errs = append(errs, permissionError(err, "logs:DescribeLogStreams", strings.TrimSuffix(streamResource, ":log-stream:"+destination.LogStreamName)))
+	} else if logStream == nil {
+		return fmt.Errorf("log stream %s does not exist in log group %s", destination.LogStreamName, destination.LogGroupName)
+	}
+
+	input := &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  groupName,
+		LogStreamName: aws.String(destination.LogStreamName),
+		LogEvents:     []*cloudwatchlogs.InputLogEvent{},
+	}
+	if withOptions, ok := client.(putLogEventsWithOptions); ok {
+		// The SDK refuses to send a request without events
+		_, err = withOptions.PutLogEventsWithContext(aws.BackgroundContext(), input, func(r *request.Request) {
+			r.Handlers.Validate.Clear()
+		})
+	} else {
+		_, err = client.PutLogEvents(input)
+	}
+	var awsErr awserr.Error
+	switch {
+	case err == nil:
+	case errors.As(err, &awsErr) && (awsErr.Code() == cloudwatchlogs.ErrCodeInvalidParameterException || awsErr.Code() == "ValidationException"):
+	default:
+		errs = append(errs, permissionError(err, "logs:PutLogEvents", streamResource))
+	}
+
+	return errors.Join(errs...)
+}
+
+// preflightDestinations returns the configured destination and the destinations of the account routes that can be
+// checked before processing, log group and stream names derived from object keys are only known when sending.
+// The stream is left empty when its name is derived.
+func preflightDestinations(config Config) []LogConfig {
+	stream := config.LogStreamName
+	if isLogNameTemplate(stream) {
+		stream = ""
+	}
+	var destinations []LogConfig
+	if !isLogNameTemplate(config.LogGroupName) {
+		destinations = append(destinations, LogConfig{LogGroupName: config.LogGroupName, LogStreamName: stream})
+	}
+	seen := make(map[LogConfig]bool)
+	keys := make([]string, 0, len(config.AccountRoutes))
+	for key := range config.AccountRoutes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		route := config.AccountRoutes[key]
+		destination := LogConfig{LogGroupName: route.LogGroupName, LogStreamName: stream, RoleARN: route.RoleARN}
+		if destination.LogGroupName == "" {
+			destination.LogGroupName = config.LogGroupName
+		}
+		if isLogNameTemplate(destination.LogGroupName) || seen[destination] {
+			continue
+		}
+		seen[destination] = true
+		destinations = append(destinations, destination)
+	}
+
+	return destinations
+}
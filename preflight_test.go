@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPreflightDestination(t *testing.T) {
+	destination := LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}
+	groupArn := "arn:aws:logs:eu-west-1:123456789012:log-group:test-log-group:*"
+	streamArn := "arn:aws:logs:eu-west-1:123456789012:log-group:test-log-group:log-stream:test-log-stream"
+	mockClient := func(putErr error) *MockCloudWatchLogsClient {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{
+			LogGroups: []*cloudwatchlogs.LogGroup{{LogGroupName: aws.String("test-log-group"), Arn: aws.String(groupArn)}},
+		}, nil)
+		client.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{
+			LogStreams: []*cloudwatchlogs.LogStream{{LogStreamName: aws.String("test-log-stream")}},
+		}, nil)
+		client.On("PutLogEvents", mock.MatchedBy(func(input *cloudwatchlogs.PutLogEventsInput) bool {
+			return len(input.LogEvents) == 0
+		})).Return(&cloudwatchlogs.PutLogEventsOutput{}, putErr)
+		return client
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		client := mockClient(awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "At least one event is required", nil))
+		assert.NoError(t, PreflightDestination(client, destination))
+		client.AssertExpectations(t)
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		message := "User: arn:aws:sts::123456789012:assumed-role/shipper/session is not authorized to perform: logs:PutLogEvents on resource: " + streamArn + " because no identity-based policy allows the logs:PutLogEvents action"
+		err := PreflightDestination(mockClient(awserr.New("AccessDeniedException", message, nil)), destination)
+		assert.EqualError(t, err, "missing logs:PutLogEvents on "+streamArn)
+		var permissionErr *PermissionError
+		assert.True(t, errors.As(err, &permissionErr))
+	})
+
+	t.Run("Denied without resource", func(t *testing.T) {
+		err := PreflightDestination(mockClient(awserr.New("AccessDeniedException", "Access denied", nil)), destination)
+		assert.EqualError(t, err, "missing logs:PutLogEvents on "+streamArn)
+	})
+
+	t.Run("Describe denied", func(t *testing.T) {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{}, awserr.New("AccessDeniedException", "Access denied", nil))
+		assert.EqualError(t, PreflightDestination(client, destination), "missing logs:DescribeLogGroups on log group test-log-group")
+	})
+
+	t.Run("Missing log group", func(t *testing.T) {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{}, nil)
+		assert.EqualError(t, PreflightDestination(client, destination), "log group test-log-group does not exist")
+	})
+
+	t.Run("Log group on a later page", func(t *testing.T) {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeLogGroups", &cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: aws.String("test-log-group")}).Return(&cloudwatchlogs.DescribeLogGroupsOutput{
+			NextToken: aws.String("page-2"),
+		}, nil)
+		client.On("DescribeLogGroups", &cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: aws.String("test-log-group"), NextToken: aws.String("page-2")}).Return(&cloudwatchlogs.DescribeLogGroupsOutput{
+			LogGroups: []*cloudwatchlogs.LogGroup{{LogGroupName: aws.String("test-log-group")}},
+		}, nil)
+		assert.NoError(t, PreflightDestination(client, LogConfig{LogGroupName: "test-log-group"}))
+	})
+
+	t.Run("Log group only", func(t *testing.T) {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{
+			LogGroups: []*cloudwatchlogs.LogGroup{{LogGroupName: aws.String("test-log-group")}},
+		}, nil)
+		assert.NoError(t, PreflightDestination(client, LogConfig{LogGroupName: "test-log-group"}))
+		client.AssertNotCalled(t, "PutLogEvents", mock.Anything)
+	})
+}
+
+func TestPreflightDestinations(t *testing.T) {
+	config := Config{LogGroupName: "/elb/default", LogStreamName: "{elb}", AccountRoutes: AccountRoutes{
+		"111111111111":           {LogGroupName: "/elb/team-a"},
+		"222222222222":           {RoleARN: "arn:aws:iam::222222222222:role/shipper"},
+		"333333333333":           {LogGroupName: "/elb/{account}"},
+		"111111111111/eu-west-1": {LogGroupName: "/elb/team-a"},
+	}}
+	assert.Equal(t, []LogConfig{
+		{LogGroupName: "/elb/default"},
+		{LogGroupName: "/elb/team-a"},
+		{LogGroupName: "/elb/default", RoleARN: "arn:aws:iam::222222222222:role/shipper"},
+	}, preflightDestinations(config))
+
+	assert.Empty(t, preflightDestinations(Config{LogGroupName: "/elb/{account}", LogStreamName: "stream"}))
+}
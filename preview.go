@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Rough figures to estimate the volume of a backfill before it starts, actual numbers depend on the traffic and
+// the configured fields, see the stats command for a measurement
+const (
+	// estimatedCompressionRatio is the typical ratio of the decompressed to the compressed size of access logs
+	estimatedCompressionRatio = 10
+	// estimatedEntryBytes is the typical size of an access log line
+	estimatedEntryBytes = 600
+	// ingestionPricePerGB is the price of ingesting a GB of logs in the standard log class in us-east-1
+	ingestionPricePerGB = 0.50
+)
+
+// VolumePreview describes the objects of a CLI run before they are processed
+type VolumePreview struct {
+	Objects     int
+	Bytes       int64 // Compressed size of the objects with a known size
+	UnknownSize int   // Objects without a size in the listing
+}
+
+func newVolumePreview(s3Objects []S3ObjectInfo) VolumePreview {
+	preview := VolumePreview{Objects: len(s3Objects)}
+	for _, s3Object := range s3Objects {
+		if s3Object.Size == nil {
+			preview.UnknownSize++
+		}
+		preview.Bytes += aws.Int64Value(s3Object.Size)
+	}
+
+	return preview
+}
+
+// EstimatedEntries estimates the number of log entries from the compressed size
+func (p VolumePreview) EstimatedEntries() int64 {
+	return p.Bytes * estimatedCompressionRatio / estimatedEntryBytes
+}
+
+// EstimatedCost estimates the ingestion cost in USD, taking the size of the JSON entries as the size of the lines
+func (p VolumePreview) EstimatedCost() float64 {
+	return float64(p.Bytes*estimatedCompressionRatio) / 1e9 * ingestionPricePerGB
+}
+
+func (p VolumePreview) Write(w io.Writer) {
+	fmt.Fprintf(w, "%d objects, %s compressed", p.Objects, formatBytes(p.Bytes))
+	if p.UnknownSize > 0 {
+		fmt.Fprintf(w, " (%d objects of unknown size)", p.UnknownSize)
+	}
+	fmt.Fprintf(w, "\nestimated ~%d entries, ~%s ingested, ~$%.2f at $%.2f/GB\n",
+		p.EstimatedEntries(), formatBytes(p.Bytes*estimatedCompressionRatio), p.EstimatedCost(), ingestionPricePerGB)
+}
+
+// formatBytes formats a size with a decimal unit, e.g. "1.5 GB"
+func formatBytes(n int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	size := float64(n)
+	unit := 0
+	for size >= 1000 && unit < len(units)-1 {
+		size /= 1000
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
+// terminalInput returns standard input and whether it is a terminal, to ask for confirmation
+var terminalInput = func() (io.Reader, bool) {
+	info, err := os.Stdin.Stat()
+
+	return os.Stdin, err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmRun asks to continue when standard input is a terminal. Without a terminal, e.g. in CI, the run must be
+// confirmed in advance with --yes.
+func confirmRun(stderr io.Writer) error {
+	in, interactive := terminalInput()
+	if !interactive {
+		return fmt.Errorf("not confirmed, pass --yes to process the objects without confirmation")
+	}
+	fmt.Fprint(stderr, "continue? [y/N] ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return fmt.Errorf("aborted")
+	}
+
+	return nil
+}
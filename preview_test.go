@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumePreview(t *testing.T) {
+	preview := newVolumePreview([]S3ObjectInfo{
+		{Key: "a", Size: aws.Int64(1_500_000_000)},
+		{Key: "b", Size: aws.Int64(500_000_000)},
+		{Key: "c"},
+	})
+	assert.Equal(t, VolumePreview{Objects: 3, Bytes: 2_000_000_000, UnknownSize: 1}, preview)
+	assert.Equal(t, int64(33_333_333), preview.EstimatedEntries())
+	assert.InDelta(t, 10.0, preview.EstimatedCost(), 0.001)
+
+	var buf bytes.Buffer
+	preview.Write(&buf)
+	assert.Equal(t, "3 objects, 2.0 GB compressed (1 objects of unknown size)\nestimated ~33333333 entries, ~20.0 GB ingested, ~$10.00 at $0.50/GB\n", buf.String())
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "999 B", formatBytes(999))
+	assert.Equal(t, "1.5 kB", formatBytes(1500))
+	assert.Equal(t, "2.3 TB", formatBytes(2_300_000_000_000))
+}
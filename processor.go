@@ -1,164 +1,878 @@
 package main
 
 import (
-	"compress/gzip"
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"io"
 	"log"
+	"sort"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 type LogProcessor interface {
-	ProcessLogs(s3Object S3ObjectInfo) error
+	ProcessLogs(s3Object S3ObjectInfo) (ObjectResult, error)
+}
+
+// ObjectResult describes the outcome of processing a single object
+type ObjectResult struct {
+	Entries           int   // Number of log entries sent to CloudWatch
+	CompressedBytes   int64 // Size of the object as downloaded
+	DecompressedBytes int64 // Size of the log file as parsed
+	SentBytes         int64 // Size of the events sent to CloudWatch, as counted by CloudWatch
+	ServerErrors      int   // Entries with a 5xx status code of the load balancer
+	ETag              string
+	SHA256            string    // Hex encoded SHA-256 of the object as downloaded
+	FirstTimestamp    time.Time // Earliest timestamp of the entries, zero without entries
+	LastTimestamp     time.Time // Latest timestamp of the entries, zero without entries
+	// Verification is the error of reading the events back, see Verifier. The object was sent, so it doesn't fail.
+	Verification string
+}
+
+// countingReader counts the bytes read from a reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// sendCounters count the entries and bytes sent for an object
+type sendCounters struct {
+	entries SafeCounter
+	bytes   SafeCounter
+	blank   SafeCounter // Entries dropped because no field is left
+	// Entries dropped because they can't be encoded or exceed the maximum event size, and events rejected by
+	// CloudWatch, only counted when not strict
+	unencodable SafeCounter
+	oversized   SafeCounter
+	rejected    SafeCounter
+	sent        *SentEvents // Events sent per destination, only recorded to verify them
 }
 
 type S3Api interface {
 	GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
 	ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
+	PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
 }
 
 type LogEntry struct {
-	Data      map[string]string // Map of field name to value, this will be converted to JSON
-	Timestamp time.Time
+	Data        map[string]interface{} // Map of field name to value, this will be converted to JSON
+	Timestamp   time.Time
+	Destination LogConfig // Overrides the configured log group and/or stream when set
+	Record      int       // Index of the record in the object, used to track progress
+	Message     string    // Data encoded as JSON, set by encode
+	Size        int       // Size of the event as counted by CloudWatch, set by encode
+}
+
+// entryEncoder is a JSON encoder writing to its own buffer
+type entryEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
 }
 
+// entryEncoders are reused between entries, so encoding an entry only allocates its message
+var entryEncoders = sync.Pool{
+	New: func() interface{} {
+		e := &entryEncoder{}
+		e.enc = json.NewEncoder(&e.buf)
+		return e
+	},
+}
+
+// encode marshals the entry data into the final message and records its exact size. Entries are encoded
+// exactly once, the message and size are used for both batching decisions and the PutLogEvents request. The
+// message is the only copy of the encoded bytes, as the buffer is reused and PutLogEvents takes a string.
+func (e *LogEntry) encode() error {
+	encoder := entryEncoders.Get().(*entryEncoder)
+	defer entryEncoders.Put(encoder)
+	encoder.buf.Reset()
+	if err := encoder.encodeValue(e.Data); err != nil {
+		return fmt.Errorf("error marshaling log entry to JSON: %v", err)
+	}
+	e.Message = encoder.buf.String()
+	e.Size = len(e.Message) + eventOverhead
+
+	return nil
+}
+
+// encodeValue writes a value as encoding/json does. Objects and strings, which make up nearly all of an entry,
+// are written directly, as encoding/json allocates for every value of a map. Other values go to the encoder.
+func (e *entryEncoder) encodeValue(value interface{}) error {
+	switch value := value.(type) {
+	case string:
+		e.encodeString(value)
+	case map[string]interface{}:
+		if value == nil {
+			e.buf.WriteString("null")
+			return nil
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		e.buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			e.encodeString(key)
+			e.buf.WriteByte(':')
+			if err := e.encodeValue(value[key]); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte('}')
+	default:
+		if err := e.enc.Encode(value); err != nil {
+			return err
+		}
+		// Encode terminates the value with a newline, which is not part of the message
+		e.buf.Truncate(e.buf.Len() - 1)
+	}
+
+	return nil
+}
+
+// encodeString writes a string as encoding/json does: quoted, with <, > and & escaped for HTML, invalid UTF-8
+// replaced and U+2028 and U+2029 escaped for JavaScript
+func (e *entryEncoder) encodeString(s string) {
+	const hex = "0123456789abcdef"
+	e.buf.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			e.buf.WriteString(s[start:i])
+			switch c {
+			case '"', '\\':
+				e.buf.WriteByte('\\')
+				e.buf.WriteByte(c)
+			case '\b':
+				e.buf.WriteString(`\b`)
+			case '\f':
+				e.buf.WriteString(`\f`)
+			case '\n':
+				e.buf.WriteString(`\n`)
+			case '\r':
+				e.buf.WriteString(`\r`)
+			case '\t':
+				e.buf.WriteString(`\t`)
+			default:
+				e.buf.WriteString(`\u00`)
+				e.buf.WriteByte(hex[c>>4])
+				e.buf.WriteByte(hex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			e.buf.WriteString(s[start:i])
+			e.buf.WriteRune(utf8.RuneError)
+		case r == '\u2028' || r == '\u2029':
+			e.buf.WriteString(s[start:i])
+			e.buf.WriteString(`\u202`)
+			e.buf.WriteByte(hex[r&0xF])
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	e.buf.WriteString(s[start:])
+	e.buf.WriteByte('"')
+}
+
+// CloudWatchLogProcessor is created once at cold start and shared by all invocations and concurrently processed
+// objects, so created destinations and stream writers are reused by warm invocations
 type CloudWatchLogProcessor struct {
-	s3Client   S3Api
-	cwClient   CloudWatchLogsAPI
-	fieldStore Fields
-	logConfig  LogConfig
+	s3Client    S3Api
+	source      Source
+	cwClient    CloudWatchLogsAPI
+	fieldStore  Fields
+	config      Config
+	logConfig   LogConfig
+	ensured     DestinationCache
+	writers     StreamWriters
+	checkpoints CheckpointStore
+	limiter     *RateLimiter      // Limits the entries per second of all objects, nil if unlimited
+	roleClients *RoleClients      // Clients for destinations in other accounts
+	progress    ProgressStore     // Tracks the records sent of partially processed objects, nil if disabled
+	spool       Spool             // Keeps batches that failed to send, nil if disabled
+	elbTags     *ELBTagCache      // Looks up the tags of load balancers, nil if disabled
+	targets     *TargetResolver   // Looks up the backends of targets, nil if disabled
+	watermarks  *Watermarks       // Latest timestamp sent per stream, nil if not tracked
+	setUp       *destinationSetUp // Sets up the configured destination before the first object, nil if done
+}
+
+// destinationSetUp remembers whether the configured destination was set up, see prepare
+type destinationSetUp struct {
+	mu   sync.Mutex
+	done bool
 }
 
 type LogConfig struct {
 	LogGroupName  string
 	LogStreamName string
+	RoleARN       string // Role assumed to write to the destination, empty uses the default credentials
 }
 
+// ErrEmptyObject is returned by ProcessLogs for objects without any log entries, ELB writes these during low traffic
+var ErrEmptyObject = errors.New("object is empty")
+
+// ErrAlreadyProcessed is returned by ProcessLogs for objects whose ETag matches an object that was already processed
+var ErrAlreadyProcessed = errors.New("object was already processed")
+
 const (
 	// maxBatchSize The maximum batch size of a PutLogEvents request to CloudWatch is 1MB (1_048_576 bytes)
 	maxBatchSize = 1_048_576
 	// maxBatchCount The maximum number of events in a PutLogEvents request to CloudWatch is 10_000
 	maxBatchCount = 10_000
+	// maxEventSize The maximum size of a single event is 256 KB, including the per-event overhead
+	maxEventSize = 262_144
+	// eventOverhead CloudWatch counts 26 bytes per event on top of the UTF-8 encoded message
+	eventOverhead = 26
 )
 
-func NewLogProcessor(config Config) (LogProcessor, error) {
-	sess := session.Must(session.NewSession())
-	fieldStore, _ := NewFields(config.Fields)
-	logConfig := LogConfig{config.LogGroupName, config.LogStreamName}
-	cwClient := cloudwatchlogs.New(sess)
-	err := EnsureLogGroupAndLogStreamExists(cwClient, logConfig)
-	if err != nil {
-		return nil, fmt.Errorf("error creating log group and stream: %v", err)
+// NewLogProcessor creates the processor shared by all invocations. The configured log group and stream and those of
+// the routes are checked and created before the first object is sent rather than here, so a cold start doesn't wait
+// for the CloudWatch Logs API and commands that don't send anything don't call it, unless PREFLIGHT is set. Commands
+// that send entries check them at startup with Handler.setUpDestinations. Any destination that can't be set up
+// fails the startup.
+func NewLogProcessor(state *State) (LogProcessor, error) {
+	lp := &CloudWatchLogProcessor{
+		s3Client:    state.S3Client,
+		source:      NewSources(state.S3Client),
+		cwClient:    state.CWClient,
+		fieldStore:  state.Fields,
+		config:      state.Config,
+		logConfig:   LogConfig{LogGroupName: state.Config.LogGroupName, LogStreamName: state.Config.LogStreamName},
+		ensured:     DestinationCache{FailureTTL: state.Config.DestinationFailureTTL},
+		checkpoints: state.Checkpoints,
+		limiter:     state.Limiter,
+		roleClients: state.RoleClients,
+		progress:    state.Progress,
+		spool:       state.Spool,
+		elbTags:     state.ELBTags,
+		targets:     state.Targets,
+		watermarks:  state.Watermarks,
+		setUp:       &destinationSetUp{},
 	}
-	return &CloudWatchLogProcessor{
-		s3Client:   s3.New(sess),
-		cwClient:   cwClient,
-		fieldStore: fieldStore,
-		logConfig:  logConfig,
-	}, nil
+	if state.Config.StreamWriterCheck != streamWriterIgnore {
+		// The streams are marked, so the check of a later run doesn't take this deployment for another writer
+		lp.writers.identity, lp.writers.markEvery = streamWriterID(state.FunctionName), state.Config.StreamWriterWindow/2
+	}
+	if state.Config.Preflight {
+		if err := lp.prepare(true); err != nil {
+			return nil, err
+		}
+	}
+
+	return lp, nil
 }
 
-func (lp *CloudWatchLogProcessor) ProcessLogs(s3Object S3ObjectInfo) error {
+// prepare makes sure the configured log group and stream exist before anything is sent, see setUpDestination,
+// and then sets up the destinations of the routes in parallel. Errors of the routes only fail it when
+// routesRequired, otherwise they are logged, as only the entries routed to them fail. Concurrent objects wait for
+// a single attempt, and a failed attempt is retried by the next object.
+func (lp *CloudWatchLogProcessor) prepare(routesRequired bool) error {
+	if lp.setUp == nil {
+		return nil
+	}
+	lp.setUp.mu.Lock()
+	defer lp.setUp.mu.Unlock()
+	if lp.setUp.done {
+		return nil
+	}
+	logConfig, err := lp.setUpDestination()
+	if err != nil {
+		return err
+	}
+	lp.logConfig = logConfig
+	lp.setUp.done = true
+	if err := lp.setUpRoutes(); err != nil {
+		if routesRequired {
+			return err
+		}
+		log.Println(err)
+	}
 
-	log.Printf("processing logs from s3://%s/%s", s3Object.Bucket, s3Object.Key)
+	return nil
+}
 
-	obj, err := lp.s3Client.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(s3Object.Bucket),
-		Key:    aws.String(s3Object.Key),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to get object: %v", err)
+// routeDestinations returns the destinations of the ACCOUNT_ROUTES and HOST_ROUTES without placeholders, which
+// are known before any object is read. Other routes are set up when first used.
+func (lp *CloudWatchLogProcessor) routeDestinations() []LogConfig {
+	seen := map[LogConfig]bool{lp.logConfig: true}
+	var destinations []LogConfig
+	add := func(destination LogConfig) {
+		if isLogNameTemplate(destination.LogGroupName) || isLogNameTemplate(destination.LogStreamName) || seen[destination] {
+			return
+		}
+		seen[destination] = true
+		destinations = append(destinations, destination)
+	}
+	for _, route := range lp.config.AccountRoutes {
+		destination := lp.logConfig
+		if route.LogGroupName != "" {
+			destination.LogGroupName = route.LogGroupName
+		}
+		destination.RoleARN = route.RoleARN
+		add(destination)
+	}
+	for _, route := range lp.config.HostRoutes {
+		destination := lp.logConfig
+		if route.LogGroupName != "" {
+			destination.LogGroupName = route.LogGroupName
+		}
+		if route.LogStreamName != "" {
+			destination.LogStreamName = route.LogStreamName
+		}
+		add(destination)
 	}
-	defer obj.Body.Close()
+	sort.Slice(destinations, func(i, j int) bool {
+		return destinations[i].LogGroupName+"/"+destinations[i].LogStreamName < destinations[j].LogGroupName+"/"+destinations[j].LogStreamName
+	})
 
-	reader, writer := io.Pipe()
+	return destinations
+}
 
-	// Decompress the gzip file in a goroutine
-	go func() {
-		gzipReader, err := gzip.NewReader(obj.Body)
+// setUpRoutes sets up the destinations of the routes concurrently, and reports all that failed in a single error
+func (lp *CloudWatchLogProcessor) setUpRoutes() error {
+	destinations := lp.routeDestinations()
+	errs := make([]error, len(destinations))
+	var wg sync.WaitGroup
+	for i, destination := range destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := lp.ensureDestination(destination); err != nil {
+				errs[i] = fmt.Errorf("log group %s, stream %s: %w", destination.LogGroupName, destination.LogStreamName, err)
+			}
+		}()
+	}
+	wg.Wait()
+	failed := 0
+	for _, err := range errs {
 		if err != nil {
-			writer.CloseWithError(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to set up %d of %d routed destinations:\n%w", failed, len(destinations), errors.Join(errs...))
+	}
 
-			return
+	return nil
+}
+
+// setUpDestination checks the configured log group and stream for other writers, creates them with the
+// subscription filter, and runs the preflight check if configured. It returns the log stream to write to.
+func (lp *CloudWatchLogProcessor) setUpDestination() (LogConfig, error) {
+	logConfig := lp.logConfig
+	// Log groups and streams derived from the object keys are created when first used
+	var err error
+	if !isLogNameTemplate(logConfig.LogGroupName) && !isLogNameTemplate(logConfig.LogStreamName) {
+		logConfig, err = checkStreamWriter(lp.cwClient, logConfig, lp.config.StreamWriterCheck, lp.config.StreamWriterWindow, lp.writers.identity, time.Now())
+		if err != nil {
+			return LogConfig{}, err
 		}
-		defer gzipReader.Close()
-		// Copy decompressed data to writer
-		if _, err := io.Copy(writer, gzipReader); err != nil {
-			writer.CloseWithError(err)
+	}
+	switch {
+	case isLogNameTemplate(logConfig.LogGroupName):
+	case isLogNameTemplate(logConfig.LogStreamName):
+		err = ensureLogGroupExists(lp.cwClient, logConfig.LogGroupName)
+	default:
+		err = EnsureLogGroupAndLogStreamExists(lp.cwClient, logConfig)
+	}
+	if err != nil {
+		return LogConfig{}, fmt.Errorf("error creating log group and stream: %v", err)
+	}
+	if lp.config.Subscription.Enabled() && !isLogNameTemplate(logConfig.LogGroupName) {
+		if err := ensureSubscriptionFilter(lp.cwClient, logConfig.LogGroupName, lp.config.Subscription); err != nil {
+			return LogConfig{}, err
+		}
+	}
+	if lp.config.Preflight && !isLogNameTemplate(logConfig.LogGroupName) && !isLogNameTemplate(logConfig.LogStreamName) {
+		if err := PreflightDestination(lp.cwClient, logConfig); err != nil {
+			return LogConfig{}, fmt.Errorf("preflight check of log group %s failed: %w", logConfig.LogGroupName, err)
+		}
+	}
 
-			return
+	return logConfig, nil
+}
+
+// ProcessLogs sends the entries of an object to CloudWatch Logs by running a Pipeline for it
+func (lp *CloudWatchLogProcessor) ProcessLogs(s3Object S3ObjectInfo) (ObjectResult, error) {
+	// Only S3 objects have an ETag to compare
+	if lp.config.HeadObjectChecks && s3Object.Bucket != "" {
+		head, err := lp.s3Client.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(s3Object.Bucket),
+			Key:    aws.String(s3Object.Key),
+		})
+		if err != nil {
+			return ObjectResult{}, fmt.Errorf("failed to head object: %v", err)
 		}
-		writer.Close()
-	}()
+		s3Object.Size = head.ContentLength
+		s3Object.ETag = aws.StringValue(head.ETag)
+		if etag, ok := lp.checkpoints.ProcessedETag(s3Object.Bucket, s3Object.Key); ok && etag == s3Object.ETag {
+			return ObjectResult{}, ErrAlreadyProcessed
+		}
+	}
+	if s3Object.Size != nil && *s3Object.Size == 0 {
+		return ObjectResult{}, ErrEmptyObject
+	}
+	result, err := lp.process(s3Object, lp.source)
+	if err != nil {
+		return result, err
+	}
+	if lp.config.HeadObjectChecks && s3Object.ETag != "" {
+		lp.checkpoints.MarkProcessed(s3Object.Bucket, s3Object.Key, s3Object.ETag)
+	}
+
+	return result, nil
+}
 
-	// Set channel buffer size to 1.25 times the max batch count to avoid blocking
-	entryChan := make(chan LogEntry, int(float64(maxBatchCount)*1.25))
+// ProcessData sends the entries of log data that was received rather than read from a source, such as the
+// records of a Kinesis stream. The name identifies the data in logs and progress tracking.
+func (lp *CloudWatchLogProcessor) ProcessData(name string, data []byte) (ObjectResult, error) {
+	return lp.process(S3ObjectInfo{Key: name}, &ReaderSource{Reader: bytes.NewReader(data)})
+}
 
-	counter := SafeCounter{v: 0}
+// ProcessNDJSON sends entries that were parsed before, such as the output of the export command, as newline
+// delimited JSON objects. The entries are sent as is, with the timestamp of their time field, or the current
+// time without it. The name identifies the data in logs.
+func (lp *CloudWatchLogProcessor) ProcessNDJSON(name string, data []byte) (ObjectResult, error) {
+	logf(verbosityVerbose, "processing entries from %s", name)
+	if err := lp.prepare(false); err != nil {
+		return ObjectResult{}, err
+	}
+	sink := &cloudWatchSink{lp: lp}
+	entries := make(chan LogEntry, maxBatchCount)
+	var sendErr error
 	var wg sync.WaitGroup
 	wg.Add(1)
-
 	go func() {
 		defer wg.Done()
-		var events []*cloudwatchlogs.InputLogEvent
-		var currentBatchSize int
-		for entry := range entryChan {
-			jsonData, err := json.Marshal(entry.Data)
-			if err != nil {
-				fmt.Println("error marshaling log entry to JSON:", err)
-			}
-			event := &cloudwatchlogs.InputLogEvent{
-				Message:   aws.String(string(jsonData)),
-				Timestamp: aws.Int64(entry.Timestamp.UnixMilli()),
+		sendErr = sink.Send(entries)
+	}()
+	// Entries that are not valid JSON are skipped like invalid records, or fail the data when strict
+	invalid := &InvalidRecords{Strict: lp.config.Strict}
+	var decodeErr error
+	record := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		record++
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var fields map[string]interface{}
+		if err := decoder.Decode(&fields); err != nil {
+			if decodeErr = invalid.Invalid(record, nil, err); decodeErr != nil {
+				break
 			}
-			eventSize := EstimateEventSize(event)
-			// Check if adding this event would exceed the size limit
-			if len(events) > 0 && (currentBatchSize+eventSize > maxBatchSize || len(events) >= maxBatchCount) {
-				// If it does, send the current batch
-				err := SendEventsToCloudWatch(lp.cwClient, lp.logConfig, events)
-				if err != nil {
-					fmt.Println("error sending events to CloudWatch:", err)
-				}
-				// Increment counter and reset the batch
-				counter.Increment(len(events))
-				events = nil
-				currentBatchSize = 0
+			continue
+		}
+		timestamp := time.Now()
+		if value, ok := fields["time"].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				timestamp = t
 			}
-			// Add the event to the batch
-			events = append(events, event)
-			currentBatchSize += eventSize
 		}
-		// Send any remaining events
-		if len(events) > 0 {
-			err := SendEventsToCloudWatch(lp.cwClient, lp.logConfig, events)
+		entries <- LogEntry{Data: fields, Timestamp: timestamp, Record: record - 1}
+	}
+	close(entries)
+	wg.Wait()
+	sent, sentBytes := sink.Sent()
+	result := ObjectResult{Entries: sent, DecompressedBytes: int64(len(data)), SentBytes: sentBytes}
+	if err := errors.Join(decodeErr, sendErr); err != nil {
+		return result, err
+	}
+	if sent == 0 {
+		return result, ErrEmptyObject
+	}
+	printf(verbosityVerbose, "processed %d log entries, sent %d bytes\n", sent, sentBytes)
+
+	return result, nil
+}
+
+// process runs a Pipeline for an object from a source
+func (lp *CloudWatchLogProcessor) process(s3Object S3ObjectInfo, source Source) (ObjectResult, error) {
+	if err := lp.prepare(false); err != nil {
+		return ObjectResult{}, err
+	}
+	objectDestination, err := lp.objectDestination(s3Object)
+	if err != nil {
+		return ObjectResult{}, err
+	}
+
+	logf(verbosityVerbose, "processing logs from %s", s3Object)
+
+	var parser Parser = &RecordParser{Fields: lp.fieldStore, Layouts: lp.config.TimestampLayouts}
+	if lp.config.ParseWorkers > 1 {
+		parser = &ParallelRecordParser{Fields: lp.fieldStore, Layouts: lp.config.TimestampLayouts, Workers: lp.config.ParseWorkers}
+	}
+	var sink *cloudWatchSink
+	pipeline := &Pipeline{
+		Source:        source,
+		Parser:        parser,
+		Strict:        lp.config.Strict,
+		StrictSending: lp.config.strictSending(),
+		EntryBuffer:   entryBuffer(lp.config.MemoryLimit, lp.config.Performance.EntryBuffer),
+		Stages: func(object S3ObjectInfo, metadata ObjectMetadata) ([]Transformer, Sink) {
+			progress := newObjectProgress(lp.progress, object.Bucket, object.Key, metadata.ETag)
+			sink = &cloudWatchSink{lp: lp, progress: progress, verify: object.Verify}
+
+			return lp.objectTransformers(object, objectDestination, progress), sink
+		},
+	}
+	result, err := pipeline.Run(s3Object)
+	if sink != nil && sink.verified != nil {
+		result.Verification = sink.verified.Error()
+	}
+	if err != nil {
+		return result.ObjectResult, err
+	}
+	stats := fmt.Sprintf("downloaded %d bytes, parsed %d bytes, sent %d bytes", result.CompressedBytes, result.DecompressedBytes, result.SentBytes)
+	if len(result.Summary) > 0 {
+		printf(verbosityVerbose, "processed %d log entries, %s (%s)\n", result.Entries, stats, formatSummary(result.Summary))
+	} else {
+		printf(verbosityVerbose, "processed %d log entries, %s\n", result.Entries, stats)
+	}
+
+	return result.ObjectResult, nil
+}
+
+// objectTransformers returns the configured transformers followed by the transformers that depend on the object
+func (lp *CloudWatchLogProcessor) objectTransformers(s3Object S3ObjectInfo, objectDestination LogConfig, progress *objectProgress) []Transformer {
+	transformers := NewTransformers(lp.config)
+	if resumeAfter := progress.resumeAfter(); resumeAfter > 0 {
+		logf(verbosityVerbose, "resuming after %d records that were sent before", resumeAfter)
+		transformers = append([]Transformer{&ResumeFilter{Records: resumeAfter}}, transformers...)
+	}
+	if objectDestination != lp.logConfig {
+		transformers = append(transformers, &ObjectDestination{Destination: objectDestination})
+	}
+	if lp.config.AccountIDField {
+		if key, err := ParseELBObjectKey(s3Object.Key); err == nil {
+			transformers = append(transformers, &AccountIDField{AccountID: key.AccountID})
+		}
+	}
+	if lp.elbTags != nil {
+		if key, err := ParseELBObjectKey(s3Object.Key); err == nil {
+			// Entries are sent without tags rather than not at all when the load balancer can't be described
+			tags, err := lp.elbTags.Lookup(key)
 			if err != nil {
-				fmt.Println("error sending events to CloudWatch:", err)
+				logf(verbosityNormal, "%v", err)
+			}
+			if len(tags) > 0 {
+				transformers = append(transformers, &ELBTagger{Tags: tags})
 			}
-			counter.Increment(len(events))
 		}
-	}()
+	}
+	if lp.targets != nil {
+		// Targets are looked up in the region of the load balancer, which is only known from the object key
+		if key, err := ParseELBObjectKey(s3Object.Key); err == nil {
+			transformers = append(transformers, &TargetEnricher{Resolver: lp.targets, Region: key.Region})
+		}
+	}
+	if lp.limiter != nil {
+		transformers = append(transformers, &RateCap{Limiter: lp.limiter, Sample: lp.config.RateLimitSampling})
+	}
+	if lp.config.RequestIDField && s3Object.RequestID != "" {
+		transformers = append(transformers, &RequestIDField{RequestID: s3Object.RequestID})
+	}
+	if s3Object.TimestampShift != 0 {
+		transformers = append(transformers, &TimeShifter{Offset: s3Object.TimestampShift})
+	}
+	if lp.config.ClockSkewTolerance > 0 {
+		// After shifting, so shifted timestamps that end up slightly ahead are clamped as well
+		transformers = append(transformers, &SkewClamper{Tolerance: lp.config.ClockSkewTolerance})
+	}
+
+	return transformers
+}
 
-	if err := processRecords(reader, entryChan, lp.fieldStore); err != nil {
-		fmt.Println("error processing records", err)
+// cloudWatchSink sends the entries of an object to CloudWatch Logs and tracks the records that were sent
+type cloudWatchSink struct {
+	lp       *CloudWatchLogProcessor
+	progress *objectProgress
+	counters sendCounters
+	verify   bool  // Read the events back after sending them, see Verifier
+	verified error // Error of reading the events back, reported apart from the error of sending them
+}
+
+func (s *cloudWatchSink) Send(entries <-chan LogEntry) error {
+	if s.verify {
+		s.counters.sent = &SentEvents{}
+	}
+	if err := s.lp.sendEntries(entries, &s.counters, s.progress); err != nil {
+		// The progress of the records that were sent is kept, so a retry resumes after them
+		return fmt.Errorf("failed to send events to CloudWatch: %v", err)
+	}
+	s.progress.clear()
+	if s.verify {
+		verifier := &Verifier{
+			Client: func(destination LogConfig) CloudWatchLogsAPI {
+				return s.lp.roleClients.Client(destination.RoleARN, s.lp.cwClient)
+			},
+			Timeout: verifyTimeout,
+		}
+		// A discrepancy doesn't fail the object: its events were sent and the progress is cleared, so a retry
+		// would send all of them again
+		if s.verified = verifier.Verify(s.counters.sent); s.verified != nil {
+			return nil
+		}
+		logf(verbosityVerbose, "verified %d events in CloudWatch", s.counters.entries.Value())
 	}
 
-	close(entryChan)
-	wg.Wait()
-	fmt.Printf("processed %d log entries\n", counter.Value())
+	return nil
+}
+
+func (s *cloudWatchSink) Sent() (int, int64) {
+	return s.counters.entries.Value(), int64(s.counters.bytes.Value())
+}
+
+func (s *cloudWatchSink) Summarize(summary map[string]int) {
+	if blank := s.counters.blank.Value(); blank > 0 {
+		summary["blank_dropped"] = blank
+	}
+	if unencodable := s.counters.unencodable.Value(); unencodable > 0 {
+		summary["unencodable_dropped"] += unencodable
+	}
+	if oversized := s.counters.oversized.Value(); oversized > 0 {
+		summary["oversized_dropped"] = oversized
+	}
+	if rejected := s.counters.rejected.Value(); rejected > 0 {
+		summary["rejected_events"] = rejected
+	}
+}
+
+// sendEntries batches the entries per destination and sends each batch when it is full, when the flush
+// interval (if configured) elapses, and when the channel is closed, see Batcher
+func (lp *CloudWatchLogProcessor) sendEntries(entryChan <-chan LogEntry, counters *sendCounters, progress *objectProgress) error {
+	batcher := &Batcher{
+		Destination: lp.destination,
+		Ensure:      lp.ensureDestination,
+		Send: func(destination LogConfig, batch *eventBatch) error {
+			return lp.sendBatch(destination, batch, counters, progress)
+		},
+		Strict:            lp.config.strictSending(),
+		FlushInterval:     lp.config.FlushInterval,
+		ReorderBufferSize: lp.config.ReorderBufferSize,
+		Counters:          counters,
+		Progress:          progress,
+	}
+
+	return batcher.Run(entryChan)
+}
+
+// batchKey identifies the batch of an entry, see Batcher
+type batchKey struct {
+	destination LogConfig
+	day         string
+}
+
+// eventBatch holds the events for a single PutLogEvents request
+type eventBatch struct {
+	events  []*cloudwatchlogs.InputLogEvent
+	records []int // Record index of every event
+	size    int
+}
+
+func (b *eventBatch) reset() {
+	b.events = nil
+	b.records = nil
+	b.size = 0
+}
+
+// sendBatch sends the batch to the destination and resets it. When the batch was sent, the counters
+// are incremented and the progress of the object is saved. A batch that fails to send is written to the
+// spool if configured, which counts as done for the progress but not for the counters.
+func (lp *CloudWatchLogProcessor) sendBatch(destination LogConfig, batch *eventBatch, counters *sendCounters, progress *objectProgress) error {
+	defer batch.reset()
+	client := lp.roleClients.Client(destination.RoleARN, lp.cwClient)
+	err := lp.writers.Send(client, destination, batch.events)
+	// The log group or stream was deleted since it was created, the batch is sent again once it exists again
+	if isResourceNotFound(err) {
+		logf(verbosityNormal, "log group or stream %s/%s no longer exists, creating it again", destination.LogGroupName, destination.LogStreamName)
+		if ensureErr := lp.recreateDestination(destination); ensureErr != nil {
+			err = fmt.Errorf("%w, creating it again failed: %v", err, ensureErr)
+		} else {
+			err = lp.writers.Send(client, destination, batch.events)
+		}
+	}
+	// The other events of a partially rejected batch were stored, so it is only retried when strict
+	var rejected *RejectedEventsError
+	if errors.As(err, &rejected) {
+		if lp.config.strictSending() {
+			return err
+		}
+		logf(verbosityNormal, "%v in %s/%s", err, destination.LogGroupName, destination.LogStreamName)
+		counters.rejected.Increment(rejected.Rejected)
+		counters.entries.Increment(len(batch.events) - rejected.Rejected)
+		counters.bytes.Increment(batch.size)
+		progress.markSent(batch.records...)
+		progress.save()
+
+		return nil
+	}
+	if err != nil {
+		fmt.Println("error sending events to CloudWatch:", err)
+		if lp.spool == nil {
+			return err
+		}
+		// The events are kept in the spool to be replayed later, so the object doesn't fail
+		if spoolErr := lp.spool.Write(newSpooledBatch(destination, batch.events)); spoolErr != nil {
+			fmt.Println("error spooling events:", spoolErr)
+			return err
+		}
+		logf(verbosityNormal, "spooled %d events for %s/%s to %v", len(batch.events), destination.LogGroupName, destination.LogStreamName, lp.spool)
+		progress.markSent(batch.records...)
+		progress.save()
+
+		return nil
+	}
+	logf(verbosityDebug, "sent %d events of %d bytes to %s/%s", len(batch.events), batch.size, destination.LogGroupName, destination.LogStreamName)
+	lp.watermarks.advance(destination, batch.events, time.Now())
+	counters.sent.add(destination, batch.events)
+	counters.entries.Increment(len(batch.events))
+	counters.bytes.Increment(batch.size)
+	progress.markSent(batch.records...)
+	progress.save()
 
 	return nil
 }
 
-func processRecords(reader io.Reader, entryChan chan LogEntry, fieldStore Fields) error {
+// objectDestination returns the log group and stream for the entries of an object, applying the account routes
+// and expanding the placeholders of the configured log group and stream names from the object key
+func (lp *CloudWatchLogProcessor) objectDestination(s3Object S3ObjectInfo) (LogConfig, error) {
+	templated := isObjectTemplate(lp.logConfig.LogGroupName) || isObjectTemplate(lp.logConfig.LogStreamName)
+	if !templated && len(lp.config.AccountRoutes) == 0 {
+		return lp.logConfig, nil
+	}
+	key, err := ParseELBObjectKey(s3Object.Key)
+	if err != nil {
+		if !templated {
+			// Only objects of routed accounts are routed, others are sent to the configured destination
+			return lp.logConfig, nil
+		}
+		return LogConfig{}, fmt.Errorf("failed to derive the log group and stream names: %v", err)
+	}
+	destination := lp.logConfig
+	if route, ok := lp.config.AccountRoutes.Lookup(key); ok {
+		if route.LogGroupName != "" {
+			destination.LogGroupName = route.LogGroupName
+		}
+		destination.RoleARN = route.RoleARN
+	}
+
+	return LogConfig{
+		LogGroupName:  expandLogName(destination.LogGroupName, key),
+		LogStreamName: expandLogName(destination.LogStreamName, key),
+		RoleARN:       destination.RoleARN,
+	}, nil
+}
+
+// destination returns the log group and stream an entry should be sent to
+func (lp *CloudWatchLogProcessor) destination(entry LogEntry) LogConfig {
+	destination := lp.logConfig
+	if entry.Destination.LogGroupName != "" {
+		destination.LogGroupName = entry.Destination.LogGroupName
+	}
+	if entry.Destination.LogStreamName != "" {
+		destination.LogStreamName = entry.Destination.LogStreamName
+	}
+	if entry.Destination.RoleARN != "" {
+		destination.RoleARN = entry.Destination.RoleARN
+	}
+	destination.LogGroupName = expandDay(destination.LogGroupName, entry.Timestamp)
+	destination.LogStreamName = expandDay(destination.LogStreamName, entry.Timestamp)
+
+	return destination
+}
+
+// recreateDestination creates a log group and stream again that were deleted while running, e.g. by hand or by a
+// deployment during a backfill. This includes the configured destination, which ensureDestination skips.
+// Concurrent batches for the destination share a single attempt.
+func (lp *CloudWatchLogProcessor) recreateDestination(destination LogConfig) error {
+	lp.ensured.Forget(LogConfig{LogGroupName: destination.LogGroupName, RoleARN: destination.RoleARN})
+	lp.ensured.Forget(destination)
+	client := lp.roleClients.Client(destination.RoleARN, lp.cwClient)
+
+	return lp.ensured.Ensure(destination, func() error {
+		if err := ensureLogGroupExists(client, destination.LogGroupName); err != nil {
+			return err
+		}
+		if lp.config.Subscription.Enabled() && destination.RoleARN == "" {
+			if err := ensureSubscriptionFilter(client, destination.LogGroupName, lp.config.Subscription); err != nil {
+				return err
+			}
+		}
+
+		return ensureLogStreamExists(client, destination.LogGroupName, destination.LogStreamName)
+	})
+}
+
+// ensureDestination makes sure the log group and stream of a routed destination exist, with the subscription
+// filter if configured. The configured log group and stream are created at startup, so they are not checked
+// again. Other log groups are checked once, not for every stream in them.
+func (lp *CloudWatchLogProcessor) ensureDestination(destination LogConfig) error {
+	if destination == lp.logConfig {
+		return nil
+	}
+	client := lp.roleClients.Client(destination.RoleARN, lp.cwClient)
+	if destination.LogGroupName != lp.logConfig.LogGroupName || destination.RoleARN != lp.logConfig.RoleARN {
+		logGroup := LogConfig{LogGroupName: destination.LogGroupName, RoleARN: destination.RoleARN}
+		if err := lp.ensured.Ensure(logGroup, func() error {
+			if err := ensureLogGroupExists(client, destination.LogGroupName); err != nil {
+				return err
+			}
+			// The destination of the subscription filter is in this account, so it can't be used for log
+			// groups written with a role in another account
+			if lp.config.Subscription.Enabled() && destination.RoleARN == "" {
+				return ensureSubscriptionFilter(client, destination.LogGroupName, lp.config.Subscription)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return lp.ensured.Ensure(destination, func() error {
+		return ensureLogStreamExists(client, destination.LogGroupName, destination.LogStreamName)
+	})
+}
+
+func processRecords(reader io.Reader, entryChan chan<- LogEntry, fieldStore Fields, layouts TimestampLayouts, transformers []Transformer) error {
 	csvReader := csv.NewReader(reader)
 	csvReader.Comma = ' '
+	// The number of fields is checked when creating the entry, so records with a missing field can be skipped
+	csvReader.FieldsPerRecord = -1
+	stage := newRecordStage(transformers)
 	for {
 		record, err := csvReader.Read()
 		if err == io.EOF {
@@ -167,9 +881,22 @@ func processRecords(reader io.Reader, entryChan chan LogEntry, fieldStore Fields
 		if err != nil {
 			return fmt.Errorf("error reading a record: %v", err)
 		}
-		entry, err := recordToLogEntry(record, fieldStore)
+		if !stage.keep(record) {
+			continue
+		}
+		entry, err := recordToLogEntry(record, fieldStore, layouts)
 		if err != nil {
-			return err
+			if err := stage.invalidRecord(record, err); err != nil {
+				return err
+			}
+			continue
+		}
+		stage.transform(record, &entry)
+		if err := entry.encode(); err != nil {
+			if err := stage.unencodable(entry, err); err != nil {
+				return err
+			}
+			continue
 		}
 		entryChan <- entry
 	}
@@ -177,16 +904,16 @@ func processRecords(reader io.Reader, entryChan chan LogEntry, fieldStore Fields
 	return nil
 }
 
-func recordToLogEntry(record []string, fieldStore Fields) (LogEntry, error) {
+func recordToLogEntry(record []string, fieldStore Fields, layouts TimestampLayouts) (LogEntry, error) {
 	// Check if the record has the expected number of fields
 	if len(record) != len(fieldNames) {
 		return LogEntry{}, fmt.Errorf("invalid log format: expected %d fields, got %d", len(fieldNames), len(record))
 	}
-	timestamp, err := time.Parse(time.RFC3339, record[1]) // Timestamp should be at index 1
+	timestamp, err := layouts.Parse(record[1]) // Timestamp should be at index 1
 	if err != nil {
 		return LogEntry{}, fmt.Errorf("error parsing timestamp: %v", err)
 	}
-	entryMap := make(map[string]string)
+	entryMap := make(map[string]interface{})
 	for i, value := range record {
 		// Only include the fields that we want
 		if fieldStore.IncludeField(i) {
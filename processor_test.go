@@ -3,11 +3,16 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"io"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/stretchr/testify/assert"
@@ -24,11 +29,26 @@ func (m *MockS3Api) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, er
 	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
 }
 
+func (m *MockS3Api) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.HeadObjectOutput), args.Error(1)
+}
+
 func (m *MockS3Api) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
 	args := m.Called(input)
 	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
 }
 
+func (m *MockS3Api) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutObjectOutput), args.Error(1)
+}
+
+func (m *MockS3Api) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
+}
+
 func TestProcessLogs(t *testing.T) {
 	t.Run("Successful Processing", func(t *testing.T) {
 		mockS3 := new(MockS3Api)
@@ -42,6 +62,7 @@ func TestProcessLogs(t *testing.T) {
 		_, err := gz.Write([]byte(mockBody))
 		require.NoError(t, err)
 		require.NoError(t, gz.Close())
+		gzipped := buf.Len()
 
 		mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{
 			Body: io.NopCloser(&buf),
@@ -55,12 +76,129 @@ func TestProcessLogs(t *testing.T) {
 
 		lp := &CloudWatchLogProcessor{
 			s3Client:   mockS3,
+			source:     NewSources(mockS3),
+			cwClient:   mockCW,
+			fieldStore: fieldStore,
+			logConfig:  LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		}
+
+		result, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Entries)
+		assert.Equal(t, int64(gzipped), result.CompressedBytes)
+		assert.Equal(t, int64(len(mockBody)), result.DecompressedBytes)
+		assert.Greater(t, result.SentBytes, int64(eventOverhead))
+
+		mockS3.AssertExpectations(t)
+		mockCW.AssertExpectations(t)
+	})
+
+	t.Run("Entries without fields are dropped", func(t *testing.T) {
+		mockS3 := new(MockS3Api)
+		mockCW := new(MockCloudWatchLogsClient)
+
+		// Without processing times, the latency breakdown leaves the second entry without any field
+		body := testRecordLine(0) + "\n" + strings.Replace(testRecordLine(1), " 0.004 0.024 0.003 ", " -1 -1 -1 ", 1) + "\n"
+		mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(strings.NewReader(body)),
+		}, nil).Once()
+		mockCW.On("PutLogEvents", mock.MatchedBy(func(input *cloudwatchlogs.PutLogEventsInput) bool {
+			return len(input.LogEvents) == 1 &&
+				aws.StringValue(input.LogEvents[0].Message) == `{"latency":{"request_ms":4,"response_ms":3,"target_ms":24,"total_ms":31}}`
+		})).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil).Once()
+
+		fieldStore, err := NewFields("request_processing_time,target_processing_time,response_processing_time")
+		require.NoError(t, err)
+
+		lp := &CloudWatchLogProcessor{
+			s3Client:   mockS3,
+			source:     NewSources(mockS3),
+			cwClient:   mockCW,
+			fieldStore: fieldStore,
+			config:     Config{LatencyBreakdown: true},
+			logConfig:  LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		}
+
+		result, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Entries)
+		mockCW.AssertExpectations(t)
+
+		// Strict runs fail on them
+		mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(strings.NewReader(body)),
+		}, nil).Once()
+		lp.config.Strict = true
+		_, err = lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
+		assert.ErrorContains(t, err, "record 2: entry without fields")
+	})
+
+	t.Run("Strict sending skips records that can't be parsed", func(t *testing.T) {
+		mockS3 := new(MockS3Api)
+		mockCW := new(MockCloudWatchLogsClient)
+		body := testRecordLine(0) + "\nnot a record\n" + strings.Replace(testRecordLine(1), " 0.004 0.024 0.003 ", " -1 -1 -1 ", 1) + "\n"
+		mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(strings.NewReader(body)),
+		}, nil).Once()
+		fieldStore, err := NewFields("request_processing_time,target_processing_time,response_processing_time")
+		require.NoError(t, err)
+		lp := &CloudWatchLogProcessor{
+			s3Client:   mockS3,
+			source:     NewSources(mockS3),
+			cwClient:   mockCW,
+			fieldStore: fieldStore,
+			config:     Config{LatencyBreakdown: true, StrictSending: true},
+			logConfig:  LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		}
+
+		// The second record is skipped, the third parsed but can't be sent without fields
+		_, err = lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
+		assert.ErrorContains(t, err, "record 3: entry without fields")
+		mockCW.AssertNotCalled(t, "PutLogEvents", mock.Anything)
+	})
+
+	t.Run("Route By Target Group", func(t *testing.T) {
+		mockS3 := new(MockS3Api)
+		mockCW := new(MockCloudWatchLogsClient)
+
+		mockBody := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify?user_ids=xxxxx4-xxxx-xxxx-xxxx-xxxxxxxxxxxx&ref_date= HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4 "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(mockBody))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(&buf),
+		}, nil)
+
+		// The routed stream doesn't exist yet, so it is created in the configured log group
+		mockCW.On("DescribeLogStreams", &cloudwatchlogs.DescribeLogStreamsInput{
+			LogGroupName:        aws.String("test-log-group"),
+			LogStreamNamePrefix: aws.String("example-prod-tg"),
+		}).Return(&cloudwatchlogs.DescribeLogStreamsOutput{}, nil)
+		mockCW.On("CreateLogStream", &cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  aws.String("test-log-group"),
+			LogStreamName: aws.String("example-prod-tg"),
+		}).Return(&cloudwatchlogs.CreateLogStreamOutput{}, nil)
+		mockCW.On("PutLogEvents", mock.MatchedBy(func(input *cloudwatchlogs.PutLogEventsInput) bool {
+			return *input.LogStreamName == "example-prod-tg"
+		})).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil)
+
+		fieldStore, err := NewFields("")
+		require.NoError(t, err)
+
+		lp := &CloudWatchLogProcessor{
+			s3Client:   mockS3,
+			source:     NewSources(mockS3),
 			cwClient:   mockCW,
 			fieldStore: fieldStore,
+			config:     Config{RouteByTargetGroup: true},
 			logConfig:  LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
 		}
 
-		err = lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
+		_, err = lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
 		require.NoError(t, err)
 
 		mockS3.AssertExpectations(t)
@@ -68,6 +206,496 @@ func TestProcessLogs(t *testing.T) {
 	})
 }
 
+func TestProcessLogsStreamPerLoadBalancer(t *testing.T) {
+	lp := &CloudWatchLogProcessor{logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "{elb}/{date}"}}
+
+	t.Run("Derived from the key", func(t *testing.T) {
+		mockBody := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4 "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(mockBody))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		mockS3 := new(MockS3Api)
+		mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(&buf),
+		}, nil)
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{}, nil).Once()
+		mockCW.On("CreateLogStream", &cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  aws.String("test-log-group"),
+			LogStreamName: aws.String("example-prod-lb/2024-03-21"),
+		}).Return(&cloudwatchlogs.CreateLogStreamOutput{}, nil)
+		mockCW.On("PutLogEvents", mock.MatchedBy(func(input *cloudwatchlogs.PutLogEventsInput) bool {
+			return *input.LogStreamName == "example-prod-lb/2024-03-21"
+		})).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil)
+		lp.s3Client = mockS3
+		lp.source = NewSources(mockS3)
+		lp.cwClient = mockCW
+		lp.fieldStore, err = NewFields("")
+		require.NoError(t, err)
+
+		key := "AWSLogs/987654321098/elasticloadbalancing/xx-west-1/2024/03/21/987654321098_elasticloadbalancing_xx-west-1_app.example-prod-lb.xxxxxxx4_20240321T1615Z_192.0.2.1_abcdefgh.log.gz"
+		_, err = lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: key})
+		require.NoError(t, err)
+
+		mockCW.AssertExpectations(t)
+	})
+
+	t.Run("Log group per load balancer", func(t *testing.T) {
+		mockBody := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4 "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+		key := "AWSLogs/987654321098/elasticloadbalancing/xx-west-1/2024/03/21/987654321098_elasticloadbalancing_xx-west-1_app.example-prod-lb.xxxxxxx4_20240321T1615Z_192.0.2.1_abcdefgh.log.gz"
+
+		mockCW := new(MockCloudWatchLogsClient)
+		// The log group is only checked once for both objects
+		mockCW.On("DescribeLogGroups", &cloudwatchlogs.DescribeLogGroupsInput{
+			LogGroupNamePrefix: aws.String("/elb/987654321098/example-prod-lb"),
+		}).Return(&cloudwatchlogs.DescribeLogGroupsOutput{}, nil).Once()
+		mockCW.On("CreateLogGroup", &cloudwatchlogs.CreateLogGroupInput{
+			LogGroupName: aws.String("/elb/987654321098/example-prod-lb"),
+		}).Return(&cloudwatchlogs.CreateLogGroupOutput{}, nil).Once()
+		mockCW.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{}, nil).Once()
+		mockCW.On("CreateLogStream", &cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  aws.String("/elb/987654321098/example-prod-lb"),
+			LogStreamName: aws.String("example-prod-tg"),
+		}).Return(&cloudwatchlogs.CreateLogStreamOutput{}, nil).Once()
+		mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil)
+		fieldStore, err := NewFields("")
+		require.NoError(t, err)
+		lp := &CloudWatchLogProcessor{
+			cwClient:   mockCW,
+			fieldStore: fieldStore,
+			config:     Config{RouteByTargetGroup: true},
+			logConfig:  LogConfig{LogGroupName: "/elb/{account}/{elb}", LogStreamName: "test-log-stream"},
+		}
+
+		for i := 0; i < 2; i++ {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			_, err := gz.Write([]byte(mockBody))
+			require.NoError(t, err)
+			require.NoError(t, gz.Close())
+			mockS3 := new(MockS3Api)
+			mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{
+				Body: io.NopCloser(&buf),
+			}, nil)
+			lp.s3Client = mockS3
+			lp.source = NewSources(mockS3)
+
+			_, err = lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: key})
+			require.NoError(t, err)
+		}
+
+		mockCW.AssertExpectations(t)
+	})
+
+	t.Run("Key without load balancer", func(t *testing.T) {
+		mockS3 := new(MockS3Api)
+		lp.s3Client = mockS3
+		lp.source = NewSources(mockS3)
+
+		_, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
+		require.ErrorContains(t, err, "failed to derive the log group and stream names")
+
+		mockS3.AssertNotCalled(t, "GetObject", mock.Anything)
+	})
+}
+
+func TestProcessLogsEmptyObject(t *testing.T) {
+	lp := &CloudWatchLogProcessor{logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}}
+
+	t.Run("Zero size from listing", func(t *testing.T) {
+		mockS3 := new(MockS3Api)
+		lp.s3Client = mockS3
+		lp.source = NewSources(mockS3)
+
+		_, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key", Size: aws.Int64(0)})
+		require.ErrorIs(t, err, ErrEmptyObject)
+
+		mockS3.AssertNotCalled(t, "GetObject", mock.Anything)
+	})
+
+	t.Run("Gzip file without entries", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		require.NoError(t, gz.Close())
+
+		mockS3 := new(MockS3Api)
+		mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(&buf),
+		}, nil)
+		lp.s3Client = mockS3
+		lp.source = NewSources(mockS3)
+
+		_, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
+		require.ErrorIs(t, err, ErrEmptyObject)
+	})
+}
+
+func TestProcessLogsHeadObjectChecks(t *testing.T) {
+	checkpoints := &MemoryCheckpointStore{}
+	checkpoints.MarkProcessed("test-bucket", "test-key", `"etag1"`)
+	lp := &CloudWatchLogProcessor{
+		config:      Config{HeadObjectChecks: true},
+		logConfig:   LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		checkpoints: checkpoints,
+	}
+
+	t.Run("Unchanged ETag", func(t *testing.T) {
+		mockS3 := new(MockS3Api)
+		mockS3.On("HeadObject", mock.Anything).Return(&s3.HeadObjectOutput{
+			ContentLength: aws.Int64(1024),
+			ETag:          aws.String(`"etag1"`),
+		}, nil)
+		lp.s3Client = mockS3
+		lp.source = NewSources(mockS3)
+
+		_, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
+		require.ErrorIs(t, err, ErrAlreadyProcessed)
+
+		mockS3.AssertNotCalled(t, "GetObject", mock.Anything)
+	})
+
+	t.Run("Changed ETag", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		require.NoError(t, gz.Close())
+
+		mockS3 := new(MockS3Api)
+		mockS3.On("HeadObject", mock.Anything).Return(&s3.HeadObjectOutput{
+			ContentLength: aws.Int64(20),
+			ETag:          aws.String(`"etag2"`),
+		}, nil)
+		mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(&buf),
+		}, nil)
+		lp.s3Client = mockS3
+		lp.source = NewSources(mockS3)
+
+		_, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "test-key"})
+		require.ErrorIs(t, err, ErrEmptyObject)
+
+		mockS3.AssertCalled(t, "GetObject", mock.Anything)
+	})
+
+	t.Run("Empty object", func(t *testing.T) {
+		mockS3 := new(MockS3Api)
+		mockS3.On("HeadObject", mock.Anything).Return(&s3.HeadObjectOutput{
+			ContentLength: aws.Int64(0),
+			ETag:          aws.String(`"etag3"`),
+		}, nil)
+		lp.s3Client = mockS3
+		lp.source = NewSources(mockS3)
+
+		_, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: "other-key"})
+		require.ErrorIs(t, err, ErrEmptyObject)
+
+		mockS3.AssertNotCalled(t, "GetObject", mock.Anything)
+	})
+}
+
+func TestNewLogProcessorLazySetUp(t *testing.T) {
+	mockCW := new(MockCloudWatchLogsClient)
+	state := &State{
+		Config:       Config{LogGroupName: "test-log-group", LogStreamName: "test-log-stream", StreamWriterCheck: streamWriterIgnore},
+		CWClient:     mockCW,
+		FunctionName: "test-function",
+	}
+	lp, err := NewLogProcessor(state)
+	require.NoError(t, err)
+	// Nothing is called before the first object
+	mockCW.AssertNotCalled(t, "DescribeLogGroups", mock.Anything)
+
+	processor := lp.(*CloudWatchLogProcessor)
+	mockCW.On("DescribeLogGroups", mock.Anything).Return((*cloudwatchlogs.DescribeLogGroupsOutput)(nil), errors.New("throttled")).Once()
+	require.EqualError(t, processor.prepare(false), "error creating log group and stream: throttled")
+
+	// A failed set up is retried, and concurrent objects share a single successful one
+	mockCW.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{
+		LogGroups: []*cloudwatchlogs.LogGroup{{LogGroupName: aws.String("test-log-group")}},
+	}, nil)
+	mockCW.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{
+		LogStreams: []*cloudwatchlogs.LogStream{{LogStreamName: aws.String("test-log-stream")}},
+	}, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, processor.prepare(false))
+		}()
+	}
+	wg.Wait()
+	mockCW.AssertNumberOfCalls(t, "DescribeLogGroups", 2)
+	mockCW.AssertNumberOfCalls(t, "DescribeLogStreams", 1)
+}
+
+func TestNewLogProcessorRoutes(t *testing.T) {
+	mockCW := new(MockCloudWatchLogsClient)
+	describedGroup := func(name string) interface{} {
+		return mock.MatchedBy(func(input *cloudwatchlogs.DescribeLogGroupsInput) bool {
+			return aws.StringValue(input.LogGroupNamePrefix) == name
+		})
+	}
+	for _, name := range []string{"test-log-group", "/elb/team-a"} {
+		mockCW.On("DescribeLogGroups", describedGroup(name)).Return(&cloudwatchlogs.DescribeLogGroupsOutput{
+			LogGroups: []*cloudwatchlogs.LogGroup{{LogGroupName: aws.String(name)}},
+		}, nil)
+	}
+	mockCW.On("DescribeLogGroups", describedGroup("/elb/team-b")).Return((*cloudwatchlogs.DescribeLogGroupsOutput)(nil), errors.New("AccessDeniedException"))
+	mockCW.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{}, nil)
+	mockCW.On("CreateLogStream", mock.Anything).Return(&cloudwatchlogs.CreateLogStreamOutput{}, nil)
+
+	state := &State{
+		Config: Config{
+			LogGroupName:      "test-log-group",
+			LogStreamName:     "test-log-stream",
+			StreamWriterCheck: streamWriterIgnore,
+			AccountRoutes: AccountRoutes{
+				"111111111111": {LogGroupName: "/elb/team-a"},
+				"222222222222": {LogGroupName: "/elb/team-b"},
+				"333333333333": {LogGroupName: "/elb/{elb}"}, // Only known per object
+			},
+			HostRoutes: HostRoutes{
+				{Host: "*.example.com", LogStreamName: "example"},
+				{Host: "*", LogStreamName: "{host}"}, // Only known per entry
+			},
+		},
+		CWClient: mockCW,
+	}
+	lp, err := NewLogProcessor(state)
+	require.NoError(t, err)
+	mockCW.AssertNotCalled(t, "DescribeLogGroups", mock.Anything)
+
+	// Commands that send entries set up all destinations known in advance at startup, and all failures are reported
+	err = (&Handler{lp: lp}).setUpDestinations()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to set up 1 of 3 routed destinations")
+	assert.Contains(t, err.Error(), "log group /elb/team-b, stream test-log-stream")
+	mockCW.AssertCalled(t, "CreateLogStream", &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName: aws.String("/elb/team-a"), LogStreamName: aws.String("test-log-stream"),
+	})
+	mockCW.AssertCalled(t, "CreateLogStream", &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName: aws.String("test-log-group"), LogStreamName: aws.String("example"),
+	})
+}
+
+func TestSendEntriesFlushInterval(t *testing.T) {
+	mockCW := new(MockCloudWatchLogsClient)
+	sent := make(chan int, 10)
+	mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil).Run(func(args mock.Arguments) {
+		sent <- len(args.Get(0).(*cloudwatchlogs.PutLogEventsInput).LogEvents)
+	})
+
+	lp := &CloudWatchLogProcessor{
+		cwClient:  mockCW,
+		config:    Config{FlushInterval: 10 * time.Millisecond},
+		logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+	}
+	entryChan := make(chan LogEntry)
+	counters := &sendCounters{}
+	done := make(chan struct{})
+	go func() {
+		lp.sendEntries(entryChan, counters, nil)
+		close(done)
+	}()
+
+	// The entry is sent by the flush interval, long before the batch is full or the channel is closed
+	entryChan <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: time.Now()}
+	select {
+	case n := <-sent:
+		assert.Equal(t, 1, n)
+	case <-time.After(time.Second):
+		t.Fatal("batch was not flushed")
+	}
+
+	close(entryChan)
+	<-done
+	assert.Equal(t, 1, counters.entries.Value())
+}
+
+func TestSendEntriesPerDay(t *testing.T) {
+	mockCW := new(MockCloudWatchLogsClient)
+	mockCW.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{}, nil)
+	mockCW.On("CreateLogStream", mock.Anything).Return(&cloudwatchlogs.CreateLogStreamOutput{}, nil)
+	sent := make(map[string][]int64)
+	mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil).Run(func(args mock.Arguments) {
+		input := args.Get(0).(*cloudwatchlogs.PutLogEventsInput)
+		for _, event := range input.LogEvents {
+			sent[aws.StringValue(input.LogStreamName)] = append(sent[aws.StringValue(input.LogStreamName)], aws.Int64Value(event.Timestamp))
+		}
+	})
+
+	lp := &CloudWatchLogProcessor{
+		cwClient:  mockCW,
+		logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "history/{day}"},
+	}
+	midnight := time.Date(2024, 3, 22, 0, 0, 0, 0, time.UTC)
+	entryChan := make(chan LogEntry, 3)
+	for _, timestamp := range []time.Time{midnight.Add(-time.Second), midnight, midnight.Add(-2 * time.Second)} {
+		entryChan <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: timestamp}
+	}
+	close(entryChan)
+
+	counters := &sendCounters{}
+	require.NoError(t, lp.sendEntries(entryChan, counters, nil))
+	assert.Equal(t, 3, counters.entries.Value())
+	assert.Equal(t, map[string][]int64{
+		"history/2024-03-21": {midnight.Add(-2 * time.Second).UnixMilli(), midnight.Add(-time.Second).UnixMilli()},
+		"history/2024-03-22": {midnight.UnixMilli()},
+	}, sent)
+	mockCW.AssertNumberOfCalls(t, "PutLogEvents", 2)
+}
+
+func TestSendEntriesDeletedDestination(t *testing.T) {
+	notFound := awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log stream does not exist.", nil)
+	newEntries := func() chan LogEntry {
+		entryChan := make(chan LogEntry, 2)
+		entryChan <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: time.Now()}
+		entryChan <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: time.Now(), Record: 1}
+		close(entryChan)
+		return entryChan
+	}
+
+	t.Run("Created again and sent", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("PutLogEvents", mock.Anything).Return((*cloudwatchlogs.PutLogEventsOutput)(nil), notFound).Once()
+		mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil).Once()
+		mockCW.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{}, nil)
+		mockCW.On("CreateLogGroup", mock.Anything).Return(&cloudwatchlogs.CreateLogGroupOutput{}, nil)
+		mockCW.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{}, nil)
+		mockCW.On("CreateLogStream", mock.Anything).Return(&cloudwatchlogs.CreateLogStreamOutput{}, nil)
+		lp := &CloudWatchLogProcessor{
+			cwClient:  mockCW,
+			logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		}
+
+		counters := &sendCounters{}
+		require.NoError(t, lp.sendEntries(newEntries(), counters, nil))
+		assert.Equal(t, 2, counters.entries.Value())
+		mockCW.AssertNumberOfCalls(t, "PutLogEvents", 2)
+		mockCW.AssertCalled(t, "CreateLogGroup", &cloudwatchlogs.CreateLogGroupInput{LogGroupName: aws.String("test-log-group")})
+		mockCW.AssertCalled(t, "CreateLogStream", &cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName: aws.String("test-log-group"), LogStreamName: aws.String("test-log-stream"),
+		})
+	})
+
+	t.Run("Creating it again fails", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("PutLogEvents", mock.Anything).Return((*cloudwatchlogs.PutLogEventsOutput)(nil), notFound)
+		mockCW.On("DescribeLogGroups", mock.Anything).Return((*cloudwatchlogs.DescribeLogGroupsOutput)(nil), errors.New("throttled"))
+		lp := &CloudWatchLogProcessor{
+			cwClient:  mockCW,
+			logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		}
+
+		counters := &sendCounters{}
+		err := lp.sendEntries(newEntries(), counters, nil)
+		require.Error(t, err)
+		assert.True(t, isResourceNotFound(err))
+		assert.Contains(t, err.Error(), "creating it again failed: throttled")
+		assert.Equal(t, 0, counters.entries.Value())
+		mockCW.AssertNumberOfCalls(t, "PutLogEvents", 1)
+	})
+}
+
+func TestLogEntryEncode(t *testing.T) {
+	t.Run("Multi-byte and escaped characters", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{"user_agent": "Mozilla/5.0 (ünïcödé) <script>"}}
+		require.NoError(t, entry.encode())
+
+		// Sizes are based on the final JSON bytes, including escaping of <, > and multi-byte characters
+		assert.Equal(t, `{"user_agent":"Mozilla/5.0 (ünïcödé) \u003cscript\u003e"}`, entry.Message)
+		assert.Equal(t, len(entry.Message)+26, entry.Size)
+		assert.Equal(t, EstimateEventSize(&cloudwatchlogs.InputLogEvent{Message: aws.String(entry.Message)}), entry.Size)
+	})
+
+	t.Run("Same as encoding/json", func(t *testing.T) {
+		var characters strings.Builder
+		for r := rune(0); r < 0x3000; r++ {
+			characters.WriteRune(r)
+		}
+		data := map[string]interface{}{
+			"characters":      characters.String(),
+			"invalid_utf8":    "a\xffb\xc3",
+			"key \"<&>\u2028": "value",
+			"latency":         map[string]interface{}{"target_ms": 24.5, "total_ms": 1e21, "request_ms": 0.0000001},
+			"is_error":        true,
+			"missing":         nil,
+			"count":           3,
+			"ids":             []string{"a", "b"},
+			"empty":           map[string]interface{}{},
+		}
+		want, err := json.Marshal(data)
+		require.NoError(t, err)
+		entry := LogEntry{Data: data}
+		require.NoError(t, entry.encode())
+		assert.Equal(t, string(want), entry.Message)
+	})
+
+	t.Run("Oversized entries are dropped", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		lp := &CloudWatchLogProcessor{
+			cwClient:  mockCW,
+			logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		}
+		entryChan := make(chan LogEntry, 1)
+		entryChan <- LogEntry{Data: map[string]interface{}{"request": strings.Repeat("x", maxEventSize)}}
+		close(entryChan)
+
+		counters := &sendCounters{}
+		lp.sendEntries(entryChan, counters, nil)
+		assert.Equal(t, 0, counters.entries.Value())
+		mockCW.AssertNotCalled(t, "PutLogEvents", mock.Anything)
+	})
+
+	t.Run("Dropped entries fail when strict", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		lp := &CloudWatchLogProcessor{
+			cwClient:  mockCW,
+			config:    Config{Strict: true},
+			logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		}
+		entryChan := make(chan LogEntry, 2)
+		entryChan <- LogEntry{Data: map[string]interface{}{"request": strings.Repeat("x", maxEventSize)}, Record: 4}
+		entryChan <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Record: 5}
+		close(entryChan)
+
+		err := lp.sendEntries(entryChan, &sendCounters{}, nil)
+		assert.ErrorContains(t, err, "record 5: entry of")
+		mockCW.AssertNotCalled(t, "PutLogEvents", mock.Anything)
+	})
+
+	t.Run("Rejected events", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{
+			RejectedLogEventsInfo: &cloudwatchlogs.RejectedLogEventsInfo{TooOldLogEventEndIndex: aws.Int64(1)},
+		}, nil)
+		newEntries := func() chan LogEntry {
+			entryChan := make(chan LogEntry, 2)
+			entryChan <- LogEntry{Data: map[string]interface{}{"request": "GET"}, Timestamp: time.Unix(1, 0)}
+			entryChan <- LogEntry{Data: map[string]interface{}{"request": "POST"}, Timestamp: time.Unix(2, 0)}
+			close(entryChan)
+			return entryChan
+		}
+		lp := &CloudWatchLogProcessor{
+			cwClient:  mockCW,
+			logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		}
+
+		counters := &sendCounters{}
+		require.NoError(t, lp.sendEntries(newEntries(), counters, nil))
+		assert.Equal(t, 1, counters.entries.Value())
+		assert.Equal(t, 1, counters.rejected.Value())
+
+		lp.config.Strict = true
+		err := lp.sendEntries(newEntries(), &sendCounters{}, nil)
+		assert.EqualError(t, err, "1 of 2 events were rejected as too old, too new or expired")
+	})
+}
+
 func TestProcessRecords(t *testing.T) {
 	t.Run("Process CSV Records", func(t *testing.T) {
 		fieldStore, err := NewFields("")
@@ -79,7 +707,7 @@ func TestProcessRecords(t *testing.T) {
 		entryChan := make(chan LogEntry, 10)
 
 		go func() {
-			err := processRecords(mockReader, entryChan, fieldStore)
+			err := processRecords(mockReader, entryChan, fieldStore, nil, nil)
 			require.NoError(t, err)
 			close(entryChan)
 		}()
@@ -92,6 +720,22 @@ func TestProcessRecords(t *testing.T) {
 
 		assert.Equal(t, 1, count)
 	})
+
+	t.Run("Duplicates are dropped", func(t *testing.T) {
+		fieldStore, err := NewFields("request")
+		require.NoError(t, err)
+
+		line := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "GET https://example.com:443/ HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 - "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "-" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+		entryChan := make(chan LogEntry, 10)
+		dedup := &Deduplicator{Window: 10}
+
+		err = processRecords(strings.NewReader(line+"\n"+line+"\n"), entryChan, fieldStore, nil, []Transformer{dedup})
+		require.NoError(t, err)
+		close(entryChan)
+
+		assert.Len(t, entryChan, 1)
+		assert.Equal(t, map[string]int{"duplicates": 1}, summarize([]Transformer{dedup}))
+	})
 }
 
 func TestRecordToLogEntry(t *testing.T) {
@@ -132,9 +776,40 @@ func TestRecordToLogEntry(t *testing.T) {
 			"TID_a1b2c3d4e5f67890abcdef1234567890",
 		}
 
-		logEntry, err := recordToLogEntry(record, fieldStore)
+		logEntry, err := recordToLogEntry(record, fieldStore, nil)
 		require.NoError(t, err)
 		assert.Equal(t, "2024-03-21T16:10:26.071854Z", logEntry.Timestamp.Format(time.RFC3339Nano))
 		assert.Equal(t, "PUT https://example.com:443/api/modify?user_ids=xxxxx4-xxxx-xxxx-xxxx-xxxxxxxxxxxx&ref_date= HTTP/1.1", logEntry.Data["request"])
 	})
 }
+
+func BenchmarkProcessRecords(b *testing.B) {
+	fieldStore, err := NewFields("")
+	require.NoError(b, err)
+
+	line := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify?user_ids=xxxxx4-xxxx-xxxx-xxxx-xxxxxxxxxxxx&ref_date= HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4 "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "arn:aws:acm:xx-west-1:987654321098:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"` + "\n"
+	data := strings.Repeat(line, 1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entryChan := make(chan LogEntry, 1000)
+		require.NoError(b, processRecords(strings.NewReader(data), entryChan, fieldStore, nil, nil))
+	}
+}
+
+// BenchmarkLogEntryEncode encodes an entry of all fields, which took 64 allocations with json.Encoder and takes 2:
+// the keys and the message
+func BenchmarkLogEntryEncode(b *testing.B) {
+	fieldStore, err := NewFields("")
+	require.NoError(b, err)
+	entries := make(chan LogEntry, 1)
+	require.NoError(b, processRecords(strings.NewReader(testRecordLine(0)+"\n"), entries, fieldStore, nil, nil))
+	entry := <-entries
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		require.NoError(b, entry.encode())
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ProgressStore remembers how many records of an object have been sent to CloudWatch, so a retry after
+// a failure halfway through a large object resumes after them instead of sending them again. The
+// progress is only valid for the same ETag, a new object written under the same key starts over.
+type ProgressStore interface {
+	SentRecords(bucket, key, etag string) int
+	SaveSentRecords(bucket, key, etag string, records int)
+	ClearProgress(bucket, key string)
+}
+
+type objectRecords struct {
+	etag    string
+	records int
+}
+
+// MemoryProgressStore is a ProgressStore that lives as long as the process, which includes
+// warm Lambda invocations. The zero value is ready to use.
+type MemoryProgressStore struct {
+	mu      sync.Mutex
+	objects map[string]objectRecords
+}
+
+func (s *MemoryProgressStore) SentRecords(bucket, key, etag string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if progress, ok := s.objects[bucket+"/"+key]; ok && progress.etag == etag {
+		return progress.records
+	}
+
+	return 0
+}
+
+func (s *MemoryProgressStore) SaveSentRecords(bucket, key, etag string, records int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects == nil {
+		s.objects = make(map[string]objectRecords)
+	}
+	s.objects[bucket+"/"+key] = objectRecords{etag: etag, records: records}
+}
+
+func (s *MemoryProgressStore) ClearProgress(bucket, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, bucket+"/"+key)
+}
+
+type DynamoDBApi interface {
+	GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+	DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error)
+	UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+}
+
+// DynamoDBProgressStore is a ProgressStore that survives the process, in a table with the string partition key
+// "object". Errors are logged, losing progress only means records may be sent twice.
+type DynamoDBProgressStore struct {
+	client DynamoDBApi
+	table  string
+}
+
+func (s *DynamoDBProgressStore) SentRecords(bucket, key, etag string) int {
+	resp, err := s.client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            map[string]*dynamodb.AttributeValue{"object": {S: aws.String(bucket + "/" + key)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		log.Printf("failed to get progress of s3://%s/%s: %v", bucket, key, err)
+		return 0
+	}
+	if resp.Item == nil || aws.StringValue(resp.Item["etag"].S) != etag || resp.Item["records"] == nil {
+		return 0
+	}
+	records, _ := strconv.Atoi(aws.StringValue(resp.Item["records"].N))
+
+	return records
+}
+
+func (s *DynamoDBProgressStore) SaveSentRecords(bucket, key, etag string, records int) {
+	_, err := s.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"object":  {S: aws.String(bucket + "/" + key)},
+			"etag":    {S: aws.String(etag)},
+			"records": {N: aws.String(strconv.Itoa(records))},
+		},
+	})
+	if err != nil {
+		log.Printf("failed to save progress of s3://%s/%s: %v", bucket, key, err)
+	}
+}
+
+func (s *DynamoDBProgressStore) ClearProgress(bucket, key string) {
+	_, err := s.client.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key:       map[string]*dynamodb.AttributeValue{"object": {S: aws.String(bucket + "/" + key)}},
+	})
+	if err != nil {
+		log.Printf("failed to clear progress of s3://%s/%s: %v", bucket, key, err)
+	}
+}
+
+// objectProgress tracks which records of an object have been sent. Batches to different destinations and
+// reordered entries are sent out of record order, so the progress is the number of leading records that have
+// all been sent. Records that were filtered or dropped count as sent. A nil objectProgress tracks nothing.
+type objectProgress struct {
+	store             ProgressStore
+	bucket, key, etag string
+	next              int          // All records before next have been sent
+	sent              map[int]bool // Records after next that have been sent
+	received          int          // Record after the last entry received for sending
+	saved             int
+}
+
+// newObjectProgress loads the progress of an object, store may be nil to disable tracking
+func newObjectProgress(store ProgressStore, bucket, key, etag string) *objectProgress {
+	if store == nil {
+		return nil
+	}
+	records := store.SentRecords(bucket, key, etag)
+
+	return &objectProgress{store: store, bucket: bucket, key: key, etag: etag, next: records, received: records, saved: records}
+}
+
+// resumeAfter returns the number of records that were sent by a previous attempt
+func (p *objectProgress) resumeAfter() int {
+	if p == nil {
+		return 0
+	}
+	return p.saved
+}
+
+// receive is called for every entry in record order, records skipped since the previous entry were filtered
+func (p *objectProgress) receive(record int) {
+	if p == nil {
+		return
+	}
+	for ; p.received < record; p.received++ {
+		p.markSent(p.received)
+	}
+	p.received = record + 1
+}
+
+// markSent marks records as sent and advances the progress
+func (p *objectProgress) markSent(records ...int) {
+	if p == nil {
+		return
+	}
+	for _, record := range records {
+		if record >= p.next {
+			if p.sent == nil {
+				p.sent = make(map[int]bool)
+			}
+			p.sent[record] = true
+		}
+	}
+	for p.sent[p.next] {
+		delete(p.sent, p.next)
+		p.next++
+	}
+}
+
+// save stores the progress if it advanced
+func (p *objectProgress) save() {
+	if p == nil || p.next <= p.saved {
+		return
+	}
+	p.store.SaveSentRecords(p.bucket, p.key, p.etag, p.next)
+	p.saved = p.next
+}
+
+// clear removes the progress once the object has been processed completely
+func (p *objectProgress) clear() {
+	if p == nil {
+		return
+	}
+	p.store.ClearProgress(p.bucket, p.key)
+}
+
+// ResumeFilter skips the records that were sent by a previous attempt. It must be the first filter, as it counts records.
+type ResumeFilter struct {
+	Records int
+	seen    int
+}
+
+func (f *ResumeFilter) Keep(record []string) bool {
+	f.seen++
+	return f.seen > f.Records
+}
+
+// Transform does nothing, records are skipped by Keep before the entry is created
+func (f *ResumeFilter) Transform(record []string, entry *LogEntry) {}
+
+func (f *ResumeFilter) Summarize(summary map[string]int) {
+	summary["resumed_after"] = f.Records
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultProgressInterval is how often progress events are written
+const defaultProgressInterval = 10 * time.Second
+
+// ProgressEvents writes the progress of a CLI run as a JSON line every interval and when the run finishes, for
+// wrappers and dashboards that track long backfills. Methods on a nil ProgressEvents do nothing.
+type ProgressEvents struct {
+	Out      io.Writer
+	Interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	started time.Time
+	total   int
+	done    int
+	failed  int
+	entries int
+	bytes   int64
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// ProgressEvent is a JSON line written by ProgressEvents. Rates are averages since the start of the run.
+type ProgressEvent struct {
+	Event          string    `json:"event"` // "progress" while running, "finished" at the end
+	Time           time.Time `json:"time"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	ObjectsTotal   int       `json:"objects_total"`
+	ObjectsDone    int       `json:"objects_done"` // Including failed objects
+	ObjectsFailed  int       `json:"objects_failed"`
+	Entries        int       `json:"entries"`
+	Bytes          int64     `json:"bytes"` // Bytes sent to CloudWatch
+	EntriesPerSec  float64   `json:"entries_per_sec"`
+	BytesPerSec    float64   `json:"bytes_per_sec"`
+}
+
+// ParseProgressFormat validates the value of --progress
+func ParseProgressFormat(value string) error {
+	if value != "" && value != "json" {
+		return fmt.Errorf("invalid progress format '%s', expected json", value)
+	}
+
+	return nil
+}
+
+// NewProgressEvents returns progress events for a run of total objects that starts now
+func NewProgressEvents(out io.Writer, interval time.Duration, total int) *ProgressEvents {
+	p := &ProgressEvents{Out: out, Interval: interval, now: time.Now, total: total}
+	p.started = p.now()
+
+	return p
+}
+
+// Start writes an event every interval until Finish is called
+func (p *ProgressEvents) Start() {
+	if p == nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.write("progress")
+			}
+		}
+	}()
+}
+
+// Finish stops the periodic events and writes the final event
+func (p *ProgressEvents) Finish() {
+	if p == nil {
+		return
+	}
+	if p.stop != nil {
+		close(p.stop)
+		<-p.stopped
+	}
+	p.write("finished")
+}
+
+// objectDone records that an object was processed, empty and already processed objects count as done
+func (p *ProgressEvents) objectDone(result ObjectResult, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if err != nil && !errors.Is(err, ErrEmptyObject) && !errors.Is(err, ErrAlreadyProcessed) {
+		p.failed++
+	}
+	p.entries += result.Entries
+	p.bytes += result.SentBytes
+}
+
+// Event returns the current progress
+func (p *ProgressEvents) Event(event string) ProgressEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	elapsed := now.Sub(p.started).Seconds()
+	progress := ProgressEvent{
+		Event:          event,
+		Time:           now.UTC(),
+		ElapsedSeconds: elapsed,
+		ObjectsTotal:   p.total,
+		ObjectsDone:    p.done,
+		ObjectsFailed:  p.failed,
+		Entries:        p.entries,
+		Bytes:          p.bytes,
+	}
+	if elapsed > 0 {
+		progress.EntriesPerSec = float64(p.entries) / elapsed
+		progress.BytesPerSec = float64(p.bytes) / elapsed
+	}
+
+	return progress
+}
+
+func (p *ProgressEvents) write(event string) {
+	data, err := json.Marshal(p.Event(event))
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _ = p.Out.Write(append(data, '\n'))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressEvents(t *testing.T) {
+	var buf bytes.Buffer
+	started := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	now := started
+	progress := &ProgressEvents{Out: &buf, Interval: time.Hour, now: func() time.Time { return now }, started: started, total: 4}
+
+	progress.objectDone(ObjectResult{Entries: 100, SentBytes: 50000}, nil)
+	progress.objectDone(ObjectResult{}, ErrEmptyObject)
+	progress.objectDone(ObjectResult{Entries: 20, SentBytes: 10000}, errors.New("throttled"))
+	now = started.Add(10 * time.Second)
+	progress.Finish()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+	var event ProgressEvent
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+	assert.Equal(t, ProgressEvent{
+		Event:          "finished",
+		Time:           now,
+		ElapsedSeconds: 10,
+		ObjectsTotal:   4,
+		ObjectsDone:    3,
+		ObjectsFailed:  1,
+		Entries:        120,
+		Bytes:          60000,
+		EntriesPerSec:  12,
+		BytesPerSec:    6000,
+	}, event)
+
+	// Methods on nil progress events do nothing
+	var disabled *ProgressEvents
+	disabled.Start()
+	disabled.objectDone(ObjectResult{}, nil)
+	disabled.Finish()
+}
+
+func TestProgressEventsInterval(t *testing.T) {
+	var buf bytes.Buffer
+	progress := NewProgressEvents(&buf, time.Millisecond, 1)
+	progress.Start()
+	time.Sleep(20 * time.Millisecond)
+	progress.Finish()
+
+	output := buf.String()
+	assert.Contains(t, output, `"event":"progress"`)
+	assert.True(t, strings.HasSuffix(output, "\n"))
+	assert.Contains(t, output[strings.LastIndex(strings.TrimSpace(output), "\n")+1:], `"event":"finished"`)
+	assert.NoError(t, ParseProgressFormat("json"))
+	assert.ErrorContains(t, ParseProgressFormat("text"), "invalid progress format 'text'")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockDynamoDBApi struct {
+	mock.Mock
+}
+
+func (m *MockDynamoDBApi) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.GetItemOutput), args.Error(1)
+}
+
+func (m *MockDynamoDBApi) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.PutItemOutput), args.Error(1)
+}
+
+func (m *MockDynamoDBApi) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.DeleteItemOutput), args.Error(1)
+}
+
+func (m *MockDynamoDBApi) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.UpdateItemOutput), args.Error(1)
+}
+
+func TestObjectProgress(t *testing.T) {
+	store := &MemoryProgressStore{}
+	progress := newObjectProgress(store, "bucket", "key", "etag")
+
+	// Records 0 and 2 are received, record 1 was filtered
+	progress.receive(0)
+	progress.receive(2)
+	progress.receive(3)
+	// The batch with record 3 is sent before the batch with records 0 and 2
+	progress.markSent(3)
+	progress.save()
+	assert.Equal(t, 0, store.SentRecords("bucket", "key", "etag"))
+
+	progress.markSent(0, 2)
+	progress.save()
+	assert.Equal(t, 4, store.SentRecords("bucket", "key", "etag"))
+	assert.Equal(t, 0, store.SentRecords("bucket", "key", "other-etag"), "progress of a replaced object is ignored")
+
+	resumed := newObjectProgress(store, "bucket", "key", "etag")
+	assert.Equal(t, 4, resumed.resumeAfter())
+	resumed.clear()
+	assert.Equal(t, 0, store.SentRecords("bucket", "key", "etag"))
+
+	var disabled *objectProgress
+	disabled.receive(1)
+	disabled.markSent(1)
+	disabled.save()
+	assert.Equal(t, 0, disabled.resumeAfter())
+}
+
+func TestDynamoDBProgressStore(t *testing.T) {
+	mockDynamoDB := new(MockDynamoDBApi)
+	mockDynamoDB.On("GetItem", mock.Anything).Return(&dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"object":  {S: aws.String("bucket/key")},
+		"etag":    {S: aws.String("etag")},
+		"records": {N: aws.String("42")},
+	}}, nil)
+	mockDynamoDB.On("PutItem", &dynamodb.PutItemInput{
+		TableName: aws.String("progress"),
+		Item: map[string]*dynamodb.AttributeValue{
+			"object":  {S: aws.String("bucket/key")},
+			"etag":    {S: aws.String("etag")},
+			"records": {N: aws.String("50")},
+		},
+	}).Return(&dynamodb.PutItemOutput{}, nil)
+	store := &DynamoDBProgressStore{client: mockDynamoDB, table: "progress"}
+
+	assert.Equal(t, 42, store.SentRecords("bucket", "key", "etag"))
+	assert.Equal(t, 0, store.SentRecords("bucket", "key", "other-etag"))
+	store.SaveSentRecords("bucket", "key", "etag", 50)
+
+	mockDynamoDB.AssertExpectations(t)
+}
+
+func TestProcessLogsProgress(t *testing.T) {
+	line := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "GET https://example.com:443/%d HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 - "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "-" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+	newS3 := func() *MockS3Api {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(fmt.Sprintf(line, 1) + "\n" + fmt.Sprintf(line, 2) + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		mockS3 := new(MockS3Api)
+		mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{Body: io.NopCloser(&buf), ETag: aws.String("etag")}, nil)
+		return mockS3
+	}
+	fieldStore, err := NewFields("request")
+	require.NoError(t, err)
+	store := &MemoryProgressStore{}
+
+	t.Run("Failed send", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, fmt.Errorf("throttled"))
+		lp := &CloudWatchLogProcessor{source: NewSources(newS3()), cwClient: mockCW, fieldStore: fieldStore, progress: store,
+			logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}}
+
+		result, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "bucket", Key: "key"})
+		require.ErrorContains(t, err, "failed to send events to CloudWatch: throttled")
+		assert.Equal(t, 0, result.Entries)
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		store.SaveSentRecords("bucket", "key", "etag", 1)
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("PutLogEvents", mock.MatchedBy(func(input *cloudwatchlogs.PutLogEventsInput) bool {
+			return len(input.LogEvents) == 1 && strings.Contains(*input.LogEvents[0].Message, "example.com:443/2")
+		})).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil)
+		lp := &CloudWatchLogProcessor{source: NewSources(newS3()), cwClient: mockCW, fieldStore: fieldStore, progress: store,
+			logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}}
+
+		result, err := lp.ProcessLogs(S3ObjectInfo{Bucket: "bucket", Key: "key"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Entries)
+		assert.Equal(t, 0, store.SentRecords("bucket", "key", "etag"), "progress is cleared when done")
+		mockCW.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHighRateWindow = time.Minute
+	// topTalkers is the number of client IPs with the most requests that are logged per object
+	topTalkers = 5
+)
+
+// HighRateTagger marks the entries of client IPs that sent more than Threshold requests within the same Window
+// with high_rate_client=true, and logs the clients with the most requests of the object. Windows are aligned
+// to the clock, e.g. 12:00-12:01 for a window of 1m, so the counts need constant memory per client.
+type HighRateTagger struct {
+	Threshold int
+	Window    time.Duration
+	clients   map[string]*clientRate
+	tagged    int
+}
+
+// clientRate counts the requests of a client IP in its current window and in the whole object
+type clientRate struct {
+	window   time.Time
+	inWindow int
+	total    int
+	high     bool // Whether the client exceeded the threshold in any window
+}
+
+func (h *HighRateTagger) Transform(record []string, entry *LogEntry) {
+	ip := clientIP(record)
+	if ip == "" {
+		return
+	}
+	if h.clients == nil {
+		h.clients = make(map[string]*clientRate)
+	}
+	client := h.clients[ip]
+	if client == nil {
+		client = &clientRate{}
+		h.clients[ip] = client
+	}
+	client.total++
+	if window := entry.Timestamp.Truncate(h.Window); !window.Equal(client.window) {
+		client.window = window
+		client.inWindow = 0
+	}
+	client.inWindow++
+	if client.inWindow > h.Threshold {
+		entry.Data["high_rate_client"] = true
+		client.high = true
+		h.tagged++
+	}
+}
+
+// Summarize reports the number of tagged entries and clients, and logs the top talkers of the object
+func (h *HighRateTagger) Summarize(summary map[string]int) {
+	if len(h.clients) == 0 {
+		return
+	}
+	highRateClients := 0
+	for _, client := range h.clients {
+		if client.high {
+			highRateClients++
+		}
+	}
+	logf(verbosityVerbose, "top talkers: %s", strings.Join(h.topTalkers(topTalkers), " "))
+	summary["high_rate_entries"] = h.tagged
+	summary["high_rate_clients"] = highRateClients
+}
+
+// topTalkers returns the n client IPs with the most requests as ip=requests, by descending number of requests
+func (h *HighRateTagger) topTalkers(n int) []string {
+	ips := make([]string, 0, len(h.clients))
+	for ip := range h.clients {
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		if a, b := h.clients[ips[i]].total, h.clients[ips[j]].total; a != b {
+			return a > b
+		}
+		return ips[i] < ips[j]
+	})
+	talkers := make([]string, 0, n)
+	for _, ip := range ips[:min(n, len(ips))] {
+		talkers = append(talkers, fmt.Sprintf("%s=%d", ip, h.clients[ip].total))
+	}
+
+	return talkers
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighRateTagger(t *testing.T) {
+	newRecord := func(client string) []string {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("client:port")] = client
+		return record
+	}
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		client string
+		offset time.Duration
+		want   interface{}
+	}{
+		{"First request", "10.0.0.1:1234", 0, nil},
+		{"Other client", "10.0.0.2:1234", time.Second, nil},
+		{"Second request", "10.0.0.1:2345", 10 * time.Second, nil},
+		{"Third request exceeds threshold", "10.0.0.1:3456", 20 * time.Second, true},
+		{"Fourth request", "10.0.0.1:4567", 59 * time.Second, true},
+		{"Next window", "10.0.0.1:5678", 60 * time.Second, nil},
+		{"IPv6 client", "[2001:db8::1]:1234", 61 * time.Second, nil},
+		{"No client", "-", 62 * time.Second, nil},
+	}
+	tagger := &HighRateTagger{Threshold: 2, Window: time.Minute}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := LogEntry{Timestamp: start.Add(tt.offset), Data: map[string]interface{}{}}
+			tagger.Transform(newRecord(tt.client), &entry)
+			assert.Equal(t, tt.want, entry.Data["high_rate_client"])
+		})
+	}
+
+	summary := map[string]int{}
+	tagger.Summarize(summary)
+	assert.Equal(t, map[string]int{"high_rate_entries": 2, "high_rate_clients": 1}, summary)
+	assert.Equal(t, []string{"10.0.0.1=5", "10.0.0.2=1"}, tagger.topTalkers(2))
+	assert.Equal(t, []string{"10.0.0.1=5", "10.0.0.2=1", "[2001:db8::1]=1"}, tagger.topTalkers(5))
+}
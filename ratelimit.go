@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the number of entries per second, shared by all objects processed
+// concurrently. The bucket holds at most one second worth of tokens.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func NewRateLimiter(perSecond int) *RateLimiter {
+	return &RateLimiter{rate: float64(perSecond), tokens: float64(perSecond), now: time.Now}
+}
+
+// reserve takes a token and returns how long to wait until it is available. When wait is false and no token
+// is available, no token is taken and ok is false.
+func (l *RateLimiter) reserve(wait bool) (delay time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens < 1 && !wait {
+		return 0, false
+	}
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0, true
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second)), true
+}
+
+// Allow takes a token if one is available
+func (l *RateLimiter) Allow() bool {
+	_, ok := l.reserve(false)
+	return ok
+}
+
+// Wait takes a token, sleeping until it is available
+func (l *RateLimiter) Wait() {
+	if delay, _ := l.reserve(true); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// RateCap applies the rate limiter to the records of an object. Records exceeding the rate are either
+// delayed or, when Sample is set, dropped and counted.
+type RateCap struct {
+	Limiter *RateLimiter
+	Sample  bool
+	dropped int
+}
+
+func (c *RateCap) Keep(record []string) bool {
+	if !c.Sample {
+		c.Limiter.Wait()
+		return true
+	}
+	if c.Limiter.Allow() {
+		return true
+	}
+	c.dropped++
+
+	return false
+}
+
+// Transform does nothing, records are limited by Keep before the entry is created
+func (c *RateCap) Transform(record []string, entry *LogEntry) {}
+
+func (c *RateCap) Summarize(summary map[string]int) {
+	if c.Sample {
+		summary["rate_limited"] = c.dropped
+	}
+}
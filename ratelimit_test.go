@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2)
+	limiter.now = func() time.Time { return now }
+
+	assert.True(t, limiter.Allow())
+	assert.True(t, limiter.Allow())
+	assert.False(t, limiter.Allow())
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, limiter.Allow())
+	assert.False(t, limiter.Allow())
+
+	// Waiting takes a token in advance and reports the delay until it is available
+	delay, ok := limiter.reserve(true)
+	assert.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, delay)
+
+	// The bucket never holds more than one second worth of tokens
+	now = now.Add(time.Hour)
+	assert.True(t, limiter.Allow())
+	assert.True(t, limiter.Allow())
+	assert.False(t, limiter.Allow())
+}
+
+func TestRateCap(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(1)
+	limiter.now = func() time.Time { return now }
+	rateCap := &RateCap{Limiter: limiter, Sample: true}
+
+	assert.True(t, rateCap.Keep(nil))
+	assert.False(t, rateCap.Keep(nil))
+	assert.False(t, rateCap.Keep(nil))
+
+	assert.Equal(t, map[string]int{"rate_limited": 2}, summarize([]Transformer{rateCap}))
+}
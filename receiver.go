@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultMaxPushSize limits the size of a request body of the Receiver
+const defaultMaxPushSize = 64 << 20
+
+// NDJSONProcessor sends entries that were parsed before, see CloudWatchLogProcessor.ProcessNDJSON
+type NDJSONProcessor interface {
+	ProcessNDJSON(name string, data []byte) (ObjectResult, error)
+}
+
+// Receiver accepts ELB access logs pushed with POST requests and processes every request body like a log file.
+// Bodies are access logs, gzipped or not, or newline delimited JSON entries with Content-Type
+// application/x-ndjson, optionally gzipped. Requests must have the token in an "Authorization: Bearer" header.
+type Receiver struct {
+	Logs        DataProcessor
+	NDJSON      NDJSONProcessor
+	Token       string
+	MaxBodySize int64 // defaultMaxPushSize if 0
+	requests    atomic.Int64
+}
+
+// pushResponse is the JSON response of the Receiver
+type pushResponse struct {
+	Entries int    `json:"entries"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writePushResponse(w, http.StatusMethodNotAllowed, pushResponse{Error: "only POST is allowed"})
+		return
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(r.Token)) != 1 {
+		writePushResponse(w, http.StatusUnauthorized, pushResponse{Error: "invalid token"})
+		return
+	}
+	maxBodySize := r.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = defaultMaxPushSize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writePushResponse(w, http.StatusRequestEntityTooLarge, pushResponse{Error: fmt.Sprintf("body exceeds %d bytes", maxBodySize)})
+		return
+	}
+	if err != nil {
+		writePushResponse(w, http.StatusBadRequest, pushResponse{Error: err.Error()})
+		return
+	}
+
+	name := fmt.Sprintf("http:%s/%d", req.RemoteAddr, r.requests.Add(1))
+	var result ObjectResult
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "application/x-ndjson" {
+		// The limit applies after decompression as well, so a small gzip bomb isn't decompressed into memory
+		if body, err = gunzip(body, maxBodySize); errors.Is(err, errDecompressedTooLarge) {
+			writePushResponse(w, http.StatusRequestEntityTooLarge, pushResponse{Error: fmt.Sprintf("decompressed body exceeds %d bytes", maxBodySize)})
+			return
+		} else if err != nil {
+			writePushResponse(w, http.StatusBadRequest, pushResponse{Error: err.Error()})
+			return
+		}
+		result, err = r.NDJSON.ProcessNDJSON(name, body)
+	} else {
+		result, err = r.Logs.ProcessData(name, body)
+	}
+	if err != nil && !errors.Is(err, ErrEmptyObject) {
+		log.Printf("error processing %s: %v", name, err)
+		writePushResponse(w, http.StatusInternalServerError, pushResponse{Entries: result.Entries, Error: err.Error()})
+		return
+	}
+	writePushResponse(w, http.StatusOK, pushResponse{Entries: result.Entries})
+}
+
+func writePushResponse(w http.ResponseWriter, code int, response pushResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(response)
+}
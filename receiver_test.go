@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryPushProcessor records what it processes
+type memoryPushProcessor struct {
+	logs, ndjson []string
+	err          error
+}
+
+func (p *memoryPushProcessor) ProcessData(name string, data []byte) (ObjectResult, error) {
+	p.logs = append(p.logs, string(data))
+	return ObjectResult{Entries: 1}, p.err
+}
+
+func (p *memoryPushProcessor) ProcessNDJSON(name string, data []byte) (ObjectResult, error) {
+	p.ndjson = append(p.ndjson, string(data))
+	return ObjectResult{Entries: 2}, p.err
+}
+
+func TestReceiver(t *testing.T) {
+	post := func(receiver *Receiver, token, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/logs", bytes.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		receiver.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("Logs", func(t *testing.T) {
+		processor := &memoryPushProcessor{}
+		receiver := &Receiver{Logs: processor, NDJSON: processor, Token: "secret"}
+		recorder := post(receiver, "secret", "application/gzip", gzipBytes(t, "line\n"))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"entries":1}`, recorder.Body.String())
+		// Log data is decompressed by the pipeline
+		assert.Equal(t, []string{string(gzipBytes(t, "line\n"))}, processor.logs)
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		processor := &memoryPushProcessor{}
+		receiver := &Receiver{Logs: processor, NDJSON: processor, Token: "secret"}
+		recorder := post(receiver, "secret", "application/x-ndjson; charset=utf-8", gzipBytes(t, "{}\n{}\n"))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, []string{"{}\n{}\n"}, processor.ndjson)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		processor := &memoryPushProcessor{}
+		receiver := &Receiver{Logs: processor, NDJSON: processor, Token: "secret", MaxBodySize: 4}
+		assert.Equal(t, http.StatusUnauthorized, post(receiver, "", "text/plain", []byte("line")).Code)
+		assert.Equal(t, http.StatusUnauthorized, post(receiver, "wrong", "text/plain", []byte("line")).Code)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, post(receiver, "secret", "text/plain", []byte("line\n")).Code)
+		// Gzipped, the body is within the limit, decompressed it isn't
+		bomb := gzipBytes(t, strings.Repeat("{}\n", 1000))
+		receiver.MaxBodySize = int64(len(bomb))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, post(receiver, "secret", "application/x-ndjson", bomb).Code)
+		assert.Empty(t, processor.ndjson)
+		recorder := httptest.NewRecorder()
+		receiver.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/logs", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+		assert.Empty(t, processor.logs)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		processor := &memoryPushProcessor{err: fmt.Errorf("throttled")}
+		receiver := &Receiver{Logs: processor, NDJSON: processor, Token: "secret"}
+		recorder := post(receiver, "secret", "text/plain", []byte("line\n"))
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.JSONEq(t, `{"entries":1,"error":"throttled"}`, recorder.Body.String())
+	})
+}
+
+func TestProcessNDJSON(t *testing.T) {
+	mockCW := new(MockCloudWatchLogsClient)
+	mockCW.On("PutLogEvents", mock.MatchedBy(func(input *cloudwatchlogs.PutLogEventsInput) bool {
+		return len(input.LogEvents) == 1 &&
+			aws.StringValue(input.LogEvents[0].Message) == `{"elb_status_code":"200","time":"2024-03-21T16:10:26.071854Z"}` &&
+			aws.Int64Value(input.LogEvents[0].Timestamp) == 1711037426071
+	})).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil).Once()
+	// The entry without a time gets the current time, so it is sent in the batch of another day
+	mockCW.On("PutLogEvents", mock.MatchedBy(func(input *cloudwatchlogs.PutLogEventsInput) bool {
+		return len(input.LogEvents) == 1 && aws.StringValue(input.LogEvents[0].Message) == `{"elb_status_code":"502"}`
+	})).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil).Once()
+	lp := &CloudWatchLogProcessor{cwClient: mockCW, logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}}
+
+	data := `{"time":"2024-03-21T16:10:26.071854Z","elb_status_code":"200"}` + "\n" + `{"elb_status_code":"502"}` + "\n"
+	result, err := lp.ProcessNDJSON("test", []byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Entries)
+	mockCW.AssertExpectations(t)
+
+	result, err = lp.ProcessNDJSON("test", []byte("not json"))
+	assert.ErrorIs(t, err, ErrEmptyObject)
+	assert.Equal(t, 0, result.Entries)
+
+	lp.config.Strict = true
+	result, err = lp.ProcessNDJSON("test", []byte("not json"))
+	assert.ErrorContains(t, err, "invalid record 1")
+	assert.Equal(t, 0, result.Entries)
+}
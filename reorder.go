@@ -0,0 +1,55 @@
+package main
+
+import "container/heap"
+
+// ReorderBuffer holds up to a fixed number of entries and releases them ordered by timestamp, so entries
+// that arrive slightly out of order end up in the right batch. Entries that are further out of order
+// than the buffer size are still released, but not in order.
+type ReorderBuffer struct {
+	size    int
+	entries entryHeap
+}
+
+func NewReorderBuffer(size int) *ReorderBuffer {
+	return &ReorderBuffer{size: size}
+}
+
+// Push adds an entry and returns the oldest entry once the buffer is full
+func (b *ReorderBuffer) Push(entry LogEntry) (LogEntry, bool) {
+	heap.Push(&b.entries, entry)
+	if b.entries.Len() <= b.size {
+		return LogEntry{}, false
+	}
+
+	return heap.Pop(&b.entries).(LogEntry), true
+}
+
+// Drain returns all buffered entries ordered by timestamp and empties the buffer
+func (b *ReorderBuffer) Drain() []LogEntry {
+	entries := make([]LogEntry, 0, b.entries.Len())
+	for b.entries.Len() > 0 {
+		entries = append(entries, heap.Pop(&b.entries).(LogEntry))
+	}
+
+	return entries
+}
+
+// entryHeap is a min-heap of entries by timestamp
+type entryHeap []LogEntry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].Timestamp.Before(h[j].Timestamp) }
+func (h entryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *entryHeap) Push(x interface{}) {
+	*h = append(*h, x.(LogEntry))
+}
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	*h = old[:n-1]
+
+	return entry
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReorderBuffer(t *testing.T) {
+	base := time.Date(2024, 3, 21, 16, 10, 26, 0, time.UTC)
+	entryAt := func(seconds int) LogEntry {
+		return LogEntry{Timestamp: base.Add(time.Duration(seconds) * time.Second)}
+	}
+
+	buffer := NewReorderBuffer(2)
+	var released []LogEntry
+	for _, seconds := range []int{3, 1, 2, 5, 4} {
+		if entry, ok := buffer.Push(entryAt(seconds)); ok {
+			released = append(released, entry)
+		}
+	}
+	released = append(released, buffer.Drain()...)
+
+	assert.Equal(t, []LogEntry{entryAt(1), entryAt(2), entryAt(3), entryAt(4), entryAt(5)}, released)
+	assert.Empty(t, buffer.Drain())
+}
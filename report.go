@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Statuses of an object in a run
+const (
+	statusProcessed        = "processed"
+	statusEmpty            = "empty"
+	statusAlreadyProcessed = "already_processed"
+	statusRequeued         = "requeued"
+	statusFailed           = "failed"
+	statusUnverified       = "unverified" // Sent, but the events read back differ, see Verifier
+)
+
+// ObjectStatus is the outcome of a single object, as recorded in the report of a run
+type ObjectStatus struct {
+	Bucket  string
+	Key     string
+	Status  string
+	Entries int
+	Error   string
+	Result  ObjectResult // Not part of the report, see Manifest
+}
+
+// newObjectStatus returns the status of an object from the outcome of processing it
+func newObjectStatus(s3Object S3ObjectInfo, result ObjectResult, err error) ObjectStatus {
+	status := ObjectStatus{Bucket: s3Object.Bucket, Key: s3Object.Key, Entries: result.Entries, Result: result}
+	switch {
+	case errors.Is(err, ErrEmptyObject):
+		status.Status = statusEmpty
+	case errors.Is(err, ErrAlreadyProcessed):
+		status.Status = statusAlreadyProcessed
+	case err != nil:
+		status.Status = statusFailed
+		status.Error = err.Error()
+	case result.Verification != "":
+		status.Status = statusUnverified
+		status.Error = result.Verification
+	default:
+		status.Status = statusProcessed
+	}
+
+	return status
+}
+
+// done reports whether the object doesn't need to be processed again when resuming. Unverified objects were sent,
+// so they aren't sent again.
+func (s ObjectStatus) done() bool {
+	return s.Status == statusProcessed || s.Status == statusEmpty || s.Status == statusAlreadyProcessed || s.Status == statusUnverified
+}
+
+var reportHeader = []string{"bucket", "key", "status", "entries", "error"}
+
+// readReport reads the statuses of a previous run, a missing report has no statuses
+func readReport(path string) ([]ObjectStatus, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open report %s: %v", path, err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report %s: %v", path, err)
+	}
+	var statuses []ObjectStatus
+	for i, row := range rows {
+		if i == 0 || len(row) != len(reportHeader) {
+			continue
+		}
+		entries, _ := strconv.Atoi(row[3])
+		statuses = append(statuses, ObjectStatus{Bucket: row[0], Key: row[1], Status: row[2], Entries: entries, Error: row[4]})
+	}
+
+	return statuses, nil
+}
+
+// writeReport writes the statuses ordered by bucket and key as CSV with a header row
+func writeReport(path string, statuses []ObjectStatus) error {
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Bucket != statuses[j].Bucket {
+			return statuses[i].Bucket < statuses[j].Bucket
+		}
+		return statuses[i].Key < statuses[j].Key
+	})
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report %s: %v", path, err)
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	_ = writer.Write(reportHeader)
+	for _, s := range statuses {
+		_ = writer.Write([]string{s.Bucket, s.Key, s.Status, strconv.Itoa(s.Entries), s.Error})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write report %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// resumeObjects removes the objects that are done according to the previous statuses. The statuses of the
+// done objects are returned, to be included in the new report.
+func resumeObjects(s3Objects []S3ObjectInfo, previous []ObjectStatus) (remaining []S3ObjectInfo, done []ObjectStatus) {
+	doneByKey := make(map[[2]string]ObjectStatus)
+	for _, status := range previous {
+		if status.done() {
+			doneByKey[[2]string{status.Bucket, status.Key}] = status
+		}
+	}
+	for _, s3Object := range s3Objects {
+		if status, ok := doneByKey[[2]string{s3Object.Bucket, s3Object.Key}]; ok {
+			done = append(done, status)
+			continue
+		}
+		remaining = append(remaining, s3Object)
+	}
+
+	return remaining, done
+}
+
+// reportCheckpoint appends the status of every object to the report when it is done, so a run that is killed can
+// be resumed from the report. It starts the report with the objects that were done before, and the report is
+// written again in order at the end of the run.
+type reportCheckpoint struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	writer *csv.Writer
+	err    error // First error appending to the report, logged once
+}
+
+func newReportCheckpoint(path string, done []ObjectStatus) (*reportCheckpoint, error) {
+	if err := writeReport(path, done); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open report %s: %v", path, err)
+	}
+
+	return &reportCheckpoint{path: path, file: file, writer: csv.NewWriter(file)}, nil
+}
+
+// objectDone appends the status of an object to the report
+func (c *reportCheckpoint) objectDone(s ObjectStatus) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	_ = c.writer.Write([]string{s.Bucket, s.Key, s.Status, strconv.Itoa(s.Entries), s.Error})
+	c.writer.Flush()
+	if c.err = c.writer.Error(); c.err != nil {
+		log.Printf("failed to write report %s: %v", c.path, c.err)
+	}
+}
+
+// close closes the report, which can then be written in full
+func (c *reportCheckpoint) close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.file.Close()
+}
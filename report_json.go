@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// jsonReportVersion is incremented when fields of the JSON report are changed or removed, not when added
+const jsonReportVersion = 1
+
+// JSONReport describes a run for CI pipelines and cron jobs that check its outcome programmatically. Objects are
+// ordered by bucket and key, so reports of the same run only differ in their times.
+type JSONReport struct {
+	Version  int              `json:"version"`
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"`
+	Success  bool             `json:"success"`   // Whether every object was processed, see ExitCode
+	ExitCode int              `json:"exit_code"` // Exit code of the CLI
+	Summary  ReportSummary    `json:"summary"`
+	Objects  []ManifestObject `json:"objects"`
+	Config   ReportConfig     `json:"config"`
+}
+
+// ReportSummary counts the objects of a run by status, with the entries and bytes of the objects processed in it
+type ReportSummary struct {
+	Objects           int                  `json:"objects"`
+	Processed         int                  `json:"processed"`
+	Empty             int                  `json:"empty"`
+	AlreadyProcessed  int                  `json:"already_processed"`
+	Requeued          int                  `json:"requeued"`
+	Failed            int                  `json:"failed"`
+	Unverified        int                  `json:"unverified"` // Objects sent of which the events read back differ
+	Resumed           int                  `json:"resumed"`    // Objects skipped because an earlier --report has them done
+	Entries           int                  `json:"entries"`
+	CompressedBytes   int64                `json:"compressed_bytes"`
+	DecompressedBytes int64                `json:"decompressed_bytes"`
+	SentBytes         int64                `json:"sent_bytes"`
+	PeakHeapBytes     int64                `json:"peak_heap_bytes,omitempty"`
+	LoadBalancers     []LoadBalancerResult `json:"load_balancers,omitempty"`
+}
+
+// ReportConfig is the part of the configuration that determines what is sent where, without secrets
+type ReportConfig struct {
+	LogGroupName       string   `json:"log_group_name"`
+	LogStreamName      string   `json:"log_stream_name"`
+	Fields             string   `json:"fields"`
+	Accounts           []string `json:"accounts,omitempty"`
+	Regions            []string `json:"regions,omitempty"`
+	AccountRoutes      []string `json:"account_routes,omitempty"` // The routed accounts
+	SampleRate         float64  `json:"sample_rate,omitempty"`
+	DedupWindow        int      `json:"dedup_window,omitempty"`
+	MaxEventsPerSecond int      `json:"max_events_per_second,omitempty"`
+	RateLimitSampling  bool     `json:"rate_limit_sampling,omitempty"`
+	TimestampShift     string   `json:"timestamp_shift,omitempty"`
+	Spool              string   `json:"spool,omitempty"`
+	Manifest           string   `json:"manifest,omitempty"`
+	Verify             bool     `json:"verify,omitempty"` // The events of every object were read back, see Verifier
+}
+
+// newJSONReport returns the report of a run with the statuses of its objects, including those resumed from an
+// earlier report
+func newJSONReport(started, finished time.Time, config Config, shift time.Duration, result RunResult, statuses []ObjectStatus, resumed int, exitCode int) JSONReport {
+	report := JSONReport{
+		Version:  jsonReportVersion,
+		Started:  started.UTC(),
+		Finished: finished.UTC(),
+		Success:  exitCode == exitSuccess,
+		ExitCode: exitCode,
+		Summary: ReportSummary{
+			Objects:           len(statuses),
+			Resumed:           resumed,
+			Entries:           result.Entries,
+			CompressedBytes:   result.CompressedBytes,
+			DecompressedBytes: result.DecompressedBytes,
+			SentBytes:         result.SentBytes,
+			PeakHeapBytes:     result.PeakHeapBytes,
+			LoadBalancers:     result.LoadBalancers,
+		},
+		Objects: newManifest(started, finished, "", statuses).Objects,
+		Config: ReportConfig{
+			LogGroupName:       config.LogGroupName,
+			LogStreamName:      config.LogStreamName,
+			Fields:             config.Fields,
+			Accounts:           config.Accounts,
+			Regions:            config.Regions,
+			SampleRate:         config.SampleRate,
+			DedupWindow:        config.DedupWindow,
+			MaxEventsPerSecond: config.MaxEventsPerSecond,
+			RateLimitSampling:  config.RateLimitSampling,
+			Spool:              config.Spool,
+			Manifest:           config.Manifest,
+		},
+	}
+	for _, status := range statuses {
+		switch status.Status {
+		case statusProcessed:
+			report.Summary.Processed++
+		case statusEmpty:
+			report.Summary.Empty++
+		case statusAlreadyProcessed:
+			report.Summary.AlreadyProcessed++
+		case statusRequeued:
+			report.Summary.Requeued++
+		case statusFailed:
+			report.Summary.Failed++
+		case statusUnverified:
+			report.Summary.Unverified++
+		}
+	}
+	if shift != 0 {
+		report.Config.TimestampShift = shift.String()
+	}
+	for account := range config.AccountRoutes {
+		report.Config.AccountRoutes = append(report.Config.AccountRoutes, account)
+	}
+	sort.Strings(report.Config.AccountRoutes)
+
+	return report
+}
+
+// writeJSONReport writes the report as indented JSON
+func writeJSONReport(path string, report JSONReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report %s: %v", path, err)
+	}
+
+	return nil
+}
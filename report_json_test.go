@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONReport(t *testing.T) {
+	started := time.Date(2024, 3, 21, 16, 0, 0, 0, time.FixedZone("CET", 3600))
+	config := Config{LogGroupName: "test-log-group", LogStreamName: "test-log-stream", ReceiverToken: "secret", AccountRoutes: AccountRoutes{
+		"222222222222": {LogGroupName: "/elb/b"},
+		"111111111111": {LogGroupName: "/elb/a"},
+	}}
+	result := RunResult{Processed: 1, Entries: 10, CompressedBytes: 100, DecompressedBytes: 1000, SentBytes: 500}
+	statuses := []ObjectStatus{
+		{Bucket: "bucket", Key: "c", Status: statusFailed, Error: "access denied"},
+		{Bucket: "bucket", Key: "b", Status: statusProcessed, Entries: 10, Result: ObjectResult{Entries: 10, SentBytes: 500}},
+		{Bucket: "bucket", Key: "a", Status: statusProcessed, Entries: 5},
+	}
+	report := newJSONReport(started, started.Add(time.Minute), config, 720*time.Hour, result, statuses, 1, exitPartialFailure)
+
+	assert.False(t, report.Success)
+	assert.Equal(t, ReportSummary{
+		Objects: 3, Processed: 2, Failed: 1, Resumed: 1, Entries: 10, CompressedBytes: 100, DecompressedBytes: 1000, SentBytes: 500,
+	}, report.Summary)
+	require.Len(t, report.Objects, 3)
+	assert.Equal(t, []string{"a", "b", "c"}, []string{report.Objects[0].Key, report.Objects[1].Key, report.Objects[2].Key})
+	assert.Equal(t, []string{"111111111111", "222222222222"}, report.Config.AccountRoutes)
+	assert.Equal(t, "720h0m0s", report.Config.TimestampShift)
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, writeJSONReport(path, report))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, float64(jsonReportVersion), decoded["version"])
+	assert.Equal(t, "2024-03-21T15:00:00Z", decoded["started"])
+	assert.Equal(t, float64(exitPartialFailure), decoded["exit_code"])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.csv")
+
+	statuses, err := readReport(path)
+	require.NoError(t, err)
+	assert.Empty(t, statuses)
+
+	statuses = []ObjectStatus{
+		{Bucket: "bucket", Key: "b", Status: statusFailed, Error: "access denied, retry later"},
+		{Bucket: "bucket", Key: "a", Status: statusProcessed, Entries: 10},
+	}
+	require.NoError(t, writeReport(path, statuses))
+	read, err := readReport(path)
+	require.NoError(t, err)
+	assert.Equal(t, []ObjectStatus{
+		{Bucket: "bucket", Key: "a", Status: statusProcessed, Entries: 10},
+		{Bucket: "bucket", Key: "b", Status: statusFailed, Error: "access denied, retry later"},
+	}, read)
+
+	remaining, done := resumeObjects([]S3ObjectInfo{{Bucket: "bucket", Key: "a"}, {Bucket: "bucket", Key: "b"}, {Bucket: "bucket", Key: "c"}}, read)
+	assert.Equal(t, []S3ObjectInfo{{Bucket: "bucket", Key: "b"}, {Bucket: "bucket", Key: "c"}}, remaining)
+	assert.Equal(t, []ObjectStatus{{Bucket: "bucket", Key: "a", Status: statusProcessed, Entries: 10}}, done)
+
+	// Objects are added to the report as they are done, so a run that is killed resumes from there
+	checkpoint, err := newReportCheckpoint(path, done)
+	require.NoError(t, err)
+	checkpoint.objectDone(newObjectStatus(S3ObjectInfo{Bucket: "bucket", Key: "c"}, ObjectResult{Entries: 3}, nil))
+	checkpoint.objectDone(newObjectStatus(S3ObjectInfo{Bucket: "bucket", Key: "b"}, ObjectResult{}, errors.New("throttled")))
+	read, err = readReport(path)
+	require.NoError(t, err)
+	assert.Equal(t, []ObjectStatus{
+		{Bucket: "bucket", Key: "a", Status: statusProcessed, Entries: 10},
+		{Bucket: "bucket", Key: "c", Status: statusProcessed, Entries: 3},
+		{Bucket: "bucket", Key: "b", Status: statusFailed, Error: "throttled"},
+	}, read)
+	require.NoError(t, checkpoint.close())
+	var disabled *reportCheckpoint
+	disabled.objectDone(ObjectStatus{})
+	assert.NoError(t, disabled.close())
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sort"
+)
+
+// RunResult summarizes the processing of a set of objects, it is returned to invokers of the Lambda function
+type RunResult struct {
+	Processed        int `json:"processed"` // Objects processed successfully
+	Entries          int `json:"entries"`   // Log entries sent to CloudWatch
+	Empty            int `json:"empty"`
+	AlreadyProcessed int `json:"alreadyProcessed"`
+	Requeued         int `json:"requeued"` // Objects handed over to other invocations
+	// Bytes downloaded from S3, parsed after decompression and sent to CloudWatch, to quantify the effect of
+	// field selection and sampling on the CloudWatch ingestion cost
+	CompressedBytes   int64 `json:"compressedBytes"`
+	DecompressedBytes int64 `json:"decompressedBytes"`
+	SentBytes         int64 `json:"sentBytes"`
+	// PeakHeapBytes is the highest heap usage during the run, to size the memory of the Lambda function
+	PeakHeapBytes    int64           `json:"peakHeapBytes,omitempty"`
+	MemoryLimitBytes int64           `json:"memoryLimitBytes,omitempty"` // 0 if unknown
+	Failures         []ObjectFailure `json:"failures,omitempty"`
+	// Unverified are the objects that were sent, but of which the events read back differ, see Verifier. They are
+	// not retried, as that would send their events again.
+	Unverified []ObjectFailure `json:"unverified,omitempty"`
+	// LoadBalancers breaks the result down per load balancer when the objects are logs of more than one
+	LoadBalancers []LoadBalancerResult `json:"loadBalancers,omitempty"`
+}
+
+// LoadBalancerResult summarizes the objects of a single load balancer in a run
+type LoadBalancerResult struct {
+	Name         string `json:"name"`
+	Objects      int    `json:"objects"`
+	Failed       int    `json:"failed"`
+	Entries      int    `json:"entries"`
+	ServerErrors int    `json:"serverErrors"` // Entries with a 5xx status code of the load balancer
+}
+
+// ServerErrorRate returns the fraction of the entries with a 5xx status code
+func (r LoadBalancerResult) ServerErrorRate() float64 {
+	if r.Entries == 0 {
+		return 0
+	}
+
+	return float64(r.ServerErrors) / float64(r.Entries)
+}
+
+// loadBalancerResults collects the results per load balancer, named as in the object keys
+type loadBalancerResults map[string]*LoadBalancerResult
+
+// add adds the outcome of an object, objects that are not ELB access logs are left out
+func (r loadBalancerResults) add(key string, result ObjectResult, failed bool) {
+	elbKey, err := ParseELBObjectKey(key)
+	if err != nil {
+		return
+	}
+	lb, ok := r[elbKey.LoadBalancer]
+	if !ok {
+		lb = &LoadBalancerResult{Name: elbKey.LoadBalancer}
+		r[elbKey.LoadBalancer] = lb
+	}
+	lb.Objects++
+	if failed {
+		lb.Failed++
+	}
+	lb.Entries += result.Entries
+	lb.ServerErrors += result.ServerErrors
+}
+
+// sorted returns the results ordered by name, or nil when the run covers a single load balancer
+func (r loadBalancerResults) sorted() []LoadBalancerResult {
+	if len(r) < 2 {
+		return nil
+	}
+	results := make([]LoadBalancerResult, 0, len(r))
+	for _, lb := range r {
+		results = append(results, *lb)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	return results
+}
+
+// ObjectFailure describes an object that could not be processed
+type ObjectFailure struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Error  string `json:"error"`
+}
+
+// sortFailures orders the failures and unverified objects by bucket and key, so results don't depend on
+// processing order
+func (r *RunResult) sortFailures() {
+	for _, failures := range [][]ObjectFailure{r.Failures, r.Unverified} {
+		sort.Slice(failures, func(i, j int) bool {
+			if failures[i].Bucket != failures[j].Bucket {
+				return failures[i].Bucket < failures[j].Bucket
+			}
+			return failures[i].Key < failures[j].Key
+		})
+	}
+}
+
+// logSummary logs the result in a single line
+func (r RunResult) logSummary() {
+	logf(verbosityNormal, "processed %d objects with %d log entries, skipped %d empty files and %d already processed files, %d requeued, %d failed",
+		r.Processed, r.Entries, r.Empty, r.AlreadyProcessed, r.Requeued, len(r.Failures))
+	if len(r.Unverified) > 0 {
+		logf(verbosityNormal, "the events of %d processed objects differ in CloudWatch", len(r.Unverified))
+	}
+	if r.DecompressedBytes > 0 {
+		logf(verbosityNormal, "downloaded %d bytes, parsed %d bytes, sent %d bytes (%.1f%% of parsed)",
+			r.CompressedBytes, r.DecompressedBytes, r.SentBytes, float64(r.SentBytes)/float64(r.DecompressedBytes)*100)
+	}
+	if r.PeakHeapBytes > 0 {
+		if r.MemoryLimitBytes > 0 {
+			logf(verbosityNormal, "peak heap usage %d MiB of %d MiB memory limit (%.0f%%)",
+				r.PeakHeapBytes>>20, r.MemoryLimitBytes>>20, float64(r.PeakHeapBytes)/float64(r.MemoryLimitBytes)*100)
+		} else {
+			logf(verbosityNormal, "peak heap usage %d MiB", r.PeakHeapBytes>>20)
+		}
+	}
+	for _, lb := range r.LoadBalancers {
+		logf(verbosityNormal, "%s: %d objects with %d log entries, %d failed, %d 5xx responses (%.2f%%)",
+			lb.Name, lb.Objects, lb.Entries, lb.Failed, lb.ServerErrors, lb.ServerErrorRate()*100)
+	}
+}
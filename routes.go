@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// AccountRoute is the destination of the logs of an account, or of an account in a region
+type AccountRoute struct {
+	LogGroupName string `json:"logGroup"`          // May contain the same placeholders as LOG_GROUP_NAME, empty keeps the configured log group
+	RoleARN      string `json:"roleArn,omitempty"` // Role assumed to write to the log group, e.g. in the workload account
+}
+
+// AccountRoutes maps an account ID, or an account ID and region as "<account-id>/<region>", to a route
+type AccountRoutes map[string]AccountRoute
+
+// ParseAccountRoutes parses routes given as JSON, e.g. {"111111111111": {"logGroup": "/elb/team-a"}}
+func ParseAccountRoutes(value string) (AccountRoutes, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var routes AccountRoutes
+	if err := json.Unmarshal([]byte(value), &routes); err != nil {
+		return nil, fmt.Errorf("invalid account routes: %v", err)
+	}
+	for key, route := range routes {
+		if route.LogGroupName == "" && route.RoleARN == "" {
+			return nil, fmt.Errorf("invalid account route '%s', expected a logGroup or roleArn", key)
+		}
+	}
+
+	return routes, nil
+}
+
+// Lookup returns the route for the account and region of an object key, a route for the
+// account and region takes precedence over a route for the whole account
+func (r AccountRoutes) Lookup(key ELBObjectKey) (AccountRoute, bool) {
+	if route, ok := r[key.AccountID+"/"+key.Region]; ok {
+		return route, true
+	}
+	route, ok := r[key.AccountID]
+
+	return route, ok
+}
+
+// RoleClients creates and caches a CloudWatch Logs client per assumed role. The zero value uses
+// the default client for every role.
+type RoleClients struct {
+	mu        sync.Mutex
+	clients   map[string]CloudWatchLogsAPI
+	newClient func(roleARN string) CloudWatchLogsAPI
+}
+
+// newRoleClients returns clients that assume the roles using the credentials of the session
+func newRoleClients(sess *session.Session) *RoleClients {
+	return &RoleClients{newClient: func(roleARN string) CloudWatchLogsAPI {
+		return cloudwatchlogs.New(sess, &aws.Config{Credentials: stscreds.NewCredentials(sess, roleARN)})
+	}}
+}
+
+// Client returns the client for a role, or defaultClient if the role is empty
+func (c *RoleClients) Client(roleARN string, defaultClient CloudWatchLogsAPI) CloudWatchLogsAPI {
+	if roleARN == "" || c == nil || c.newClient == nil {
+		return defaultClient
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[roleARN]; ok {
+		return client
+	}
+	if c.clients == nil {
+		c.clients = make(map[string]CloudWatchLogsAPI)
+	}
+	client := c.newClient(roleARN)
+	c.clients[roleARN] = client
+
+	return client
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccountRoutes(t *testing.T) {
+	routes, err := ParseAccountRoutes(`{"111": {"logGroup": "/elb/team-a"}, "111/us-east-1": {"logGroup": "/elb/{elb}", "roleArn": "arn:aws:iam::111:role/shipper"}}`)
+	require.NoError(t, err)
+
+	route, ok := routes.Lookup(ELBObjectKey{AccountID: "111", Region: "eu-west-1"})
+	assert.True(t, ok)
+	assert.Equal(t, AccountRoute{LogGroupName: "/elb/team-a"}, route)
+
+	route, ok = routes.Lookup(ELBObjectKey{AccountID: "111", Region: "us-east-1"})
+	assert.True(t, ok)
+	assert.Equal(t, "arn:aws:iam::111:role/shipper", route.RoleARN)
+
+	_, ok = routes.Lookup(ELBObjectKey{AccountID: "222", Region: "us-east-1"})
+	assert.False(t, ok)
+
+	routes, err = ParseAccountRoutes("")
+	require.NoError(t, err)
+	assert.Nil(t, routes)
+
+	_, err = ParseAccountRoutes(`{"111": {}}`)
+	assert.Error(t, err)
+	_, err = ParseAccountRoutes(`111=/elb/team-a`)
+	assert.Error(t, err)
+}
+
+func TestProcessLogsAccountRoutes(t *testing.T) {
+	mockBody := `https 2024-03-21T16:10:26.071854Z app/example-prod-lb/xxxxxxx4 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 203 203 1694 10783 "PUT https://example.com:443/api/modify HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 - "Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx" "example.com" "-" 203 2024-03-21T16:10:26.061854Z "cache" "-" "-" "10.0.0.24:3003" "203" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(mockBody))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	mockS3 := new(MockS3Api)
+	mockS3.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{Body: io.NopCloser(&buf)}, nil)
+
+	// The default client is not used, all requests use the client of the assumed role
+	defaultCW := new(MockCloudWatchLogsClient)
+	roleCW := new(MockCloudWatchLogsClient)
+	roleCW.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{}, nil)
+	roleCW.On("CreateLogGroup", &cloudwatchlogs.CreateLogGroupInput{LogGroupName: aws.String("/elb/example-prod-lb")}).Return(&cloudwatchlogs.CreateLogGroupOutput{}, nil)
+	roleCW.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{}, nil)
+	roleCW.On("CreateLogStream", &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String("/elb/example-prod-lb"),
+		LogStreamName: aws.String("test-log-stream"),
+	}).Return(&cloudwatchlogs.CreateLogStreamOutput{}, nil)
+	roleCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil)
+
+	fieldStore, err := NewFields("")
+	require.NoError(t, err)
+	var assumedRoles []string
+	lp := &CloudWatchLogProcessor{
+		s3Client:   mockS3,
+		source:     NewSources(mockS3),
+		cwClient:   defaultCW,
+		fieldStore: fieldStore,
+		config: Config{AccountRoutes: AccountRoutes{
+			"987654321098": {LogGroupName: "/elb/{elb}", RoleARN: "arn:aws:iam::987654321098:role/shipper"},
+		}},
+		logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		roleClients: &RoleClients{newClient: func(roleARN string) CloudWatchLogsAPI {
+			assumedRoles = append(assumedRoles, roleARN)
+			return roleCW
+		}},
+	}
+
+	key := "AWSLogs/987654321098/elasticloadbalancing/xx-west-1/2024/03/21/987654321098_elasticloadbalancing_xx-west-1_app.example-prod-lb.xxxxxxx4_20240321T1615Z_192.0.2.1_abcdefgh.log.gz"
+	_, err = lp.ProcessLogs(S3ObjectInfo{Bucket: "test-bucket", Key: key})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"arn:aws:iam::987654321098:role/shipper"}, assumedRoles)
+	roleCW.AssertExpectations(t)
+	defaultCW.AssertNotCalled(t, "PutLogEvents", mock.Anything)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultRulePriority is the matched_rule_priority of requests handled by the default action of a listener
+const defaultRulePriority = "0"
+
+// RuleNames maps a rule priority, or a load balancer name and rule priority as "<name>/<priority>", to a rule name
+type RuleNames map[string]string
+
+// ParseRuleNames parses rule names given as JSON, e.g. {"10": "api", "my-alb/20": "static"}
+func ParseRuleNames(value string) (RuleNames, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var names RuleNames
+	if err := json.Unmarshal([]byte(value), &names); err != nil {
+		return nil, fmt.Errorf("invalid rule names: %v", err)
+	}
+	for key := range names {
+		priority := key[strings.LastIndex(key, "/")+1:]
+		if _, err := strconv.Atoi(priority); err != nil {
+			return nil, fmt.Errorf("invalid rule names key '%s', expected <priority> or <load-balancer-name>/<priority>", key)
+		}
+	}
+
+	return names, nil
+}
+
+// Lookup returns the name of a rule of a load balancer, a name for the load balancer and priority takes precedence
+// over a name for the priority. The default rule is named "default" unless configured otherwise.
+func (r RuleNames) Lookup(loadBalancer, priority string) (string, bool) {
+	if name, ok := r[loadBalancer+"/"+priority]; ok {
+		return name, true
+	}
+	if name, ok := r[priority]; ok {
+		return name, true
+	}
+	if priority == defaultRulePriority {
+		return "default", true
+	}
+
+	return "", false
+}
+
+// RuleNamer adds the name of the listener rule that matched a request as matched_rule. Rule priorities are only
+// unique within a listener, so names that differ between load balancers are configured per load balancer.
+// Requests without a matched rule, e.g. for which rule evaluation failed, are left untouched.
+type RuleNamer struct {
+	Names RuleNames
+}
+
+func (n *RuleNamer) Transform(record []string, entry *LogEntry) {
+	// The elb field is app/<name>/<id>
+	loadBalancer := recordValue(record, "elb")
+	if parts := strings.Split(loadBalancer, "/"); len(parts) == 3 {
+		loadBalancer = parts[1]
+	}
+	if name, ok := n.Names.Lookup(loadBalancer, recordValue(record, "matched_rule_priority")); ok {
+		entry.Data["matched_rule"] = name
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRuleNames(t *testing.T) {
+	names, err := ParseRuleNames(`{"10": "api", "my-alb/20": "static"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, RuleNames{"10": "api", "my-alb/20": "static"}, names)
+
+	names, err = ParseRuleNames(" ")
+	assert.NoError(t, err)
+	assert.Nil(t, names)
+
+	_, err = ParseRuleNames(`{"my-alb": "api"}`)
+	assert.EqualError(t, err, "invalid rule names key 'my-alb', expected <priority> or <load-balancer-name>/<priority>")
+
+	_, err = ParseRuleNames(`{"10": 1}`)
+	assert.Error(t, err)
+}
+
+func TestRuleNamer(t *testing.T) {
+	namer := &RuleNamer{Names: RuleNames{"10": "api", "20": "static", "other-alb/20": "images"}}
+	transform := func(elb, priority string) LogEntry {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("elb")] = elb
+		record[getFieldIndex("matched_rule_priority")] = priority
+		entry := LogEntry{Data: map[string]interface{}{}}
+		namer.Transform(record, &entry)
+		return entry
+	}
+
+	assert.Equal(t, "api", transform("app/my-alb/50dc6c495c0c9188", "10").Data["matched_rule"])
+	assert.Equal(t, "static", transform("app/my-alb/50dc6c495c0c9188", "20").Data["matched_rule"])
+	assert.Equal(t, "images", transform("app/other-alb/6d0ecf831eec9f09", "20").Data["matched_rule"])
+	assert.Equal(t, "default", transform("app/my-alb/50dc6c495c0c9188", "0").Data["matched_rule"])
+	assert.NotContains(t, transform("app/my-alb/50dc6c495c0c9188", "30").Data, "matched_rule")
+	assert.NotContains(t, transform("app/my-alb/50dc6c495c0c9188", "-1").Data, "matched_rule")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+)
+
+// Sampler keeps a consistent fraction of the records, based on a hash of the root of their trace ID. All records of
+// a request, such as retries, and the application logs that carry the same trace ID are kept or dropped together.
+// Records are kept if their hash falls in [Offset, Offset+Rate) of the hash space (wrapping around), so shippers
+// with the same seed and adjacent ranges, e.g. offset 0 and 0.5 with rate 0.5, ship complementary samples.
+// Records without a trace ID are sampled by a hash of the whole record.
+type Sampler struct {
+	Rate    float64
+	Offset  float64
+	Seed    uint64
+	dropped int
+}
+
+func (s *Sampler) Keep(record []string) bool {
+	h := fnv.New64a()
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], s.Seed)
+	h.Write(seed[:])
+	if root := ParseTraceID(recordValue(record, "trace_id")).Root; root != "" {
+		h.Write([]byte(root))
+	} else {
+		for _, value := range record {
+			h.Write([]byte(value))
+			h.Write([]byte{0})
+		}
+	}
+	// Position of the hash in the hash space as a fraction in [0, 1), relative to the offset
+	position := float64(mix64(h.Sum64())>>11) / (1 << 53)
+	if position -= s.Offset; position < 0 {
+		position += 1
+	}
+	if position < s.Rate {
+		return true
+	}
+	s.dropped++
+
+	return false
+}
+
+// Transform does nothing, records are sampled by Keep before the entry is created
+func (s *Sampler) Transform(record []string, entry *LogEntry) {}
+
+func (s *Sampler) Summarize(summary map[string]int) {
+	summary["sampled_out"] = s.dropped
+}
+
+// mix64 is the splitmix64 finalizer. FNV changes the high bits of the hash only little for inputs that differ
+// in their last bytes, such as trace IDs, so the bits are mixed before using the hash as a fraction.
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+
+	return h
+}
+
+// validSampleFraction reports whether a sample rate or offset is within [0, 1]
+func validSampleFraction(f float64) bool {
+	return !math.IsNaN(f) && f >= 0 && f <= 1
+}
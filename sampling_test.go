@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler(t *testing.T) {
+	newRecord := func(traceID string) []string {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("trace_id")] = traceID
+		return record
+	}
+
+	t.Run("Consistent by trace root", func(t *testing.T) {
+		sampler := &Sampler{Rate: 0.5}
+		for i := 0; i < 100; i++ {
+			root := fmt.Sprintf("Root=1-67891233-%024d", i)
+			assert.Equal(t, sampler.Keep(newRecord(root)), sampler.Keep(newRecord("Self=1-67891234-1;"+root)))
+		}
+	})
+
+	t.Run("Complementary offsets", func(t *testing.T) {
+		first := &Sampler{Rate: 0.5, Seed: 42}
+		second := &Sampler{Rate: 0.5, Offset: 0.5, Seed: 42}
+		kept := 0
+		for i := 0; i < 1000; i++ {
+			record := newRecord(fmt.Sprintf("Root=1-67891233-%024d", i))
+			keepFirst, keepSecond := first.Keep(record), second.Keep(record)
+			assert.NotEqual(t, keepFirst, keepSecond)
+			if keepFirst {
+				kept++
+			}
+		}
+		assert.InDelta(t, 500, kept, 75)
+		assert.Equal(t, map[string]int{"sampled_out": 1000 - kept}, summarize([]Transformer{first}))
+	})
+
+	t.Run("Records without trace ID", func(t *testing.T) {
+		sampler := &Sampler{Rate: 0.5}
+		record := newRecord("-")
+		record[0] = "https"
+		assert.Equal(t, sampler.Keep(record), sampler.Keep(record))
+	})
+}
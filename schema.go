@@ -0,0 +1,17 @@
+package main
+
+const (
+	// schemaVersion is incremented whenever fields of emitted events are renamed, retyped or nested differently,
+	// so consumers can tell events from before and after the change apart
+	schemaVersion = 1
+	// logFormat names the access log format the events are parsed from
+	logFormat = "alb_access_log"
+)
+
+// SchemaStamper adds schema_version and log_format fields to every entry
+type SchemaStamper struct{}
+
+func (s *SchemaStamper) Transform(record []string, entry *LogEntry) {
+	entry.Data["schema_version"] = schemaVersion
+	entry.Data["log_format"] = logFormat
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaStamper(t *testing.T) {
+	entry := LogEntry{Data: map[string]interface{}{"elb_status_code": "200"}}
+	(&SchemaStamper{}).Transform(nil, &entry)
+	require.NoError(t, entry.encode())
+	assert.Equal(t, `{"elb_status_code":"200","log_format":"alb_access_log","schema_version":1}`, entry.Message)
+}
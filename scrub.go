@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Scrubber normalizes string values that CloudWatch Logs rejects or renders badly: invalid UTF-8 is replaced by
+// U+FFFD, tabs and line breaks by a space, and other control characters (such as NUL, DEL and the C1 range)
+// are escaped as \xNN text. Values come from requests of clients, e.g. user agents and URLs, so they
+// may contain anything. It runs with SCRUB_CONTROL_CHARACTERS, before the FieldTruncator so the scrubbed values are
+// truncated.
+type Scrubber struct {
+	scrubbed int
+}
+
+func (s *Scrubber) Transform(record []string, entry *LogEntry) {
+	scrubbed := false
+	for field, value := range entry.Data {
+		if v, ok := value.(string); ok && needsScrubbing(v) {
+			entry.Data[field] = scrubValue(v)
+			scrubbed = true
+		}
+	}
+	if scrubbed {
+		s.scrubbed++
+	}
+}
+
+func (s *Scrubber) Summarize(summary map[string]int) {
+	if s.scrubbed > 0 {
+		summary["scrubbed"] = s.scrubbed
+	}
+}
+
+func needsScrubbing(value string) bool {
+	if !utf8.ValidString(value) {
+		return true
+	}
+
+	return strings.IndexFunc(value, unicode.IsControl) >= 0
+}
+
+func scrubValue(value string) string {
+	value = strings.ToValidUTF8(value, string(utf8.RuneError))
+	var b strings.Builder
+	b.Grow(len(value))
+	for _, r := range value {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			b.WriteByte(' ')
+		case unicode.IsControl(r):
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrubber(t *testing.T) {
+	scrubber := &Scrubber{}
+	entry := LogEntry{Data: map[string]interface{}{
+		"user_agent":      "curl\x00/8.0\x7f",
+		"request":         "GET https://example.com:443/a\r\nb HTTP/1.1",
+		"domain_name":     "example.com",
+		"redirect_url":    "https://example.com/\xff",
+		"trace_id":        "Root=\u0085x",
+		"elb_status_code": 200,
+	}}
+	scrubber.Transform(nil, &entry)
+
+	assert.Equal(t, `curl\x00/8.0\x7f`, entry.Data["user_agent"])
+	assert.Equal(t, "GET https://example.com:443/a  b HTTP/1.1", entry.Data["request"])
+	assert.Equal(t, "example.com", entry.Data["domain_name"])
+	assert.Equal(t, "https://example.com/�", entry.Data["redirect_url"])
+	assert.Equal(t, `Root=\x85x`, entry.Data["trace_id"])
+	assert.Equal(t, 200, entry.Data["elb_status_code"])
+
+	scrubber.Transform(nil, &LogEntry{Data: map[string]interface{}{"domain_name": "example.com"}})
+	summary := make(map[string]int)
+	scrubber.Summarize(summary)
+	assert.Equal(t, map[string]int{"scrubbed": 1}, summary)
+}
+
+func TestScrubberConfig(t *testing.T) {
+	hasScrubber := func(config Config) bool {
+		for _, transformer := range NewTransformers(config) {
+			if _, ok := transformer.(*Scrubber); ok {
+				return true
+			}
+		}
+		return false
+	}
+	assert.False(t, hasScrubber(Config{}))
+	assert.True(t, hasScrubber(Config{ScrubControlCharacters: true}))
+}
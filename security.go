@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SecurityRules tags entries that look suspicious with security_flags, see SecurityTagger
+type SecurityRules struct {
+	Patterns []PatternRule `json:"patterns"`
+	Bursts   []BurstRule   `json:"bursts"`
+}
+
+// PatternRule flags entries whose field matches a regular expression
+type PatternRule struct {
+	Flag    string `json:"flag"`
+	Field   string `json:"field"`   // Any field of the log format, also if it is not included in FIELDS
+	Pattern string `json:"pattern"` // Go regular expression, use (?i) to ignore case
+	regexp  *regexp.Regexp
+}
+
+// BurstRule flags the entries of a client IP with one of the status codes once it had Threshold of them within
+// Window, e.g. a burst of 401 and 403 responses from credential stuffing
+type BurstRule struct {
+	Flag        string   `json:"flag"`
+	StatusCodes []string `json:"status_codes"` // Status codes of the load balancer
+	Threshold   int      `json:"threshold"`
+	Window      string   `json:"window"` // Duration such as 1m
+	window      time.Duration
+}
+
+// LoadSecurityRules reads the rules from a JSON file, or from the value itself if it is a JSON object
+func LoadSecurityRules(value string) (*SecurityRules, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return nil, fmt.Errorf("failed to read security rules: %v", err)
+		}
+	}
+	var rules SecurityRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid security rules: %v", err)
+	}
+	for i, rule := range rules.Patterns {
+		if rule.Flag == "" {
+			return nil, fmt.Errorf("invalid security rule %d, expected a flag", i+1)
+		}
+		if _, ok := fieldIndexes[rule.Field]; !ok {
+			return nil, fmt.Errorf("invalid security rule %s, unknown field '%s'", rule.Flag, rule.Field)
+		}
+		compiled, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid security rule %s: %v", rule.Flag, err)
+		}
+		rules.Patterns[i].regexp = compiled
+	}
+	for i, rule := range rules.Bursts {
+		if rule.Flag == "" || len(rule.StatusCodes) == 0 || rule.Threshold < 1 {
+			return nil, fmt.Errorf("invalid security burst rule %d, expected a flag, status codes and a threshold", i+1)
+		}
+		window, err := time.ParseDuration(rule.Window)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid security rule %s, invalid window '%s'", rule.Flag, rule.Window)
+		}
+		rules.Bursts[i].window = window
+	}
+
+	return &rules, nil
+}
+
+// SecurityTagger adds the flags of the matching rules as a security_flags array, so CloudWatch metric filters and
+// alarms can match e.g. { $.security_flags[0] = * }. Bursts are counted within the log file, which ELB writes
+// every 5 minutes per load balancer node.
+type SecurityTagger struct {
+	Rules   *SecurityRules
+	bursts  []map[string][]time.Time // Per burst rule, the recent times of the matching responses per client IP
+	flagged int
+}
+
+func (s *SecurityTagger) Transform(record []string, entry *LogEntry) {
+	var flags []string
+	for _, rule := range s.Rules.Patterns {
+		if value := recordValue(record, rule.Field); value != "-" && rule.regexp.MatchString(value) {
+			flags = append(flags, rule.Flag)
+		}
+	}
+	if s.bursts == nil {
+		s.bursts = make([]map[string][]time.Time, len(s.Rules.Bursts))
+		for i := range s.bursts {
+			s.bursts[i] = make(map[string][]time.Time)
+		}
+	}
+	client := clientIP(record)
+	status := recordValue(record, "elb_status_code")
+	for i, rule := range s.Rules.Bursts {
+		if !slices.Contains(rule.StatusCodes, status) {
+			continue
+		}
+		// Keep the times within the window before this entry, entries are roughly ordered by time
+		times := s.bursts[i][client]
+		start := 0
+		for start < len(times) && entry.Timestamp.Sub(times[start]) >= rule.window {
+			start++
+		}
+		times = append(times[start:], entry.Timestamp)
+		s.bursts[i][client] = times
+		if len(times) >= rule.Threshold {
+			flags = append(flags, rule.Flag)
+		}
+	}
+	if len(flags) > 0 {
+		entry.Data["security_flags"] = flags
+		s.flagged++
+	}
+}
+
+func (s *SecurityTagger) Summarize(summary map[string]int) {
+	summary["security_flagged"] = s.flagged
+}
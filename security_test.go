@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecurityRules = `{
+	"patterns": [
+		{"flag": "path_traversal", "field": "request", "pattern": "(\\.\\./|%2e%2e%2f)"},
+		{"flag": "sqli", "field": "request", "pattern": "(?i)(union(\\s|%20|\\+)+select|'(\\s|%20)*or(\\s|%20)*'?1)"},
+		{"flag": "scanner", "field": "user_agent", "pattern": "(?i)(sqlmap|nikto|nmap)"}
+	],
+	"bursts": [
+		{"flag": "auth_burst", "status_codes": ["401", "403"], "threshold": 3, "window": "1m"}
+	]
+}`
+
+func TestLoadSecurityRules(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		rules, err := LoadSecurityRules("")
+		assert.NoError(t, err)
+		assert.Nil(t, rules)
+	})
+
+	t.Run("Inline", func(t *testing.T) {
+		rules, err := LoadSecurityRules(testSecurityRules)
+		require.NoError(t, err)
+		assert.Len(t, rules.Patterns, 3)
+		assert.Equal(t, time.Minute, rules.Bursts[0].window)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		require.NoError(t, os.WriteFile(path, []byte(testSecurityRules), 0o644))
+		rules, err := LoadSecurityRules(path)
+		require.NoError(t, err)
+		assert.Len(t, rules.Bursts, 1)
+	})
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"Unknown field", `{"patterns": [{"flag": "x", "field": "url", "pattern": "x"}]}`, "unknown field 'url'"},
+		{"Invalid pattern", `{"patterns": [{"flag": "x", "field": "request", "pattern": "("}]}`, "invalid security rule x"},
+		{"Missing flag", `{"patterns": [{"field": "request", "pattern": "x"}]}`, "expected a flag"},
+		{"Invalid window", `{"bursts": [{"flag": "x", "status_codes": ["401"], "threshold": 2, "window": "soon"}]}`, "invalid window 'soon'"},
+		{"Missing threshold", `{"bursts": [{"flag": "x", "status_codes": ["401"], "window": "1m"}]}`, "expected a flag, status codes and a threshold"},
+		{"Missing file", "/nonexistent/rules.json", "failed to read security rules"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSecurityRules(tt.value)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestSecurityTagger(t *testing.T) {
+	rules, err := LoadSecurityRules(testSecurityRules)
+	require.NoError(t, err)
+	newRecord := func(client, status, request, userAgent string) []string {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("client:port")] = client
+		record[getFieldIndex("elb_status_code")] = status
+		record[getFieldIndex("request")] = request
+		record[getFieldIndex("user_agent")] = userAgent
+		return record
+	}
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		record []string
+		offset time.Duration
+		want   interface{}
+	}{
+		{"Clean", newRecord("10.0.0.1:1234", "200", "GET https://example.com:443/ HTTP/1.1", "curl/8.0"), 0, nil},
+		{"Path traversal", newRecord("10.0.0.1:1234", "400", "GET https://example.com:443/../../etc/passwd HTTP/1.1", "curl/8.0"), 0, []string{"path_traversal"}},
+		{"Scanner with SQL injection", newRecord("10.0.0.2:1234", "200", "GET https://example.com:443/?id=1%20UNION%20SELECT%20password HTTP/1.1", "sqlmap/1.7"), 0, []string{"sqli", "scanner"}},
+		{"First denied", newRecord("10.0.0.3:1234", "401", "POST https://example.com:443/login HTTP/1.1", "-"), 0, nil},
+		{"Denied from other client", newRecord("10.0.0.4:1234", "401", "POST https://example.com:443/login HTTP/1.1", "-"), 10 * time.Second, nil},
+		{"Second denied", newRecord("10.0.0.3:2345", "403", "POST https://example.com:443/login HTTP/1.1", "-"), 20 * time.Second, nil},
+		{"Third denied", newRecord("10.0.0.3:3456", "401", "POST https://example.com:443/login HTTP/1.1", "-"), 30 * time.Second, []string{"auth_burst"}},
+		{"Denied after the window", newRecord("10.0.0.3:4567", "401", "POST https://example.com:443/login HTTP/1.1", "-"), 90 * time.Second, nil},
+	}
+	tagger := &SecurityTagger{Rules: rules}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := LogEntry{Timestamp: start.Add(tt.offset), Data: map[string]interface{}{}}
+			tagger.Transform(tt.record, &entry)
+			if tt.want == nil {
+				assert.NotContains(t, entry.Data, "security_flags")
+			} else {
+				assert.Equal(t, tt.want, entry.Data["security_flags"])
+			}
+		})
+	}
+
+	summary := map[string]int{}
+	tagger.Summarize(summary)
+	assert.Equal(t, 3, summary["security_flagged"])
+}
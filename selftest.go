@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+const (
+	// selfTestEntries is the number of synthetic entries shipped by the self-test
+	selfTestEntries = 5
+	// selfTestLogGroupPrefix is the start of the name of the temporary log groups of the self-test
+	selfTestLogGroupPrefix = "/elb-logs-to-cloudwatch/selftest/"
+	// selfTestPollInterval is how often the self-test reads the log stream until its entries arrived
+	selfTestPollInterval = 2 * time.Second
+)
+
+// SelfTestAPI is the CloudWatch Logs client of the self-test, which also reads the entries back and deletes the
+// log group
+type SelfTestAPI interface {
+	CloudWatchLogsAPI
+	GetLogEvents(*cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error)
+	DeleteLogGroup(*cloudwatchlogs.DeleteLogGroupInput) (*cloudwatchlogs.DeleteLogGroupOutput, error)
+}
+
+// SelfTest ships a few synthetic entries with the configured fields and transformations to a temporary log group,
+// reads them back and deletes the log group, to verify the permissions, network access and configuration of a
+// deployment with a single command. The routes, sampling and subscription filter are left out, so every entry goes
+// to the temporary log group and nothing is forwarded.
+type SelfTest struct {
+	Client  SelfTestAPI
+	Config  Config
+	Fields  Fields
+	Timeout time.Duration // How long to wait for the entries to be readable
+	Keep    bool          // Keep the log group, e.g. to look at the entries
+	Out     io.Writer     // Receives a line for every step
+	// poll is the interval between reads of the log stream, selfTestPollInterval when zero
+	poll time.Duration
+}
+
+// Run runs the self-test and returns the error of the first step that failed. The log group is deleted even if a
+// later step failed.
+func (s *SelfTest) Run(now time.Time) (err error) {
+	destination := LogConfig{LogGroupName: fmt.Sprintf("%s%d", selfTestLogGroupPrefix, now.UnixNano()), LogStreamName: "selftest"}
+	config := s.Config
+	config.LogGroupName = destination.LogGroupName
+	config.LogStreamName = destination.LogStreamName
+	config.AccountRoutes = nil
+	config.HostRoutes = nil
+	config.RouteByTargetGroup = false
+	config.SampleRate = 0
+	config.StreamWriterCheck = streamWriterIgnore
+	config.Subscription = SubscriptionFilter{}
+	state := &State{Config: config, CWClient: s.Client, Fields: s.Fields, Checkpoints: &MemoryCheckpointStore{}}
+	lp, err := NewLogProcessor(state)
+	if err == nil {
+		err = (&Handler{lp: lp}).setUpDestinations()
+	}
+	if err != nil {
+		s.report(false, "create log group %s: %v", destination.LogGroupName, err)
+		return err
+	}
+	s.report(true, "created log group %s with stream %s", destination.LogGroupName, destination.LogStreamName)
+	defer func() {
+		if s.Keep {
+			s.report(true, "kept log group %s", destination.LogGroupName)
+			return
+		}
+		_, deleteErr := s.Client.DeleteLogGroup(&cloudwatchlogs.DeleteLogGroupInput{LogGroupName: aws.String(destination.LogGroupName)})
+		if deleteErr != nil {
+			deleteErr = permissionError(deleteErr, "logs:DeleteLogGroup", "log group "+destination.LogGroupName)
+			s.report(false, "delete log group %s: %v", destination.LogGroupName, deleteErr)
+			err = errors.Join(err, deleteErr)
+			return
+		}
+		s.report(true, "deleted log group %s", destination.LogGroupName)
+	}()
+
+	processor, ok := lp.(DataProcessor)
+	if !ok {
+		err = errors.New("the log processor can't process synthetic entries")
+		s.report(false, "send entries: %v", err)
+		return err
+	}
+	data, timestamps := selfTestLog(now)
+	result, err := processor.ProcessData("selftest.log", []byte(data))
+	if err == nil && result.Entries != len(timestamps) {
+		err = fmt.Errorf("only %d of %d entries were sent, the others were dropped by the configuration", result.Entries, len(timestamps))
+	}
+	if err != nil {
+		s.report(false, "send %d entries: %v", len(timestamps), err)
+		return err
+	}
+	s.report(true, "sent %d entries", result.Entries)
+
+	if err = s.readBack(destination, timestamps); err != nil {
+		s.report(false, "read back %d entries: %v", len(timestamps), err)
+		return err
+	}
+	s.report(true, "read back %d entries", len(timestamps))
+
+	return nil
+}
+
+// readBack reads the log stream until it holds an event for every timestamp or the timeout passed. CloudWatch
+// Logs makes events readable a few seconds after they were put, and a handful fit on the first page.
+func (s *SelfTest) readBack(destination LogConfig, timestamps []time.Time) error {
+	poll := s.poll
+	if poll == 0 {
+		poll = selfTestPollInterval
+	}
+	deadline := time.Now().Add(s.Timeout)
+	for {
+		resp, err := s.Client.GetLogEvents(&cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(destination.LogGroupName),
+			LogStreamName: aws.String(destination.LogStreamName),
+			StartFromHead: aws.Bool(true),
+		})
+		if err != nil {
+			return permissionError(err, "logs:GetLogEvents", fmt.Sprintf("log stream %s in log group %s", destination.LogStreamName, destination.LogGroupName))
+		}
+		stored := make(map[int64]bool)
+		for _, event := range resp.Events {
+			stored[aws.Int64Value(event.Timestamp)] = true
+		}
+		missing := 0
+		for _, timestamp := range timestamps {
+			if !stored[timestamp.UnixMilli()] {
+				missing++
+			}
+		}
+		if missing == 0 {
+			return nil
+		}
+		if time.Now().Add(poll).After(deadline) {
+			return fmt.Errorf("%d entries did not arrive within %s", missing, s.Timeout)
+		}
+		time.Sleep(poll)
+	}
+}
+
+func (s *SelfTest) report(ok bool, format string, args ...interface{}) {
+	status := "ok  "
+	if !ok {
+		status = "FAIL"
+	}
+	message := fmt.Sprintf(format, args...)
+	for _, line := range strings.Split(message, "\n") {
+		fmt.Fprintf(s.Out, "%s %s\n", status, line)
+	}
+}
+
+// selfTestLog returns the log file of the synthetic entries and their timestamps, a second apart and ending a
+// minute before now, so they are within the time range CloudWatch Logs accepts
+func selfTestLog(now time.Time) (string, []time.Time) {
+	var lines []string
+	var timestamps []time.Time
+	start := now.Add(-time.Minute).Truncate(time.Millisecond)
+	for i := 0; i < selfTestEntries; i++ {
+		timestamp := start.Add(time.Duration(i-selfTestEntries) * time.Second).UTC()
+		received := timestamp.Format("2006-01-02T15:04:05.000000Z")
+		lines = append(lines, fmt.Sprintf(`https %s app/selftest/0123456789abcdef 192.0.2.10:40000 10.0.0.10:80 0.001 0.002 0.000 200 200 100 200 "GET https://selftest.example.com:443/selftest/%d HTTP/1.1" "elb-logs-to-cloudwatch-selftest" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/selftest/0123456789abcdef "Root=1-00000000-selftest%016d" "selftest.example.com" "-" 0 %s "forward" "-" "-" "10.0.0.10:80" "200" "-" "-" "-"`,
+			received, i, i, received))
+		timestamps = append(timestamps, timestamp)
+	}
+
+	return strings.Join(lines, "\n") + "\n", timestamps
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// selfTestCloudWatch stores the events put, and returns them once the log stream was read `delay` times
+type selfTestCloudWatch struct {
+	MockCloudWatchLogsClient
+	mu     sync.Mutex
+	events []*cloudwatchlogs.OutputLogEvent
+	delay  int
+	reads  int
+}
+
+func (c *selfTestCloudWatch) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, event := range input.LogEvents {
+		c.events = append(c.events, &cloudwatchlogs.OutputLogEvent{Message: event.Message, Timestamp: event.Timestamp})
+	}
+
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+func (c *selfTestCloudWatch) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reads++
+	if c.reads <= c.delay {
+		return &cloudwatchlogs.GetLogEventsOutput{}, nil
+	}
+
+	return &cloudwatchlogs.GetLogEventsOutput{Events: c.events}, nil
+}
+
+func (c *selfTestCloudWatch) DeleteLogGroup(input *cloudwatchlogs.DeleteLogGroupInput) (*cloudwatchlogs.DeleteLogGroupOutput, error) {
+	args := c.Called(input)
+	return args.Get(0).(*cloudwatchlogs.DeleteLogGroupOutput), args.Error(1)
+}
+
+func newSelfTestCloudWatch() *selfTestCloudWatch {
+	client := &selfTestCloudWatch{delay: 1}
+	client.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{}, nil)
+	client.On("CreateLogGroup", mock.Anything).Return(&cloudwatchlogs.CreateLogGroupOutput{}, nil)
+	client.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{}, nil)
+	client.On("CreateLogStream", mock.Anything).Return(&cloudwatchlogs.CreateLogStreamOutput{}, nil)
+
+	return client
+}
+
+func TestSelfTest(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 30, 0, 0, time.UTC)
+	group := "/elb-logs-to-cloudwatch/selftest/1711038600000000000"
+	fields, err := NewFields("")
+	require.NoError(t, err)
+
+	t.Run("Entries are read back and the log group is deleted", func(t *testing.T) {
+		client := newSelfTestCloudWatch()
+		client.On("DeleteLogGroup", &cloudwatchlogs.DeleteLogGroupInput{LogGroupName: aws.String(group)}).Return(&cloudwatchlogs.DeleteLogGroupOutput{}, nil)
+		var out bytes.Buffer
+		// The routes of the configuration are not used
+		config := Config{LogGroupName: "/elb/access-logs", LogStreamName: "all", HostRoutes: HostRoutes{{Host: "*.example.com", LogGroupName: "/elb/example"}}}
+		selfTest := &SelfTest{Client: client, Config: config, Fields: fields, Timeout: time.Second, Out: &out, poll: time.Millisecond}
+
+		require.NoError(t, selfTest.Run(now))
+		assert.Equal(t, "ok   created log group "+group+" with stream selftest\n"+
+			"ok   sent 5 entries\n"+
+			"ok   read back 5 entries\n"+
+			"ok   deleted log group "+group+"\n", out.String())
+		assert.Len(t, client.events, selfTestEntries)
+		assert.Equal(t, 2, client.reads)
+		client.AssertCalled(t, "CreateLogGroup", &cloudwatchlogs.CreateLogGroupInput{LogGroupName: aws.String(group)})
+	})
+
+	t.Run("Missing entries fail and the log group is deleted", func(t *testing.T) {
+		client := newSelfTestCloudWatch()
+		client.delay = 1000
+		client.On("DeleteLogGroup", mock.Anything).Return(&cloudwatchlogs.DeleteLogGroupOutput{}, nil)
+		var out bytes.Buffer
+		selfTest := &SelfTest{Client: client, Config: Config{}, Fields: fields, Timeout: 10 * time.Millisecond, Out: &out, poll: time.Millisecond}
+
+		require.EqualError(t, selfTest.Run(now), "5 entries did not arrive within 10ms")
+		assert.Contains(t, out.String(), "FAIL read back 5 entries: 5 entries did not arrive within 10ms\n")
+		client.AssertCalled(t, "DeleteLogGroup", mock.Anything)
+	})
+
+	t.Run("A failed deletion is reported", func(t *testing.T) {
+		client := newSelfTestCloudWatch()
+		client.On("DeleteLogGroup", mock.Anything).Return((*cloudwatchlogs.DeleteLogGroupOutput)(nil), errors.New("AccessDeniedException: denied"))
+		var out bytes.Buffer
+		selfTest := &SelfTest{Client: client, Config: Config{}, Fields: fields, Timeout: time.Second, Out: &out, poll: time.Millisecond}
+
+		require.EqualError(t, selfTest.Run(now), "AccessDeniedException: denied")
+		assert.Contains(t, out.String(), "ok   read back 5 entries\nFAIL delete log group "+group+": AccessDeniedException: denied\n")
+	})
+}
+
+func TestSelfTestLog(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 30, 0, 0, time.UTC)
+	data, timestamps := selfTestLog(now)
+	require.Len(t, timestamps, selfTestEntries)
+	assert.Equal(t, now.Add(-time.Minute-5*time.Second), timestamps[0])
+	assert.Equal(t, now.Add(-time.Minute-time.Second), timestamps[len(timestamps)-1])
+
+	fields, err := NewFields("")
+	require.NoError(t, err)
+	entries, err := parseAll(t, &RecordParser{Fields: fields}, data, func() []Transformer { return nil })
+	require.NoError(t, err)
+	require.Len(t, entries, selfTestEntries)
+	for i, entry := range entries {
+		assert.True(t, entry.Timestamp.Equal(timestamps[i]), "entry %d", i)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "strings"
+
+// Severity levels of entries, see Severity
+const (
+	levelError = "ERROR"
+	levelWarn  = "WARN"
+	levelInfo  = "INFO"
+)
+
+// SeverityLevel sets a level field on every entry, so alarms and subscription filters can match on a single field
+// instead of combining status codes and error reasons
+type SeverityLevel struct{}
+
+func (s *SeverityLevel) Transform(record []string, entry *LogEntry) {
+	entry.Data["level"] = Severity(record)
+}
+
+// Severity returns ERROR for 5xx responses and errors generated by the load balancer itself, such as failed
+// connections to targets (reported in error_reason), WARN for 4xx responses and requests rejected as
+// severe by desync mitigation, and INFO otherwise
+func Severity(record []string) string {
+	elbStatus := recordValue(record, "elb_status_code")
+	targetStatus := recordValue(record, "target_status_code")
+	errorReason := recordValue(record, "error_reason")
+	switch {
+	case strings.HasPrefix(elbStatus, "5"), strings.HasPrefix(targetStatus, "5"):
+		return levelError
+	case errorReason != "" && errorReason != "-":
+		return levelError
+	case strings.HasPrefix(elbStatus, "4"), strings.HasPrefix(targetStatus, "4"):
+		return levelWarn
+	case recordValue(record, "classification") == "Severe":
+		return levelWarn
+	default:
+		return levelInfo
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeverity(t *testing.T) {
+	newRecord := func(elbStatus, targetStatus, errorReason, classification string) []string {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("elb_status_code")] = elbStatus
+		record[getFieldIndex("target_status_code")] = targetStatus
+		record[getFieldIndex("error_reason")] = errorReason
+		record[getFieldIndex("classification")] = classification
+		return record
+	}
+
+	tests := []struct {
+		name   string
+		record []string
+		want   string
+	}{
+		{"Success", newRecord("200", "200", "-", "-"), "INFO"},
+		{"Redirect", newRecord("301", "-", "-", "-"), "INFO"},
+		{"Target error", newRecord("502", "-", "-", "-"), "ERROR"},
+		{"Target 5xx passed through", newRecord("200", "503", "-", "-"), "ERROR"},
+		{"Target connection failure", newRecord("200", "-", "TargetConnectionErrorCodes", "-"), "ERROR"},
+		{"Client error", newRecord("404", "404", "-", "-"), "WARN"},
+		{"Rejected by WAF", newRecord("403", "-", "-", "-"), "WARN"},
+		{"Desync mitigation", newRecord("200", "200", "-", "Severe"), "WARN"},
+		{"Short record", []string{"http"}, "INFO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Severity(tt.record))
+		})
+	}
+
+	entry := LogEntry{Data: map[string]interface{}{}}
+	(&SeverityLevel{}).Transform(newRecord("500", "-", "-", "-"), &entry)
+	assert.Equal(t, "ERROR", entry.Data["level"])
+}
@@ -0,0 +1,29 @@
+package main
+
+import "time"
+
+// SkewClamper sets timestamps that are up to Tolerance ahead of the clock of this host to the current time.
+// Clocks of load balancer nodes and of the ingesting host drift apart by a few seconds, which would otherwise
+// put entries in the future. Timestamps further ahead are kept, they are not caused by clock skew.
+type SkewClamper struct {
+	Tolerance time.Duration
+	now       func() time.Time // time.Now if nil
+	clamped   int
+}
+
+func (s *SkewClamper) Transform(record []string, entry *LogEntry) {
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	if ahead := entry.Timestamp.Sub(now); ahead > 0 && ahead <= s.Tolerance {
+		entry.Timestamp = now
+		s.clamped++
+	}
+}
+
+func (s *SkewClamper) Summarize(summary map[string]int) {
+	if s.clamped > 0 {
+		summary["clock_skew_clamped"] = s.clamped
+	}
+}
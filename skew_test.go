@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkewClamper(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		want      time.Time
+	}{
+		{"In the past", now.Add(-time.Minute), now.Add(-time.Minute)},
+		{"Now", now, now},
+		{"Within tolerance", now.Add(3 * time.Second), now},
+		{"At tolerance", now.Add(5 * time.Second), now},
+		{"Beyond tolerance", now.Add(time.Hour), now.Add(time.Hour)},
+	}
+	clamper := &SkewClamper{Tolerance: 5 * time.Second, now: func() time.Time { return now }}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := LogEntry{Timestamp: tt.timestamp, Data: map[string]interface{}{}}
+			clamper.Transform(nil, &entry)
+			assert.Equal(t, tt.want, entry.Timestamp)
+		})
+	}
+
+	summary := map[string]int{}
+	clamper.Summarize(summary)
+	assert.Equal(t, map[string]int{"clock_skew_clamped": 2}, summary)
+}
@@ -0,0 +1,155 @@
+//go:build soak
+
+package main
+
+// The soak test runs the watcher against LocalStack for hours of synthetic logs, and checks that the memory
+// and goroutines of the pipeline stay steady and that every entry arrives in CloudWatch Logs exactly once. It
+// is excluded from normal test runs by the soak build tag, see the README for how to run it.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// soakSample is a measurement of the process, taken after a garbage collection
+type soakSample struct {
+	at         time.Duration
+	heap       uint64
+	goroutines int
+}
+
+func takeSoakSample(started time.Time) soakSample {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	return soakSample{at: time.Since(started), heap: stats.HeapAlloc, goroutines: runtime.NumGoroutine()}
+}
+
+// soakLine returns a log line of the current time with a unique trace ID
+func soakLine(now time.Time, i int) string {
+	timestamp := now.UTC().Format("2006-01-02T15:04:05.000000Z")
+	return fmt.Sprintf(`https %s app/soak-lb/0123456789abcdef 192.0.2.104:36217 10.0.0.24:3003 0.004 0.024 0.003 200 200 1694 10783 "GET https://example.com:443/api/items/%d HTTP/1.1" "axios/1.6.5" ECDHE-RSA-AES256-GCM-SHA384 TLSv1.3 arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/soak-tg/0123456789abcdef "Root=1-soak-%024d" "example.com" "arn:aws:acm:us-east-1:123456789012:certificate/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" 0 %s "forward" "-" "-" "10.0.0.24:3003" "200" "-" "-" "TID_a1b2c3d4e5f67890abcdef1234567890"`, timestamp, i%1000, i, timestamp)
+}
+
+func soakSetting(t *testing.T, name string, fallback time.Duration) time.Duration {
+	value, err := durationFromEnv(name, fallback)
+	require.NoError(t, err)
+	return value
+}
+
+func TestSoak(t *testing.T) {
+	if os.Getenv("AWS_ENDPOINT_URL") == "" {
+		t.Skip("AWS_ENDPOINT_URL is not set, the soak test needs LocalStack")
+	}
+	duration := soakSetting(t, "SOAK_DURATION", time.Hour)
+	interval := soakSetting(t, "SOAK_OBJECT_INTERVAL", 5*time.Second)
+	perObject, err := intFromEnv("SOAK_ENTRIES_PER_OBJECT", 1000)
+	require.NoError(t, err)
+
+	run := strconv.FormatInt(time.Now().Unix(), 10)
+	bucket := "soak-" + run
+	t.Setenv("LOG_GROUP_NAME", "/soak/"+run)
+	t.Setenv("LOG_STREAM_NAME", "soak")
+	t.Setenv("FIELDS", "trace_id,request")
+	handler, err := NewHandler()
+	require.NoError(t, err)
+	sess := newSession(handler.config)
+	s3Client := s3.New(sess)
+	_, err = s3Client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	require.NoError(t, err)
+
+	started := time.Now()
+	stop := make(chan struct{})
+	watcher := &Watcher{Handler: handler, Bucket: bucket, Prefix: "AWSLogs/", Interval: 2 * time.Second}
+	stopped := make(chan struct{})
+	go func() {
+		watcher.Run(stop)
+		close(stopped)
+	}()
+
+	generated := 0
+	var samples []soakSample
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	nextSample := time.Now()
+	for object := 0; time.Since(started) < duration; object++ {
+		now := time.Now()
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		for i := 0; i < perObject; i++ {
+			_, err := fmt.Fprintln(gz, soakLine(now, generated))
+			require.NoError(t, err)
+			generated++
+		}
+		require.NoError(t, gz.Close())
+		key := fmt.Sprintf("AWSLogs/123456789012/elasticloadbalancing/us-east-1/%s/123456789012_elasticloadbalancing_us-east-1_app.soak-lb.0123456789abcdef_%sZ_10.0.0.1_%08d.log.gz",
+			now.UTC().Format("2006/01/02"), now.UTC().Format("20060102T1504"), object)
+		_, err := s3Client.PutObject(&s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: bytes.NewReader(buf.Bytes())})
+		require.NoError(t, err)
+		if now.After(nextSample) {
+			sample := takeSoakSample(started)
+			t.Logf("%s: %d entries generated, %d KiB heap, %d goroutines", sample.at.Round(time.Second), generated, sample.heap/1024, sample.goroutines)
+			samples = append(samples, sample)
+			nextSample = now.Add(time.Minute)
+		}
+		<-ticker.C
+	}
+
+	// Wait until the watcher caught up with the last objects
+	stored := make(map[string]int)
+	cwClient := cloudwatchlogs.New(sess)
+	for deadline := time.Now().Add(5 * time.Minute); time.Now().Before(deadline); time.Sleep(10 * time.Second) {
+		stored = make(map[string]int)
+		err := cwClient.GetLogEventsPages(&cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String("/soak/" + run),
+			LogStreamName: aws.String("soak"),
+			StartFromHead: aws.Bool(true),
+		}, func(page *cloudwatchlogs.GetLogEventsOutput, _ bool) bool {
+			for _, event := range page.Events {
+				var message struct {
+					TraceID string `json:"trace_id"`
+				}
+				require.NoError(t, json.Unmarshal([]byte(aws.StringValue(event.Message)), &message))
+				stored[message.TraceID]++
+			}
+			return true
+		})
+		require.NoError(t, err)
+		if len(stored) >= generated {
+			break
+		}
+	}
+	close(stop)
+	<-stopped
+	final := takeSoakSample(started)
+	t.Logf("%s: %d entries generated, %d stored, %d KiB heap, %d goroutines", final.at.Round(time.Second), generated, len(stored), final.heap/1024, final.goroutines)
+
+	duplicates := 0
+	for _, n := range stored {
+		duplicates += n - 1
+	}
+	assert.Equal(t, generated, len(stored), "lost entries")
+	assert.Zero(t, duplicates, "duplicate entries")
+
+	// Compare the end of the run with a baseline after the first tenth, when the caches and buffers are warm
+	require.NotEmpty(t, samples)
+	baseline := samples[len(samples)/10]
+	last := samples[len(samples)-1]
+	assert.LessOrEqual(t, last.heap, 2*baseline.heap+16<<20, "heap grew from %d to %d bytes", baseline.heap, last.heap)
+	assert.LessOrEqual(t, last.goroutines, baseline.goroutines+10, "goroutines grew from %d to %d", baseline.goroutines, last.goroutines)
+	assert.LessOrEqual(t, final.goroutines, baseline.goroutines, "goroutines left after stopping the watcher")
+}
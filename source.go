@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// stdinKey is the key of the object that is read from standard input
+const stdinKey = "-"
+
+// ObjectMetadata describes an opened object
+type ObjectMetadata struct {
+	ETag string // Identifies the version of the object if known, used to track progress
+	Size *int64 // Size of the object in bytes if known
+}
+
+// Source opens the gzipped log files that are processed. Objects are identified by an S3ObjectInfo, which for
+// sources other than S3 has an empty bucket and the location of the log file as key.
+type Source interface {
+	Open(object S3ObjectInfo) (io.ReadCloser, ObjectMetadata, error)
+}
+
+// Sources opens every object with the source for its location: S3 objects have a bucket, the stdinKey
+// is standard input and any other key is a local file
+type Sources struct {
+	S3    Source
+	File  Source
+	Stdin Source
+}
+
+// NewSources returns the sources for S3 objects, local files and standard input
+func NewSources(s3Client S3Api) Sources {
+	return Sources{
+		S3:    &S3Source{Client: s3Client},
+		File:  FileSource{},
+		Stdin: &ReaderSource{Reader: os.Stdin},
+	}
+}
+
+func (s Sources) Open(object S3ObjectInfo) (io.ReadCloser, ObjectMetadata, error) {
+	switch {
+	case object.Bucket != "":
+		return s.S3.Open(object)
+	case object.Key == stdinKey:
+		return s.Stdin.Open(object)
+	default:
+		return s.File.Open(object)
+	}
+}
+
+// S3Source gets objects from S3
+type S3Source struct {
+	Client S3Api
+}
+
+func (s *S3Source) Open(object S3ObjectInfo) (io.ReadCloser, ObjectMetadata, error) {
+	obj, err := s.Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(object.Bucket),
+		Key:    aws.String(object.Key),
+	})
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	return obj.Body, ObjectMetadata{ETag: aws.StringValue(obj.ETag), Size: obj.ContentLength}, nil
+}
+
+// FileSource reads local files, the key of an object is its path
+type FileSource struct{}
+
+func (FileSource) Open(object S3ObjectInfo) (io.ReadCloser, ObjectMetadata, error) {
+	file, err := os.Open(object.Key)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ObjectMetadata{}, err
+	}
+
+	return file, ObjectMetadata{Size: aws.Int64(info.Size())}, nil
+}
+
+// ReaderSource reads a single stream such as standard input, its size and version are unknown
+type ReaderSource struct {
+	Reader io.Reader
+}
+
+func (s *ReaderSource) Open(object S3ObjectInfo) (io.ReadCloser, ObjectMetadata, error) {
+	return io.NopCloser(s.Reader), ObjectMetadata{}, nil
+}
+
+// String returns the S3 URL of an object, or the key of objects from other sources
+func (o S3ObjectInfo) String() string {
+	if o.Bucket == "" {
+		return o.Key
+	}
+
+	return fmt.Sprintf("s3://%s/%s", o.Bucket, o.Key)
+}
+
+// listLocalObjects returns the gzipped files in a directory and its subdirectories in lexical order, a single
+// file, or standard input for "-"
+func listLocalObjects(path string) ([]S3ObjectInfo, error) {
+	if path == stdinKey {
+		return []S3ObjectInfo{{Key: stdinKey}}, nil
+	}
+	var objects []S3ObjectInfo
+	err := filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// A file given explicitly is processed regardless of its extension
+		// Extensions are matched regardless of case, as on the default file systems of Windows and macOS
+		if !entry.Type().IsRegular() || (name != path && !strings.EqualFold(filepath.Ext(name), ".gz")) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, S3ObjectInfo{Key: name, Size: aws.Int64(info.Size())})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local files: %v", err)
+	}
+
+	return objects, nil
+}
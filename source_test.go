@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.log.gz")
+	require.NoError(t, os.WriteFile(path, []byte("file"), 0o644))
+
+	mockS3 := new(MockS3Api)
+	mockS3.On("GetObject", mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		return aws.StringValue(input.Bucket) == "bucket" && aws.StringValue(input.Key) == "key"
+	})).Return(&s3.GetObjectOutput{
+		Body:          io.NopCloser(strings.NewReader("object")),
+		ContentLength: aws.Int64(6),
+		ETag:          aws.String(`"etag"`),
+	}, nil)
+	sources := NewSources(mockS3)
+	sources.Stdin = &ReaderSource{Reader: strings.NewReader("stdin")}
+
+	tests := []struct {
+		name     string
+		object   S3ObjectInfo
+		want     string
+		metadata ObjectMetadata
+	}{
+		{"S3", S3ObjectInfo{Bucket: "bucket", Key: "key"}, "object", ObjectMetadata{ETag: `"etag"`, Size: aws.Int64(6)}},
+		{"Local file", S3ObjectInfo{Key: path}, "file", ObjectMetadata{Size: aws.Int64(4)}},
+		{"Standard input", S3ObjectInfo{Key: "-"}, "stdin", ObjectMetadata{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, metadata, err := sources.Open(tt.object)
+			require.NoError(t, err)
+			defer body.Close()
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(data))
+			assert.Equal(t, tt.metadata, metadata)
+		})
+	}
+
+	_, _, err := sources.Open(S3ObjectInfo{Key: filepath.Join(dir, "missing.log.gz")})
+	assert.Error(t, err)
+}
+
+func TestListLocalObjects(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "01"), 0o755))
+	for _, name := range []string{"01/b.log.gz", "01/a.log.gz", "02.log.gz", "03.LOG.GZ", "README.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644))
+	}
+
+	objects, err := listLocalObjects(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []S3ObjectInfo{
+		{Key: filepath.Join(dir, "01/a.log.gz"), Size: aws.Int64(1)},
+		{Key: filepath.Join(dir, "01/b.log.gz"), Size: aws.Int64(1)},
+		{Key: filepath.Join(dir, "02.log.gz"), Size: aws.Int64(1)},
+		{Key: filepath.Join(dir, "03.LOG.GZ"), Size: aws.Int64(1)},
+	}, objects)
+
+	// A file given explicitly is listed regardless of its extension
+	objects, err = listLocalObjects(filepath.Join(dir, "README.txt"))
+	require.NoError(t, err)
+	assert.Len(t, objects, 1)
+
+	objects, err = listLocalObjects("-")
+	require.NoError(t, err)
+	assert.Equal(t, []S3ObjectInfo{{Key: "-"}}, objects)
+
+	_, err = listLocalObjects(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+
+	assert.Equal(t, "s3://bucket/key", S3ObjectInfo{Bucket: "bucket", Key: "key"}.String())
+	assert.Equal(t, "logs/a.log.gz", S3ObjectInfo{Key: "logs/a.log.gz"}.String())
+}
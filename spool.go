@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// SpooledBatch is a batch of events that could not be sent to CloudWatch, kept to be replayed later
+type SpooledBatch struct {
+	LogGroupName  string         `json:"logGroup"`
+	LogStreamName string         `json:"logStream"`
+	RoleARN       string         `json:"roleArn,omitempty"`
+	Events        []SpooledEvent `json:"events"`
+}
+
+// SpooledEvent is a log event of a SpooledBatch
+type SpooledEvent struct {
+	Timestamp int64  `json:"timestamp"` // Milliseconds since the epoch
+	Message   string `json:"message"`
+}
+
+// newSpooledBatch converts the events of a failed PutLogEvents request
+func newSpooledBatch(destination LogConfig, events []*cloudwatchlogs.InputLogEvent) SpooledBatch {
+	batch := SpooledBatch{
+		LogGroupName:  destination.LogGroupName,
+		LogStreamName: destination.LogStreamName,
+		RoleARN:       destination.RoleARN,
+		Events:        make([]SpooledEvent, len(events)),
+	}
+	for i, event := range events {
+		batch.Events[i] = SpooledEvent{Timestamp: aws.Int64Value(event.Timestamp), Message: aws.StringValue(event.Message)}
+	}
+
+	return batch
+}
+
+func (b SpooledBatch) destination() LogConfig {
+	return LogConfig{LogGroupName: b.LogGroupName, LogStreamName: b.LogStreamName, RoleARN: b.RoleARN}
+}
+
+func (b SpooledBatch) events() []*cloudwatchlogs.InputLogEvent {
+	events := make([]*cloudwatchlogs.InputLogEvent, len(b.Events))
+	for i, event := range b.Events {
+		events[i] = &cloudwatchlogs.InputLogEvent{Timestamp: aws.Int64(event.Timestamp), Message: aws.String(event.Message)}
+	}
+
+	return events
+}
+
+// Spool stores batches that could not be sent, e.g. during a CloudWatch outage, until they are replayed.
+// Every batch is stored as a separate JSON document, named such that the names sort in the order of writing.
+type Spool interface {
+	Write(batch SpooledBatch) error
+	List() ([]string, error)
+	Read(name string) (SpooledBatch, error)
+	Remove(name string) error
+}
+
+// spoolLocker is implemented by spools that must be locked while replaying, so two replays don't send the same
+// batches. S3 prefixes are not locked, replays of them should be run from one place.
+type spoolLocker interface {
+	Lock() (unlock func() error, err error)
+}
+
+// newSpoolName returns a unique name for a spooled batch that sorts by time
+func newSpoolName() string {
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+
+	return fmt.Sprintf("%s-%s.json", time.Now().UTC().Format("20060102T150405.000000000Z"), hex.EncodeToString(random))
+}
+
+// NewSpool returns a spool in an S3 prefix for an S3 URL, or in a local directory otherwise
+func NewSpool(location string, s3Client S3Api) (Spool, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		return &DirSpool{Dir: location}, nil
+	}
+	bucket, prefix, err := ParseS3URL(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spool location: %v", err)
+	}
+
+	return &S3Spool{Client: s3Client, Bucket: bucket, Prefix: prefix}, nil
+}
+
+// DirSpool stores batches as files in a local directory, which is created when needed
+type DirSpool struct {
+	Dir string
+}
+
+func (s *DirSpool) Write(batch SpooledBatch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	// Written under a temporary name first, so a replay never reads a partially written batch
+	name := filepath.Join(s.Dir, newSpoolName())
+	if err := os.WriteFile(name+".tmp", data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+// Lock locks the directory with a lock file, which is not listed as it doesn't end with .json
+func (s *DirSpool) Lock() (func() error, error) {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return lockFile(filepath.Join(s.Dir, "replay.lock"))
+}
+
+func (s *DirSpool) List() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func (s *DirSpool) Read(name string) (SpooledBatch, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, name))
+	if err != nil {
+		return SpooledBatch{}, err
+	}
+	var batch SpooledBatch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return SpooledBatch{}, fmt.Errorf("invalid spooled batch %s: %v", name, err)
+	}
+
+	return batch, nil
+}
+
+func (s *DirSpool) Remove(name string) error {
+	return os.Remove(filepath.Join(s.Dir, name))
+}
+
+func (s *DirSpool) String() string {
+	return s.Dir
+}
+
+// S3Spool stores batches as objects under a prefix, for Lambda functions that have no persistent local storage
+type S3Spool struct {
+	Client S3Api
+	Bucket string
+	Prefix string
+}
+
+func (s *S3Spool) Write(batch SpooledBatch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.Prefix + newSpoolName()),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+
+	return err
+}
+
+func (s *S3Spool) List() ([]string, error) {
+	var names []string
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(s.Bucket), Prefix: aws.String(s.Prefix)}
+	for {
+		resp, err := s.Client.ListObjectsV2(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range resp.Contents {
+			if name := strings.TrimPrefix(aws.StringValue(obj.Key), s.Prefix); strings.HasSuffix(name, ".json") && !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !aws.BoolValue(resp.IsTruncated) {
+			return names, nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
+func (s *S3Spool) Read(name string) (SpooledBatch, error) {
+	obj, err := s.Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String(s.Prefix + name)})
+	if err != nil {
+		return SpooledBatch{}, err
+	}
+	defer obj.Body.Close()
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return SpooledBatch{}, err
+	}
+	var batch SpooledBatch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return SpooledBatch{}, fmt.Errorf("invalid spooled batch %s: %v", name, err)
+	}
+
+	return batch, nil
+}
+
+func (s *S3Spool) Remove(name string) error {
+	_, err := s.Client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String(s.Prefix + name)})
+
+	return err
+}
+
+func (s *S3Spool) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, s.Prefix)
+}
+
+const (
+	// maxEventAge is how old events may be to be accepted by PutLogEvents
+	maxEventAge = 14 * 24 * time.Hour
+	// maxEventLead is how far in the future events may be to be accepted by PutLogEvents
+	maxEventLead = 2 * time.Hour
+)
+
+// ReplayResult counts the spooled batches and events that were replayed, and the events that could no
+// longer be ingested because they are outside the ingestion window of CloudWatch
+type ReplayResult struct {
+	Batches int
+	Events  int
+	TooOld  int
+	TooNew  int
+}
+
+// Replayer sends spooled batches to CloudWatch
+type Replayer struct {
+	Client      CloudWatchLogsAPI
+	RoleClients *RoleClients
+	Limiter     *RateLimiter     // Limits the events per second, nil if unlimited
+	now         func() time.Time // Overridden in tests
+	ensured     DestinationCache
+}
+
+// Replay sends the spooled batches in the order they were written and removes every batch that was sent. Events
+// outside the ingestion window are dropped and reported. Replaying stops at the first batch that fails, so it
+// can simply be run again once CloudWatch is available.
+func (r *Replayer) Replay(spool Spool) (ReplayResult, error) {
+	var result ReplayResult
+	if locker, ok := spool.(spoolLocker); ok {
+		unlock, err := locker.Lock()
+		if err != nil {
+			return result, fmt.Errorf("failed to lock spool: %v", err)
+		}
+		defer func() { _ = unlock() }()
+	}
+	names, err := spool.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to list spooled batches: %v", err)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		batch, err := spool.Read(name)
+		if err != nil {
+			return result, fmt.Errorf("failed to read spooled batch %s: %v", name, err)
+		}
+		destination := batch.destination()
+		events, tooOld, tooNew := r.ingestible(batch.events())
+		if tooOld+tooNew > 0 {
+			logf(verbosityNormal, "dropping %d events of spooled batch %s for %s/%s outside the ingestion window (%d older than %s, %d more than %s ahead)",
+				tooOld+tooNew, name, destination.LogGroupName, destination.LogStreamName, tooOld, maxEventAge, tooNew, maxEventLead)
+		}
+		if len(events) > 0 {
+			if err := r.send(destination, events); err != nil {
+				return result, fmt.Errorf("failed to replay spooled batch %s: %v", name, err)
+			}
+		}
+		if err := spool.Remove(name); err != nil {
+			return result, fmt.Errorf("failed to remove replayed batch %s: %v", name, err)
+		}
+		logf(verbosityVerbose, "replayed %d events to %s/%s", len(events), destination.LogGroupName, destination.LogStreamName)
+		result.Batches++
+		result.Events += len(events)
+		result.TooOld += tooOld
+		result.TooNew += tooNew
+	}
+
+	return result, nil
+}
+
+// ingestible returns the events within the ingestion window, and the number of events before and after it
+func (r *Replayer) ingestible(events []*cloudwatchlogs.InputLogEvent) (kept []*cloudwatchlogs.InputLogEvent, tooOld, tooNew int) {
+	now := time.Now()
+	if r.now != nil {
+		now = r.now()
+	}
+	oldest := now.Add(-maxEventAge).UnixMilli()
+	newest := now.Add(maxEventLead).UnixMilli()
+	for _, event := range events {
+		switch timestamp := aws.Int64Value(event.Timestamp); {
+		case timestamp < oldest:
+			tooOld++
+		case timestamp > newest:
+			tooNew++
+		default:
+			kept = append(kept, event)
+		}
+	}
+
+	return kept, tooOld, tooNew
+}
+
+// send makes sure the destination exists and sends the events at the configured rate
+func (r *Replayer) send(destination LogConfig, events []*cloudwatchlogs.InputLogEvent) error {
+	client := r.RoleClients.Client(destination.RoleARN, r.Client)
+	if err := r.ensured.Ensure(destination, func() error {
+		return EnsureLogGroupAndLogStreamExists(client, destination)
+	}); err != nil {
+		return fmt.Errorf("error creating log group and stream: %v", err)
+	}
+	if r.Limiter != nil {
+		for range events {
+			r.Limiter.Wait()
+		}
+	}
+
+	err := SendEventsToCloudWatch(client, destination, events)
+	// Events too old or new to ingest were dropped before sending, so rejections are rare and not retried
+	var rejected *RejectedEventsError
+	if errors.As(err, &rejected) {
+		logf(verbosityNormal, "%v in %s/%s", err, destination.LogGroupName, destination.LogStreamName)
+		return nil
+	}
+
+	return err
+}
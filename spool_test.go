@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func testSpooledBatch(message string) SpooledBatch {
+	return SpooledBatch{
+		LogGroupName:  "test-log-group",
+		LogStreamName: "test-log-stream",
+		Events:        []SpooledEvent{{Timestamp: 1711037426071, Message: message}},
+	}
+}
+
+func TestDirSpool(t *testing.T) {
+	spool := &DirSpool{Dir: t.TempDir() + "/spool"}
+
+	// The directory is created when the first batch is written
+	names, err := spool.List()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	require.NoError(t, spool.Write(testSpooledBatch("first")))
+	require.NoError(t, spool.Write(testSpooledBatch("second")))
+	names, err = spool.List()
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+
+	batch, err := spool.Read(names[0])
+	require.NoError(t, err)
+	assert.Equal(t, testSpooledBatch("first"), batch)
+
+	require.NoError(t, spool.Remove(names[0]))
+	names, err = spool.List()
+	require.NoError(t, err)
+	assert.Len(t, names, 1)
+}
+
+func TestDirSpoolLock(t *testing.T) {
+	spool := &DirSpool{Dir: filepath.Join(t.TempDir(), "spool")}
+	require.NoError(t, spool.Write(testSpooledBatch("first")))
+
+	// A replay of the same directory by another process fails without sending anything
+	unlock, err := spool.Lock()
+	require.NoError(t, err)
+	mockCW := new(MockCloudWatchLogsClient)
+	_, err = (&Replayer{Client: mockCW}).Replay(spool)
+	assert.ErrorContains(t, err, "locked by another process")
+	mockCW.AssertNotCalled(t, "PutLogEvents", mock.Anything)
+
+	// The lock file is not a spooled batch
+	names, err := spool.List()
+	require.NoError(t, err)
+	assert.Len(t, names, 1)
+
+	require.NoError(t, unlock())
+	_, err = spool.Lock()
+	assert.NoError(t, err)
+}
+
+func TestS3Spool(t *testing.T) {
+	mockS3 := new(MockS3Api)
+	spool, err := NewSpool("s3://bucket/spool/", mockS3)
+	require.NoError(t, err)
+	assert.Equal(t, "s3://bucket/spool/", fmt.Sprint(spool))
+
+	var written []byte
+	mockS3.On("PutObject", mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.StringValue(input.Bucket) == "bucket" && len(aws.StringValue(input.Key)) > len("spool/")
+	})).Run(func(args mock.Arguments) {
+		written, _ = io.ReadAll(args.Get(0).(*s3.PutObjectInput).Body)
+	}).Return(&s3.PutObjectOutput{}, nil)
+	require.NoError(t, spool.Write(testSpooledBatch("first")))
+	assert.JSONEq(t, `{"logGroup":"test-log-group","logStream":"test-log-stream","events":[{"timestamp":1711037426071,"message":"first"}]}`, string(written))
+
+	mockS3.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{{Key: aws.String("spool/20240321T161026.000000000Z-0a1b2c3d.json")}, {Key: aws.String("spool/nested/other.json")}},
+	}, nil)
+	names, err := spool.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240321T161026.000000000Z-0a1b2c3d.json"}, names)
+
+	mockS3.On("GetObject", &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("spool/" + names[0])}).
+		Return(&s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(written))}, nil)
+	batch, err := spool.Read(names[0])
+	require.NoError(t, err)
+	assert.Equal(t, testSpooledBatch("first"), batch)
+
+	mockS3.On("DeleteObject", &s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("spool/" + names[0])}).
+		Return(&s3.DeleteObjectOutput{}, nil)
+	require.NoError(t, spool.Remove(names[0]))
+	mockS3.AssertExpectations(t)
+
+	local, err := NewSpool("/tmp/spool", mockS3)
+	require.NoError(t, err)
+	assert.Equal(t, &DirSpool{Dir: "/tmp/spool"}, local)
+}
+
+func TestSendBatchSpool(t *testing.T) {
+	mockCW := new(MockCloudWatchLogsClient)
+	mockCW.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{}, fmt.Errorf("service unavailable"))
+	mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, fmt.Errorf("service unavailable"))
+	spool := &DirSpool{Dir: t.TempDir()}
+	lp := &CloudWatchLogProcessor{
+		cwClient:  mockCW,
+		logConfig: LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"},
+		spool:     spool,
+	}
+	timestamp := time.Date(2024, 3, 21, 16, 10, 26, 71000000, time.UTC)
+	entryChan := make(chan LogEntry, 2)
+	entryChan <- LogEntry{Data: map[string]interface{}{"elb_status_code": "200"}, Timestamp: timestamp}
+	entryChan <- LogEntry{Data: map[string]interface{}{"elb_status_code": "503"}, Timestamp: timestamp, Record: 1}
+	close(entryChan)
+
+	// The object doesn't fail, the batch is kept in the spool but not counted as sent
+	counters := &sendCounters{}
+	require.NoError(t, lp.sendEntries(entryChan, counters, nil))
+	assert.Equal(t, 0, counters.entries.Value())
+	names, err := spool.List()
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+	batch, err := spool.Read(names[0])
+	require.NoError(t, err)
+	assert.Equal(t, SpooledBatch{
+		LogGroupName:  "test-log-group",
+		LogStreamName: "test-log-stream",
+		Events: []SpooledEvent{
+			{Timestamp: 1711037426071, Message: `{"elb_status_code":"200"}`},
+			{Timestamp: 1711037426071, Message: `{"elb_status_code":"503"}`},
+		},
+	}, batch)
+}
+
+func TestReplayer(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 10, 26, 0, time.UTC)
+	spool := &DirSpool{Dir: t.TempDir()}
+	first := testSpooledBatch("first")
+	first.Events[0].Timestamp = now.Add(-time.Hour).UnixMilli()
+	second := testSpooledBatch("second")
+	second.Events = []SpooledEvent{
+		{Timestamp: now.Add(-15 * 24 * time.Hour).UnixMilli(), Message: "expired"},
+		{Timestamp: now.Add(-13 * 24 * time.Hour).UnixMilli(), Message: "second"},
+		{Timestamp: now.Add(3 * time.Hour).UnixMilli(), Message: "future"},
+	}
+	require.NoError(t, spool.Write(first))
+	require.NoError(t, spool.Write(second))
+
+	t.Run("Outage continues", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{}, fmt.Errorf("service unavailable"))
+		replayer := &Replayer{Client: mockCW, now: func() time.Time { return now }}
+		result, err := replayer.Replay(spool)
+		require.Error(t, err)
+		assert.Equal(t, ReplayResult{}, result)
+		mockCW.AssertNotCalled(t, "PutLogEvents", mock.Anything)
+	})
+
+	t.Run("Replayed in order", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("DescribeLogGroups", mock.Anything).Return(&cloudwatchlogs.DescribeLogGroupsOutput{
+			LogGroups: []*cloudwatchlogs.LogGroup{{LogGroupName: aws.String("test-log-group")}},
+		}, nil)
+		mockCW.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{
+			LogStreams: []*cloudwatchlogs.LogStream{{LogStreamName: aws.String("test-log-stream")}},
+		}, nil)
+		var messages []string
+		mockCW.On("PutLogEvents", mock.Anything).Run(func(args mock.Arguments) {
+			for _, event := range args.Get(0).(*cloudwatchlogs.PutLogEventsInput).LogEvents {
+				messages = append(messages, aws.StringValue(event.Message))
+			}
+		}).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil)
+
+		limiter := NewRateLimiter(100)
+		limiter.now = func() time.Time { return now }
+		replayer := &Replayer{Client: mockCW, Limiter: limiter, now: func() time.Time { return now }}
+		result, err := replayer.Replay(spool)
+		require.NoError(t, err)
+		assert.Equal(t, ReplayResult{Batches: 2, Events: 2, TooOld: 1, TooNew: 1}, result)
+		assert.Equal(t, []string{"first", "second"}, messages)
+		// The destination is checked once, and the rate limiter was used for every event that was sent
+		mockCW.AssertNumberOfCalls(t, "DescribeLogStreams", 1)
+		assert.InDelta(t, 98, limiter.tokens, 0.001)
+
+		names, err := spool.List()
+		require.NoError(t, err)
+		assert.Empty(t, names)
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go driver, so the binary needs no C toolchain
+)
+
+// sqliteTable is the table of the entries in an exported SQLite database
+const sqliteTable = "entries"
+
+// sqliteIndexed are the columns indexed for the most common queries: a time range, errors and a load balancer
+var sqliteIndexed = []string{"time", "elb_status_code", "elb"}
+
+// sqliteWriter writes entries as the rows of a table in a new SQLite database, with columns of the type of their
+// field (see columnType, timestamps are RFC 3339 text) and indexes on sqliteIndexed, see rowColumns. All rows are inserted
+// in a single transaction, committed by close.
+type sqliteWriter struct {
+	rowColumns
+	db     *sql.DB
+	tx     *sql.Tx
+	insert *sql.Stmt
+	types  []string // Column types, see columnType
+}
+
+// newSQLiteWriter creates the database at path, replacing an existing file like the other formats do
+func newSQLiteWriter(path string, columns []string) (*sqliteWriter, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// The database is written once by a single connection, a failed export is simply run again
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA journal_mode = OFF; PRAGMA synchronous = OFF"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create %s: %v", path, err)
+	}
+
+	return &sqliteWriter{rowColumns: rowColumns{columns: columns}, db: db}, nil
+}
+
+// write inserts an entry, creating the table for the first entry. It returns 0 bytes, as the size of the
+// database is only known when it is closed.
+func (s *sqliteWriter) write(entry LogEntry) (int, error) {
+	if s.insert == nil {
+		if err := s.createTable(entry); err != nil {
+			return 0, err
+		}
+	}
+	s.track(entry)
+	values := make([]interface{}, len(s.columns))
+	for i, column := range s.columns {
+		value, err := typedValue(entry.Data[column], s.types[i])
+		if err != nil {
+			return 0, fmt.Errorf("failed to convert %s: %v", column, err)
+		}
+		// Bound as is, a time would be stored in the format of Time.String, which doesn't sort or parse as a date
+		if t, ok := value.(time.Time); ok {
+			value = t.UTC().Format(time.RFC3339Nano)
+		}
+		values[i] = value
+	}
+	if _, err := s.insert.Exec(values...); err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// createTable creates the table with the columns of the first entry and starts the transaction of the inserts
+func (s *sqliteWriter) createTable(entry LogEntry) error {
+	s.choose(entry)
+	definitions := make([]string, len(s.columns))
+	placeholders := make([]string, len(s.columns))
+	for i, column := range s.columns {
+		s.types = append(s.types, columnType(column, entry.Data[column]))
+		definitions[i] = sqliteQuote(column) + " " + sqliteType(s.types[i])
+		placeholders[i] = "?"
+	}
+	statements := []string{fmt.Sprintf("CREATE TABLE %s (%s)", sqliteTable, strings.Join(definitions, ", "))}
+	for _, column := range sqliteIndexed {
+		if s.isColumn[column] {
+			statements = append(statements, fmt.Sprintf("CREATE INDEX %s ON %s (%s)", sqliteQuote(sqliteTable+"_"+column), sqliteTable, sqliteQuote(column)))
+		}
+	}
+	for _, statement := range statements {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create the table: %v", err)
+		}
+	}
+	var err error
+	if s.tx, err = s.db.Begin(); err != nil {
+		return err
+	}
+	quoted := make([]string, len(s.columns))
+	for i, column := range s.columns {
+		quoted[i] = sqliteQuote(column)
+	}
+	s.insert, err = s.tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", sqliteTable, strings.Join(quoted, ", "), strings.Join(placeholders, ", ")))
+
+	return err
+}
+
+// close commits the inserted rows and closes the database
+func (s *sqliteWriter) close() error {
+	var err error
+	if s.tx != nil {
+		err = s.tx.Commit()
+	}
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// sqliteType returns the SQLite type of a column type, SQLite has no type for timestamps
+func sqliteType(columnType string) string {
+	if columnType == columnTimestamp {
+		return columnText
+	}
+
+	return columnType
+}
+
+// sqliteQuote quotes an identifier, as fields such as client:port are not valid unquoted
+func sqliteQuote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportSQLite(t *testing.T) {
+	dir := t.TempDir()
+	lines := []string{testRecordLine(0), testRecordLine(1)}
+	// A request that didn't reach a target has no target status code
+	lines = append(lines, strings.Replace(testRecordLine(2), " 0.004 0.024 0.003 203 203 ", " 0.004 -1 -1 502 - ", 1))
+	logFile := filepath.Join(dir, "a.log")
+	require.NoError(t, os.WriteFile(logFile, []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+	s3Objects, err := listLocalObjects(logFile)
+	require.NoError(t, err)
+	fields, err := NewFields("time,elb,elb_status_code,target_status_code,target_processing_time,sent_bytes,trace_id")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "out.db")
+	// An existing file is replaced
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o644))
+
+	exporter := &Exporter{Source: NewSources(nil), Fields: fields, Config: Config{TraceFields: true}, Format: exportSQLite}
+	entries, err := exporter.ExportSQLite(s3Objects, path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, entries)
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	var tableSQL string
+	require.NoError(t, db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'entries'`).Scan(&tableSQL))
+	// Log fields in the order of the log format, followed by the fields of the transformers
+	assert.Equal(t, `CREATE TABLE entries ("time" TEXT, "elb" TEXT, "target_processing_time" REAL, "elb_status_code" INTEGER, "target_status_code" INTEGER, "sent_bytes" INTEGER, "trace_id" TEXT, "trace_root" TEXT)`, tableSQL)
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'index' ORDER BY name`)
+	require.NoError(t, err)
+	var indexes []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		indexes = append(indexes, name)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"entries_elb", "entries_elb_status_code", "entries_time"}, indexes)
+
+	var count int
+	var bytes int64
+	require.NoError(t, db.QueryRow(`SELECT count(*), sum(sent_bytes) FROM entries WHERE elb_status_code >= 200 AND elb_status_code < 300`).Scan(&count, &bytes))
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(2*10783), bytes)
+	var targetStatus sql.NullInt64
+	var targetTime float64
+	require.NoError(t, db.QueryRow(`SELECT target_status_code, target_processing_time FROM entries WHERE elb_status_code = 502`).Scan(&targetStatus, &targetTime))
+	assert.False(t, targetStatus.Valid)
+	assert.Equal(t, -1.0, targetTime)
+	// Timestamps are RFC 3339, so they sort and compare as text and SQLite's date functions read them
+	var first string
+	var unix int64
+	require.NoError(t, db.QueryRow(`SELECT min(time), unixepoch(min(time)) FROM entries WHERE time >= '2024-03-21T16:00:00Z'`).Scan(&first, &unix))
+	assert.Equal(t, "2024-03-21T16:10:26.071854Z", first)
+	assert.Equal(t, int64(1711037426), unix)
+}
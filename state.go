@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// State holds everything that is initialized once at cold start and shared by all invocations of a warm Lambda
+// function, as well as by all objects processed concurrently. All fields are safe for concurrent use. The caches
+// of created log groups and streams and the stream writers are owned by the CloudWatchLogProcessor created from
+// the state, which is shared the same way.
+type State struct {
+	Config       Config
+	Session      *session.Session // Single session for all clients, so credentials are resolved once
+	S3Client     S3Api
+	CWClient     CloudWatchLogsAPI
+	LambdaClient LambdaApi // Only set when running in Lambda
+	FunctionName string
+	Fields       Fields
+	RoleClients  *RoleClients
+	Limiter      *RateLimiter // nil if unlimited
+	Checkpoints  CheckpointStore
+	Progress     ProgressStore   // nil if progress tracking is disabled
+	Spool        Spool           // nil if spooling is disabled
+	DynamoDB     DynamoDBApi     // Only set when PROGRESS_TABLE or LEASE_TABLE is configured
+	ELBTags      *ELBTagCache    // nil if ELB_TAGS is not configured
+	Targets      *TargetResolver // nil if TARGET_ENRICHMENT is disabled
+	Watermarks   *Watermarks     // nil if INGESTION_LAG_METRIC is disabled
+}
+
+// NewState initializes the state from the config, functionName is the name of the Lambda function if running in Lambda
+func NewState(config Config, functionName string) (*State, error) {
+	start := time.Now()
+	fields, err := NewFields(config.Fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FIELDS: %v", err)
+	}
+	sess := newSession(config)
+	s3Client := s3.New(sess)
+	state := &State{
+		Config:       config,
+		Session:      sess,
+		S3Client:     s3Client,
+		CWClient:     cloudwatchlogs.New(sess),
+		FunctionName: functionName,
+		Fields:       fields,
+		RoleClients:  newRoleClients(sess),
+		Checkpoints:  &MemoryCheckpointStore{},
+	}
+	if functionName != "" {
+		state.LambdaClient = lambda.New(sess)
+	}
+	if config.ProgressTable != "" || config.LeaseTable != "" {
+		state.DynamoDB = dynamodb.New(sess)
+	}
+	switch {
+	case config.ProgressTable != "":
+		state.Progress = &DynamoDBProgressStore{client: state.DynamoDB, table: config.ProgressTable}
+	case config.ProgressTracking:
+		state.Progress = &MemoryProgressStore{}
+	}
+	if config.Spool != "" {
+		if state.Spool, err = NewSpool(config.Spool, s3Client); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.ELBTags) > 0 {
+		state.ELBTags = newELBTagCache(sess, config.ELBTags)
+	}
+	if config.TargetEnrichment {
+		state.Targets = newTargetResolver(sess)
+	}
+	if config.IngestionLagMetric {
+		state.Watermarks = &Watermarks{}
+	}
+	if config.MaxEventsPerSecond > 0 {
+		state.Limiter = NewRateLimiter(config.MaxEventsPerSecond)
+	}
+	applyMemoryLimit(config.MemoryLimit, config.Performance.EntryBuffer)
+	logf(verbosityVerbose, "initialized in %s", time.Since(start).Round(time.Millisecond))
+
+	return state, nil
+}
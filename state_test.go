@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewState(t *testing.T) {
+	t.Run("Lambda", func(t *testing.T) {
+		state, err := NewState(Config{Fields: "request", MaxEventsPerSecond: 100}, "my-function")
+		require.NoError(t, err)
+
+		assert.NotNil(t, state.LambdaClient)
+		assert.Equal(t, "my-function", state.FunctionName)
+		assert.NotNil(t, state.Limiter)
+		assert.True(t, state.Fields.IncludeField(getFieldIndex("request")))
+	})
+
+	t.Run("CLI", func(t *testing.T) {
+		state, err := NewState(Config{}, "")
+		require.NoError(t, err)
+
+		assert.Nil(t, state.LambdaClient)
+		assert.Nil(t, state.Limiter)
+	})
+
+	t.Run("Invalid fields", func(t *testing.T) {
+		_, err := NewState(Config{Fields: "no_such_field"}, "")
+		assert.ErrorContains(t, err, "invalid FIELDS")
+	})
+}
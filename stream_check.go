@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// What to do when the configured log stream has another writer, see checkStreamWriter
+const (
+	streamWriterIgnore = ""
+	streamWriterWarn   = "warn"
+	streamWriterSuffix = "suffix"
+)
+
+// defaultStreamWriterWindow is long because CloudWatch updates the last ingestion time of a stream with a delay
+// that is typically less than an hour
+const defaultStreamWriterWindow = time.Hour
+
+// maxStreamSuffix limits the streams tried when looking for a stream without another writer
+const maxStreamSuffix = 100
+
+// streamWriterMarkerField is the field of the marker events that identify the writer of a stream, see
+// streamWriterMarker
+const streamWriterMarkerField = "elb_logs_to_cloudwatch_writer"
+
+// logEventsFilter is implemented by the CloudWatch Logs client of the SDK
+type logEventsFilter interface {
+	FilterLogEvents(*cloudwatchlogs.FilterLogEventsInput) (*cloudwatchlogs.FilterLogEventsOutput, error)
+}
+
+// streamWriterID identifies the deployment writing to a stream: the Lambda function, whose concurrent invocations
+// share the stream by design, or the host
+func streamWriterID(functionName string) string {
+	if functionName != "" {
+		return "lambda:" + functionName
+	}
+	hostname, _ := os.Hostname()
+
+	return "host:" + hostname
+}
+
+// streamWriterMarker returns a marker event with the identity of the writer. Writers write it to their streams
+// every half window, so the events of the window can be told apart from those of other writers.
+func streamWriterMarker(writer string, now time.Time) *cloudwatchlogs.InputLogEvent {
+	message, _ := json.Marshal(map[string]string{streamWriterMarkerField: writer})
+
+	return &cloudwatchlogs.InputLogEvent{Message: aws.String(string(message)), Timestamp: aws.Int64(now.UnixMilli())}
+}
+
+// ParseStreamWriterCheck validates the behavior when the log stream has another writer
+func ParseStreamWriterCheck(value string) (string, error) {
+	switch value {
+	case streamWriterIgnore, streamWriterWarn, streamWriterSuffix:
+		return value, nil
+	}
+
+	return "", fmt.Errorf("invalid stream writer check '%s', expected warn or suffix", value)
+}
+
+// checkStreamWriter looks for another writer of the log stream before anything is written to it, e.g. a second
+// deployment configured with the same LOG_STREAM_NAME, whose entries would interleave with ours. A stream that
+// received events less than window ago is taken to have another writer, unless all marker events of the last two
+// windows are of this writer, such as those of its earlier runs. With warn this is logged, with suffix the first
+// stream named <stream>-2, <stream>-3, ... without another writer is returned instead.
+func checkStreamWriter(client CloudWatchLogsAPI, destination LogConfig, check string, window time.Duration, writer string, now time.Time) (LogConfig, error) {
+	if check == streamWriterIgnore {
+		return destination, nil
+	}
+	streams := make(map[string]*cloudwatchlogs.LogStream)
+	input := &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(destination.LogGroupName),
+		LogStreamNamePrefix: aws.String(destination.LogStreamName),
+	}
+	for {
+		resp, err := client.DescribeLogStreams(input)
+		if err != nil {
+			return destination, permissionError(err, "logs:DescribeLogStreams", "log group "+destination.LogGroupName)
+		}
+		for _, stream := range resp.LogStreams {
+			streams[aws.StringValue(stream.LogStreamName)] = stream
+		}
+		// Only the configured stream is needed to warn, all streams with suffixes to pick one
+		if resp.NextToken == nil || check == streamWriterWarn {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+	active := func(name string) (time.Duration, bool, error) {
+		stream, ok := streams[name]
+		if !ok || stream.LastIngestionTime == nil {
+			return 0, false, nil
+		}
+		age := now.Sub(time.UnixMilli(*stream.LastIngestionTime))
+		if age >= window {
+			return age, false, nil
+		}
+		own, err := ownStream(client, destination.LogGroupName, name, writer, now.Add(-2*window))
+
+		return age, !own, err
+	}
+	age, ok, err := active(destination.LogStreamName)
+	if err != nil || !ok {
+		return destination, err
+	}
+	if check == streamWriterWarn {
+		logf(verbosityNormal, "WARNING: log stream %s in log group %s received events %s ago from another writer, its entries will interleave with ours",
+			destination.LogStreamName, destination.LogGroupName, age.Round(time.Second))
+		return destination, nil
+	}
+	for n := 2; n <= maxStreamSuffix; n++ {
+		name := fmt.Sprintf("%s-%d", destination.LogStreamName, n)
+		_, ok, err := active(name)
+		if err != nil {
+			return destination, err
+		}
+		if !ok {
+			logf(verbosityNormal, "log stream %s in log group %s received events %s ago from another writer, writing to %s instead",
+				destination.LogStreamName, destination.LogGroupName, age.Round(time.Second), name)
+			destination.LogStreamName = name
+			return destination, nil
+		}
+	}
+
+	return destination, fmt.Errorf("log streams %s-2 to %s-%d all have other writers", destination.LogStreamName, destination.LogStreamName, maxStreamSuffix)
+}
+
+// ownStream reports whether the stream has marker events since a time and all are of the writer. Clients that
+// can't filter events have no markers.
+func ownStream(client CloudWatchLogsAPI, logGroupName, logStreamName, writer string, since time.Time) (bool, error) {
+	filter, ok := client.(logEventsFilter)
+	if !ok || writer == "" {
+		return false, nil
+	}
+	input := &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName:   aws.String(logGroupName),
+		LogStreamNames: []*string{aws.String(logStreamName)},
+		FilterPattern:  aws.String(fmt.Sprintf("{ $.%s = * }", streamWriterMarkerField)),
+		StartTime:      aws.Int64(since.UnixMilli()),
+	}
+	own := false
+	for {
+		resp, err := filter.FilterLogEvents(input)
+		if err != nil {
+			return false, permissionError(err, "logs:FilterLogEvents", "log group "+logGroupName)
+		}
+		for _, event := range resp.Events {
+			var marker map[string]string
+			if json.Unmarshal([]byte(aws.StringValue(event.Message)), &marker) != nil {
+				continue
+			}
+			if marker[streamWriterMarkerField] != writer {
+				return false, nil
+			}
+			own = true
+		}
+		if resp.NextToken == nil {
+			return own, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
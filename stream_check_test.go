@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStreamWriter(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 0, 0, 0, time.UTC)
+	destination := LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}
+	stream := func(name string, ago time.Duration) *cloudwatchlogs.LogStream {
+		return &cloudwatchlogs.LogStream{LogStreamName: aws.String(name), LastIngestionTime: aws.Int64(now.Add(-ago).UnixMilli())}
+	}
+	mockClient := func(streams ...*cloudwatchlogs.LogStream) *MockCloudWatchLogsClient {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{LogStreams: streams}, nil)
+		return client
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		client := new(MockCloudWatchLogsClient)
+		result, err := checkStreamWriter(client, destination, streamWriterIgnore, time.Hour, "", now)
+		require.NoError(t, err)
+		assert.Equal(t, destination, result)
+		client.AssertNotCalled(t, "DescribeLogStreams", mock.Anything)
+	})
+
+	t.Run("Warn", func(t *testing.T) {
+		result, err := checkStreamWriter(mockClient(stream("test-log-stream", time.Minute)), destination, streamWriterWarn, time.Hour, "", now)
+		require.NoError(t, err)
+		assert.Equal(t, destination, result)
+	})
+
+	t.Run("Suffix", func(t *testing.T) {
+		client := mockClient(stream("test-log-stream", time.Minute), stream("test-log-stream-2", 10*time.Minute), stream("test-log-stream-3", 2*time.Hour))
+		result, err := checkStreamWriter(client, destination, streamWriterSuffix, time.Hour, "", now)
+		require.NoError(t, err)
+		assert.Equal(t, "test-log-stream-3", result.LogStreamName)
+	})
+
+	t.Run("No other writer", func(t *testing.T) {
+		result, err := checkStreamWriter(mockClient(stream("test-log-stream", 2*time.Hour)), destination, streamWriterSuffix, time.Hour, "", now)
+		require.NoError(t, err)
+		assert.Equal(t, destination, result)
+
+		result, err = checkStreamWriter(mockClient(), destination, streamWriterSuffix, time.Hour, "", now)
+		require.NoError(t, err)
+		assert.Equal(t, destination, result)
+	})
+}
+
+func TestCheckStreamWriterMarkers(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 0, 0, 0, time.UTC)
+	destination := LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}
+	mockClient := func(writers ...string) *MockCloudWatchLogsClient {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeLogStreams", mock.Anything).Return(&cloudwatchlogs.DescribeLogStreamsOutput{LogStreams: []*cloudwatchlogs.LogStream{
+			{LogStreamName: aws.String("test-log-stream"), LastIngestionTime: aws.Int64(now.Add(-time.Minute).UnixMilli())},
+		}}, nil)
+		output := &cloudwatchlogs.FilterLogEventsOutput{}
+		for _, writer := range writers {
+			marker := streamWriterMarker(writer, now.Add(-10*time.Minute))
+			output.Events = append(output.Events, &cloudwatchlogs.FilteredLogEvent{Message: marker.Message, Timestamp: marker.Timestamp})
+		}
+		client.On("FilterLogEvents", &cloudwatchlogs.FilterLogEventsInput{
+			LogGroupName:   aws.String("test-log-group"),
+			LogStreamNames: []*string{aws.String("test-log-stream")},
+			FilterPattern:  aws.String("{ $.elb_logs_to_cloudwatch_writer = * }"),
+			StartTime:      aws.Int64(now.Add(-2 * time.Hour).UnixMilli()),
+		}).Return(output, nil)
+		return client
+	}
+
+	// The stream was written by an earlier run of this writer
+	result, err := checkStreamWriter(mockClient("lambda:shipper", "lambda:shipper"), destination, streamWriterSuffix, time.Hour, "lambda:shipper", now)
+	require.NoError(t, err)
+	assert.Equal(t, destination, result)
+
+	// Another writer marked the stream, or the writer is unknown
+	for _, client := range []*MockCloudWatchLogsClient{mockClient("lambda:shipper", "host:laptop"), mockClient()} {
+		result, err = checkStreamWriter(client, destination, streamWriterSuffix, time.Hour, "lambda:shipper", now)
+		require.NoError(t, err)
+		assert.Equal(t, "test-log-stream-2", result.LogStreamName)
+	}
+}
+
+func TestStreamWriterMarker(t *testing.T) {
+	marker := streamWriterMarker("lambda:shipper", time.UnixMilli(1711036800000))
+	assert.Equal(t, `{"elb_logs_to_cloudwatch_writer":"lambda:shipper"}`, aws.StringValue(marker.Message))
+	assert.Equal(t, int64(1711036800000), aws.Int64Value(marker.Timestamp))
+	assert.Equal(t, "lambda:shipper", streamWriterID("shipper"))
+}
+
+func TestParseStreamWriterCheck(t *testing.T) {
+	for _, value := range []string{"", "warn", "suffix"} {
+		check, err := ParseStreamWriterCheck(value)
+		require.NoError(t, err)
+		assert.Equal(t, value, check)
+	}
+	_, err := ParseStreamWriterCheck("rename")
+	assert.EqualError(t, err, "invalid stream writer check 'rename', expected warn or suffix")
+}
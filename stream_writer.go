@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// defaultStreamWriterIdle is how long the writer of a destination waits for a request before it stops, so a long
+// running process doesn't keep a goroutine for every stream it ever wrote to, such as a stream per day
+const defaultStreamWriterIdle = 5 * time.Minute
+
+// StreamWriters serializes PutLogEvents requests per log stream: every destination gets a single writer
+// goroutine that sends the batches of all workers one at a time, so concurrent objects targeting the same
+// stream don't interleave requests. Writers stop when idle and are started again when needed. With a writer
+// identity, a writer also sends a marker event before its first batch and then every markEvery, see
+// checkStreamWriter. The zero value is ready to use.
+type StreamWriters struct {
+	mu        sync.Mutex
+	writers   map[LogConfig]*streamWriter
+	idle      time.Duration // defaultStreamWriterIdle when zero
+	identity  string        // Identity of this deployment in marker events, no markers when empty
+	markEvery time.Duration
+}
+
+type streamWriter struct {
+	requests chan writeRequest
+	pending  int // Requests that were handed the writer and aren't sent yet, guarded by StreamWriters.mu
+}
+
+type writeRequest struct {
+	events []*cloudwatchlogs.InputLogEvent
+	done   chan error
+}
+
+// Send queues the events for the writer of the destination and waits until they have been sent
+func (w *StreamWriters) Send(client CloudWatchLogsAPI, destination LogConfig, events []*cloudwatchlogs.InputLogEvent) error {
+	request := writeRequest{events: events, done: make(chan error, 1)}
+	w.writer(client, destination).requests <- request
+
+	return <-request.done
+}
+
+// writer returns the writer for a destination with a pending request, starting the writer if needed. A writer
+// with pending requests doesn't stop.
+func (w *StreamWriters) writer(client CloudWatchLogsAPI, destination LogConfig) *streamWriter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if writer, ok := w.writers[destination]; ok {
+		writer.pending++
+		return writer
+	}
+	if w.writers == nil {
+		w.writers = make(map[LogConfig]*streamWriter)
+	}
+	writer := &streamWriter{requests: make(chan writeRequest), pending: 1}
+	w.writers[destination] = writer
+	go w.run(client, destination, writer)
+
+	return writer
+}
+
+// run sends the requests of a writer until it was idle for the idle duration without pending requests
+func (w *StreamWriters) run(client CloudWatchLogsAPI, destination LogConfig, writer *streamWriter) {
+	idle := w.idle
+	if idle == 0 {
+		idle = defaultStreamWriterIdle
+	}
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	var marked time.Time
+	for {
+		select {
+		case request := <-writer.requests:
+			if w.identity != "" && time.Since(marked) >= w.markEvery {
+				// A failed marker is sent again with the next batch
+				now := time.Now()
+				if err := SendEventsToCloudWatch(client, destination, []*cloudwatchlogs.InputLogEvent{streamWriterMarker(w.identity, now)}); err == nil {
+					marked = now
+				}
+			}
+			request.done <- SendEventsToCloudWatch(client, destination, request.events)
+			w.mu.Lock()
+			writer.pending--
+			w.mu.Unlock()
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+			w.mu.Lock()
+			if writer.pending == 0 {
+				delete(w.writers, destination)
+				w.mu.Unlock()
+				return
+			}
+			w.mu.Unlock()
+		}
+		timer.Reset(idle)
+	}
+}
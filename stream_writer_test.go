@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamWriters(t *testing.T) {
+	t.Run("Requests to a stream are serialized", func(t *testing.T) {
+		var inFlight, maxInFlight int32
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil).Run(func(args mock.Arguments) {
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				m := atomic.LoadInt32(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+		})
+
+		var writers StreamWriters
+		destination := LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				events := []*cloudwatchlogs.InputLogEvent{{Message: aws.String(fmt.Sprint(i)), Timestamp: aws.Int64(int64(i))}}
+				require.NoError(t, writers.Send(mockCW, destination, events))
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), maxInFlight)
+		mockCW.AssertNumberOfCalls(t, "PutLogEvents", 10)
+	})
+
+	t.Run("Errors are returned to the sender", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, fmt.Errorf("throttled"))
+
+		var writers StreamWriters
+		destination := LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}
+		err := writers.Send(mockCW, destination, []*cloudwatchlogs.InputLogEvent{{Message: aws.String("message"), Timestamp: aws.Int64(1)}})
+		require.Error(t, err)
+		assert.Equal(t, "throttled", err.Error())
+	})
+
+	t.Run("Streams are marked with the identity of the writer", func(t *testing.T) {
+		var messages []string
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil).Run(func(args mock.Arguments) {
+			for _, event := range args.Get(0).(*cloudwatchlogs.PutLogEventsInput).LogEvents {
+				messages = append(messages, aws.StringValue(event.Message))
+			}
+		})
+
+		writers := StreamWriters{identity: "lambda:shipper", markEvery: time.Hour}
+		destination := LogConfig{LogGroupName: "test-log-group", LogStreamName: "test-log-stream"}
+		for _, message := range []string{"a", "b"} {
+			require.NoError(t, writers.Send(mockCW, destination, []*cloudwatchlogs.InputLogEvent{{Message: aws.String(message), Timestamp: aws.Int64(1)}}))
+		}
+		assert.Equal(t, []string{`{"elb_logs_to_cloudwatch_writer":"lambda:shipper"}`, "a", "b"}, messages)
+	})
+
+	t.Run("Idle writers stop", func(t *testing.T) {
+		mockCW := new(MockCloudWatchLogsClient)
+		mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil)
+
+		writers := StreamWriters{idle: 10 * time.Millisecond}
+		running := func() int {
+			writers.mu.Lock()
+			defer writers.mu.Unlock()
+			return len(writers.writers)
+		}
+		events := []*cloudwatchlogs.InputLogEvent{{Message: aws.String("message"), Timestamp: aws.Int64(1)}}
+		for _, stream := range []string{"2024-03-21", "2024-03-22"} {
+			require.NoError(t, writers.Send(mockCW, LogConfig{LogGroupName: "test-log-group", LogStreamName: stream}, events))
+		}
+		assert.Eventually(t, func() bool { return running() == 0 }, time.Second, time.Millisecond)
+
+		// A writer is started again when needed
+		require.NoError(t, writers.Send(mockCW, LogConfig{LogGroupName: "test-log-group", LogStreamName: "2024-03-21"}, events))
+		mockCW.AssertNumberOfCalls(t, "PutLogEvents", 3)
+	})
+}
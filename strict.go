@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxLoggedInvalidRecords limits the invalid records logged per object, the others are only counted
+const maxLoggedInvalidRecords = 3
+
+// errUnencodable is wrapped by the error of an entry that can't be encoded, which fails a run when StrictSending
+var errUnencodable = errors.New("unencodable record")
+
+// InvalidRecords handles the records of an object of which no entry can be created. When strict, the first invalid
+// record fails the object, for pipelines that must not lose a record. Otherwise invalid records are skipped and
+// counted as invalid_records in the summary of the object. Entries that can't be encoded are counted as
+// unencodable_dropped, and fail the object when StrictSending. Invalid and Unencodable may run concurrently.
+type InvalidRecords struct {
+	Strict        bool
+	StrictSending bool
+	skipped       int
+	unencodable   int
+}
+
+func (r *InvalidRecords) Invalid(index int, record []string, err error) error {
+	if r.Strict {
+		return fmt.Errorf("invalid record %d: %v", index, err)
+	}
+	if r.skipped++; r.skipped <= maxLoggedInvalidRecords {
+		logf(verbosityNormal, "skipping invalid record %d: %v", index, err)
+	}
+
+	return nil
+}
+
+func (r *InvalidRecords) Unencodable(index int, err error) error {
+	if r.Strict || r.StrictSending {
+		return fmt.Errorf("%w %d: %v", errUnencodable, index, err)
+	}
+	if r.unencodable++; r.unencodable <= maxLoggedInvalidRecords {
+		logf(verbosityNormal, "skipping unencodable record %d: %v", index, err)
+	}
+
+	return nil
+}
+
+// Transform does nothing, invalid records never become entries
+func (r *InvalidRecords) Transform(record []string, entry *LogEntry) {}
+
+func (r *InvalidRecords) Summarize(summary map[string]int) {
+	if r.skipped > 0 {
+		summary["invalid_records"] = r.skipped
+	}
+	if r.unencodable > 0 {
+		summary["unencodable_dropped"] += r.unencodable
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// defaultSubscriptionFilterName is the name of the subscription filter if SUBSCRIPTION_FILTER_NAME is not set
+const defaultSubscriptionFilterName = "elb-logs-to-cloudwatch"
+
+// SubscriptionFilter forwards the entries of the log groups written by this tool to a Lambda function, Firehose
+// delivery stream or Kinesis stream, so a pipeline from S3 through CloudWatch to a downstream processor is set up
+// in one step
+type SubscriptionFilter struct {
+	Name           string
+	DestinationARN string // Empty disables the subscription filter
+	FilterPattern  string // Empty forwards every entry
+	RoleARN        string // Role that lets CloudWatch Logs write to a Firehose or Kinesis destination
+}
+
+// Enabled reports whether a subscription filter is configured
+func (f SubscriptionFilter) Enabled() bool {
+	return f.DestinationARN != ""
+}
+
+// Validate checks that the destination is a Lambda function, Firehose delivery stream or Kinesis stream, and that
+// a role is given for destinations that need one
+func (f SubscriptionFilter) Validate() error {
+	service := ""
+	if parts := strings.SplitN(f.DestinationARN, ":", 4); len(parts) == 4 && parts[0] == "arn" {
+		service = parts[2]
+	}
+	switch service {
+	case "lambda":
+	case "firehose", "kinesis":
+		if f.RoleARN == "" {
+			return fmt.Errorf("a role is required to subscribe to %s", f.DestinationARN)
+		}
+	default:
+		return fmt.Errorf("invalid subscription destination '%s', expected the ARN of a Lambda function, Firehose delivery stream or Kinesis stream", f.DestinationARN)
+	}
+
+	return nil
+}
+
+// ensureSubscriptionFilter creates or updates the subscription filter of a log group, unless it exists with the
+// same destination and pattern already. A Lambda destination must allow logs.amazonaws.com to invoke it.
+func ensureSubscriptionFilter(client CloudWatchLogsAPI, logGroupName string, filter SubscriptionFilter) error {
+	resp, err := client.DescribeSubscriptionFilters(&cloudwatchlogs.DescribeSubscriptionFiltersInput{
+		LogGroupName:     aws.String(logGroupName),
+		FilterNamePrefix: aws.String(filter.Name),
+	})
+	if err != nil {
+		return permissionError(err, "logs:DescribeSubscriptionFilters", "log group "+logGroupName)
+	}
+	for _, existing := range resp.SubscriptionFilters {
+		if aws.StringValue(existing.FilterName) == filter.Name &&
+			aws.StringValue(existing.DestinationArn) == filter.DestinationARN &&
+			aws.StringValue(existing.FilterPattern) == filter.FilterPattern &&
+			aws.StringValue(existing.RoleArn) == filter.RoleARN {
+			return nil
+		}
+	}
+	logf(verbosityVerbose, "creating subscription filter %s on log group %s to %s", filter.Name, logGroupName, filter.DestinationARN)
+	input := &cloudwatchlogs.PutSubscriptionFilterInput{
+		LogGroupName:   aws.String(logGroupName),
+		FilterName:     aws.String(filter.Name),
+		DestinationArn: aws.String(filter.DestinationARN),
+		FilterPattern:  aws.String(filter.FilterPattern),
+	}
+	if filter.RoleARN != "" {
+		input.RoleArn = aws.String(filter.RoleARN)
+	}
+	if _, err = client.PutSubscriptionFilter(input); err != nil {
+		return fmt.Errorf("failed to create subscription filter on log group %s: %w", logGroupName,
+			permissionError(err, "logs:PutSubscriptionFilter", "log group "+logGroupName))
+	}
+
+	return nil
+}
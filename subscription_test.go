@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSubscriptionFilterValidate(t *testing.T) {
+	assert.NoError(t, SubscriptionFilter{DestinationARN: "arn:aws:lambda:eu-west-1:123456789012:function:downstream"}.Validate())
+	assert.NoError(t, SubscriptionFilter{
+		DestinationARN: "arn:aws:firehose:eu-west-1:123456789012:deliverystream/downstream",
+		RoleARN:        "arn:aws:iam::123456789012:role/cwl-to-firehose",
+	}.Validate())
+	assert.EqualError(t, SubscriptionFilter{DestinationARN: "arn:aws:kinesis:eu-west-1:123456789012:stream/downstream"}.Validate(),
+		"a role is required to subscribe to arn:aws:kinesis:eu-west-1:123456789012:stream/downstream")
+	assert.ErrorContains(t, SubscriptionFilter{DestinationARN: "arn:aws:sqs:eu-west-1:123456789012:queue"}.Validate(), "invalid subscription destination")
+	assert.ErrorContains(t, SubscriptionFilter{DestinationARN: "downstream"}.Validate(), "invalid subscription destination")
+}
+
+func TestEnsureSubscriptionFilter(t *testing.T) {
+	filter := SubscriptionFilter{
+		Name:           defaultSubscriptionFilterName,
+		DestinationARN: "arn:aws:lambda:eu-west-1:123456789012:function:downstream",
+		FilterPattern:  `{ $.elb_status_code = 5* }`,
+	}
+	existing := func(pattern string) *cloudwatchlogs.DescribeSubscriptionFiltersOutput {
+		return &cloudwatchlogs.DescribeSubscriptionFiltersOutput{SubscriptionFilters: []*cloudwatchlogs.SubscriptionFilter{{
+			FilterName:     aws.String(defaultSubscriptionFilterName),
+			DestinationArn: aws.String(filter.DestinationARN),
+			FilterPattern:  aws.String(pattern),
+		}}}
+	}
+
+	t.Run("Up to date", func(t *testing.T) {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeSubscriptionFilters", mock.Anything).Return(existing(filter.FilterPattern), nil)
+		assert.NoError(t, ensureSubscriptionFilter(client, "test-log-group", filter))
+		client.AssertNotCalled(t, "PutSubscriptionFilter", mock.Anything)
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeSubscriptionFilters", mock.Anything).Return(existing(""), nil)
+		client.On("PutSubscriptionFilter", mock.MatchedBy(func(input *cloudwatchlogs.PutSubscriptionFilterInput) bool {
+			return aws.StringValue(input.LogGroupName) == "test-log-group" &&
+				aws.StringValue(input.FilterName) == defaultSubscriptionFilterName &&
+				aws.StringValue(input.DestinationArn) == filter.DestinationARN &&
+				aws.StringValue(input.FilterPattern) == filter.FilterPattern &&
+				input.RoleArn == nil
+		})).Return(&cloudwatchlogs.PutSubscriptionFilterOutput{}, nil)
+		assert.NoError(t, ensureSubscriptionFilter(client, "test-log-group", filter))
+		client.AssertExpectations(t)
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		client := new(MockCloudWatchLogsClient)
+		client.On("DescribeSubscriptionFilters", mock.Anything).Return(&cloudwatchlogs.DescribeSubscriptionFiltersOutput{}, nil)
+		client.On("PutSubscriptionFilter", mock.Anything).Return(&cloudwatchlogs.PutSubscriptionFilterOutput{}, awserr.New("AccessDeniedException", "Access denied", nil))
+		assert.EqualError(t, ensureSubscriptionFilter(client, "test-log-group", filter),
+			"failed to create subscription filter on log group test-log-group: missing logs:PutSubscriptionFilter on log group test-log-group")
+	})
+}
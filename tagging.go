@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestTagger annotates entries with precomputed fields for metric filters and Insights queries,
+// so those don't need to repeat status code and latency arithmetic
+type RequestTagger struct {
+	FastThreshold time.Duration
+	SlowThreshold time.Duration
+}
+
+func (t *RequestTagger) Transform(record []string, entry *LogEntry) {
+	status := recordValue(record, "elb_status_code")
+	entry.Data["is_error"] = strings.HasPrefix(status, "5")
+	entry.Data["is_client_error"] = strings.HasPrefix(status, "4")
+
+	// target_processing_time is -1 when the request was not dispatched to a target or the target didn't respond
+	latency, ok := parseProcessingTime(recordValue(record, "target_processing_time"))
+	if !ok {
+		return
+	}
+	entry.Data["is_slow"] = latency > t.SlowThreshold
+	switch {
+	case latency < t.FastThreshold:
+		entry.Data["latency_bucket"] = "fast"
+	case latency > t.SlowThreshold:
+		entry.Data["latency_bucket"] = "slow"
+	default:
+		entry.Data["latency_bucket"] = "normal"
+	}
+}
+
+// parseProcessingTime parses a processing time in seconds as found in the log, e.g. "0.024"
+func parseProcessingTime(value string) (time.Duration, bool) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds * float64(time.Second)), true
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTagger(t *testing.T) {
+	tagger := &RequestTagger{FastThreshold: 100 * time.Millisecond, SlowThreshold: time.Second}
+
+	newRecord := func(status, targetProcessingTime string) []string {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("elb_status_code")] = status
+		record[getFieldIndex("target_processing_time")] = targetProcessingTime
+		return record
+	}
+
+	t.Run("Fast successful request", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		tagger.Transform(newRecord("200", "0.024"), &entry)
+
+		assert.Equal(t, false, entry.Data["is_error"])
+		assert.Equal(t, false, entry.Data["is_client_error"])
+		assert.Equal(t, false, entry.Data["is_slow"])
+		assert.Equal(t, "fast", entry.Data["latency_bucket"])
+	})
+
+	t.Run("Slow server error", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		tagger.Transform(newRecord("502", "2.5"), &entry)
+
+		assert.Equal(t, true, entry.Data["is_error"])
+		assert.Equal(t, true, entry.Data["is_slow"])
+		assert.Equal(t, "slow", entry.Data["latency_bucket"])
+	})
+
+	t.Run("Client error without target", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		tagger.Transform(newRecord("404", "-1"), &entry)
+
+		assert.Equal(t, true, entry.Data["is_client_error"])
+		assert.NotContains(t, entry.Data, "is_slow")
+		assert.NotContains(t, entry.Data, "latency_bucket")
+	})
+
+	t.Run("Normal latency", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		tagger.Transform(newRecord("200", "0.5"), &entry)
+
+		assert.Equal(t, "normal", entry.Data["latency_bucket"])
+	})
+}
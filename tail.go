@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entryFilterOperators are the operators of a filter expression, two-character operators first so that >= isn't
+// read as >
+var entryFilterOperators = []string{"!=", ">=", "<=", "=", ">", "<", "~"}
+
+// EntryFilter keeps the entries of which a field compares to a value, such as elb_status_code>=500. Values are
+// compared as numbers when both are numbers and as text otherwise, which also orders ISO 8601 times. The ~
+// operator matches a regular expression.
+type EntryFilter struct {
+	Field    string
+	Operator string
+	Value    string
+	pattern  *regexp.Regexp
+}
+
+// ParseEntryFilter parses a filter expression of a field, an operator and a value, such as request~/api/
+func ParseEntryFilter(expression string) (EntryFilter, error) {
+	position, operator := -1, ""
+	for _, op := range entryFilterOperators {
+		// The first operator in the expression, the value may contain operators
+		if i := strings.Index(expression, op); i > 0 && (position < 0 || i < position || (i == position && len(op) > len(operator))) {
+			position, operator = i, op
+		}
+	}
+	if position < 0 {
+		return EntryFilter{}, fmt.Errorf("invalid filter '%s', expected <field><operator><value> with an operator of %s", expression, strings.Join(entryFilterOperators, " "))
+	}
+	filter := EntryFilter{Field: strings.TrimSpace(expression[:position]), Operator: operator, Value: strings.TrimSpace(expression[position+len(operator):])}
+	if operator == "~" {
+		pattern, err := regexp.Compile(filter.Value)
+		if err != nil {
+			return EntryFilter{}, fmt.Errorf("invalid filter '%s': %v", expression, err)
+		}
+		filter.pattern = pattern
+	}
+
+	return filter, nil
+}
+
+// Keep reports whether the entry passes the filter. An entry without the field only passes !=.
+func (f EntryFilter) Keep(entry LogEntry) bool {
+	raw, ok := entry.Data[f.Field]
+	if !ok || raw == nil {
+		return f.Operator == "!="
+	}
+	value, err := tableValue(raw)
+	if err != nil {
+		return false
+	}
+	if f.Operator == "~" {
+		return f.pattern.MatchString(value)
+	}
+	comparison := strings.Compare(value, f.Value)
+	if a, err := strconv.ParseFloat(value, 64); err == nil {
+		if b, err := strconv.ParseFloat(f.Value, 64); err == nil {
+			switch {
+			case a < b:
+				comparison = -1
+			case a > b:
+				comparison = 1
+			default:
+				comparison = 0
+			}
+		}
+	}
+	switch f.Operator {
+	case "=":
+		return comparison == 0
+	case "!=":
+		return comparison != 0
+	case ">":
+		return comparison > 0
+	case ">=":
+		return comparison >= 0
+	case "<":
+		return comparison < 0
+	default:
+		return comparison <= 0
+	}
+}
+
+// entryFilters is the value of a repeatable --filter flag, an entry must pass all filters
+type entryFilters []EntryFilter
+
+func (f *entryFilters) String() string {
+	expressions := make([]string, len(*f))
+	for i, filter := range *f {
+		expressions[i] = filter.Field + filter.Operator + filter.Value
+	}
+
+	return strings.Join(expressions, " ")
+}
+
+func (f *entryFilters) Set(expression string) error {
+	filter, err := ParseEntryFilter(expression)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, filter)
+
+	return nil
+}
+
+// keep reports whether the entry passes all filters
+func (f entryFilters) keep(entry LogEntry) bool {
+	for _, filter := range f {
+		if !filter.Keep(entry) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Output formats of the tail command
+const (
+	tailText  = "text"
+	tailHuman = exportHuman
+)
+
+// ParseTailFormat validates the value of --format
+func ParseTailFormat(value string) (string, error) {
+	switch value {
+	case tailText, tailHuman:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid format '%s', expected %s or %s", value, tailText, tailHuman)
+	}
+}
+
+// tailSink writes the entries of an object that pass the filters as rows, or without rows as a line of
+// field=value pairs with values quoted where needed. Without columns, the fields of a line are those of the entry
+// in the order of the log format.
+type tailSink struct {
+	w       io.Writer
+	rows    rowWriter
+	filters entryFilters
+	columns []string
+	entries int
+	bytes   int64
+}
+
+func (s *tailSink) Send(entries <-chan LogEntry) error {
+	var err error
+	for entry := range entries {
+		if err != nil || !s.filters.keep(entry) {
+			continue
+		}
+		var n int
+		if s.rows != nil {
+			n, err = s.rows.write(entry)
+		} else {
+			n, err = io.WriteString(s.w, tailLine(entry, s.columns))
+		}
+		if err == nil {
+			s.entries++
+			s.bytes += int64(n)
+		}
+	}
+
+	return err
+}
+
+func (s *tailSink) Sent() (int, int64) {
+	return s.entries, s.bytes
+}
+
+// tailLine formats the columns of an entry as field=value pairs, fields the entry doesn't have are left out
+func tailLine(entry LogEntry, columns []string) string {
+	if len(columns) == 0 {
+		columns = entryColumns(entry)
+	}
+	var line strings.Builder
+	for _, column := range columns {
+		raw, ok := entry.Data[column]
+		if !ok {
+			continue
+		}
+		value, err := tableValue(raw)
+		if err != nil {
+			continue
+		}
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		if line.Len() > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(column + "=" + value)
+	}
+	line.WriteByte('\n')
+
+	return line.String()
+}
+
+// Tailer polls an S3 prefix and writes the entries of new objects to the terminal, with the configured fields and
+// transformers, without sending anything. Objects of the latest day that exist when it starts are skipped, and
+// earlier days aren't listed. Like the Watcher, every poll lists the keys of every log directory from the directory
+// of its latest object, see listingCursor.
+type Tailer struct {
+	Handler  *Handler // Lists the objects
+	Source   Source
+	Fields   Fields
+	Config   Config
+	Bucket   string
+	Prefix   string
+	Interval time.Duration
+	Filters  entryFilters
+	Columns  []string // Fields to write, all fields of the entry when empty
+	Format   string   // tailText when empty
+	Color    bool     // Whether the human format is colored
+	Out      io.Writer
+	cursor   listingCursor
+	started  bool // Whether the existing objects were skipped
+	out      *bufio.Writer
+	rows     rowWriter // Shared by all objects, so the human format has a single header
+}
+
+// Poll lists the objects once and writes the entries of the new ones. Objects that fail are logged and not retried,
+// as some of their entries may have been written.
+func (t *Tailer) Poll() error {
+	if !t.started {
+		if err := t.cursor.skipExisting(t.Handler, t.Bucket, t.Prefix); err != nil {
+			return err
+		}
+		t.started = true
+		return nil
+	}
+	listed, err := t.cursor.list(t.Handler, t.Bucket, t.Prefix)
+	if err != nil {
+		return err
+	}
+	if t.out == nil {
+		t.out = bufio.NewWriter(t.Out)
+		if t.Format == tailHuman {
+			t.rows = newHumanWriter(t.out, t.Columns, t.Color, t.Config.SlowRequestThreshold)
+		}
+	}
+	pipeline := &Pipeline{
+		Source: t.Source,
+		Parser: &RecordParser{Fields: t.Fields, Layouts: t.Config.TimestampLayouts},
+		Stages: func(object S3ObjectInfo, metadata ObjectMetadata) ([]Transformer, Sink) {
+			return NewTransformers(t.Config), &tailSink{w: t.out, rows: t.rows, filters: t.Filters, columns: t.Columns}
+		},
+	}
+	for _, s3Object := range listed {
+		t.cursor.markDone(s3Object.Key)
+		result, err := pipeline.Run(s3Object)
+		if err != nil && err != ErrEmptyObject {
+			log.Printf("error reading %s: %v", s3Object, err)
+		}
+		logf(verbosityVerbose, "read %d log entries from %s", result.Entries, s3Object)
+		if err := t.out.Flush(); err != nil {
+			return err
+		}
+	}
+	t.cursor.advance()
+
+	return nil
+}
+
+// Run polls every interval until stop is closed, errors are logged and retried by the next poll
+func (t *Tailer) Run(stop <-chan struct{}) {
+	logf(verbosityNormal, "tailing s3://%s/%s every %s", t.Bucket, t.Prefix, t.Interval)
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+	for {
+		if err := t.Poll(); err != nil {
+			log.Println(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
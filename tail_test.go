@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryFilter(t *testing.T) {
+	entry := LogEntry{Data: map[string]interface{}{
+		"elb_status_code": "503",
+		"request":         "GET https://example.com:443/api/users?a=b HTTP/1.1",
+		"time":            "2024-03-21T16:10:26.071854Z",
+		"duration_ms":     4.5,
+	}}
+	for expression, want := range map[string]bool{
+		"elb_status_code>=500":             true,
+		"elb_status_code<500":              false,
+		"elb_status_code=503":              true,
+		"elb_status_code!=503":             false,
+		"elb_status_code>60":               true, // As numbers, not as text
+		"duration_ms>4":                    true,
+		"request~/api/users\\?a=b":         true,
+		"request~^POST":                    false,
+		"time>=2024-03-21T16:00":           true,
+		"target_status_code=200":           false,
+		"target_status_code!=200":          true,
+		" elb_status_code <= 503 ":         true,
+		"request~a=b":                      true,
+		"time<2024-03-21T16:10:26.071854Z": false,
+	} {
+		filter, err := ParseEntryFilter(expression)
+		require.NoError(t, err, expression)
+		assert.Equal(t, want, filter.Keep(entry), expression)
+	}
+
+	filter, err := ParseEntryFilter("request~a=b")
+	require.NoError(t, err)
+	assert.Equal(t, EntryFilter{Field: "request", Operator: "~", Value: "a=b", pattern: filter.pattern}, filter)
+	_, err = ParseEntryFilter("elb_status_code")
+	assert.EqualError(t, err, "invalid filter 'elb_status_code', expected <field><operator><value> with an operator of != >= <= = > < ~")
+	_, err = ParseEntryFilter("request~(")
+	assert.Error(t, err)
+}
+
+func TestTailLine(t *testing.T) {
+	entry := LogEntry{Data: map[string]interface{}{
+		"time":            "2024-03-21T16:10:26.071854Z",
+		"elb_status_code": "200",
+		"request":         "GET https://example.com:443/ HTTP/1.1",
+		"user_agent":      "",
+		"duration_ms":     4.5,
+	}}
+	assert.Equal(t, `time=2024-03-21T16:10:26.071854Z elb_status_code=200 request="GET https://example.com:443/ HTTP/1.1" user_agent="" duration_ms=4.5`+"\n", tailLine(entry, nil))
+	assert.Equal(t, "elb_status_code=200 time=2024-03-21T16:10:26.071854Z\n", tailLine(entry, []string{"elb_status_code", "missing", "time"}))
+}
+
+func TestTailer(t *testing.T) {
+	s3Client := newListingS3(testUSDirectory+"2024/03/20/a-0", testUSDirectory+"2024/03/21/a-1")
+	expectGet := func(key string, lines ...string) {
+		s3Client.On("GetObject", &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key)}).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(strings.NewReader(strings.Join(lines, "\n") + "\n")),
+		}, nil).Once()
+	}
+	fields, err := NewFields("elb_status_code")
+	require.NoError(t, err)
+	filter, err := ParseEntryFilter("elb_status_code>=500")
+	require.NoError(t, err)
+	var out bytes.Buffer
+	tailer := &Tailer{
+		Handler: &Handler{s3Client: s3Client},
+		Source:  NewSources(s3Client),
+		Fields:  fields,
+		Bucket:  "bucket",
+		Prefix:  "logs/",
+		Filters: entryFilters{filter},
+		Columns: []string{"elb_status_code"},
+		Out:     &out,
+	}
+
+	// Existing objects are skipped
+	require.NoError(t, tailer.Poll())
+	assert.Empty(t, out.String())
+
+	s3Client.add(testUSDirectory+"2024/03/21/a-2", testUSDirectory+"2024/03/22/a-3")
+	expectGet(testUSDirectory+"2024/03/21/a-2", testRecordLine(0), strings.Replace(testRecordLine(1), " 203 203 ", " 502 - ", 1))
+	expectGet(testUSDirectory+"2024/03/22/a-3", strings.Replace(testRecordLine(2), " 203 203 ", " 504 - ", 1))
+	require.NoError(t, tailer.Poll())
+	assert.Equal(t, "elb_status_code=502\nelb_status_code=504\n", out.String())
+
+	// Nothing new
+	require.NoError(t, tailer.Poll())
+	assert.Equal(t, "elb_status_code=502\nelb_status_code=504\n", out.String())
+
+	s3Client.AssertExpectations(t)
+}
+
+func TestParseTailFormat(t *testing.T) {
+	for _, format := range []string{tailText, tailHuman} {
+		_, err := ParseTailFormat(format)
+		assert.NoError(t, err)
+	}
+	_, err := ParseTailFormat("json")
+	assert.EqualError(t, err, "invalid format 'json', expected text or human")
+}
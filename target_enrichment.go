@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// targetLookupTTL is how long the backend of a target IP is cached. IPs of ECS tasks are reused soon after a task
+// stops, so this is shorter than for load balancer tags.
+const targetLookupTTL = 10 * time.Minute
+
+// EC2Api is the part of the EC2 API used to find the network interface of a target IP
+type EC2Api interface {
+	DescribeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error)
+}
+
+// ECSApi is the part of the ECS API used to find the task a network interface belongs to
+type ECSApi interface {
+	ListClusters(input *ecs.ListClustersInput) (*ecs.ListClustersOutput, error)
+	ListTasks(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error)
+	DescribeTasks(input *ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error)
+}
+
+// Backend is the EC2 instance or ECS task behind a target IP, at most one of the fields is set
+type Backend struct {
+	InstanceID string
+	TaskARN    string
+}
+
+type cachedBackend struct {
+	backend Backend
+	expires time.Time
+}
+
+type cachedTasks struct {
+	tasks   map[string]string // Network interface ID to task ARN
+	expires time.Time
+}
+
+// TargetResolver finds the backend of a target IP from its network interface: the instance it is attached to,
+// or for ECS tasks with awsvpc networking, the task it belongs to. Backends are cached per IP, and the network
+// interfaces of all tasks in a region are listed at most once per targetLookupTTL. Lookups are made without
+// holding the lock, concurrent lookups of the same IP or region share a single call.
+type TargetResolver struct {
+	mu           sync.Mutex
+	ec2Clients   map[string]EC2Api
+	ecsClients   map[string]ECSApi
+	newEC2Client func(region string) EC2Api
+	newECSClient func(region string) ECSApi
+	backends     map[string]cachedBackend // By region and IP
+	tasks        map[string]cachedTasks   // By region
+	lookups      flightGroup[Backend]
+	listings     flightGroup[map[string]string]
+	now          func() time.Time
+}
+
+// newTargetResolver returns a resolver that uses the credentials of the session
+func newTargetResolver(sess *session.Session) *TargetResolver {
+	return &TargetResolver{
+		newEC2Client: func(region string) EC2Api { return ec2.New(sess, aws.NewConfig().WithRegion(region)) },
+		newECSClient: func(region string) ECSApi { return ecs.New(sess, aws.NewConfig().WithRegion(region)) },
+	}
+}
+
+// Resolve returns the backend of a target IP in a region. Failures are cached like backends, so targets that
+// can't be resolved, e.g. in another account, aren't looked up again for every entry.
+func (r *TargetResolver) Resolve(region, ip string) (Backend, error) {
+	key := region + "/" + ip
+	r.mu.Lock()
+	cached, ok := r.backends[key]
+	r.mu.Unlock()
+	if ok && r.currentTime().Before(cached.expires) {
+		return cached.backend, nil
+	}
+
+	return r.lookups.do(key, func() (Backend, error) {
+		backend, err := r.lookup(region, ip)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.backends == nil {
+			r.backends = make(map[string]cachedBackend)
+		}
+		r.backends[key] = cachedBackend{backend: backend, expires: r.currentTime().Add(targetLookupTTL)}
+
+		return backend, err
+	})
+}
+
+func (r *TargetResolver) currentTime() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+
+	return time.Now()
+}
+
+func (r *TargetResolver) lookup(region, ip string) (Backend, error) {
+	r.mu.Lock()
+	if r.ec2Clients == nil {
+		r.ec2Clients = make(map[string]EC2Api)
+	}
+	client, ok := r.ec2Clients[region]
+	if !ok {
+		client = r.newEC2Client(region)
+		r.ec2Clients[region] = client
+	}
+	r.mu.Unlock()
+	resp, err := client.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("addresses.private-ip-address"), Values: []*string{aws.String(ip)}}},
+	})
+	if err != nil {
+		return Backend{}, fmt.Errorf("failed to describe the network interface of target %s: %v", ip, err)
+	}
+	if len(resp.NetworkInterfaces) == 0 {
+		return Backend{}, nil
+	}
+	networkInterface := resp.NetworkInterfaces[0]
+	if networkInterface.Attachment != nil && aws.StringValue(networkInterface.Attachment.InstanceId) != "" {
+		return Backend{InstanceID: aws.StringValue(networkInterface.Attachment.InstanceId)}, nil
+	}
+	// Network interfaces of tasks with awsvpc networking are managed by ECS and not attached to an instance
+	if aws.StringValue(networkInterface.RequesterId) == "" {
+		return Backend{}, nil
+	}
+	tasks, err := r.listTasks(region)
+	if err != nil {
+		return Backend{}, err
+	}
+
+	return Backend{TaskARN: tasks[aws.StringValue(networkInterface.NetworkInterfaceId)]}, nil
+}
+
+// listTasks returns the task ARNs of the running tasks in a region by the ID of their network interface
+func (r *TargetResolver) listTasks(region string) (map[string]string, error) {
+	r.mu.Lock()
+	cached, ok := r.tasks[region]
+	r.mu.Unlock()
+	if ok && r.currentTime().Before(cached.expires) {
+		return cached.tasks, nil
+	}
+
+	return r.listings.do(region, func() (map[string]string, error) {
+		tasks, err := r.listRegionTasks(region)
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.tasks == nil {
+			r.tasks = make(map[string]cachedTasks)
+		}
+		r.tasks[region] = cachedTasks{tasks: tasks, expires: r.currentTime().Add(targetLookupTTL)}
+
+		return tasks, nil
+	})
+}
+
+// listRegionTasks lists the task ARNs of the running tasks of all clusters in a region
+func (r *TargetResolver) listRegionTasks(region string) (map[string]string, error) {
+	r.mu.Lock()
+	if r.ecsClients == nil {
+		r.ecsClients = make(map[string]ECSApi)
+	}
+	client, ok := r.ecsClients[region]
+	if !ok {
+		client = r.newECSClient(region)
+		r.ecsClients[region] = client
+	}
+	r.mu.Unlock()
+	tasks := make(map[string]string)
+	var clusterToken *string
+	for {
+		clusters, err := client.ListClusters(&ecs.ListClustersInput{NextToken: clusterToken})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ECS clusters: %v", err)
+		}
+		for _, cluster := range clusters.ClusterArns {
+			if err := listClusterTasks(client, cluster, tasks); err != nil {
+				return nil, err
+			}
+		}
+		if clusterToken = clusters.NextToken; clusterToken == nil {
+			return tasks, nil
+		}
+	}
+}
+
+// listClusterTasks adds the tasks of a cluster to tasks by the ID of their network interface
+func listClusterTasks(client ECSApi, cluster *string, tasks map[string]string) error {
+	var token *string
+	for {
+		// ListTasks returns at most 100 tasks, as many as DescribeTasks accepts
+		list, err := client.ListTasks(&ecs.ListTasksInput{Cluster: cluster, NextToken: token})
+		if err != nil {
+			return fmt.Errorf("failed to list the tasks of ECS cluster %s: %v", aws.StringValue(cluster), err)
+		}
+		if len(list.TaskArns) > 0 {
+			described, err := client.DescribeTasks(&ecs.DescribeTasksInput{Cluster: cluster, Tasks: list.TaskArns})
+			if err != nil {
+				return fmt.Errorf("failed to describe the tasks of ECS cluster %s: %v", aws.StringValue(cluster), err)
+			}
+			for _, task := range described.Tasks {
+				for _, attachment := range task.Attachments {
+					for _, detail := range attachment.Details {
+						if aws.StringValue(detail.Name) == "networkInterfaceId" {
+							tasks[aws.StringValue(detail.Value)] = aws.StringValue(task.TaskArn)
+						}
+					}
+				}
+			}
+		}
+		if token = list.NextToken; token == nil {
+			return nil
+		}
+	}
+}
+
+// TargetEnricher adds the backend of the target of a request as target_instance_id or ecs_task_arn, so failing
+// requests can be traced to the instance or task that handled them. Targets in the region of the object that
+// can't be resolved, such as Lambda functions and IPs outside of AWS, are left untouched. It is created per object,
+// and resolves every distinct target IP of the object once.
+type TargetEnricher struct {
+	Resolver *TargetResolver
+	Region   string
+	resolved map[string]Backend // By IP
+}
+
+func (e *TargetEnricher) Transform(record []string, entry *LogEntry) {
+	ip, _, err := net.SplitHostPort(recordValue(record, "target:port"))
+	if err != nil {
+		return
+	}
+	backend, ok := e.resolved[ip]
+	if !ok {
+		if backend, err = e.Resolver.Resolve(e.Region, ip); err != nil {
+			logf(verbosityNormal, "%v", err)
+		}
+		if e.resolved == nil {
+			e.resolved = make(map[string]Backend)
+		}
+		e.resolved[ip] = backend
+	}
+	if backend.InstanceID != "" {
+		entry.Data["target_instance_id"] = backend.InstanceID
+	}
+	if backend.TaskARN != "" {
+		entry.Data["ecs_task_arn"] = backend.TaskARN
+	}
+}
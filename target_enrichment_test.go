@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockEC2Api struct {
+	mock.Mock
+}
+
+func (m *MockEC2Api) DescribeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ec2.DescribeNetworkInterfacesOutput), args.Error(1)
+}
+
+type MockECSApi struct {
+	mock.Mock
+}
+
+func (m *MockECSApi) ListClusters(input *ecs.ListClustersInput) (*ecs.ListClustersOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ecs.ListClustersOutput), args.Error(1)
+}
+
+func (m *MockECSApi) ListTasks(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ecs.ListTasksOutput), args.Error(1)
+}
+
+func (m *MockECSApi) DescribeTasks(input *ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ecs.DescribeTasksOutput), args.Error(1)
+}
+
+func networkInterfacesFilter(ip string) *ec2.DescribeNetworkInterfacesInput {
+	return &ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("addresses.private-ip-address"), Values: []*string{aws.String(ip)}}},
+	}
+}
+
+func TestTargetEnricher(t *testing.T) {
+	const cluster = "arn:aws:ecs:eu-west-1:123456789012:cluster/main"
+	const task = "arn:aws:ecs:eu-west-1:123456789012:task/main/0123456789abcdef"
+	ec2Client := &MockEC2Api{}
+	ec2Client.On("DescribeNetworkInterfaces", networkInterfacesFilter("10.0.0.1")).Return(&ec2.DescribeNetworkInterfacesOutput{
+		NetworkInterfaces: []*ec2.NetworkInterface{{
+			NetworkInterfaceId: aws.String("eni-1"),
+			Attachment:         &ec2.NetworkInterfaceAttachment{InstanceId: aws.String("i-0123456789abcdef0")},
+		}},
+	}, nil).Once()
+	ec2Client.On("DescribeNetworkInterfaces", networkInterfacesFilter("10.0.0.2")).Return(&ec2.DescribeNetworkInterfacesOutput{
+		NetworkInterfaces: []*ec2.NetworkInterface{{
+			NetworkInterfaceId: aws.String("eni-2"),
+			RequesterId:        aws.String("578734482556"),
+			Attachment:         &ec2.NetworkInterfaceAttachment{},
+		}},
+	}, nil).Once()
+	ec2Client.On("DescribeNetworkInterfaces", networkInterfacesFilter("10.0.0.3")).Return(&ec2.DescribeNetworkInterfacesOutput{}, nil).Once()
+	ec2Client.On("DescribeNetworkInterfaces", networkInterfacesFilter("10.0.0.4")).Return((*ec2.DescribeNetworkInterfacesOutput)(nil), errors.New("UnauthorizedOperation")).Once()
+	ecsClient := &MockECSApi{}
+	ecsClient.On("ListClusters", &ecs.ListClustersInput{}).Return(&ecs.ListClustersOutput{ClusterArns: []*string{aws.String(cluster)}}, nil).Once()
+	ecsClient.On("ListTasks", &ecs.ListTasksInput{Cluster: aws.String(cluster)}).Return(&ecs.ListTasksOutput{TaskArns: []*string{aws.String(task)}}, nil).Once()
+	ecsClient.On("DescribeTasks", &ecs.DescribeTasksInput{Cluster: aws.String(cluster), Tasks: []*string{aws.String(task)}}).Return(&ecs.DescribeTasksOutput{
+		Tasks: []*ecs.Task{{
+			TaskArn: aws.String(task),
+			Attachments: []*ecs.Attachment{{
+				Type:    aws.String("ElasticNetworkInterface"),
+				Details: []*ecs.KeyValuePair{{Name: aws.String("networkInterfaceId"), Value: aws.String("eni-2")}},
+			}},
+		}},
+	}, nil).Once()
+	enricher := &TargetEnricher{Region: "eu-west-1", Resolver: &TargetResolver{
+		newEC2Client: func(region string) EC2Api { return ec2Client },
+		newECSClient: func(region string) ECSApi { return ecsClient },
+	}}
+	transform := func(target string) LogEntry {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("target:port")] = target
+		entry := LogEntry{Data: map[string]interface{}{}}
+		enricher.Transform(record, &entry)
+		return entry
+	}
+
+	// Every target is looked up once
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, map[string]interface{}{"target_instance_id": "i-0123456789abcdef0"}, transform("10.0.0.1:80").Data)
+		assert.Equal(t, map[string]interface{}{"ecs_task_arn": task}, transform("10.0.0.2:8080").Data)
+		assert.Empty(t, transform("10.0.0.3:80").Data)
+		assert.Empty(t, transform("10.0.0.4:80").Data)
+		assert.Empty(t, transform("-").Data)
+	}
+	ec2Client.AssertExpectations(t)
+	ecsClient.AssertExpectations(t)
+}
+
+func TestTargetResolverConcurrency(t *testing.T) {
+	release := make(chan time.Time)
+	ec2Client := &MockEC2Api{}
+	// The lookup of a slow IP doesn't hold up other IPs, and concurrent lookups of it share a single call
+	ec2Client.On("DescribeNetworkInterfaces", networkInterfacesFilter("10.0.0.1")).Return(&ec2.DescribeNetworkInterfacesOutput{}, nil).
+		WaitUntil(release).Once()
+	ec2Client.On("DescribeNetworkInterfaces", networkInterfacesFilter("10.0.0.2")).Return(&ec2.DescribeNetworkInterfacesOutput{
+		NetworkInterfaces: []*ec2.NetworkInterface{{Attachment: &ec2.NetworkInterfaceAttachment{InstanceId: aws.String("i-2")}}},
+	}, nil).Once()
+	resolver := &TargetResolver{newEC2Client: func(region string) EC2Api { return ec2Client }}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			backend, err := resolver.Resolve("eu-west-1", "10.0.0.1")
+			assert.NoError(t, err)
+			assert.Equal(t, Backend{}, backend)
+		}()
+	}
+	backend, err := resolver.Resolve("eu-west-1", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, Backend{InstanceID: "i-2"}, backend)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	ec2Client.AssertExpectations(t)
+}
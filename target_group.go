@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TargetGroup holds the components of a target group ARN,
+// e.g. arn:aws:elasticloadbalancing:eu-west-1:123456789012:targetgroup/my-service/6d0ecf831eec9f09
+type TargetGroup struct {
+	Region    string
+	AccountID string
+	Name      string
+}
+
+// ParseTargetGroupARN splits a target group ARN into its region, account and name
+func ParseTargetGroupARN(arn string) (TargetGroup, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "elasticloadbalancing" {
+		return TargetGroup{}, fmt.Errorf("invalid target group ARN '%s'", arn)
+	}
+	resource := strings.Split(parts[5], "/")
+	if len(resource) != 3 || resource[0] != "targetgroup" {
+		return TargetGroup{}, fmt.Errorf("invalid target group ARN '%s'", arn)
+	}
+
+	return TargetGroup{
+		Region:    parts[3],
+		AccountID: parts[4],
+		Name:      resource[1],
+	}, nil
+}
+
+// TargetGroupLabeler adds the components of target_group_arn as separate fields and optionally
+// routes the entry to a log stream named after the target group. Entries without a target group,
+// such as redirects and fixed responses, are left untouched.
+type TargetGroupLabeler struct {
+	Labels bool
+	Route  bool
+}
+
+func (l *TargetGroupLabeler) Transform(record []string, entry *LogEntry) {
+	targetGroup, err := ParseTargetGroupARN(recordValue(record, "target_group_arn"))
+	if err != nil {
+		return
+	}
+	if l.Labels {
+		entry.Data["target_group_region"] = targetGroup.Region
+		entry.Data["target_group_account"] = targetGroup.AccountID
+		entry.Data["target_group_name"] = targetGroup.Name
+	}
+	if l.Route {
+		entry.Destination.LogStreamName = targetGroup.Name
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTargetGroupARN(t *testing.T) {
+	t.Run("Valid ARN", func(t *testing.T) {
+		targetGroup, err := ParseTargetGroupARN("arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4")
+		require.NoError(t, err)
+		assert.Equal(t, TargetGroup{Region: "xx-west-1", AccountID: "987654321098", Name: "example-prod-tg"}, targetGroup)
+	})
+
+	t.Run("Invalid ARN", func(t *testing.T) {
+		_, err := ParseTargetGroupARN("-")
+		require.Error(t, err)
+		assert.Equal(t, "invalid target group ARN '-'", err.Error())
+
+		_, err = ParseTargetGroupARN("arn:aws:elasticloadbalancing:xx-west-1:987654321098:loadbalancer/app/example/123")
+		require.Error(t, err)
+	})
+}
+
+func TestTargetGroupLabeler(t *testing.T) {
+	record := make([]string, len(fieldNames))
+	record[getFieldIndex("target_group_arn")] = "arn:aws:elasticloadbalancing:xx-west-1:987654321098:targetgroup/example-prod-tg/xxxxxxxx4"
+
+	t.Run("Labels", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		labeler := &TargetGroupLabeler{Labels: true}
+		labeler.Transform(record, &entry)
+
+		assert.Equal(t, "xx-west-1", entry.Data["target_group_region"])
+		assert.Equal(t, "987654321098", entry.Data["target_group_account"])
+		assert.Equal(t, "example-prod-tg", entry.Data["target_group_name"])
+		assert.Equal(t, LogConfig{}, entry.Destination)
+	})
+
+	t.Run("Route", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		labeler := &TargetGroupLabeler{Route: true}
+		labeler.Transform(record, &entry)
+
+		assert.Empty(t, entry.Data)
+		assert.Equal(t, "example-prod-tg", entry.Destination.LogStreamName)
+	})
+
+	t.Run("No target group", func(t *testing.T) {
+		entry := LogEntry{Data: map[string]interface{}{}}
+		labeler := &TargetGroupLabeler{Labels: true, Route: true}
+		labeler.Transform(make([]string, len(fieldNames)), &entry)
+
+		assert.Empty(t, entry.Data)
+		assert.Equal(t, LogConfig{}, entry.Destination)
+	})
+}
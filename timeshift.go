@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeShifter moves the timestamps of entries by a fixed offset, so old logs can be ingested for demos and
+// load tests although CloudWatch only accepts events from the last 14 days. The original time is kept in
+// original_timestamp and the entry is marked with timestamp_shifted=true.
+type TimeShifter struct {
+	Offset time.Duration
+}
+
+func (s *TimeShifter) Transform(record []string, entry *LogEntry) {
+	entry.Data["original_timestamp"] = entry.Timestamp.Format(time.RFC3339Nano)
+	entry.Data["timestamp_shifted"] = true
+	entry.Timestamp = entry.Timestamp.Add(s.Offset)
+}
+
+// timestampShift returns the offset for a --timestamp-shift value: a duration such as 720h, or "now" to move
+// the end of the last log file of the objects to the current time, preserving the time between entries
+func timestampShift(value string, s3Objects []S3ObjectInfo, now time.Time) (time.Duration, error) {
+	if value != "now" {
+		offset, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp shift '%s', expected now or a duration like 720h", value)
+		}
+		return offset, nil
+	}
+	var latest time.Time
+	for _, s3Object := range s3Objects {
+		if key, err := ParseELBObjectKey(s3Object.Key); err == nil && key.EndTime.After(latest) {
+			latest = key.EndTime
+		}
+	}
+	if latest.IsZero() {
+		return 0, fmt.Errorf("timestamp shift 'now' needs ELB access log file names to find the latest time, give a duration instead")
+	}
+
+	return now.Sub(latest).Truncate(time.Minute), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeShifter(t *testing.T) {
+	timestamp := time.Date(2024, 3, 21, 16, 10, 26, 71854000, time.UTC)
+	entry := LogEntry{Data: map[string]interface{}{}, Timestamp: timestamp}
+	(&TimeShifter{Offset: 24 * time.Hour}).Transform(nil, &entry)
+
+	assert.Equal(t, time.Date(2024, 3, 22, 16, 10, 26, 71854000, time.UTC), entry.Timestamp)
+	assert.Equal(t, "2024-03-21T16:10:26.071854Z", entry.Data["original_timestamp"])
+	assert.Equal(t, true, entry.Data["timestamp_shifted"])
+}
+
+func TestTimestampShift(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 30, 15, 0, time.UTC)
+	s3Objects := []S3ObjectInfo{
+		{Key: "AWSLogs/123456789012/elasticloadbalancing/eu-west-1/2024/01/01/123456789012_elasticloadbalancing_eu-west-1_app.my-lb.1234567890abcdef_20240101T0005Z_10.0.0.1_2x9kdk1n.log.gz"},
+		{Key: "AWSLogs/123456789012/elasticloadbalancing/eu-west-1/2024/01/01/123456789012_elasticloadbalancing_eu-west-1_app.my-lb.1234567890abcdef_20240101T0010Z_10.0.0.1_3k2jd8sl.log.gz"},
+	}
+
+	t.Run("Now", func(t *testing.T) {
+		offset, err := timestampShift("now", s3Objects, now)
+		require.NoError(t, err)
+		// The end of the latest log file is moved to the current time, in whole minutes
+		assert.Equal(t, time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC).Add(offset))
+	})
+
+	t.Run("Duration", func(t *testing.T) {
+		offset, err := timestampShift("720h", nil, now)
+		require.NoError(t, err)
+		assert.Equal(t, 720*time.Hour, offset)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := timestampShift("tomorrow", s3Objects, now)
+		require.Error(t, err)
+		assert.Equal(t, "invalid timestamp shift 'tomorrow', expected now or a duration like 720h", err.Error())
+
+		_, err = timestampShift("now", []S3ObjectInfo{{Key: "logs/access.log.gz"}}, now)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampLayouts is an ordered list of layouts that is tried when parsing the time field of a record.
+// Besides Go time layouts it supports the named layouts in namedTimestampLayouts and the epoch variants
+// "epoch" (seconds, optionally with a fraction) and "epoch_millis". A nil list uses defaultTimestampLayouts.
+type TimestampLayouts []string
+
+const (
+	epochLayout       = "epoch"
+	epochMillisLayout = "epoch_millis"
+)
+
+var namedTimestampLayouts = map[string]string{
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc3339":     time.RFC3339,
+	// Timestamps without a zone designator are interpreted as UTC
+	"rfc3339_nozone": "2006-01-02T15:04:05.999999999",
+}
+
+// defaultTimestampLayouts accepts ALB timestamps as well as second-precision timestamps and timestamps lacking the trailing Z
+var defaultTimestampLayouts = TimestampLayouts{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"}
+
+// ParseTimestampLayouts parses a comma separated list of layout names or Go time layouts, e.g. "rfc3339,epoch"
+func ParseTimestampLayouts(config string) (TimestampLayouts, error) {
+	if config == "" {
+		return nil, nil
+	}
+	var layouts TimestampLayouts
+	for _, layout := range strings.Split(config, ",") {
+		layout = strings.TrimSpace(layout)
+		if named, ok := namedTimestampLayouts[strings.ToLower(layout)]; ok {
+			layout = named
+		} else if layout != epochLayout && layout != epochMillisLayout && !strings.Contains(layout, "2006") {
+			return nil, fmt.Errorf("invalid timestamp layout '%s'", layout)
+		}
+		layouts = append(layouts, layout)
+	}
+
+	return layouts, nil
+}
+
+// Parse tries each layout in order and returns the first successfully parsed time
+func (layouts TimestampLayouts) Parse(value string) (time.Time, error) {
+	if layouts == nil {
+		layouts = defaultTimestampLayouts
+	}
+	for _, layout := range layouts {
+		if t, err := parseTimestamp(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("timestamp '%s' does not match any of the layouts %s", value, strings.Join(layouts, ", "))
+}
+
+func parseTimestamp(layout, value string) (time.Time, error) {
+	switch layout {
+	case epochLayout:
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*float64(time.Second))).UTC(), nil
+	case epochMillisLayout:
+		millis, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(millis).UTC(), nil
+	default:
+		return time.Parse(layout, value)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimestampLayouts(t *testing.T) {
+	t.Run("Empty config uses defaults", func(t *testing.T) {
+		layouts, err := ParseTimestampLayouts("")
+		require.NoError(t, err)
+		assert.Nil(t, layouts)
+	})
+
+	t.Run("Named and custom layouts", func(t *testing.T) {
+		layouts, err := ParseTimestampLayouts("rfc3339, epoch_millis, 2006-01-02 15:04:05")
+		require.NoError(t, err)
+		assert.Equal(t, TimestampLayouts{time.RFC3339, "epoch_millis", "2006-01-02 15:04:05"}, layouts)
+	})
+
+	t.Run("Invalid layout", func(t *testing.T) {
+		_, err := ParseTimestampLayouts("rfc3339,unix")
+		require.Error(t, err)
+		assert.Equal(t, "invalid timestamp layout 'unix'", err.Error())
+	})
+}
+
+func TestTimestampLayoutsParse(t *testing.T) {
+	expected := time.Date(2024, 3, 21, 16, 10, 26, 0, time.UTC)
+
+	t.Run("Default layouts", func(t *testing.T) {
+		var layouts TimestampLayouts
+		for _, value := range []string{"2024-03-21T16:10:26Z", "2024-03-21T16:10:26.000000Z", "2024-03-21T16:10:26"} {
+			timestamp, err := layouts.Parse(value)
+			require.NoError(t, err)
+			assert.True(t, expected.Equal(timestamp), value)
+		}
+	})
+
+	t.Run("Epoch layouts", func(t *testing.T) {
+		timestamp, err := TimestampLayouts{"epoch"}.Parse("1711037426.5")
+		require.NoError(t, err)
+		assert.Equal(t, expected.Add(500*time.Millisecond), timestamp)
+
+		timestamp, err = TimestampLayouts{"epoch_millis"}.Parse("1711037426000")
+		require.NoError(t, err)
+		assert.Equal(t, expected, timestamp)
+	})
+
+	t.Run("No matching layout", func(t *testing.T) {
+		_, err := TimestampLayouts{time.RFC3339}.Parse("yesterday")
+		require.Error(t, err)
+		assert.Equal(t, "timestamp 'yesterday' does not match any of the layouts "+time.RFC3339, err.Error())
+	})
+}
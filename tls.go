@@ -0,0 +1,45 @@
+package main
+
+import "strings"
+
+// deprecatedTLSProtocols are protocols that are disabled by modern ELB security policies
+var deprecatedTLSProtocols = map[string]bool{
+	"SSLv3":   true,
+	"TLSv1":   true,
+	"TLSv1.1": true,
+}
+
+// weakCipherMarkers are substrings of OpenSSL cipher names that indicate a weak cipher
+var weakCipherMarkers = []string{"RC4", "DES", "NULL", "EXPORT", "MD5", "ADH", "AECDH"}
+
+// TLSReporter flags entries that use a deprecated TLS protocol or a weak cipher with insecure_tls=true
+// and counts them, helping to find clients that break when old TLS versions are disabled
+type TLSReporter struct {
+	insecure int
+}
+
+func (r *TLSReporter) Transform(record []string, entry *LogEntry) {
+	if !IsInsecureTLS(recordValue(record, "ssl_protocol"), recordValue(record, "ssl_cipher")) {
+		return
+	}
+	entry.Data["insecure_tls"] = true
+	r.insecure++
+}
+
+func (r *TLSReporter) Summarize(summary map[string]int) {
+	summary["insecure_tls"] = r.insecure
+}
+
+// IsInsecureTLS reports whether a protocol or cipher is considered insecure. Plain HTTP requests ("-") are not reported.
+func IsInsecureTLS(protocol, cipher string) bool {
+	if deprecatedTLSProtocols[protocol] {
+		return true
+	}
+	for _, marker := range weakCipherMarkers {
+		if strings.Contains(cipher, marker) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsInsecureTLS(t *testing.T) {
+	assert.False(t, IsInsecureTLS("TLSv1.3", "ECDHE-RSA-AES256-GCM-SHA384"))
+	assert.False(t, IsInsecureTLS("TLSv1.2", "ECDHE-RSA-AES128-GCM-SHA256"))
+	assert.False(t, IsInsecureTLS("-", "-"))
+	assert.True(t, IsInsecureTLS("TLSv1", "ECDHE-RSA-AES128-SHA"))
+	assert.True(t, IsInsecureTLS("TLSv1.1", "ECDHE-RSA-AES128-SHA"))
+	assert.True(t, IsInsecureTLS("TLSv1.2", "DES-CBC3-SHA"))
+}
+
+func TestTLSReporter(t *testing.T) {
+	newRecord := func(protocol, cipher string) []string {
+		record := make([]string, len(fieldNames))
+		record[getFieldIndex("ssl_protocol")] = protocol
+		record[getFieldIndex("ssl_cipher")] = cipher
+		return record
+	}
+	reporter := &TLSReporter{}
+
+	secure := LogEntry{Data: map[string]interface{}{}}
+	reporter.Transform(newRecord("TLSv1.3", "ECDHE-RSA-AES256-GCM-SHA384"), &secure)
+	assert.NotContains(t, secure.Data, "insecure_tls")
+
+	insecure := LogEntry{Data: map[string]interface{}{}}
+	reporter.Transform(newRecord("TLSv1", "ECDHE-RSA-AES128-SHA"), &insecure)
+	assert.Equal(t, true, insecure.Data["insecure_tls"])
+
+	summary := summarize([]Transformer{reporter})
+	assert.Equal(t, map[string]int{"insecure_tls": 1}, summary)
+	assert.Equal(t, "insecure_tls=1", formatSummary(summary))
+}
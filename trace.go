@@ -0,0 +1,52 @@
+package main
+
+import "strings"
+
+// TraceID holds the components of an X-Amzn-Trace-Id header value,
+// e.g. Self=1-67891234-12456789abcdef012345678;Root=1-67891233-abcdef012345678912345678;Sampled=1
+type TraceID struct {
+	Root    string
+	Parent  string
+	Self    string
+	Sampled string
+}
+
+// ParseTraceID splits a trace ID into its components, unknown keys are ignored
+func ParseTraceID(value string) TraceID {
+	var traceID TraceID
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Root":
+			traceID.Root = val
+		case "Parent":
+			traceID.Parent = val
+		case "Self":
+			traceID.Self = val
+		case "Sampled":
+			traceID.Sampled = val
+		}
+	}
+
+	return traceID
+}
+
+// TraceFields adds trace_root, trace_parent and trace_sampled fields parsed from trace_id,
+// so entries can be joined with X-Ray traces and application logs
+type TraceFields struct{}
+
+func (f *TraceFields) Transform(record []string, entry *LogEntry) {
+	traceID := ParseTraceID(recordValue(record, "trace_id"))
+	if traceID.Root != "" {
+		entry.Data["trace_root"] = traceID.Root
+	}
+	if traceID.Parent != "" {
+		entry.Data["trace_parent"] = traceID.Parent
+	}
+	if traceID.Sampled != "" {
+		entry.Data["trace_sampled"] = traceID.Sampled == "1"
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceID(t *testing.T) {
+	t.Run("Root only", func(t *testing.T) {
+		assert.Equal(t, TraceID{Root: "1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx"}, ParseTraceID("Root=1-xxxxxx4-xxxxxxxxxxxxxxxxxxxxxxxx"))
+	})
+
+	t.Run("All components", func(t *testing.T) {
+		traceID := ParseTraceID("Self=1-67891234-12456789abcdef012345678;Root=1-67891233-abcdef012345678912345678;Parent=53995c3f42cd8ad8;Sampled=1")
+		assert.Equal(t, TraceID{
+			Root:    "1-67891233-abcdef012345678912345678",
+			Parent:  "53995c3f42cd8ad8",
+			Self:    "1-67891234-12456789abcdef012345678",
+			Sampled: "1",
+		}, traceID)
+	})
+
+	t.Run("Missing trace ID", func(t *testing.T) {
+		assert.Equal(t, TraceID{}, ParseTraceID("-"))
+	})
+}
+
+func TestTraceFields(t *testing.T) {
+	record := make([]string, len(fieldNames))
+	record[getFieldIndex("trace_id")] = "Root=1-67891233-abcdef012345678912345678;Sampled=0"
+	entry := LogEntry{Data: map[string]interface{}{}}
+
+	transformer := &TraceFields{}
+	transformer.Transform(record, &entry)
+
+	assert.Equal(t, "1-67891233-abcdef012345678912345678", entry.Data["trace_root"])
+	assert.Equal(t, false, entry.Data["trace_sampled"])
+	assert.NotContains(t, entry.Data, "trace_parent")
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Dimensions of the TrafficProfile, in the order they are reported
+var trafficDimensions = []string{"paths", "user agents", "client IPs", "target groups"}
+
+// trafficValues returns the value of every dimension of a record, empty if it has none
+func trafficValues(record []string) [4]string {
+	var values [4]string
+	values[0] = RequestPath(recordValue(record, "request"))
+	if userAgent := recordValue(record, "user_agent"); userAgent != "-" {
+		values[1] = userAgent
+	}
+	values[2] = clientIP(record)
+	if targetGroup, err := ParseTargetGroupARN(recordValue(record, "target_group_arn")); err == nil {
+		values[3] = targetGroup.Name
+	}
+
+	return values
+}
+
+// clientIP returns the IP address of the client of a record without the port, empty if it has none
+func clientIP(record []string) string {
+	client := recordValue(record, "client:port")
+	if client == "-" {
+		return ""
+	}
+	// IPv6 addresses contain colons as well, the port follows the last one
+	if i := strings.LastIndex(client, ":"); i > 0 {
+		client = client[:i]
+	}
+
+	return client
+}
+
+// TrafficProfile finds the most frequent paths, user agents, client IPs and target groups and estimates the
+// number of unique values of each in a single pass with bounded memory, to analyze the traffic of a load balancer
+// from its logs. It sees the records that are kept by sampling and other filters.
+type TrafficProfile struct {
+	Top        int // Number of most frequent values reported per dimension
+	dimensions [4]*trafficDimension
+}
+
+type trafficDimension struct {
+	top    *spaceSaving
+	unique *hyperLogLog
+}
+
+func (p *TrafficProfile) Transform(record []string, entry *LogEntry) {
+	if p.dimensions[0] == nil {
+		for i := range p.dimensions {
+			// Tracking more values than reported keeps the counts of the reported values accurate
+			p.dimensions[i] = &trafficDimension{top: newSpaceSaving(max(10*p.Top, 1000)), unique: &hyperLogLog{}}
+		}
+	}
+	for i, value := range trafficValues(record) {
+		if value != "" {
+			p.dimensions[i].top.add(value)
+			p.dimensions[i].unique.add(value)
+		}
+	}
+}
+
+// Write writes the most frequent values of every dimension with the estimated number of unique values
+func (p *TrafficProfile) Write(w io.Writer) error {
+	for i, name := range trafficDimensions {
+		dimension := p.dimensions[i]
+		if dimension == nil || dimension.top.total == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\ntop %d %s of ~%d unique\n", p.Top, name, dimension.unique.estimate())
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for _, counter := range dimension.top.sorted(p.Top) {
+			share := float64(counter.count) / float64(dimension.top.total) * 100
+			// Counts of values that were evicted before are overestimated by at most their error
+			approx := ""
+			if counter.err > 0 {
+				approx = "~"
+			}
+			fmt.Fprintf(tw, "%s%d\t%.1f%%\t\t%s\n", approx, counter.count, share, counter.value)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// spaceSaving counts the most frequent values with a bounded number of counters (the Space-Saving algorithm):
+// when all counters are in use, the least frequent value is replaced and the new value inherits its count as
+// error. Values more frequent than total/capacity are always counted.
+type spaceSaving struct {
+	capacity int
+	total    int
+	counters map[string]*topCounter
+	heap     topHeap // Least frequent counter first
+}
+
+type topCounter struct {
+	value string
+	count int
+	err   int // The count may be overestimated by this much
+	index int // Position in the heap
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, counters: make(map[string]*topCounter)}
+}
+
+func (s *spaceSaving) add(value string) {
+	s.total++
+	if counter, ok := s.counters[value]; ok {
+		counter.count++
+		heap.Fix(&s.heap, counter.index)
+		return
+	}
+	if len(s.heap) < s.capacity {
+		counter := &topCounter{value: value, count: 1}
+		s.counters[value] = counter
+		heap.Push(&s.heap, counter)
+		return
+	}
+	counter := s.heap[0]
+	delete(s.counters, counter.value)
+	counter.value = value
+	counter.err = counter.count
+	counter.count++
+	s.counters[value] = counter
+	heap.Fix(&s.heap, 0)
+}
+
+// sorted returns the n most frequent values, the most frequent first
+func (s *spaceSaving) sorted(n int) []topCounter {
+	counters := make([]topCounter, 0, len(s.heap))
+	for _, counter := range s.heap {
+		counters = append(counters, *counter)
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].count != counters[j].count {
+			return counters[i].count > counters[j].count
+		}
+		return counters[i].value < counters[j].value
+	})
+
+	return counters[:min(n, len(counters))]
+}
+
+type topHeap []*topCounter
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topHeap) Push(x any) {
+	counter := x.(*topCounter)
+	counter.index = len(*h)
+	*h = append(*h, counter)
+}
+
+func (h *topHeap) Pop() any {
+	old := *h
+	counter := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return counter
+}
+
+// hyperLogLogPrecision gives 2^14 registers, a standard error of about 0.8% in 16 KB
+const hyperLogLogPrecision = 14
+
+// hyperLogLog estimates the number of unique values with a fixed amount of memory
+type hyperLogLog struct {
+	registers [1 << hyperLogLogPrecision]uint8
+}
+
+func (h *hyperLogLog) add(value string) {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	x := mix64(hash.Sum64())
+	index := x >> (64 - hyperLogLogPrecision)
+	// Position of the first 1 bit in the remaining bits, the marker bit bounds it when they are all 0
+	rank := uint8(bits.LeadingZeros64(x<<hyperLogLogPrecision|1<<(hyperLogLogPrecision-1)) + 1)
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() int {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, register := range h.registers {
+		sum += math.Pow(2, -float64(register))
+		if register == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// Small cardinalities are estimated better by counting the empty registers
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int(math.Round(estimate))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := newSpaceSaving(10)
+	for i := 0; i < 100; i++ {
+		s.add("frequent")
+		if i%2 == 0 {
+			s.add("common")
+		}
+		s.add(fmt.Sprintf("rare-%d", i))
+	}
+
+	top := s.sorted(2)
+	assert.Equal(t, 250, s.total)
+	assert.Equal(t, "frequent", top[0].value)
+	assert.Equal(t, 100, top[0].count)
+	assert.Equal(t, "common", top[1].value)
+	// Values more frequent than total/capacity are kept, their count is overestimated by at most the error
+	assert.GreaterOrEqual(t, top[1].count, 50)
+	assert.LessOrEqual(t, top[1].count-top[1].err, 50)
+	assert.Len(t, s.sorted(20), 10)
+}
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 10_000, 200_000} {
+		h := &hyperLogLog{}
+		for i := 0; i < n; i++ {
+			h.add(fmt.Sprintf("192.0.%d.%d", i/256, i%256))
+			h.add(fmt.Sprintf("192.0.%d.%d", i/256, i%256))
+		}
+		assert.InDelta(t, n, h.estimate(), float64(n)*0.03+1, "%d unique values", n)
+	}
+}
+
+func TestTrafficValues(t *testing.T) {
+	record := make([]string, len(fieldIndexes))
+	set := func(field, value string) { record[fieldIndexes[field]] = value }
+	set("request", "GET https://example.com:443/users/42?page=2 HTTP/1.1")
+	set("user_agent", "-")
+	set("client:port", "2001:db8::1:36217")
+	set("target_group_arn", "-")
+
+	assert.Equal(t, [4]string{"/users/42", "", "2001:db8::1", ""}, trafficValues(record))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Transformer derives additional fields for a log entry from the raw log record.
+// Transformers run after field selection, so they have access to every field of
+// the record even if it is not included in the entry itself.
+type Transformer interface {
+	Transform(record []string, entry *LogEntry)
+}
+
+// Filter is implemented by transformers that drop records, such as duplicates. Records
+// are checked by all filters before the entry is created and the transformers run.
+type Filter interface {
+	Keep(record []string) bool
+}
+
+// InvalidRecordHandler is implemented by transformers that decide what happens with records of which no entry can
+// be created, such as records with a missing field or an invalid timestamp, or of which the entry can't be encoded.
+// The record is skipped if Invalid or Unencodable returns nil, otherwise parsing stops with the error. Without a
+// handler, parsing stops at the first invalid record.
+type InvalidRecordHandler interface {
+	Invalid(index int, record []string, err error) error
+	Unencodable(index int, err error) error
+}
+
+// Summarizer is implemented by transformers that keep per-object statistics,
+// which are added to the summary that is logged after an object is processed
+type Summarizer interface {
+	Summarize(summary map[string]int)
+}
+
+// NewTransformers returns the transformers enabled by the given config, in the order they should be applied.
+// Transformers may keep state, so a new set is created for every object that is processed.
+func NewTransformers(config Config) []Transformer {
+	var transformers []Transformer
+	if config.Histograms {
+		// Before sampling, so the histograms count all records
+		transformers = append(transformers, &ObjectHistograms{})
+	}
+	if config.SampleRate > 0 && config.SampleRate < 1 {
+		transformers = append(transformers, &Sampler{Rate: config.SampleRate, Offset: config.SampleOffset, Seed: config.SampleSeed})
+	}
+	if config.DedupWindow > 0 {
+		transformers = append(transformers, &Deduplicator{Window: config.DedupWindow})
+	}
+	if config.NormalizePaths {
+		transformers = append(transformers, &PathNormalizer{})
+	}
+	if config.RequestFingerprint {
+		transformers = append(transformers, &RequestFingerprinter{})
+	}
+	if config.RequestTagging {
+		transformers = append(transformers, &RequestTagger{
+			FastThreshold: config.FastRequestThreshold,
+			SlowThreshold: config.SlowRequestThreshold,
+		})
+	}
+	if config.LatencyUnit != "" {
+		transformers = append(transformers, &LatencyConverter{Unit: config.LatencyUnit})
+	}
+	if config.LatencyBreakdown {
+		transformers = append(transformers, &LatencyBreakdown{})
+	}
+	if config.TLSReporting {
+		transformers = append(transformers, &TLSReporter{})
+	}
+	if config.TargetGroupLabels || config.RouteByTargetGroup {
+		transformers = append(transformers, &TargetGroupLabeler{
+			Labels: config.TargetGroupLabels,
+			Route:  config.RouteByTargetGroup,
+		})
+	}
+	if len(config.HostRoutes) > 0 {
+		// After the target group, so a stream of a host route takes precedence
+		transformers = append(transformers, &HostRouter{Routes: config.HostRoutes})
+	}
+	if len(config.RuleNames) > 0 {
+		transformers = append(transformers, &RuleNamer{Names: config.RuleNames})
+	}
+	if config.TraceFields {
+		transformers = append(transformers, &TraceFields{})
+	}
+	if config.AuthenticatedField || config.AuthTargetGroupPattern != "" {
+		transformers = append(transformers, &AuthenticationTagger{TargetGroupPattern: config.AuthTargetGroupPattern})
+	}
+	if config.ExpandActions {
+		transformers = append(transformers, &ActionsExpander{})
+	}
+	if config.SeverityLevels {
+		transformers = append(transformers, &SeverityLevel{})
+	}
+	if config.SchemaVersion {
+		transformers = append(transformers, &SchemaStamper{})
+	}
+	if config.HighRateThreshold > 0 {
+		transformers = append(transformers, &HighRateTagger{Threshold: config.HighRateThreshold, Window: config.HighRateWindow})
+	}
+	if config.ClientClassifier != nil {
+		transformers = append(transformers, config.ClientClassifier)
+	}
+	if config.SecurityRules != nil {
+		transformers = append(transformers, &SecurityTagger{Rules: config.SecurityRules})
+	}
+	if config.ScrubControlCharacters {
+		transformers = append(transformers, &Scrubber{})
+	}
+	if config.MaxFieldLengths.Enabled() {
+		transformers = append(transformers, &FieldTruncator{Lengths: config.MaxFieldLengths})
+	}
+
+	return transformers
+}
+
+// summarize collects the statistics of all transformers that implement Summarizer
+func summarize(transformers []Transformer) map[string]int {
+	summary := make(map[string]int)
+	for _, transformer := range transformers {
+		if s, ok := transformer.(Summarizer); ok {
+			s.Summarize(summary)
+		}
+	}
+
+	return summary
+}
+
+// formatSummary formats a summary as sorted key=value pairs, e.g. "insecure_tls=3"
+func formatSummary(summary map[string]int) string {
+	keys := make([]string, 0, len(summary))
+	for key := range summary {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%d", key, summary[key])
+	}
+
+	return strings.Join(pairs, " ")
+}
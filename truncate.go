@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// truncatedSuffix is appended to values that are cut off by the FieldTruncator
+const truncatedSuffix = "…[truncated]"
+
+// FieldLengths holds the maximum length in bytes of field values. Default applies to every field
+// without an override in Fields, 0 means unlimited.
+type FieldLengths struct {
+	Default int
+	Fields  map[string]int
+}
+
+// ParseFieldLengths parses a default length and/or per-field overrides as a comma separated list,
+// e.g. "2048,user_agent=512,request=8192". An override of 0 disables the limit for that field.
+func ParseFieldLengths(value string) (FieldLengths, error) {
+	var lengths FieldLengths
+	if strings.TrimSpace(value) == "" {
+		return lengths, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		field, limit, isOverride := strings.Cut(part, "=")
+		if !isOverride {
+			limit = field
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || length < 0 {
+			return FieldLengths{}, fmt.Errorf("invalid field length '%s', expected a number of bytes or <field>=<bytes>", part)
+		}
+		if !isOverride {
+			lengths.Default = length
+			continue
+		}
+		if lengths.Fields == nil {
+			lengths.Fields = make(map[string]int)
+		}
+		lengths.Fields[strings.TrimSpace(field)] = length
+	}
+
+	return lengths, nil
+}
+
+// Enabled reports whether any field has a maximum length
+func (l FieldLengths) Enabled() bool {
+	if l.Default > 0 {
+		return true
+	}
+	for _, length := range l.Fields {
+		if length > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limit returns the maximum length of a field, 0 if it is unlimited
+func (l FieldLengths) limit(field string) int {
+	if length, ok := l.Fields[field]; ok {
+		return length
+	}
+
+	return l.Default
+}
+
+// FieldTruncator cuts off string values that exceed their maximum length, such as 10 KB user agents or huge
+// query strings, bounding the size of events without dropping them. It runs after the other configured transformers,
+// so derived fields are truncated as well.
+type FieldTruncator struct {
+	Lengths   FieldLengths
+	truncated int
+}
+
+func (t *FieldTruncator) Transform(record []string, entry *LogEntry) {
+	truncated := false
+	for field, value := range entry.Data {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if limit := t.Lengths.limit(field); limit > 0 && len(s) > limit {
+			entry.Data[field] = truncateValue(s, limit)
+			truncated = true
+		}
+	}
+	if truncated {
+		t.truncated++
+	}
+}
+
+func (t *FieldTruncator) Summarize(summary map[string]int) {
+	summary["truncated"] = t.truncated
+}
+
+// truncateValue keeps at most limit bytes of a value, without splitting a multi-byte character, and adds the suffix
+func truncateValue(value string, limit int) string {
+	end := limit
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+
+	return value[:end] + truncatedSuffix
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFieldLengths(t *testing.T) {
+	lengths, err := ParseFieldLengths("2048, user_agent=512,request=0")
+	require.NoError(t, err)
+	assert.Equal(t, FieldLengths{Default: 2048, Fields: map[string]int{"user_agent": 512, "request": 0}}, lengths)
+	assert.True(t, lengths.Enabled())
+	assert.Equal(t, 512, lengths.limit("user_agent"))
+	assert.Equal(t, 0, lengths.limit("request"))
+	assert.Equal(t, 2048, lengths.limit("redirect_url"))
+
+	lengths, err = ParseFieldLengths("")
+	require.NoError(t, err)
+	assert.False(t, lengths.Enabled())
+
+	_, err = ParseFieldLengths("user_agent=long")
+	require.Error(t, err)
+	assert.Equal(t, "invalid field length 'user_agent=long', expected a number of bytes or <field>=<bytes>", err.Error())
+	_, err = ParseFieldLengths("-1")
+	require.Error(t, err)
+}
+
+func TestFieldTruncator(t *testing.T) {
+	truncator := &FieldTruncator{Lengths: FieldLengths{Default: 8, Fields: map[string]int{"user_agent": 4}}}
+
+	entry := LogEntry{Data: map[string]interface{}{
+		"request":          "GET https://example.com:443/ HTTP/1.1",
+		"user_agent":       "curl/8.4.0",
+		"elb_status_code":  "200",
+		"actions_executed": []string{"forward"},
+	}}
+	truncator.Transform(nil, &entry)
+	assert.Equal(t, "GET http…[truncated]", entry.Data["request"])
+	assert.Equal(t, "curl…[truncated]", entry.Data["user_agent"])
+	assert.Equal(t, "200", entry.Data["elb_status_code"])
+	assert.Equal(t, []string{"forward"}, entry.Data["actions_executed"])
+
+	short := LogEntry{Data: map[string]interface{}{"user_agent": "curl"}}
+	truncator.Transform(nil, &short)
+	assert.Equal(t, "curl", short.Data["user_agent"])
+
+	assert.Equal(t, map[string]int{"truncated": 1}, summarize([]Transformer{truncator}))
+}
+
+func TestTruncateValue(t *testing.T) {
+	// Multi-byte characters are not split
+	assert.Equal(t, "ab…[truncated]", truncateValue("abé", 3))
+	assert.Equal(t, "abé…[truncated]", truncateValue("abéc", 4))
+	assert.True(t, strings.HasPrefix(truncateValue(strings.Repeat("x", 10240), 1024), strings.Repeat("x", 1024)))
+}
@@ -3,15 +3,169 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
+	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	LogGroupName  string
 	LogStreamName string
 	Fields        string
+	// Accounts and Regions limit the load balancers processed when listing a central log bucket, nil selects all
+	Accounts []string
+	Regions  []string
+	// AccountRoutes sends the logs of accounts to other log groups, possibly in the accounts themselves
+	AccountRoutes AccountRoutes
+	// DeliveryMode is at-least-once or best-effort, it sets the settings below that it implies, see applyDeliveryMode
+	DeliveryMode string
+	// Strict fails an object on any record that can't be parsed or sent, instead of skipping and counting it
+	Strict bool
+	// StrictSending fails an object on any parsed record that can't be sent, records that can't be parsed are
+	// skipped and counted unless Strict
+	StrictSending bool
+	// AccountIDField adds the account ID from the object key as a field
+	AccountIDField bool
+	// MaxEventsPerSecond limits the entries sent per second by the process, 0 means no limit
+	MaxEventsPerSecond int
+	// RateLimitSampling drops entries exceeding MaxEventsPerSecond instead of delaying them
+	RateLimitSampling bool
+	// SampleRate is the fraction of requests to keep, sampled consistently by trace ID, 0 disables sampling
+	SampleRate float64
+	// SampleOffset and SampleSeed select which requests are kept, see Sampler
+	SampleOffset float64
+	SampleSeed   uint64
+	// Histograms adds the sent_bytes and latency histograms of every object to its summary
+	Histograms bool
+	// IngestionLagMetric emits the lag of the latest entry sent to every stream behind real time as a metric
+	IngestionLagMetric bool
+	// DedupWindow is the number of preceding records a record is compared with to drop duplicates, 0 disables it
+	DedupWindow    int
+	NormalizePaths bool
+	// RequestFingerprint adds a request_fingerprint field, a hash of the method, normalized path and user agent family
+	RequestFingerprint bool
+	// RequestTagging enables the is_slow, is_error, is_client_error and latency_bucket fields
+	RequestTagging       bool
+	FastRequestThreshold time.Duration
+	SlowRequestThreshold time.Duration
+	// LatencyUnit adds the processing times as integers in ms or us, empty disables it
+	LatencyUnit string
+	// LatencyBreakdown replaces the processing times with a latency object in milliseconds
+	LatencyBreakdown bool
+	// TLSReporting flags entries using deprecated TLS protocols or weak ciphers
+	TLSReporting bool
+	// TargetGroupLabels adds the region, account and name of the target group as separate fields
+	TargetGroupLabels bool
+	// RouteByTargetGroup sends entries to a log stream named after their target group
+	RouteByTargetGroup bool
+	// HostRoutes sends entries to log groups and streams by the domain_name of the request
+	HostRoutes HostRoutes
+	// RuleNames adds a matched_rule field with the name of the listener rule by its priority
+	RuleNames RuleNames
+	// ELBTags are the keys of the load balancer tags added to every entry as an elb_tags object
+	ELBTags []string
+	// TargetEnrichment adds the EC2 instance or ECS task of the target as target_instance_id or ecs_task_arn
+	TargetEnrichment bool
+	// TraceFields adds the components of the X-Amzn-Trace-Id as separate fields
+	TraceFields bool
+	// AuthenticatedField adds an authenticated field, true for requests that passed an authenticate action
+	AuthenticatedField bool
+	// ClockSkewTolerance is how far ahead of the current time timestamps are set to the current time, 0 disables it
+	ClockSkewTolerance time.Duration
+	// HighRateThreshold tags entries of client IPs with more requests within HighRateWindow, 0 disables it
+	HighRateThreshold int
+	HighRateWindow    time.Duration
+	// SecurityRules flags suspicious entries with security_flags, nil disables it
+	SecurityRules *SecurityRules
+	// ClientClassifier adds client_class (human, bot or known_crawler) by user agent, nil disables it
+	ClientClassifier *ClientClassifier
+	// AuthTargetGroupPattern also marks requests to target groups with a matching name as authenticated
+	AuthTargetGroupPattern string
+	// ExpandActions parses actions_executed into a list and describes the error_reason code
+	ExpandActions bool
+	// SchemaVersion adds the schema_version and log_format fields to every entry
+	SchemaVersion bool
+	// ScrubControlCharacters replaces invalid UTF-8 and control characters in string values, see Scrubber
+	ScrubControlCharacters bool
+	// MaxFieldLengths truncates string values longer than a maximum number of bytes
+	MaxFieldLengths FieldLengths
+	// SeverityLevels adds a level field of ERROR, WARN or INFO derived from the status codes and error reason
+	SeverityLevels bool
+	// TimestampLayouts are tried in order when parsing the time field, nil uses the defaults
+	TimestampLayouts TimestampLayouts
+	// Preflight checks at startup that the configured destination can be written, see PreflightDestination
+	Preflight bool
+	// HeadObjectChecks requests the size and ETag of each object before processing, to skip unchanged objects
+	HeadObjectChecks bool
+	// FanOutChunkSize is the number of objects per asynchronous invocation when listing a prefix in Lambda, 0 disables fan-out
+	FanOutChunkSize int
+	// RetryFailedObjects is the number of times failed objects are retried in a new invocation instead of failing
+	// the whole invocation, 0 disables it
+	RetryFailedObjects int
+	// ProgressTracking remembers the records sent of objects that fail halfway, so a retry resumes after them.
+	// The progress is kept in memory, or in the DynamoDB table ProgressTable if set.
+	ProgressTracking bool
+	ProgressTable    string
+	// FirehoseTransform runs the Lambda function as Firehose data transformation, see FirehoseTransformer
+	FirehoseTransform bool
+	// ReceiverToken must be sent as bearer token with logs pushed to the serve command, see Receiver
+	ReceiverToken string
+	// LeaseTable is a DynamoDB table with leases, so only one of multiple replicas watches a prefix, see Lease
+	LeaseTable string
+	// MaxObjectsPerInvocation and MaxEntriesPerInvocation limit the work of a Lambda invocation, 0 means no limit
+	MaxObjectsPerInvocation int
+	MaxEntriesPerInvocation int
+	// Spool is a local directory or S3 URL where batches that fail to send are kept to be replayed, empty disables it
+	Spool string
+	// Manifest is a local file or S3 URL where the manifest of every run is written, see Manifest. A location
+	// ending with a slash is a directory or prefix with a manifest per run. Empty disables it.
+	Manifest string
+	// StreamWriterCheck is what to do when the log stream received events less than StreamWriterWindow ago from
+	// another writer: nothing if empty, "warn" or "suffix", see checkStreamWriter
+	StreamWriterCheck  string
+	StreamWriterWindow time.Duration
+	// Subscription is created on the log groups that entries are sent to, see SubscriptionFilter
+	Subscription SubscriptionFilter
+	// DestinationFailureTTL is how long a log group or stream that failed to be created is not tried again
+	DestinationFailureTTL time.Duration
+	// ParseWorkers is the number of workers parsing a single object, 1 parses without splitting the object
+	ParseWorkers int
+	// FlushInterval sends partially filled batches periodically when entries arrive slowly, 0 disables it
+	FlushInterval time.Duration
+	// ReorderBufferSize is the number of entries buffered to send them ordered by timestamp, 0 disables reordering
+	ReorderBufferSize int
+	// RequestIDField adds the ID of the Lambda invocation that shipped an entry as a field
+	RequestIDField bool
+	// HTTPConnectTimeout, HTTPReadTimeout, HTTPMaxIdleConns and HTTPIdleConnTimeout tune the HTTP client
+	// of the AWS clients, 0 keeps the defaults
+	HTTPConnectTimeout  time.Duration
+	HTTPReadTimeout     time.Duration
+	HTTPMaxIdleConns    int
+	HTTPIdleConnTimeout time.Duration
+	// RetryMaxAttempts is the number of attempts per AWS request including the first, 0 keeps the SDK default
+	RetryMaxAttempts int
+	// RetryMinThrottleDelay is the initial backoff after a throttling error, 0 keeps the SDK default
+	RetryMinThrottleDelay time.Duration
+	// RetryMode is standard, or adaptive to also space requests after throttling errors, see adaptiveLimiter
+	RetryMode string
+	// Endpoint overrides the endpoint of all AWS clients, e.g. for LocalStack, empty uses the AWS endpoints
+	Endpoint string
+	// Performance holds the architecture dependent defaults, see performanceProfile
+	Performance PerformanceProfile
+	// MemoryLimit is the memory available in bytes from GOMEMLIMIT or the Lambda memory size, 0 if unknown
+	MemoryLimit int64
 }
 
+const (
+	defaultFastRequestThreshold = 100 * time.Millisecond
+	defaultSlowRequestThreshold = time.Second
+	// defaultDestinationFailureTTL is long enough to avoid storms, but retries missing permissions soon after they are fixed
+	defaultDestinationFailureTTL = 30 * time.Second
+)
+
 func ParseS3URL(url string) (bucket string, prefix string, err error) {
 	if !strings.HasPrefix(url, "s3://") {
 		return "", "", fmt.Errorf("invalid S3 URL, missing 's3://' prefix")
@@ -37,11 +191,357 @@ func LoadConfigFromEnv() (Config, error) {
 		return Config{}, fmt.Errorf("environment variable LOG_STREAM_NAME is required")
 	}
 
-	fields := os.Getenv("FIELDS")
-
-	return Config{
+	config := Config{
 		LogGroupName:  logGroupName,
 		LogStreamName: logStreamName,
-		Fields:        fields,
-	}, nil
+		Fields:        os.Getenv("FIELDS"),
+	}
+
+	var err error
+	if config.Strict, err = boolFromEnv("STRICT"); err != nil {
+		return Config{}, err
+	}
+
+	if config.TimestampLayouts, err = ParseTimestampLayouts(os.Getenv("TIMESTAMP_LAYOUTS")); err != nil {
+		return Config{}, err
+	}
+
+	if config.HeadObjectChecks, err = boolFromEnv("HEAD_OBJECT_CHECKS"); err != nil {
+		return Config{}, err
+	}
+
+	if config.Preflight, err = boolFromEnv("PREFLIGHT"); err != nil {
+		return Config{}, err
+	}
+
+	if config.FanOutChunkSize, err = intFromEnv("FAN_OUT_CHUNK_SIZE", 0); err != nil {
+		return Config{}, err
+	}
+
+	if config.RetryFailedObjects, err = intFromEnv("RETRY_FAILED_OBJECTS", 0); err != nil {
+		return Config{}, err
+	}
+
+	if config.ProgressTracking, err = boolFromEnv("PROGRESS_TRACKING"); err != nil {
+		return Config{}, err
+	}
+	config.ProgressTable = os.Getenv("PROGRESS_TABLE")
+	config.LeaseTable = os.Getenv("LEASE_TABLE")
+	config.ReceiverToken = os.Getenv("RECEIVER_TOKEN")
+
+	if config.MaxObjectsPerInvocation, err = intFromEnv("MAX_OBJECTS_PER_INVOCATION", 0); err != nil {
+		return Config{}, err
+	}
+	if config.MaxEntriesPerInvocation, err = intFromEnv("MAX_ENTRIES_PER_INVOCATION", 0); err != nil {
+		return Config{}, err
+	}
+
+	config.Spool = os.Getenv("SPOOL")
+	config.Manifest = os.Getenv("MANIFEST")
+	if strings.HasPrefix(config.Manifest, "s3://") {
+		if _, _, err := ParseS3URL(config.Manifest); err != nil {
+			return Config{}, fmt.Errorf("invalid MANIFEST: %v", err)
+		}
+	}
+
+	if config.DestinationFailureTTL, err = durationFromEnv("DESTINATION_FAILURE_TTL", defaultDestinationFailureTTL); err != nil {
+		return Config{}, err
+	}
+
+	config.Performance = performanceProfile(runtime.GOARCH, runtime.NumCPU())
+	if config.ParseWorkers, err = intFromEnv("PARSE_WORKERS", config.Performance.ParseWorkers); err != nil {
+		return Config{}, err
+	}
+
+	if config.FlushInterval, err = durationFromEnv("FLUSH_INTERVAL", 0); err != nil {
+		return Config{}, err
+	}
+
+	if config.StreamWriterCheck, err = ParseStreamWriterCheck(os.Getenv("STREAM_WRITER_CHECK")); err != nil {
+		return Config{}, err
+	}
+	if config.StreamWriterWindow, err = durationFromEnv("STREAM_WRITER_WINDOW", defaultStreamWriterWindow); err != nil {
+		return Config{}, err
+	}
+
+	if config.ReorderBufferSize, err = intFromEnv("REORDER_BUFFER_SIZE", 0); err != nil {
+		return Config{}, err
+	}
+
+	if config.RequestIDField, err = boolFromEnv("REQUEST_ID_FIELD"); err != nil {
+		return Config{}, err
+	}
+
+	if config.HTTPConnectTimeout, err = durationFromEnv("HTTP_CONNECT_TIMEOUT", 0); err != nil {
+		return Config{}, err
+	}
+	if config.HTTPReadTimeout, err = durationFromEnv("HTTP_READ_TIMEOUT", 0); err != nil {
+		return Config{}, err
+	}
+	if config.HTTPMaxIdleConns, err = intFromEnv("HTTP_MAX_IDLE_CONNS", 0); err != nil {
+		return Config{}, err
+	}
+	if config.HTTPIdleConnTimeout, err = durationFromEnv("HTTP_IDLE_CONN_TIMEOUT", 0); err != nil {
+		return Config{}, err
+	}
+
+	if config.RetryMaxAttempts, err = intFromEnv("RETRY_MAX_ATTEMPTS", 0); err != nil {
+		return Config{}, err
+	}
+	if config.RetryMinThrottleDelay, err = durationFromEnv("RETRY_MIN_THROTTLE_DELAY", 0); err != nil {
+		return Config{}, err
+	}
+	if config.RetryMode, err = ParseRetryMode(os.Getenv("RETRY_MODE")); err != nil {
+		return Config{}, err
+	}
+	config.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	config.MemoryLimit = memoryLimit(debug.SetMemoryLimit(-1), os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE"))
+
+	config.Accounts = listFromEnv("ACCOUNTS")
+	config.Regions = listFromEnv("REGIONS")
+	if config.AccountRoutes, err = ParseAccountRoutes(os.Getenv("ACCOUNT_ROUTES")); err != nil {
+		return Config{}, err
+	}
+	if config.AccountIDField, err = boolFromEnv("ACCOUNT_ID_FIELD"); err != nil {
+		return Config{}, err
+	}
+
+	config.Subscription = SubscriptionFilter{
+		Name:           os.Getenv("SUBSCRIPTION_FILTER_NAME"),
+		DestinationARN: os.Getenv("SUBSCRIPTION_DESTINATION_ARN"),
+		FilterPattern:  os.Getenv("SUBSCRIPTION_FILTER_PATTERN"),
+		RoleARN:        os.Getenv("SUBSCRIPTION_ROLE_ARN"),
+	}
+	if config.Subscription.Name == "" {
+		config.Subscription.Name = defaultSubscriptionFilterName
+	}
+	if config.Subscription.Enabled() {
+		if err := config.Subscription.Validate(); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if config.MaxEventsPerSecond, err = intFromEnv("MAX_EVENTS_PER_SECOND", 0); err != nil {
+		return Config{}, err
+	}
+	if config.RateLimitSampling, err = boolFromEnv("RATE_LIMIT_SAMPLING"); err != nil {
+		return Config{}, err
+	}
+
+	if config.SampleRate, err = fractionFromEnv("SAMPLE_RATE", 0); err != nil {
+		return Config{}, err
+	}
+	if os.Getenv("SAMPLE_RATE") != "" && config.SampleRate == 0 {
+		return Config{}, fmt.Errorf("SAMPLE_RATE must be greater than 0")
+	}
+	if config.SampleOffset, err = fractionFromEnv("SAMPLE_OFFSET", 0); err != nil {
+		return Config{}, err
+	}
+	if value := os.Getenv("SAMPLE_SEED"); value != "" {
+		if config.SampleSeed, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return Config{}, fmt.Errorf("invalid value '%s' for environment variable SAMPLE_SEED, expected a non-negative integer", value)
+		}
+	}
+
+	if config.Histograms, err = boolFromEnv("HISTOGRAMS"); err != nil {
+		return Config{}, err
+	}
+	if config.IngestionLagMetric, err = boolFromEnv("INGESTION_LAG_METRIC"); err != nil {
+		return Config{}, err
+	}
+
+	if config.DedupWindow, err = intFromEnv("DEDUP_WINDOW", 0); err != nil {
+		return Config{}, err
+	}
+
+	if config.NormalizePaths, err = boolFromEnv("NORMALIZE_PATHS"); err != nil {
+		return Config{}, err
+	}
+	if config.RequestFingerprint, err = boolFromEnv("REQUEST_FINGERPRINT"); err != nil {
+		return Config{}, err
+	}
+
+	if config.RequestTagging, err = boolFromEnv("REQUEST_TAGGING"); err != nil {
+		return Config{}, err
+	}
+	if config.FastRequestThreshold, err = durationFromEnv("FAST_REQUEST_THRESHOLD", defaultFastRequestThreshold); err != nil {
+		return Config{}, err
+	}
+	if config.SlowRequestThreshold, err = durationFromEnv("SLOW_REQUEST_THRESHOLD", defaultSlowRequestThreshold); err != nil {
+		return Config{}, err
+	}
+	if config.FastRequestThreshold > config.SlowRequestThreshold {
+		return Config{}, fmt.Errorf("FAST_REQUEST_THRESHOLD must not be greater than SLOW_REQUEST_THRESHOLD")
+	}
+
+	if config.LatencyUnit, err = ParseLatencyUnit(os.Getenv("LATENCY_UNIT")); err != nil {
+		return Config{}, err
+	}
+	if config.LatencyBreakdown, err = boolFromEnv("LATENCY_BREAKDOWN"); err != nil {
+		return Config{}, err
+	}
+
+	if config.TLSReporting, err = boolFromEnv("TLS_REPORTING"); err != nil {
+		return Config{}, err
+	}
+
+	if config.TargetGroupLabels, err = boolFromEnv("TARGET_GROUP_LABELS"); err != nil {
+		return Config{}, err
+	}
+	if config.RouteByTargetGroup, err = boolFromEnv("ROUTE_BY_TARGET_GROUP"); err != nil {
+		return Config{}, err
+	}
+	if config.HostRoutes, err = ParseHostRoutes(os.Getenv("HOST_ROUTES")); err != nil {
+		return Config{}, err
+	}
+	if config.RuleNames, err = ParseRuleNames(os.Getenv("RULE_NAMES")); err != nil {
+		return Config{}, err
+	}
+	config.ELBTags = ParseELBTagKeys(os.Getenv("ELB_TAGS"))
+	if config.TargetEnrichment, err = boolFromEnv("TARGET_ENRICHMENT"); err != nil {
+		return Config{}, err
+	}
+
+	if config.TraceFields, err = boolFromEnv("TRACE_FIELDS"); err != nil {
+		return Config{}, err
+	}
+
+	if config.AuthenticatedField, err = boolFromEnv("AUTHENTICATED_FIELD"); err != nil {
+		return Config{}, err
+	}
+
+	if config.ClockSkewTolerance, err = durationFromEnv("CLOCK_SKEW_TOLERANCE", 0); err != nil {
+		return Config{}, err
+	}
+
+	if config.HighRateThreshold, err = intFromEnv("HIGH_RATE_THRESHOLD", 0); err != nil {
+		return Config{}, err
+	}
+	if config.HighRateWindow, err = durationFromEnv("HIGH_RATE_WINDOW", defaultHighRateWindow); err != nil {
+		return Config{}, err
+	}
+
+	if config.SecurityRules, err = LoadSecurityRules(os.Getenv("SECURITY_RULES")); err != nil {
+		return Config{}, err
+	}
+
+	clientClassification, err := boolFromEnv("CLIENT_CLASSIFICATION")
+	if err != nil {
+		return Config{}, err
+	}
+	if config.ClientClassifier, err = LoadClientClassifier(clientClassification, os.Getenv("CLIENT_PATTERNS")); err != nil {
+		return Config{}, err
+	}
+
+	config.AuthTargetGroupPattern = os.Getenv("AUTH_TARGET_GROUP_PATTERN")
+	if _, err := path.Match(config.AuthTargetGroupPattern, ""); err != nil {
+		return Config{}, fmt.Errorf("invalid AUTH_TARGET_GROUP_PATTERN '%s': %v", config.AuthTargetGroupPattern, err)
+	}
+
+	if config.ExpandActions, err = boolFromEnv("EXPAND_ACTIONS"); err != nil {
+		return Config{}, err
+	}
+
+	if config.SeverityLevels, err = boolFromEnv("SEVERITY_LEVELS"); err != nil {
+		return Config{}, err
+	}
+
+	if config.FirehoseTransform, err = boolFromEnv("FIREHOSE_TRANSFORM"); err != nil {
+		return Config{}, err
+	}
+
+	if config.SchemaVersion, err = boolFromEnv("SCHEMA_VERSION"); err != nil {
+		return Config{}, err
+	}
+
+	if config.ScrubControlCharacters, err = boolFromEnv("SCRUB_CONTROL_CHARACTERS"); err != nil {
+		return Config{}, err
+	}
+
+	if config.MaxFieldLengths, err = ParseFieldLengths(os.Getenv("MAX_FIELD_LENGTH")); err != nil {
+		return Config{}, err
+	}
+
+	// Last, as it depends on the other settings
+	if config.DeliveryMode, err = ParseDeliveryMode(os.Getenv("DELIVERY_MODE")); err != nil {
+		return Config{}, err
+	}
+	if err := applyDeliveryMode(&config, isEnvSet); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// listFromEnv parses an optional comma separated environment variable, unset means nil
+func listFromEnv(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// strictSending reports whether an object fails on a parsed record that can't be sent
+func (c Config) strictSending() bool {
+	return c.Strict || c.StrictSending
+}
+
+// boolFromEnv parses an optional boolean environment variable, unset means false
+func boolFromEnv(name string) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value '%s' for environment variable %s, expected true or false", value, name)
+	}
+
+	return b, nil
+}
+
+// intFromEnv parses an optional non-negative integer environment variable
+func intFromEnv(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid value '%s' for environment variable %s, expected a non-negative integer", value, name)
+	}
+
+	return i, nil
+}
+
+// fractionFromEnv parses an optional number between 0 and 1 from an environment variable
+func fractionFromEnv(name string, defaultValue float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || !validSampleFraction(f) {
+		return 0, fmt.Errorf("invalid value '%s' for environment variable %s, expected a number between 0 and 1", value, name)
+	}
+
+	return f, nil
+}
+
+// durationFromEnv parses an optional duration environment variable such as "500ms" or "2s"
+func durationFromEnv(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid value '%s' for environment variable %s, expected a duration like 500ms or 2s", value, name)
+	}
+
+	return d, nil
 }
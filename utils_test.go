@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -74,4 +75,43 @@ func TestLoadConfigFromEnv(t *testing.T) {
 		os.Unsetenv("LOG_GROUP_NAME")
 		os.Unsetenv("FIELDS")
 	})
+
+	t.Run("Invalid NORMALIZE_PATHS", func(t *testing.T) {
+		os.Setenv("LOG_GROUP_NAME", "test-log-group")
+		os.Setenv("LOG_STREAM_NAME", "test-log-stream")
+		os.Setenv("NORMALIZE_PATHS", "maybe")
+
+		_, err := LoadConfigFromEnv()
+		require.Error(t, err)
+		assert.Equal(t, "invalid value 'maybe' for environment variable NORMALIZE_PATHS, expected true or false", err.Error())
+
+		// Cleanup
+		os.Unsetenv("LOG_GROUP_NAME")
+		os.Unsetenv("LOG_STREAM_NAME")
+		os.Unsetenv("NORMALIZE_PATHS")
+	})
+
+	t.Run("Request tagging thresholds", func(t *testing.T) {
+		os.Setenv("LOG_GROUP_NAME", "test-log-group")
+		os.Setenv("LOG_STREAM_NAME", "test-log-stream")
+		os.Setenv("REQUEST_TAGGING", "true")
+		os.Setenv("SLOW_REQUEST_THRESHOLD", "2s")
+
+		config, err := LoadConfigFromEnv()
+		require.NoError(t, err)
+		assert.True(t, config.RequestTagging)
+		assert.Equal(t, 100*time.Millisecond, config.FastRequestThreshold)
+		assert.Equal(t, 2*time.Second, config.SlowRequestThreshold)
+
+		os.Setenv("SLOW_REQUEST_THRESHOLD", "fast")
+		_, err = LoadConfigFromEnv()
+		require.Error(t, err)
+		assert.Equal(t, "invalid value 'fast' for environment variable SLOW_REQUEST_THRESHOLD, expected a duration like 500ms or 2s", err.Error())
+
+		// Cleanup
+		os.Unsetenv("LOG_GROUP_NAME")
+		os.Unsetenv("LOG_STREAM_NAME")
+		os.Unsetenv("REQUEST_TAGGING")
+		os.Unsetenv("SLOW_REQUEST_THRESHOLD")
+	})
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Verbosity is the level of detail of the output. Errors are always written.
+type Verbosity int
+
+const (
+	verbosityQuiet   Verbosity = iota // Only errors
+	verbosityNormal                   // Also summaries and warnings
+	verbosityVerbose                  // Also every object
+	verbosityDebug                    // Also every batch sent to CloudWatch
+)
+
+// verbosity is the current level. Lambda functions and the serve and kinesis commands log every object, processing
+// objects with the CLI only logs summaries unless -v is given, and -q, -v and -vv before a command apply to
+// the command, see runCLI.
+var verbosity = verbosityVerbose
+
+// verbosityFlags are the -q, -v and -vv flags of the CLI
+type verbosityFlags struct {
+	quiet, verbose, debug bool
+}
+
+// given reports whether any of the flags is set
+func (f verbosityFlags) given() bool {
+	return f.quiet || f.verbose || f.debug
+}
+
+// level returns the verbosity of the flags, the most detailed one wins and normal without flags
+func (f verbosityFlags) level() Verbosity {
+	switch {
+	case f.debug:
+		return verbosityDebug
+	case f.verbose:
+		return verbosityVerbose
+	case f.quiet:
+		return verbosityQuiet
+	default:
+		return verbosityNormal
+	}
+}
+
+// parseLeadingVerbosity reads the verbosity flags before a command, such as -q in -q export, and returns the
+// arguments after them
+func parseLeadingVerbosity(args []string) (verbosityFlags, []string) {
+	var flags verbosityFlags
+	for len(args) > 0 {
+		switch strings.TrimPrefix(strings.TrimPrefix(args[0], "-"), "-") {
+		case "q":
+			flags.quiet = true
+		case "v":
+			flags.verbose = true
+		case "vv":
+			flags.debug = true
+		default:
+			return flags, args
+		}
+		args = args[1:]
+	}
+
+	return flags, args
+}
+
+// logf logs a message if the verbosity is at least level
+func logf(level Verbosity, format string, args ...interface{}) {
+	if verbosity >= level {
+		log.Printf(format, args...)
+	}
+}
+
+// printf writes a message to standard output if the verbosity is at least level
+func printf(level Verbosity, format string, args ...interface{}) {
+	if verbosity >= level {
+		fmt.Printf(format, args...)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogf(t *testing.T) {
+	defer func(original Verbosity) { verbosity = original }(verbosity)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	verbosity = verbosityNormal
+	logf(verbosityNormal, "summary")
+	logf(verbosityVerbose, "object")
+	logf(verbosityDebug, "batch")
+	assert.Contains(t, buf.String(), "summary")
+	assert.NotContains(t, buf.String(), "object")
+	assert.NotContains(t, buf.String(), "batch")
+
+	buf.Reset()
+	verbosity = verbosityQuiet
+	logf(verbosityNormal, "summary")
+	assert.Empty(t, buf.String())
+
+	verbosity = verbosityDebug
+	logf(verbosityDebug, "batch")
+	assert.Contains(t, buf.String(), "batch")
+}
+
+func TestRunCLIVerbosity(t *testing.T) {
+	defer func(original Verbosity) { verbosity = original }(verbosity)
+	file := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.WriteFile(file, []byte("line\n"), 0o644))
+
+	tests := []struct {
+		args []string
+		want Verbosity
+	}{
+		{nil, verbosityNormal},
+		{[]string{"-q"}, verbosityQuiet},
+		{[]string{"-v"}, verbosityVerbose},
+		{[]string{"-vv"}, verbosityDebug},
+		{[]string{"-q", "--yes"}, verbosityQuiet},
+		{[]string{"-q", "--yes", "-v"}, verbosityVerbose},
+	}
+	for _, tt := range tests {
+		mockProcessor := new(MockLogProcessor)
+		mockProcessor.On("ProcessLogs", mock.Anything).Return(ObjectResult{Entries: 1}, nil)
+		code := runCLI(&Handler{lp: mockProcessor}, append(tt.args, file), &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitSuccess, code)
+		assert.Equal(t, tt.want, verbosity, tt.args)
+	}
+}
+
+func TestRunCLILeadingVerbosity(t *testing.T) {
+	defer func(original Verbosity) { verbosity = original }(verbosity)
+	file := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.WriteFile(file, []byte(testRecordLine(0)+"\n"), 0o644))
+
+	// Before a command
+	for args, want := range map[string]Verbosity{"-q": verbosityQuiet, "--v": verbosityVerbose, "-vv": verbosityDebug} {
+		verbosity = verbosityNormal
+		code := runCLI(&Handler{}, []string{args, "stats", file}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Equal(t, exitSuccess, code, args)
+		assert.Equal(t, want, verbosity, args)
+	}
+
+	// Commands keep their own default without flags
+	verbosity = verbosityVerbose
+	assert.Equal(t, exitSuccess, runCLI(&Handler{}, []string{"stats", file}, &bytes.Buffer{}, &bytes.Buffer{}))
+	assert.Equal(t, verbosityVerbose, verbosity)
+
+	flags, args := parseLeadingVerbosity([]string{"-q", "-vv", "export", "-v"})
+	assert.Equal(t, verbosityFlags{quiet: true, debug: true}, flags)
+	assert.Equal(t, []string{"export", "-v"}, args)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+const (
+	// verifyTimeout is how long the events of an object are read back until none is missing, as CloudWatch Logs
+	// makes events readable a few seconds after they were put
+	verifyTimeout = time.Minute
+	// verifyPollInterval is the time between reads of a destination with missing events
+	verifyPollInterval = 5 * time.Second
+)
+
+// logEventsReader is implemented by the CloudWatch Logs client of the SDK
+type logEventsReader interface {
+	GetLogEvents(*cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error)
+}
+
+// SentEvents records the events sent for an object per destination, so they can be verified afterwards. Events
+// are recorded by a hash of their timestamp and message. Batches that were spooled or partially rejected are not
+// recorded, as they are not (all) in CloudWatch Logs. Methods on nil SentEvents do nothing.
+type SentEvents struct {
+	mu           sync.Mutex
+	destinations map[LogConfig]*sentStream
+}
+
+// sentStream is what was sent to a single destination
+type sentStream struct {
+	events map[uint64]int // Number of events sent per hash
+	count  int
+	first  int64 // Earliest timestamp in milliseconds
+	last   int64 // Latest timestamp in milliseconds
+}
+
+// eventHash identifies an event by its timestamp and message
+func eventHash(timestamp int64, message string) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d\n%s", timestamp, message)
+
+	return h.Sum64()
+}
+
+// add records the events that were sent to a destination
+func (s *SentEvents) add(destination LogConfig, events []*cloudwatchlogs.InputLogEvent) {
+	if s == nil || len(events) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.destinations == nil {
+		s.destinations = make(map[LogConfig]*sentStream)
+	}
+	stream, ok := s.destinations[destination]
+	if !ok {
+		stream = &sentStream{events: make(map[uint64]int), first: aws.Int64Value(events[0].Timestamp), last: aws.Int64Value(events[0].Timestamp)}
+		s.destinations[destination] = stream
+	}
+	for _, event := range events {
+		timestamp := aws.Int64Value(event.Timestamp)
+		stream.events[eventHash(timestamp, aws.StringValue(event.Message))]++
+		stream.count++
+		stream.first = min(stream.first, timestamp)
+		stream.last = max(stream.last, timestamp)
+	}
+}
+
+// Discrepancy is a destination where the events read back differ from the events sent
+type Discrepancy struct {
+	Destination LogConfig
+	Sent        int
+	Missing     int // Events sent that were not found
+	Duplicates  int // Events found more often than they were sent
+}
+
+func (d Discrepancy) String() string {
+	var problems []string
+	if d.Missing > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d events missing", d.Missing, d.Sent))
+	}
+	if d.Duplicates > 0 {
+		problems = append(problems, fmt.Sprintf("%d duplicate events", d.Duplicates))
+	}
+
+	return fmt.Sprintf("%s in %s/%s", strings.Join(problems, " and "), d.Destination.LogGroupName, d.Destination.LogStreamName)
+}
+
+// VerificationError is returned when the events of an object in CloudWatch Logs differ from what was sent
+type VerificationError struct {
+	Discrepancies []Discrepancy
+}
+
+func (e *VerificationError) Error() string {
+	descriptions := make([]string, len(e.Discrepancies))
+	for i, discrepancy := range e.Discrepancies {
+		descriptions[i] = discrepancy.String()
+	}
+
+	return "verification failed: " + strings.Join(descriptions, ", ")
+}
+
+// Verifier reads the events sent for an object back from CloudWatch Logs with GetLogEvents, for the time range
+// of the events in every destination, and compares them with what was sent. Other events in the same time
+// range, such as those of other log files of the load balancer, are ignored. Destinations with missing events
+// are read again until the timeout, as events are only readable some time after they were put.
+type Verifier struct {
+	Client  func(destination LogConfig) CloudWatchLogsAPI
+	Timeout time.Duration
+	// poll is the time between reads of a destination, verifyPollInterval when zero
+	poll time.Duration
+}
+
+// Verify returns a VerificationError listing the destinations whose events differ, or the error of reading them
+func (v *Verifier) Verify(sent *SentEvents) error {
+	if sent == nil {
+		return nil
+	}
+	sent.mu.Lock()
+	defer sent.mu.Unlock()
+	destinations := make([]LogConfig, 0, len(sent.destinations))
+	for destination := range sent.destinations {
+		destinations = append(destinations, destination)
+	}
+	sort.Slice(destinations, func(i, j int) bool {
+		return destinations[i].LogGroupName+"/"+destinations[i].LogStreamName < destinations[j].LogGroupName+"/"+destinations[j].LogStreamName
+	})
+	var discrepancies []Discrepancy
+	for _, destination := range destinations {
+		discrepancy, err := v.verifyDestination(destination, sent.destinations[destination])
+		if err != nil {
+			return fmt.Errorf("failed to verify the events in %s/%s: %w", destination.LogGroupName, destination.LogStreamName, err)
+		}
+		if discrepancy.Missing > 0 || discrepancy.Duplicates > 0 {
+			discrepancies = append(discrepancies, discrepancy)
+		}
+	}
+	if len(discrepancies) > 0 {
+		return &VerificationError{Discrepancies: discrepancies}
+	}
+
+	return nil
+}
+
+// verifyDestination reads the events of a destination until none is missing or the timeout passed
+func (v *Verifier) verifyDestination(destination LogConfig, stream *sentStream) (Discrepancy, error) {
+	reader, ok := v.Client(destination).(logEventsReader)
+	if !ok {
+		return Discrepancy{}, errors.New("the CloudWatch Logs client can't read events")
+	}
+	poll := v.poll
+	if poll == 0 {
+		poll = verifyPollInterval
+	}
+	deadline := time.Now().Add(v.Timeout)
+	for {
+		discrepancy, err := readDiscrepancy(reader, destination, stream)
+		if err != nil || discrepancy.Missing == 0 || time.Now().Add(poll).After(deadline) {
+			return discrepancy, err
+		}
+		logf(verbosityVerbose, "%d events not yet readable in %s/%s, reading again in %s", discrepancy.Missing, destination.LogGroupName, destination.LogStreamName, poll)
+		time.Sleep(poll)
+	}
+}
+
+// readDiscrepancy reads all events in the time range of the events sent to a destination and compares them
+func readDiscrepancy(reader logEventsReader, destination LogConfig, stream *sentStream) (Discrepancy, error) {
+	found := make(map[uint64]int)
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(destination.LogGroupName),
+		LogStreamName: aws.String(destination.LogStreamName),
+		StartTime:     aws.Int64(stream.first),
+		EndTime:       aws.Int64(stream.last + 1), // The end time is exclusive
+		StartFromHead: aws.Bool(true),
+	}
+	for {
+		resp, err := reader.GetLogEvents(input)
+		if err != nil {
+			return Discrepancy{}, permissionError(err, "logs:GetLogEvents", fmt.Sprintf("log stream %s in log group %s", destination.LogStreamName, destination.LogGroupName))
+		}
+		for _, event := range resp.Events {
+			hash := eventHash(aws.Int64Value(event.Timestamp), aws.StringValue(event.Message))
+			if stream.events[hash] > 0 {
+				found[hash]++
+			}
+		}
+		// The last page returns the token it was given
+		if resp.NextForwardToken == nil || aws.StringValue(resp.NextForwardToken) == aws.StringValue(input.NextToken) {
+			break
+		}
+		input.NextToken = resp.NextForwardToken
+	}
+	discrepancy := Discrepancy{Destination: destination, Sent: stream.count}
+	for hash, sent := range stream.events {
+		discrepancy.Missing += max(sent-found[hash], 0)
+		discrepancy.Duplicates += max(found[hash]-sent, 0)
+	}
+
+	return discrepancy, nil
+}
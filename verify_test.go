@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// verifyCloudWatch stores the events put and returns those in the requested time range, two per page. Events only
+// become readable after `delay` reads of a page.
+type verifyCloudWatch struct {
+	MockCloudWatchLogsClient
+	mu     sync.Mutex
+	events []*cloudwatchlogs.OutputLogEvent
+	delay  int
+	reads  int
+	err    error
+}
+
+func (c *verifyCloudWatch) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, event := range input.LogEvents {
+		c.events = append(c.events, &cloudwatchlogs.OutputLogEvent{Message: event.Message, Timestamp: event.Timestamp})
+	}
+
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+func (c *verifyCloudWatch) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reads++
+	if c.err != nil {
+		return nil, c.err
+	}
+	var events []*cloudwatchlogs.OutputLogEvent
+	if c.reads > c.delay {
+		for _, event := range c.events {
+			if timestamp := aws.Int64Value(event.Timestamp); timestamp >= *input.StartTime && timestamp < *input.EndTime {
+				events = append(events, event)
+			}
+		}
+	}
+	start, _ := strconv.Atoi(aws.StringValue(input.NextToken))
+	end := min(start+2, len(events))
+	if start >= end {
+		return &cloudwatchlogs.GetLogEventsOutput{NextForwardToken: input.NextToken}, nil
+	}
+
+	return &cloudwatchlogs.GetLogEventsOutput{Events: events[start:end], NextForwardToken: aws.String(strconv.Itoa(end))}, nil
+}
+
+func verifyEvents(timestamp time.Time, messages ...string) []*cloudwatchlogs.InputLogEvent {
+	var events []*cloudwatchlogs.InputLogEvent
+	for i, message := range messages {
+		events = append(events, &cloudwatchlogs.InputLogEvent{Message: aws.String(message), Timestamp: aws.Int64(timestamp.Add(time.Duration(i) * time.Second).UnixMilli())})
+	}
+	return events
+}
+
+func TestVerifier(t *testing.T) {
+	timestamp := time.Date(2024, 3, 21, 16, 10, 0, 0, time.UTC)
+	destination := LogConfig{LogGroupName: "group", LogStreamName: "stream"}
+	events := verifyEvents(timestamp, `{"a":1}`, `{"a":2}`, `{"a":3}`, `{"a":4}`, `{"a":5}`)
+	verifier := func(client CloudWatchLogsAPI) *Verifier {
+		return &Verifier{Client: func(LogConfig) CloudWatchLogsAPI { return client }, Timeout: 50 * time.Millisecond, poll: time.Millisecond}
+	}
+
+	t.Run("All events are found among others", func(t *testing.T) {
+		client := &verifyCloudWatch{}
+		_, err := client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{LogEvents: events})
+		require.NoError(t, err)
+		// Events of another log file in the same time range are ignored
+		_, err = client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{LogEvents: verifyEvents(timestamp, `{"other":1}`, `{"other":2}`)})
+		require.NoError(t, err)
+		sent := &SentEvents{}
+		sent.add(destination, events)
+
+		require.NoError(t, verifier(client).Verify(sent))
+		// Every page was read
+		assert.Equal(t, 5, client.reads)
+	})
+
+	t.Run("Events that arrive later are read again", func(t *testing.T) {
+		client := &verifyCloudWatch{delay: 3}
+		_, err := client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{LogEvents: events})
+		require.NoError(t, err)
+		sent := &SentEvents{}
+		sent.add(destination, events)
+
+		require.NoError(t, verifier(client).Verify(sent))
+		assert.Greater(t, client.reads, 3)
+	})
+
+	t.Run("Missing and duplicate events are reported", func(t *testing.T) {
+		client := &verifyCloudWatch{}
+		_, err := client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{LogEvents: append(events[:2:2], events[0])})
+		require.NoError(t, err)
+		other := LogConfig{LogGroupName: "group", LogStreamName: "other"}
+		sent := &SentEvents{}
+		sent.add(other, events[:1])
+		sent.add(destination, events)
+
+		err = verifier(client).Verify(sent)
+		var verificationErr *VerificationError
+		require.ErrorAs(t, err, &verificationErr)
+		assert.Equal(t, []Discrepancy{
+			{Destination: other, Sent: 1, Duplicates: 1},
+			{Destination: destination, Sent: 5, Missing: 3, Duplicates: 1},
+		}, verificationErr.Discrepancies)
+		assert.EqualError(t, err, "verification failed: 1 duplicate events in group/other, 3 of 5 events missing and 1 duplicate events in group/stream")
+	})
+
+	t.Run("Read errors are returned", func(t *testing.T) {
+		client := &verifyCloudWatch{err: errors.New("AccessDeniedException: denied")}
+		sent := &SentEvents{}
+		sent.add(destination, events)
+
+		assert.EqualError(t, verifier(client).Verify(sent), "failed to verify the events in group/stream: AccessDeniedException: denied")
+	})
+
+	t.Run("Nothing to verify", func(t *testing.T) {
+		assert.NoError(t, verifier(&verifyCloudWatch{}).Verify(nil))
+		var disabled *SentEvents
+		disabled.add(destination, events)
+	})
+}
+
+func TestCloudWatchSinkVerify(t *testing.T) {
+	client := &verifyCloudWatch{}
+	lp := &CloudWatchLogProcessor{cwClient: client, logConfig: LogConfig{LogGroupName: "group", LogStreamName: "stream"}}
+	sink := &cloudWatchSink{lp: lp, verify: true}
+	entries := make(chan LogEntry, 3)
+	for i := 0; i < 3; i++ {
+		entries <- LogEntry{Data: map[string]interface{}{"request": i}, Timestamp: time.Now(), Record: i}
+	}
+	close(entries)
+
+	require.NoError(t, sink.Send(entries))
+	assert.Equal(t, 3, sink.counters.sent.destinations[lp.logConfig].count)
+	// Two pages and the last one without events
+	assert.Equal(t, 3, client.reads)
+}
+
+func TestUnverifiedObjects(t *testing.T) {
+	client := &verifyCloudWatch{}
+	// The event is in the stream already, e.g. from an earlier run, so it is duplicated
+	_, err := client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+		LogEvents: verifyEvents(time.Date(2024, 3, 21, 16, 10, 26, 71854000, time.UTC), `{"elb_status_code":"203"}`),
+	})
+	require.NoError(t, err)
+	s3Client := new(MockS3Api)
+	s3Client.On("GetObject", mock.Anything).Return(&s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(testRecordLine(0) + "\n"))}, nil).Once()
+	fields, err := NewFields("elb_status_code")
+	require.NoError(t, err)
+	lp := &CloudWatchLogProcessor{
+		s3Client:   s3Client,
+		source:     NewSources(s3Client),
+		cwClient:   client,
+		fieldStore: fields,
+		logConfig:  LogConfig{LogGroupName: "group", LogStreamName: "stream"},
+	}
+	h := &Handler{lp: lp, s3Client: s3Client}
+
+	// The object was sent, so it isn't failed and retried, but reported apart
+	result, statuses, err := h.runS3Objects([]S3ObjectInfo{{Bucket: "bucket", Key: "key", Verify: true}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Processed)
+	assert.Empty(t, result.Failures)
+	assert.Equal(t, []ObjectFailure{{Bucket: "bucket", Key: "key", Error: "verification failed: 1 duplicate events in group/stream"}}, result.Unverified)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, statusUnverified, statuses[0].Status)
+	assert.True(t, statuses[0].done())
+	assert.Equal(t, exitPartialFailure, exitCode(result, err))
+}
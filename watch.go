@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Watcher polls an S3 prefix and processes new objects as they arrive, to run as a long-lived service. Every poll
+// lists the keys of every log directory from the directory of its oldest object that may still be incomplete, and
+// skips the keys that are done, see listingCursor.
+type Watcher struct {
+	Handler  *Handler
+	Bucket   string
+	Prefix   string
+	Interval time.Duration
+	Health   *Health // Optional, updated by every poll
+	Lease    *Lease  // Optional, only the replica holding the lease polls
+	cursor   listingCursor
+	leader   bool // Whether the lease is held
+}
+
+// Poll lists and processes the new objects once. Failed objects are retried by the next poll.
+func (w *Watcher) Poll() error {
+	s3Objects, err := w.cursor.list(w.Handler, w.Bucket, w.Prefix)
+	w.Health.listed(len(s3Objects), err)
+	if err != nil || len(s3Objects) == 0 {
+		return err
+	}
+	_, statuses, err := w.Handler.processS3ObjectsWithStatuses(s3Objects)
+	for _, status := range statuses {
+		if status.done() {
+			w.cursor.markDone(status.Key)
+		}
+	}
+	w.cursor.advance()
+
+	return err
+}
+
+// Run polls every interval until stop is closed, errors are logged and retried by the next poll. With a lease,
+// replicas that don't hold it only check every interval whether it is free.
+func (w *Watcher) Run(stop <-chan struct{}) {
+	log.Printf("watching s3://%s/%s every %s", w.Bucket, w.Prefix, w.Interval)
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if w.Lease == nil {
+			if err := w.Poll(); err != nil {
+				log.Println(err)
+			}
+		} else if w.acquireLease() {
+			if err := w.pollWithLease(); err != nil {
+				log.Println(err)
+			}
+		}
+		select {
+		case <-stop:
+			if w.leader {
+				if err := w.Lease.Release(); err != nil {
+					log.Println(err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquireLease takes or extends the lease and reports whether it is held. When taken over from another
+// replica, the watcher continues from the position saved in the lease.
+func (w *Watcher) acquireLease() bool {
+	leader, state, err := w.Lease.Acquire()
+	w.Health.leaseChecked(leader, err)
+	if err != nil {
+		log.Println(err)
+		leader = false
+	}
+	switch {
+	case leader && !w.leader:
+		log.Printf("acquired lease %s, continuing from %d log directories", w.Lease.Name, len(state.From))
+		w.cursor.restore(state)
+	case !leader && w.leader:
+		log.Printf("lost lease %s", w.Lease.Name)
+	}
+	w.leader = leader
+
+	return leader
+}
+
+// pollWithLease polls while extending the lease, and saves the position in the lease afterwards. When the lease
+// can't be extended, which may hand it to another replica, no more objects are started and the objects being
+// processed are finished before the position is saved, which fails if another replica took the lease over.
+func (w *Watcher) pollWithLease() error {
+	renewed := make(chan struct{})
+	stopRenewing := make(chan struct{})
+	lost := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(w.Lease.Duration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopRenewing:
+				return
+			case <-ticker.C:
+				if leader, _, err := w.Lease.Acquire(); err != nil || !leader {
+					log.Printf("failed to extend lease %s while polling, starting no more objects: %v", w.Lease.Name, err)
+					close(lost)
+					return
+				}
+			}
+		}
+	}()
+	w.Handler.stop = lost
+	err := w.Poll()
+	w.Handler.stop = nil
+	close(stopRenewing)
+	<-renewed
+	select {
+	case <-lost:
+		// Acquiring the lease again restores the saved position
+		w.leader = false
+		err = errors.Join(err, fmt.Errorf("stopped polling after failing to extend lease %s", w.Lease.Name))
+	default:
+	}
+	if saveErr := w.Lease.Save(w.cursor.state()); saveErr != nil {
+		w.leader = false
+		return errors.Join(err, saveErr)
+	}
+
+	return err
+}
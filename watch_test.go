@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher(t *testing.T) {
+	s3Client := newListingS3(testUSDirectory+"2024/03/21/a-1", testUSDirectory+"2024/03/21/b-1")
+	mockProcessor := new(MockLogProcessor)
+	object := func(key string) S3ObjectInfo { return S3ObjectInfo{Bucket: "bucket", Key: key} }
+	health := NewHealth(defaultStaleAfter)
+	watcher := &Watcher{
+		Handler: &Handler{lp: mockProcessor, s3Client: s3Client, health: health},
+		Bucket:  "bucket",
+		Prefix:  "logs/",
+		Health:  health,
+	}
+
+	// The object of load balancer b fails and is retried, objects of other load balancers that sort before it
+	// and arrive later are still picked up
+	mockProcessor.On("ProcessLogs", object(testUSDirectory+"2024/03/21/a-1")).Return(ObjectResult{Entries: 1}, nil).Once()
+	mockProcessor.On("ProcessLogs", object(testUSDirectory+"2024/03/21/b-1")).Return(ObjectResult{}, fmt.Errorf("throttled")).Once()
+	require.Error(t, watcher.Poll())
+	assert.Equal(t, 1, health.Status().Backlog)
+
+	// Objects of another region that sorts before the latest object are picked up
+	s3Client.add(testUSDirectory+"2024/03/21/a-2", testUSDirectory+"2024/03/22/a-3", testEUDirectory+"2024/03/21/c-1")
+	mockProcessor.On("ProcessLogs", object(testEUDirectory+"2024/03/21/c-1")).Return(ObjectResult{Entries: 1}, nil).Once()
+	mockProcessor.On("ProcessLogs", object(testUSDirectory+"2024/03/21/a-2")).Return(ObjectResult{Entries: 1}, nil).Once()
+	mockProcessor.On("ProcessLogs", object(testUSDirectory+"2024/03/21/b-1")).Return(ObjectResult{Entries: 1}, nil).Once()
+	mockProcessor.On("ProcessLogs", object(testUSDirectory+"2024/03/22/a-3")).Return(ObjectResult{Entries: 1}, nil).Once()
+	require.NoError(t, watcher.Poll())
+
+	s3Client.add(testEUDirectory + "2024/03/21/c-2")
+	mockProcessor.On("ProcessLogs", object(testEUDirectory+"2024/03/21/c-2")).Return(ObjectResult{Entries: 1}, nil).Once()
+	require.NoError(t, watcher.Poll())
+
+	// Nothing new
+	require.NoError(t, watcher.Poll())
+
+	mockProcessor.AssertExpectations(t)
+	status := health.Status()
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 0, status.Backlog)
+	assert.NotNil(t, status.LastSent)
+}
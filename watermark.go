@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// ingestionLagNamespace is the CloudWatch namespace of the ingestion lag metric
+const ingestionLagNamespace = "elb-logs-to-cloudwatch"
+
+// Watermarks tracks the latest timestamp of the events sent to every log stream. Now minus the watermark is the
+// ingestion lag: how far the shipped entries trail real time, the delay of ELB writing the logs to S3 included.
+// Methods on nil Watermarks do nothing.
+type Watermarks struct {
+	mu      sync.Mutex
+	streams map[LogConfig]watermark
+}
+
+type watermark struct {
+	latest  time.Time // Latest timestamp of an event sent to the stream
+	updated time.Time // When events were last sent to the stream
+}
+
+// StreamLag is the ingestion lag of a log stream
+type StreamLag struct {
+	Destination LogConfig
+	Watermark   time.Time
+	Lag         time.Duration
+}
+
+// advance records the events that were sent to a destination at the given time
+func (w *Watermarks) advance(destination LogConfig, events []*cloudwatchlogs.InputLogEvent, now time.Time) {
+	if w == nil || len(events) == 0 {
+		return
+	}
+	var latest int64
+	for _, event := range events {
+		latest = max(latest, aws.Int64Value(event.Timestamp))
+	}
+	// Only the stream matters, the role used to write it doesn't
+	destination.RoleARN = ""
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.streams == nil {
+		w.streams = make(map[LogConfig]watermark)
+	}
+	mark := w.streams[destination]
+	mark.latest = maxTime(mark.latest, time.UnixMilli(latest).UTC())
+	mark.updated = now
+	w.streams[destination] = mark
+}
+
+// lags returns the ingestion lag of the streams that received events since the given time, ordered by stream
+func (w *Watermarks) lags(since, now time.Time) []StreamLag {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var lags []StreamLag
+	for destination, mark := range w.streams {
+		if mark.updated.Before(since) {
+			continue
+		}
+		lags = append(lags, StreamLag{Destination: destination, Watermark: mark.latest, Lag: now.Sub(mark.latest)})
+	}
+	sort.Slice(lags, func(i, j int) bool {
+		a, b := lags[i].Destination, lags[j].Destination
+		return a.LogGroupName < b.LogGroupName || a.LogGroupName == b.LogGroupName && a.LogStreamName < b.LogStreamName
+	})
+
+	return lags
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// ingestionLagMetric formats the lag of a stream in the CloudWatch embedded metric format, which CloudWatch Logs
+// turns into the IngestionLag metric (in seconds) with the LogGroup and LogStream dimensions when written to the
+// log of a Lambda function. No PutMetricData permission is needed.
+func ingestionLagMetric(lag StreamLag, now time.Time) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": now.UnixMilli(),
+			"CloudWatchMetrics": []map[string]interface{}{{
+				"Namespace":  ingestionLagNamespace,
+				"Dimensions": [][]string{{"LogGroup", "LogStream"}},
+				"Metrics":    []map[string]string{{"Name": "IngestionLag", "Unit": "Seconds"}},
+			}},
+		},
+		"LogGroup":     lag.Destination.LogGroupName,
+		"LogStream":    lag.Destination.LogStreamName,
+		"IngestionLag": lag.Lag.Seconds(),
+		"Watermark":    lag.Watermark.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// emitIngestionLag writes the ingestion lag metric of every stream that received events since the given time
+// to w, the standard output of a Lambda function. Without w the lags are only logged, as the metric is only
+// extracted from the log of a Lambda function and standard output may be the output of a command.
+func emitIngestionLag(w io.Writer, watermarks *Watermarks, since, now time.Time) {
+	for _, lag := range watermarks.lags(since, now) {
+		if w == nil {
+			logf(verbosityVerbose, "ingestion lag of %s/%s is %s", lag.Destination.LogGroupName, lag.Destination.LogStreamName, lag.Lag.Round(time.Second))
+			continue
+		}
+		data, err := ingestionLagMetric(lag, now)
+		if err != nil {
+			logf(verbosityNormal, "failed to format the ingestion lag of %s/%s: %v", lag.Destination.LogGroupName, lag.Destination.LogStreamName, err)
+			continue
+		}
+		fmt.Fprintln(w, string(data))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents(timestamps ...time.Time) []*cloudwatchlogs.InputLogEvent {
+	var events []*cloudwatchlogs.InputLogEvent
+	for _, timestamp := range timestamps {
+		events = append(events, &cloudwatchlogs.InputLogEvent{Message: aws.String("{}"), Timestamp: aws.Int64(timestamp.UnixMilli())})
+	}
+	return events
+}
+
+func TestWatermarks(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 30, 0, 0, time.UTC)
+	streamA := LogConfig{LogGroupName: "group", LogStreamName: "a"}
+	streamB := LogConfig{LogGroupName: "group", LogStreamName: "b"}
+	watermarks := &Watermarks{}
+
+	watermarks.advance(streamA, testEvents(now.Add(-10*time.Minute), now.Add(-5*time.Minute)), now.Add(-time.Hour))
+	// An older batch sent later, e.g. of another object, doesn't move the watermark back
+	watermarks.advance(streamA, testEvents(now.Add(-20*time.Minute)), now)
+	// The role used to write doesn't make it another stream
+	watermarks.advance(LogConfig{LogGroupName: "group", LogStreamName: "b", RoleARN: "arn:aws:iam::111111111111:role/writer"}, testEvents(now.Add(-time.Minute)), now.Add(-2*time.Hour))
+
+	assert.Equal(t, []StreamLag{
+		{Destination: streamA, Watermark: now.Add(-5 * time.Minute), Lag: 5 * time.Minute},
+		{Destination: streamB, Watermark: now.Add(-time.Minute), Lag: time.Minute},
+	}, watermarks.lags(time.Time{}, now))
+	// Streams that received nothing since are left out
+	assert.Equal(t, []StreamLag{
+		{Destination: streamA, Watermark: now.Add(-5 * time.Minute), Lag: 5 * time.Minute},
+	}, watermarks.lags(now.Add(-time.Minute), now))
+
+	var disabled *Watermarks
+	disabled.advance(streamA, testEvents(now), now)
+	assert.Nil(t, disabled.lags(time.Time{}, now))
+}
+
+func TestIngestionLagMetric(t *testing.T) {
+	now := time.Date(2024, 3, 21, 16, 30, 0, 0, time.UTC)
+	data, err := ingestionLagMetric(StreamLag{
+		Destination: LogConfig{LogGroupName: "group", LogStreamName: "stream"},
+		Watermark:   now.Add(-90 * time.Second),
+		Lag:         90 * time.Second,
+	}, now)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 1711038600000,
+			"CloudWatchMetrics": [{
+				"Namespace": "elb-logs-to-cloudwatch",
+				"Dimensions": [["LogGroup", "LogStream"]],
+				"Metrics": [{"Name": "IngestionLag", "Unit": "Seconds"}]
+			}]
+		},
+		"LogGroup": "group",
+		"LogStream": "stream",
+		"IngestionLag": 90,
+		"Watermark": "2024-03-21T16:28:30Z"
+	}`, string(data))
+	assert.True(t, json.Valid(data))
+}
+
+func TestEmitIngestionLag(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+	watermarks := &Watermarks{}
+	watermarks.advance(LogConfig{LogGroupName: "group", LogStreamName: "stream"}, testEvents(now.Add(-time.Minute)), now)
+
+	var out bytes.Buffer
+	emitIngestionLag(&out, watermarks, now.Add(-time.Second), now)
+	var metric map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &metric))
+	assert.Equal(t, "stream", metric["LogStream"])
+	assert.Equal(t, 60.0, metric["IngestionLag"])
+
+	// Outside of Lambda the lags are only logged
+	emitIngestionLag(nil, watermarks, now.Add(-time.Second), now)
+}
+
+func TestSendBatchAdvancesWatermark(t *testing.T) {
+	mockCW := new(MockCloudWatchLogsClient)
+	mockCW.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{}, nil)
+	lp := &CloudWatchLogProcessor{cwClient: mockCW, watermarks: &Watermarks{}}
+	destination := LogConfig{LogGroupName: "group", LogStreamName: "stream"}
+	timestamp := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+
+	require.NoError(t, lp.sendBatch(destination, &eventBatch{events: testEvents(timestamp)}, &sendCounters{}, nil))
+	lags := lp.watermarks.lags(time.Time{}, time.Now())
+	require.Len(t, lags, 1)
+	assert.True(t, lags[0].Watermark.Equal(timestamp))
+}